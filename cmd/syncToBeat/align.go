@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// alignmentSampleRate is the sample rate used to compare audio tracks,
+	// high enough for sub-frame accuracy while keeping the FFTs small.
+	alignmentSampleRate = 4000
+	// alignmentMaxDuration limits how many seconds of each track get decoded
+	// when looking for an offset.
+	alignmentMaxDuration = 300
+)
+
+// detectAudioOffset cross-correlates the master audio with the scratch audio
+// and returns the time, in seconds, in the scratch audio matching the start
+// of the master audio. A negative offset means the scratch recording started
+// after the master. The confidence is the normalized correlation peak, values
+// close to 0 mean the tracks don't have much in common.
+func detectAudioOffset(master, scratch []float64, sampleRate int) (float64, float64) {
+	correlation := crossCorrelate(master, scratch)
+	n := len(correlation)
+
+	bestLag := 0
+	bestValue := math.Inf(-1)
+	for i, v := range correlation {
+		lag := i
+		if i >= n-len(master) {
+			lag = i - n // negative lags wrap around
+		} else if i >= len(scratch) {
+			continue
+		}
+		if v > bestValue {
+			bestValue = v
+			bestLag = lag
+		}
+	}
+
+	var confidence float64
+	if norm := math.Sqrt(energy(master) * energy(scratch)); norm > 0 {
+		confidence = bestValue / norm
+	}
+	return float64(bestLag) / float64(sampleRate), confidence
+}
+
+// detectAngleOffsets sets the offset of every camera angle by aligning its
+// scratch audio with the music.
+func detectAngleOffsets(angles []CameraAngle, audioPath string) error {
+	master, err := decodeAudio(audioPath, alignmentSampleRate, alignmentMaxDuration)
+	if err != nil {
+		return fmt.Errorf("failed to decode the music: %v", err)
+	}
+
+	for i := range angles {
+		scratch, err := decodeAudio(angles[i].Path, alignmentSampleRate, alignmentMaxDuration)
+		if err != nil {
+			return fmt.Errorf("failed to decode the scratch audio of %s: %v", angles[i].Path, err)
+		}
+		offset, confidence := detectAudioOffset(master, scratch, alignmentSampleRate)
+		say("align.offset", i, angles[i].Path, offset, confidence)
+		angles[i].Offset = offset
+	}
+
+	return nil
+}
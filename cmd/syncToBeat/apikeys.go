@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// APIKeysPath is the JSON file of the API keys accepted by the server, set
+// with the SYNCTOBEAT_API_KEYS environment variable. Without keys the server
+// only listens on localhost.
+var APIKeysPath = os.Getenv("SYNCTOBEAT_API_KEYS")
+
+const (
+	// maxUploadSize is the size of the largest file uploaded, unless the key
+	// sets its own limit.
+	maxUploadSize = 1 << 30
+	// maxJobRequestSize is the size of the largest job submitted.
+	maxJobRequestSize = 1 << 20
+	// quotaWindow is the period the jobs are counted over for the quotas.
+	quotaWindow = 24 * time.Hour
+)
+
+// apiKey is a key accepted by the server and its quotas.
+type apiKey struct {
+	Key string `json:"key"`
+	// User is the user the jobs submitted with the key run for.
+	User string `json:"user"`
+	// JobsPerDay bounds the jobs submitted with the key over 24 hours, 0 for
+	// no limit.
+	JobsPerDay int `json:"jobs_per_day"`
+	// MaxUpload bounds the size of the uploaded files, such as "2GB", empty
+	// for the default of 1GB.
+	MaxUpload string `json:"max_upload"`
+
+	maxUpload int64
+}
+
+// readAPIKeys reads the API keys from a JSON file.
+func readAPIKeys(filePath string) ([]apiKey, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	var keys []apiKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("%s: %v", filePath, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no API keys found in %s", filePath)
+	}
+	for i := range keys {
+		key := &keys[i]
+		if len(key.Key) < 16 {
+			return nil, fmt.Errorf("API key %d of %s is shorter than 16 characters", i, filePath)
+		}
+		if key.User == "" {
+			return nil, fmt.Errorf("API key %d of %s has no user", i, filePath)
+		}
+		if key.JobsPerDay < 0 {
+			return nil, fmt.Errorf("API key %d of %s has a negative number of jobs per day", i, filePath)
+		}
+		key.maxUpload = maxUploadSize
+		if key.MaxUpload != "" {
+			if key.maxUpload, err = parseSize(key.MaxUpload); err != nil {
+				return nil, fmt.Errorf("API key %d of %s: %v", i, filePath, err)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// requestKey returns the API key sent with the request, as a bearer token or
+// in the X-API-Key header.
+func requestKey(r *http.Request) string {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// authenticate returns the key the request is made with, nil when the server
+// has no keys.
+func (s *jobServer) authenticate(r *http.Request) (*apiKey, error) {
+	if s.Keys == nil {
+		return nil, nil
+	}
+	sent := requestKey(r)
+	for i := range s.Keys {
+		if subtle.ConstantTimeCompare([]byte(s.Keys[i].Key), []byte(sent)) == 1 {
+			return &s.Keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("missing or invalid API key")
+}
+
+// authorized wraps a handler so that it's only called for requests made with
+// one of the keys of the server.
+func (s *jobServer) authorized(handle func(w http.ResponseWriter, r *http.Request, key *apiKey)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		handle(w, r, key)
+	}
+}
+
+// checkQuota returns an error when the key submitted all the jobs its quota
+// allows over the last 24 hours. The caller holds the lock.
+func (s *jobServer) checkQuota(key *apiKey, now time.Time) error {
+	if key == nil || key.JobsPerDay == 0 {
+		return nil
+	}
+	submitted := 0
+	for _, job := range s.jobs {
+		if job.key == key && now.Sub(job.Submitted) < quotaWindow {
+			submitted++
+		}
+	}
+	if submitted >= key.JobsPerDay {
+		return fmt.Errorf("quota of %d jobs per day reached", key.JobsPerDay)
+	}
+	return nil
+}
+
+// handleUpload stores the body of the request in the uploads directory and
+// responds with its path on the server, to use in the arguments of jobs.
+func (s *jobServer) handleUpload(w http.ResponseWriter, r *http.Request, key *apiKey) {
+	limit := int64(maxUploadSize)
+	if key != nil {
+		limit = key.maxUpload
+	}
+	if r.ContentLength > limit {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("the upload is larger than %d bytes", limit))
+		return
+	}
+	name := filepath.Base(r.PathValue("name"))
+	if name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid file name %q", r.PathValue("name")))
+		return
+	}
+	if err := os.MkdirAll(s.UploadDir, 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	file, err := os.CreateTemp(s.UploadDir, "*-"+name)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	_, err = io.Copy(file, http.MaxBytesReader(w, r.Body, limit))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		status := http.StatusInternalServerError
+		if _, ok := err.(*http.MaxBytesError); ok {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeError(w, status, fmt.Errorf("upload failed: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"path": file.Name()})
+}
+
+// loopback reports whether the address only listens on the local machine.
+func loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
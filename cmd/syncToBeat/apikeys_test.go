@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		wantMaxUpload int64
+		wantErr       bool
+	}{
+		{name: "default upload limit", content: `[{"key": "0123456789abcdef", "user": "ana"}]`, wantMaxUpload: maxUploadSize},
+		{name: "upload limit", content: `[{"key": "0123456789abcdef", "user": "ana", "max_upload": "2GB"}]`, wantMaxUpload: 2 << 30},
+		{name: "no keys", content: `[]`, wantErr: true},
+		{name: "short key", content: `[{"key": "secret", "user": "ana"}]`, wantErr: true},
+		{name: "no user", content: `[{"key": "0123456789abcdef"}]`, wantErr: true},
+		{name: "negative quota", content: `[{"key": "0123456789abcdef", "user": "ana", "jobs_per_day": -1}]`, wantErr: true},
+		{name: "invalid upload limit", content: `[{"key": "0123456789abcdef", "user": "ana", "max_upload": "lots"}]`, wantErr: true},
+		{name: "not JSON", content: `key=0123456789abcdef`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := readAPIKeys(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readAPIKeys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && keys[0].maxUpload != tt.wantMaxUpload {
+				t.Errorf("readAPIKeys() upload limit = %d, want %d", keys[0].maxUpload, tt.wantMaxUpload)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	server := newJobServer(1, 0)
+	server.Keys = []apiKey{{Key: "0123456789abcdef", User: "ana"}, {Key: "fedcba9876543210", User: "ben"}}
+	tests := []struct {
+		name     string
+		header   string
+		value    string
+		wantUser string
+		wantErr  bool
+	}{
+		{name: "bearer token", header: "Authorization", value: "Bearer fedcba9876543210", wantUser: "ben"},
+		{name: "header", header: "X-API-Key", value: "0123456789abcdef", wantUser: "ana"},
+		{name: "invalid key", header: "X-API-Key", value: "0123456789abcdeg", wantErr: true},
+		{name: "prefix of a key", header: "X-API-Key", value: "0123456789", wantErr: true},
+		{name: "other scheme", header: "Authorization", value: "Basic 0123456789abcdef", wantErr: true},
+		{name: "no key", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/jobs", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			key, err := server.authenticate(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && key.User != tt.wantUser {
+				t.Errorf("authenticate() user = %q, want %q", key.User, tt.wantUser)
+			}
+		})
+	}
+
+	open := newJobServer(1, 0)
+	if key, err := open.authenticate(httptest.NewRequest("GET", "/jobs", nil)); key != nil || err != nil {
+		t.Errorf("authenticate() without keys = %v, %v, want nil, nil", key, err)
+	}
+}
+
+func TestCheckQuota(t *testing.T) {
+	now := time.Now()
+	limited := &apiKey{Key: "0123456789abcdef", User: "ana", JobsPerDay: 2}
+	unlimited := &apiKey{Key: "fedcba9876543210", User: "ben"}
+	tests := []struct {
+		name      string
+		key       *apiKey
+		submitted []time.Duration
+		wantErr   bool
+	}{
+		{name: "under the quota", key: limited, submitted: []time.Duration{time.Hour}},
+		{name: "quota reached", key: limited, submitted: []time.Duration{time.Hour, 2 * time.Hour}, wantErr: true},
+		{name: "older jobs", key: limited, submitted: []time.Duration{time.Hour, 25 * time.Hour, 30 * time.Hour}},
+		{name: "no quota", key: unlimited, submitted: []time.Duration{time.Hour, time.Hour, time.Hour}},
+		{name: "no key", key: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newJobServer(1, 0)
+			for _, ago := range tt.submitted {
+				server.jobs = append(server.jobs, &serveJob{State: "succeeded", Submitted: now.Add(-ago), key: tt.key})
+			}
+			// the jobs of other keys don't count
+			server.jobs = append(server.jobs, &serveJob{State: "queued", Submitted: now, key: &apiKey{User: "cleo"}})
+			if err := server.checkQuota(tt.key, now); (err != nil) != tt.wantErr {
+				t.Errorf("checkQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ASSOverlay is an ASS subtitle file composited over the synced video, empty
+// disables it. Its events can be templates repeated on the grid, see
+// expandASSEvent.
+var ASSOverlay = ""
+
+// assTime formats a time as an ASS timestamp, H:MM:SS.cc.
+func assTime(t float64) string {
+	centiseconds := int64(math.Round(max(t, 0) * 100))
+	return fmt.Sprintf("%d:%02d:%02d.%02d", centiseconds/360000, centiseconds/6000%60, centiseconds/100%60, centiseconds%100)
+}
+
+// expandASSEvent expands an event template into one event per repetition on
+// the grid, up to duration. Templates have a start of "every N" or "every N
+// from M" (in beats) and an end of "+D" (the duration in beats). In their
+// text, {beat} and {bar} are replaced by the number of the beat and bar of
+// the repetition, counted from 1, and {ms:D} by the duration of D beats in
+// milliseconds, for the \t animation tags. Other events are kept as is, in
+// the time of the synced video. fields are the fields of the event, without
+// the "Dialogue:" prefix.
+func expandASSEvent(grid beatGrid, fields []string, duration float64) ([]string, error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(fields[1]), "every ")
+	if !ok {
+		return []string{"Dialogue:" + strings.Join(fields, ",")}, nil
+	}
+	everyStr, fromStr, hasFrom := strings.Cut(spec, " from ")
+	every, err := strconv.ParseFloat(strings.TrimSpace(everyStr), 64)
+	if err != nil || every <= 0 {
+		return nil, fmt.Errorf("invalid repetition %q, expected every N [from M] in beats", fields[1])
+	}
+	var from float64
+	if hasFrom {
+		if from, err = strconv.ParseFloat(strings.TrimSpace(fromStr), 64); err != nil {
+			return nil, fmt.Errorf("invalid repetition %q, expected every N [from M] in beats", fields[1])
+		}
+	}
+	lengthStr, ok := strings.CutPrefix(strings.TrimSpace(fields[2]), "+")
+	length, err := strconv.ParseFloat(lengthStr, 64)
+	if !ok || err != nil || length <= 0 {
+		return nil, fmt.Errorf("invalid end %q of a repeated event, expected +D in beats", fields[2])
+	}
+
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	var events []string
+	for beat := from; grid.BeatTime(beat) < duration; beat += every {
+		start := grid.BeatTime(beat)
+		text := fields[9]
+		text = strings.ReplaceAll(text, "{beat}", strconv.Itoa(int(beat)+1))
+		text = strings.ReplaceAll(text, "{bar}", strconv.Itoa(int(math.Floor(beat/beatsPerBar))+1))
+		text, err = replaceBeatDurations(text, grid, beat)
+		if err != nil {
+			return nil, err
+		}
+		event := append([]string{}, fields...)
+		event[1] = assTime(start)
+		event[2] = assTime(grid.BeatTime(beat + length))
+		event[9] = text
+		events = append(events, "Dialogue:"+strings.Join(event, ","))
+	}
+	return events, nil
+}
+
+// replaceBeatDurations replaces the {ms:D} placeholders of the text by the
+// duration, in milliseconds, of D beats from the given beat.
+func replaceBeatDurations(text string, grid beatGrid, beat float64) (string, error) {
+	var replaced strings.Builder
+	for {
+		before, rest, ok := strings.Cut(text, "{ms:")
+		if !ok {
+			replaced.WriteString(text)
+			return replaced.String(), nil
+		}
+		beatsStr, after, ok := strings.Cut(rest, "}")
+		if !ok {
+			return "", fmt.Errorf("unclosed {ms: placeholder in %q", text)
+		}
+		beats, err := strconv.ParseFloat(beatsStr, 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid {ms:%s} placeholder, expected a number of beats", beatsStr)
+		}
+		ms := (grid.BeatTime(beat+beats) - grid.BeatTime(beat)) * 1000
+		replaced.WriteString(before)
+		replaced.WriteString(strconv.Itoa(int(math.Round(ms))))
+		text = after
+	}
+}
+
+// expandASS writes the subtitles of templatePath, their templates expanded on
+// the grid up to duration, to a temporary file and returns its path. The
+// caller removes it once done.
+func expandASS(templatePath string, grid beatGrid, duration float64) (string, error) {
+	template, err := os.Open(templatePath)
+	if err != nil {
+		return "", err
+	}
+	defer template.Close()
+
+	var expanded strings.Builder
+	scanner := bufio.NewScanner(template)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		fields, ok := strings.CutPrefix(text, "Dialogue:")
+		if !ok {
+			expanded.WriteString(text + "\n")
+			continue
+		}
+		// the text, last, can contain commas
+		parts := strings.SplitN(fields, ",", 10)
+		if len(parts) < 10 {
+			return "", fmt.Errorf("%s:%d: invalid event, expected 10 fields", templatePath, line)
+		}
+		events, err := expandASSEvent(grid, parts, duration)
+		if err != nil {
+			return "", fmt.Errorf("%s:%d: %v", templatePath, line, err)
+		}
+		for _, event := range events {
+			expanded.WriteString(event + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	// the workers of the farm read the overlay too
+	file, err := os.CreateTemp(FarmDir, "overlay-*.ass")
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.WriteString(expanded.String()); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+)
+
+// decodeAudio decodes up to maxDuration seconds (everything when 0) of the
+// audio track of the given file into mono float samples at the requested
+// sample rate.
+func decodeAudio(mediaPath string, sampleRate int, maxDuration float64) ([]float64, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+
+	cmdArgs := []string{
+		"-v", "error",
+		"-i", mediaPath,
+	}
+	if maxDuration > 0 {
+		cmdArgs = append(cmdArgs, "-t", seconds(maxDuration).timestamp())
+	}
+	cmdArgs = append(cmdArgs,
+		"-vn",      // only decode the audio
+		"-ac", "1", // downmix to mono
+		"-ar", fmt.Sprintf("%d", sampleRate),
+		"-f", "f32le", // raw little endian 32bit floats
+		"-",
+	)
+
+	cmd := exec.Command(ffmpegPath, cmdArgs...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if Debug {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error decoding audio from %s: %v", mediaPath, err)
+	}
+
+	raw := out.Bytes()
+	samples := make([]float64, len(raw)/4)
+	for i := range samples {
+		samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(raw[i*4:])))
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no audio found in %s", mediaPath)
+	}
+
+	return samples, nil
+}
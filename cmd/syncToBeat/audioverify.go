@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// audioDurationTolerance is the difference, in seconds, between the muxed
+// audio and video above which they're reported out of sync.
+const audioDurationTolerance = 0.1
+
+// audioStreamHash returns the MD5 of the packets of the first audio stream of
+// the file, limited to the first duration seconds when positive.
+func audioStreamHash(path string, duration float64) (string, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	args := []string{"-hide_banner", "-v", "error", "-i", path}
+	if duration > 0 {
+		args = append(args, "-t", seconds(duration).timestamp())
+	}
+	args = append(args, "-map", "0:a:0", "-c", "copy", "-f", "streamhash", "-hash", "md5", "-")
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffmpegPath, args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("error hashing the audio of %s: %v %s", path, err, stderr.String())
+	}
+	// the hash is written as: 0,a,MD5=<hex>
+	_, hash, ok := strings.Cut(strings.TrimSpace(string(output)), "=")
+	if !ok {
+		return "", fmt.Errorf("no audio hash for %s", path)
+	}
+	return hash, nil
+}
+
+// verifyMuxedAudio checks that the audio muxed from audioPath into muxedPath
+// was passed through untouched or transcoded as requested, and that it lasts
+// as long as the video.
+func verifyMuxedAudio(audioPath string, muxedPath string) error {
+	muxed, err := probe.ProbeMedia(muxedPath)
+	if err != nil {
+		return err
+	}
+	muxedAudio, ok := muxed.Audio()
+	if !ok {
+		return fmt.Errorf("%s has no audio", muxedPath)
+	}
+	muxedVideo, _ := muxed.Video()
+	source, err := probe.ProbeMedia(audioPath)
+	if err != nil {
+		return err
+	}
+	sourceAudio, ok := source.Audio()
+	if !ok {
+		return fmt.Errorf("%s has no audio", audioPath)
+	}
+	audioDuration := muxedAudio.Duration
+	if audioDuration == 0 {
+		audioDuration = muxed.Duration
+	}
+
+	if AudioEncoder.Copy && !limitAudio {
+		muxedHash, err := audioStreamHash(muxedPath, 0)
+		if err != nil {
+			return err
+		}
+		sourceHash, err := audioStreamHash(audioPath, audioDuration)
+		if err != nil {
+			return err
+		}
+		if muxedHash != sourceHash {
+			warn(WarnAudioNotPassedThrough, muxedPath, sourceHash, muxedHash)
+		}
+	} else {
+		expected := codecFamily(AudioEncoder.Codec)
+		if got := codecFamily(muxedAudio.Codec); got != expected {
+			warn(WarnAudioTranscode, muxedPath, "codec", expected, got)
+		}
+		if AudioEncoder.SampleRate > 0 && muxedAudio.SampleRate != AudioEncoder.SampleRate {
+			warn(WarnAudioTranscode, muxedPath, "sample rate", fmt.Sprint(AudioEncoder.SampleRate), fmt.Sprint(muxedAudio.SampleRate))
+		}
+		if AudioEncoder.Channels > 0 && muxedAudio.Channels != AudioEncoder.Channels {
+			warn(WarnAudioTranscode, muxedPath, "channels", fmt.Sprint(AudioEncoder.Channels), fmt.Sprint(muxedAudio.Channels))
+		}
+	}
+
+	// shorter audio sources are expected to end before the video
+	videoDuration := muxedVideo.Duration
+	if videoDuration == 0 {
+		videoDuration = muxed.Duration
+	}
+	sourceDuration := sourceAudio.Duration
+	if sourceDuration == 0 {
+		sourceDuration = source.Duration
+	}
+	expected := math.Min(videoDuration, MusicStart+sourceDuration-min(AVOffset, 0))
+	if GapFill {
+		// the original audio plays where the music doesn't
+		expected = videoDuration
+	}
+	if math.Abs(audioDuration-expected) > audioDurationTolerance {
+		warn(WarnAudioDuration, muxedPath, audioDuration, expected)
+	}
+	return nil
+}
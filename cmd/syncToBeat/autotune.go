@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+)
+
+// the settings searched by the auto-tuning.
+var (
+	tuneSubdivisions = []int{1, 2, 4}
+	// tuneOffsets are the nudges, in seconds, tried on the grid offset. They
+	// stay small: the offset is where the music beats and can only be
+	// refined.
+	tuneOffsets     = []float64{-0.04, -0.03, -0.02, -0.01, 0, 0.01, 0.02, 0.03, 0.04}
+	tuneSpeedLimits = []float64{0, 4, 3, 2}
+)
+
+// autoTune searches the snapping subdivision, grid offset and speed limit
+// maximizing the sync score of the plan. It returns the tuned grid, SpeedLimit
+// is set to the chosen limit.
+func autoTune(grid beatGrid, keyframes []Keyframe) (beatGrid, syncScore, error) {
+	quiet = true
+	defer func() { quiet = false }()
+
+	evaluate := func(candidate beatGrid, limit float64) (syncScore, error) {
+		SpeedLimit = limit
+		segments, err := planSegments(candidate, keyframes)
+		if err != nil {
+			return syncScore{}, err
+		}
+		return plan.Score(candidate, keyframes, segments), nil
+	}
+
+	// the current settings win ties
+	best, bestLimit := grid, SpeedLimit
+	bestScore, err := evaluate(grid, SpeedLimit)
+	if err != nil {
+		return grid, syncScore{}, fmt.Errorf("can't plan the keyframes with the current settings: %v", err)
+	}
+	subdivisions := append([]int{grid.Subdivision}, tuneSubdivisions...)
+	for _, subdivision := range subdivisions {
+		for _, nudge := range tuneOffsets {
+			for _, limit := range tuneSpeedLimits {
+				candidate := grid
+				candidate.Subdivision = subdivision
+				candidate.Offset = grid.Offset + nudge
+				if score, err := evaluate(candidate, limit); err == nil && score.Score > bestScore.Score {
+					best, bestLimit, bestScore = candidate, limit, score
+				}
+			}
+		}
+	}
+	SpeedLimit = bestLimit
+	return best, bestScore, nil
+}
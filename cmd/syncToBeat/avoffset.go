@@ -0,0 +1,37 @@
+package main
+
+import "fmt"
+
+// AVOffset shifts the video relative to the audio, in seconds, whenever they
+// get muxed together. A positive offset shows the video later than the audio,
+// compensating for audio latency such as Bluetooth headphones.
+var AVOffset = 0.0
+
+// audioOffsetArgs returns the input options delaying the audio input they
+// precede when the video has to be shown earlier than the audio.
+func audioOffsetArgs() []string {
+	if AVOffset >= 0 {
+		return nil
+	}
+	return []string{"-itsoffset", seconds(-AVOffset).timestamp()}
+}
+
+// videoOffsetArgs returns the input options delaying the video input they
+// precede when the video has to be shown later than the audio. Use
+// videoOffsetFilter instead when the video goes through a filter graph, so
+// that overlays like the pulse shift along with the video.
+func videoOffsetArgs() []string {
+	if AVOffset <= 0 {
+		return nil
+	}
+	return []string{"-itsoffset", seconds(AVOffset).timestamp()}
+}
+
+// videoOffsetFilter returns the filter, to append to a filter chain, delaying
+// the video when it has to be shown later than the audio.
+func videoOffsetFilter() string {
+	if AVOffset <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(",setpts=PTS+(%s)/TB", seconds(AVOffset).rational(1000))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// benchmarkTempos are the tempos of the fixtures the benchmark syncs.
+var benchmarkTempos = []float64{90, 120, 150}
+
+const (
+	// benchmarkFlashes is the number of flashes of each fixture.
+	benchmarkFlashes = 8
+	// benchmarkSweep is the parameter grid benchmarked by default.
+	benchmarkSweep = "subdivision=1,2,4"
+	// flashLuma is the average luma above which a frame is a flash.
+	flashLuma = 128
+)
+
+const benchmarkUsage = `Usage:
+  <program> benchmark [sweep]    sync generated fixtures with each combination of settings (as with -sweep, "subdivision=1,2,4" by default) and print the cut-to-beat errors`
+
+// detectFlashes returns the times at which the flashes of a fixture start in
+// the video.
+func detectFlashes(videoPath string) ([]float64, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	cmd := exec.Command(ffmpegPath,
+		"-hide_banner", "-nostats",
+		"-i", videoPath,
+		"-an",
+		"-vf", "signalstats,metadata=mode=print:key=lavfi.signalstats.YAVG:file=-",
+		"-f", "null", "-",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("error analyzing %s: %v %s", videoPath, err, stderr.String())
+	}
+
+	// each frame is printed as:
+	//   frame:12   pts:6144    pts_time:0.4
+	//   lavfi.signalstats.YAVG=235.0
+	var flashes []float64
+	var frameTime float64
+	lit := false
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if _, value, ok := strings.Cut(line, "pts_time:"); ok {
+			frameTime, _ = strconv.ParseFloat(strings.TrimSpace(value), 64)
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "lavfi.signalstats.YAVG="); ok {
+			luma, _ := strconv.ParseFloat(value, 64)
+			if luma > flashLuma && !lit {
+				flashes = append(flashes, frameTime)
+			}
+			lit = luma > flashLuma
+		}
+	}
+	return flashes, nil
+}
+
+// beatErrors returns the distance, in seconds, between each time and the
+// nearest beat of the grid.
+func beatErrors(grid beatGrid, times []float64) []float64 {
+	errors := make([]float64, len(times))
+	for i, t := range times {
+		nearest := grid.BeatTime(math.Round(grid.BeatPosition(t)))
+		errors[i] = math.Abs(t - nearest)
+	}
+	return errors
+}
+
+// percentile returns the p-th percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	return sorted[max(index, 0)]
+}
+
+// runBenchmarkCommand syncs generated fixtures with every combination of the
+// sweep and prints the distribution of the errors between the cuts, found
+// by detecting the flashes of the synced videos, and the beats.
+func runBenchmarkCommand(args []string) error {
+	spec := benchmarkSweep
+	if len(args) > 0 {
+		if args[0] == "help" {
+			say("usage.benchmark")
+			return nil
+		}
+		spec = args[0]
+	}
+	parameters, err := parseSweep(spec)
+	if err != nil {
+		say("usage.benchmark")
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "syncToBeat-benchmark-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	var fixtures []fixture
+	for _, bpm := range benchmarkTempos {
+		say("benchmark.fixture", bpm)
+		f, err := writeFixture(filepath.Join(dir, fmt.Sprintf("fixture_%g", bpm)), bpm, benchmarkFlashes)
+		if err != nil {
+			return err
+		}
+		fixtures = append(fixtures, f)
+	}
+
+	say("benchmark.header")
+	for _, values := range sweepCombinations(parameters) {
+		var label []string
+		for j, parameter := range parameters {
+			label = append(label, parameter.Name+"="+values[j])
+		}
+
+		var cutErrors []float64
+		found := 0
+		for _, f := range fixtures {
+			grid, err := applySweepCombination(beatGrid{BPM: f.BPM}, parameters, values)
+			if err != nil {
+				return err
+			}
+			synced := filepath.Join(filepath.Dir(f.VideoPath), "synced.mkv")
+			quiet = true
+			err = ffmpegAdjustSpeed(grid, f.VideoPath, "", synced, f.Keyframes)
+			quiet = false
+			if err != nil {
+				return fmt.Errorf("%s at %g BPM: %v", strings.Join(label, " "), f.BPM, err)
+			}
+			flashes, err := detectFlashes(synced)
+			if err != nil {
+				return err
+			}
+			found += len(flashes)
+			// the errors are measured against the beats of the click track
+			cutErrors = append(cutErrors, beatErrors(beatGrid{BPM: f.BPM}, flashes)...)
+		}
+
+		sort.Float64s(cutErrors)
+		var mean float64
+		for _, e := range cutErrors {
+			mean += e
+		}
+		if len(cutErrors) > 0 {
+			mean /= float64(len(cutErrors))
+		}
+		cuts := fmt.Sprintf("%d/%d", found, len(fixtures)*benchmarkFlashes)
+		say("benchmark.result", strings.Join(label, " "), cuts,
+			mean*1000, percentile(cutErrors, 50)*1000, percentile(cutErrors, 95)*1000, percentile(cutErrors, 100)*1000)
+	}
+	return nil
+}
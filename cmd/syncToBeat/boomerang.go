@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// boomerangOptions holds the settings of a boomerang loop.
+type boomerangOptions struct {
+	// FirstBar is the first bar of the range, counted from 1, and Bars the
+	// number of bars of the range (1 or 2).
+	FirstBar int
+	Bars     int
+	// Loops is the number of forward-backward cycles rendered, each lasting
+	// one bar.
+	Loops int
+}
+
+// parseBarRange parses a range of bars such as "9" or "9-10".
+func parseBarRange(value string) (first int, bars int, err error) {
+	firstValue, lastValue, isRange := strings.Cut(value, "-")
+	if first, err = strconv.Atoi(strings.TrimSpace(firstValue)); err != nil || first < 1 {
+		return 0, 0, fmt.Errorf("invalid bar range %q, expected a bar such as 9 or a range such as 9-10", value)
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.Atoi(strings.TrimSpace(lastValue)); err != nil {
+			return 0, 0, fmt.Errorf("invalid bar range %q, expected a bar such as 9 or a range such as 9-10", value)
+		}
+	}
+	bars = last - first + 1
+	if bars < 1 || bars > 2 {
+		return 0, 0, fmt.Errorf("invalid bar range %q, a boomerang loops 1 or 2 bars", value)
+	}
+	return first, bars, nil
+}
+
+// renderBoomerang extracts the range of bars of the video and renders it
+// played forward then backward, each cycle retimed to last exactly one bar.
+// The music, when given, plays from the start of the range.
+func renderBoomerang(grid beatGrid, videoPath string, audioPath string, outputPath string, opts boomerangOptions) error {
+	if opts.Loops < 1 {
+		return fmt.Errorf("invalid number of loops %d", opts.Loops)
+	}
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	firstBeat := float64(opts.FirstBar-1) * beatsPerBar
+	start := grid.BeatTime(firstBeat)
+	end := grid.BeatTime(firstBeat + float64(opts.Bars)*beatsPerBar)
+	barDuration := grid.BeatTime(firstBeat+beatsPerBar) - start
+
+	videoDuration, err := getVideoDuration(videoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+	if start < 0 || end > videoDuration {
+		return fmt.Errorf("bars %d to %d (%.2fs - %.2fs) are outside of the %.2fs video", opts.FirstBar, opts.FirstBar+opts.Bars-1, start, end, videoDuration)
+	}
+	detectSourceColor(videoPath)
+	planDeinterlace(videoPath)
+	var deinterlace string
+	if deinterlaceFilter != "" {
+		deinterlace = deinterlaceFilter + ","
+	}
+	fps := getVideoFrameRate(videoPath)
+	if fps == 0 {
+		fps = slideshowFPS
+	}
+
+	// a cycle plays the range forward and backward in one bar
+	factor := barDuration / (2 * (end - start))
+	filterComplexParts := []string{
+		fmt.Sprintf("[0:v]%strim=start=%s:end=%s,setpts=PTS-STARTPTS,split[forward][backward]", deinterlace, seconds(start), seconds(end)),
+		"[backward]reverse[reversed]",
+		fmt.Sprintf("[forward][reversed]concat=n=2:v=1:a=0,setpts=%s*PTS,fps=%s[cycle]", exact(factor), exact(fps)),
+	}
+	var cycles string
+	for i := 0; i < opts.Loops; i++ {
+		cycles += fmt.Sprintf("[c%d]", i)
+	}
+	filterComplexParts = append(filterComplexParts,
+		fmt.Sprintf("[cycle]split=%d%s", opts.Loops, cycles),
+		fmt.Sprintf("%sconcat=n=%d:v=1:a=0%s[outv]", cycles, opts.Loops, videoOffsetFilter()),
+	)
+	totalDuration := float64(opts.Loops) * barDuration
+
+	cmdArgs := []string{"-y", "-i", videoPath}
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-ss", seconds(start).timestamp(), "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", strings.Join(filterComplexParts, "; "),
+		"-map", "[outv]",
+	)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", "1:a:0")
+		cmdArgs = append(cmdArgs, audioMuxArgs()...)
+	} else {
+		cmdArgs = append(cmdArgs, "-an")
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	cmdArgs = append(cmdArgs,
+		"-t", seconds(totalDuration+max(AVOffset, 0)).timestamp(),
+		outputPath,
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("boomerang.start", opts.FirstBar, opts.FirstBar+opts.Bars-1, opts.Loops, barDuration)
+	if err := runFFmpeg("boomerang", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("boomerang.saved", outputPath)
+	recordOutput(outputPath)
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+const (
+	// bounceSampleRate is the sample rate the music is analyzed at, only the
+	// low frequencies matter.
+	bounceSampleRate = 4000
+	// bounceCutoff is the frequency, in Hz, below which the music drives the
+	// bounce (kicks and bass).
+	bounceCutoff = 150
+	// bounceRate is the number of zoom updates per second.
+	bounceRate = 25
+)
+
+// BounceAmount is how much the frame zooms in on the loudest bass hits, 0.05
+// zooms by 5%. 0 disables the bounce effect.
+var BounceAmount = 0.0
+
+// bounceCommands returns the sendcmd script resizing the crop@bounce filter
+// to follow the low frequency envelope of the music, zooming in on a
+// width x height frame by up to amount.
+func bounceCommands(envelope []float64, width, height int, amount float64) string {
+	var commands strings.Builder
+	lastWidth, lastHeight := width, height
+	for i, level := range envelope {
+		zoom := 1 + amount*level
+		// yuv420 frames need even dimensions
+		w := int(float64(width)/zoom) &^ 1
+		h := int(float64(height)/zoom) &^ 1
+		if w == lastWidth && h == lastHeight {
+			continue
+		}
+		fmt.Fprintf(&commands, "%s crop@bounce w %d, crop@bounce h %d;\n", seconds(float64(i)/bounceRate), w, h)
+		lastWidth, lastHeight = w, h
+	}
+	return commands.String()
+}
+
+// writeFilterCommands writes a sendcmd script to a temporary file and returns
+// its path, the caller removes it once done. The file goes to the farm
+// directory when there is one so that the workers can read it.
+func writeFilterCommands(name string, commands string) (string, error) {
+	commandsFile, err := os.CreateTemp(FarmDir, name+"-*.cmd")
+	if err != nil {
+		return "", err
+	}
+	if _, err := commandsFile.WriteString(commands); err != nil {
+		commandsFile.Close()
+		os.Remove(commandsFile.Name())
+		return "", err
+	}
+	if err := commandsFile.Close(); err != nil {
+		os.Remove(commandsFile.Name())
+		return "", err
+	}
+	return commandsFile.Name(), nil
+}
+
+// addBounceToVideo zooms the frames of the video along with the bass of the
+// music, giving a bouncing feel without flashes.
+func addBounceToVideo(inputVideoPath string, audioPath string, outputVideoPath string, amount float64) error {
+	if amount <= 0 || amount >= 1 {
+		return fmt.Errorf("invalid bounce amount %.2f, expected a fraction between 0 and 1", amount)
+	}
+	info, err := probe.ProbeMedia(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %v", err)
+	}
+	video, ok := info.Video()
+	if !ok {
+		return fmt.Errorf("failed to get video dimensions: no video streams found")
+	}
+
+	samples, err := decodeAudio(audioPath, bounceSampleRate, info.Duration)
+	if err != nil {
+		return err
+	}
+	envelope := rmsEnvelope(lowPass(samples, bounceSampleRate, bounceCutoff), bounceSampleRate, bounceRate)
+
+	commandsPath, err := writeFilterCommands("bounce", bounceCommands(envelope, video.Width, video.Height, amount))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(commandsPath)
+
+	filterComplex := fmt.Sprintf(
+		"[0:v]sendcmd=f=%s,crop@bounce=w=%d:h=%d,scale=%[2]d:%[3]d,setsar=1[output]",
+		escapeFilterText(commandsPath), video.Width, video.Height,
+	)
+
+	cmdArgs := []string{
+		"-y",
+		"-i", inputVideoPath,
+		"-filter_complex", filterComplex,
+		"-map", "[output]",
+		"-map", "0:a?",
+		"-c:a", "copy",
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("bounce.start", inputVideoPath)
+	if err := runFFmpeg("bounce", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// canvasSettings is the frame every clip of a multi-clip edit is normalized
+// to before being concatenated.
+type canvasSettings struct {
+	Width  int
+	Height int
+	// FPS is the frame rate, 0 picks the one of the first clip.
+	FPS float64
+}
+
+// Canvas is the canvas of the multicam edits as WIDTHxHEIGHT[@FPS], empty
+// uses the size of the angles and the frame rate of the first one.
+var Canvas = ""
+
+// parseCanvas parses a WIDTHxHEIGHT[@FPS] canvas.
+func parseCanvas(spec string) (canvasSettings, error) {
+	var canvas canvasSettings
+	size, fps, hasFPS := strings.Cut(spec, "@")
+	widthStr, heightStr, ok := strings.Cut(size, "x")
+	if !ok {
+		return canvas, fmt.Errorf("invalid canvas %q, expected WIDTHxHEIGHT[@FPS] (e.g. 1920x1080@30)", spec)
+	}
+	var err error
+	if canvas.Width, err = strconv.Atoi(widthStr); err != nil || canvas.Width <= 0 || canvas.Width%2 != 0 {
+		return canvas, fmt.Errorf("invalid canvas width %q, expected an even number of pixels", widthStr)
+	}
+	if canvas.Height, err = strconv.Atoi(heightStr); err != nil || canvas.Height <= 0 || canvas.Height%2 != 0 {
+		return canvas, fmt.Errorf("invalid canvas height %q, expected an even number of pixels", heightStr)
+	}
+	if hasFPS {
+		if canvas.FPS, err = strconv.ParseFloat(fps, 64); err != nil || canvas.FPS <= 0 {
+			return canvas, fmt.Errorf("invalid canvas frame rate %q", fps)
+		}
+	}
+	return canvas, nil
+}
+
+// normalizeFilter returns the filter chain fitting a clip to the canvas:
+// anamorphic pixels are made square, the frame is scaled to fit and padded
+// with black bars, and the frame rate is converted. scaleFlags are the
+// options of the scaler, such as ":flags=lanczos".
+func normalizeFilter(canvas canvasSettings, scaleFlags string) string {
+	return fmt.Sprintf(
+		"scale='trunc(iw*sar/2)*2':ih,setsar=1,"+
+			"scale=%[1]d:%[2]d:force_original_aspect_ratio=decrease%[3]s,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%[4]s",
+		canvas.Width, canvas.Height, scaleFlags, strconv.FormatFloat(canvas.FPS, 'f', -1, 64),
+	)
+}
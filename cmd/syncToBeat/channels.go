@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// channelMix is the audio filter chain converting the channel layout of the
+// music to the one of the muxed audio, set by planChannelMix.
+var channelMix = ""
+
+// channelLayouts are the layouts written for each number of channels.
+var channelLayouts = map[int]string{
+	1: "mono",
+	2: "stereo",
+	6: "5.1",
+	8: "7.1",
+}
+
+// surroundChannels lists the surround channels of the multichannel layouts
+// the itu downmix knows, left then right.
+var surroundChannels = map[string][]string{
+	"5.1":       {"BL", "BR"},
+	"5.1(side)": {"SL", "SR"},
+	"7.1":       {"BL", "SL", "BR", "SR"},
+}
+
+// validateChannelMix checks the downmix and upmix settings.
+func validateChannelMix(settings audioSettings) error {
+	switch settings.Downmix {
+	case "", "matrix", "itu", "front":
+	default:
+		return fmt.Errorf("invalid downmix %q, expected matrix, itu or front", settings.Downmix)
+	}
+	switch settings.Upmix {
+	case "", "front", "surround":
+	default:
+		return fmt.Errorf("invalid upmix %q, expected front or surround", settings.Upmix)
+	}
+	return nil
+}
+
+// ituDownmix returns the pan filter folding the layout to stereo as ITU-R
+// BS.775 does: the center and surrounds at -3dB and the LFE dropped.
+func ituDownmix(layout string) (string, bool) {
+	surrounds, ok := surroundChannels[layout]
+	if !ok {
+		return "", false
+	}
+	left := "FL+0.707*FC"
+	right := "FR+0.707*FC"
+	half := len(surrounds) / 2
+	for _, channel := range surrounds[:half] {
+		left += "+0.707*" + channel
+	}
+	for _, channel := range surrounds[half:] {
+		right += "+0.707*" + channel
+	}
+	return fmt.Sprintf("pan=stereo|FL<%s|FR<%s", left, right), true
+}
+
+// planChannelMix probes the music and sets channelMix to convert its channel
+// layout to the one of AudioEncoder. Music without a layout gets the default
+// layout of its number of channels instead of an unknown one.
+func planChannelMix(audioPath string) error {
+	channelMix = ""
+	if AudioEncoder.Copy && !limitAudio {
+		return nil
+	}
+	info, err := probe.ProbeMedia(audioPath)
+	if err != nil {
+		return err
+	}
+	audio, ok := info.Audio()
+	if !ok {
+		return fmt.Errorf("%s has no audio", audioPath)
+	}
+
+	var filters []string
+	layout := audio.ChannelLayout
+	if layout == "" {
+		if layout = channelLayouts[audio.Channels]; layout == "" {
+			return fmt.Errorf("%s has %d channels without a layout", audioPath, audio.Channels)
+		}
+		filters = append(filters, "channelmap=channel_layout="+layout)
+		say("audio.layout_guessed", audioPath, audio.Channels, layout)
+	}
+
+	target := layout
+	if AudioEncoder.Channels > 0 {
+		if target = channelLayouts[AudioEncoder.Channels]; target == "" {
+			// -ac picks the layout
+			channelMix = strings.Join(filters, ",")
+			return nil
+		}
+	}
+
+	switch {
+	case target == "stereo" && audio.Channels > 2:
+		switch AudioEncoder.Downmix {
+		case "itu":
+			pan, ok := ituDownmix(layout)
+			if !ok {
+				return fmt.Errorf("no itu downmix of the %s layout, use the matrix or front downmix", layout)
+			}
+			filters = append(filters, pan)
+		case "front":
+			filters = append(filters, "pan=stereo|FL=FL|FR=FR")
+		}
+	case layout == "stereo" && AudioEncoder.Channels > 2 && AudioEncoder.Upmix == "surround":
+		filters = append(filters, "surround=chl_out="+target)
+	}
+	if target != layout || len(filters) > 0 {
+		say("audio.layout", audioPath, layout, target)
+	}
+	channelMix = strings.Join(append(filters, "aformat=channel_layouts="+target), ",")
+	return nil
+}
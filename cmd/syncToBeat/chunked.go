@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ChunkLimit bounds the temporary disk space, in bytes, used to render the
+// synced video in chunks streamed into the output. 0 renders it in a single
+// pass.
+var ChunkLimit int64
+
+// RenderMode picks how the synced video is rendered: "graph" renders every
+// segment in a single filter graph, "segments" renders groups of segments to
+// files concatenated into the output, "auto" switches to segment files when
+// the filter graph would be too large.
+var RenderMode = "auto"
+
+const (
+	// maxGraphSegments is the number of segments above which the filter graph
+	// gets slow to start and memory hungry.
+	maxGraphSegments = 150
+	// segmentsPerChunk is the number of segments of each file when
+	// rendering to segment files.
+	segmentsPerChunk = 50
+)
+
+// validateRenderMode checks the render mode setting.
+func validateRenderMode(mode string) error {
+	switch mode {
+	case "auto", "graph", "segments":
+		return nil
+	}
+	return fmt.Errorf("invalid render mode %q, expected auto, graph or segments", mode)
+}
+
+// useSegmentFiles reports whether the segments get rendered to files
+// concatenated into the output rather than in a single filter graph.
+func useSegmentFiles(segments []segment) (bool, error) {
+	switch RenderMode {
+	case "graph":
+		return false, nil
+	case "segments":
+		return true, chunkStreamable()
+	}
+	if len(segments) <= maxGraphSegments {
+		return false, nil
+	}
+	if err := chunkStreamable(); err != nil {
+		say("render.graph_kept", len(segments), err)
+		return false, nil
+	}
+	say("render.segment_files", len(segments), maxGraphSegments)
+	return true, nil
+}
+
+// initialChunkBitrate is the bitrate, in bytes per second, assumed for the
+// size of the first chunk, the next ones use the bitrate measured so far.
+const initialChunkBitrate = 1 << 20
+
+// parseSize parses a size in bytes with an optional KB, MB or GB suffix.
+func parseSize(original string) (int64, error) {
+	size := strings.ToUpper(strings.TrimSpace(original))
+	multiplier := int64(1)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(size, suffix) {
+			multiplier = m
+			size = strings.TrimSpace(strings.TrimSuffix(size, suffix))
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(size, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q, expected a number of bytes with an optional KB, MB or GB suffix", original)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// chunkStreamable checks that the video encoder can be streamed as MPEG-TS,
+// the format the chunks get concatenated in.
+func chunkStreamable() error {
+	switch codecFamily(Encoder.Codec) {
+	case "h264", "hevc", "mpeg4":
+		return nil
+	}
+	return fmt.Errorf("chunked rendering needs an h264, hevc or mpeg4 encoder, not %s", Encoder.Codec)
+}
+
+// chunkFilterComplex builds the filter graph of a chunk of segments, read from
+// the source starting at sourceStart, landing at outputStart in the synced
+// video. The effects see the timestamps of the whole synced video.
+func chunkFilterComplex(segments []segment, effects []string, sourceStart float64, outputStart float64) string {
+	shifted := make([]segment, len(segments))
+	for i, seg := range segments {
+		if seg.Timebase.Den != 0 {
+			// the chunk input starts on the first segment of the chunk
+			seg.StartPTS -= segments[0].StartPTS
+			seg.EndPTS -= segments[0].StartPTS
+		}
+		seg.Start -= sourceStart
+		seg.End -= sourceStart
+		shifted[i] = seg
+	}
+	filterComplex := speedFilterComplex(shifted)
+	if len(effects) == 0 {
+		return filterComplex + "; [outv]null[chunk]"
+	}
+	return filterComplex + fmt.Sprintf("; [outv]setpts=PTS+%[1]s/TB,%[2]s,setpts=PTS-%[1]s/TB[chunk]", seconds(outputStart), strings.Join(effects, ","))
+}
+
+// chunkArgs returns the ffmpeg arguments rendering a chunk of segments,
+// landing at outputStart in the synced video, as MPEG-TS to chunkPath.
+func chunkArgs(chunk []segment, effects []string, originalVideoPath string, outputStart float64, chunkPath string) []string {
+	sourceStart := chunk[0].Start
+	cmdArgs := []string{
+		"-y",
+		"-ss", seconds(sourceStart).timestamp(),
+		"-i", originalVideoPath,
+		"-filter_complex", chunkFilterComplex(chunk, effects, sourceStart, outputStart),
+		"-map", "[chunk]",
+		"-an",
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	return append(cmdArgs, "-output_ts_offset", seconds(outputStart).timestamp(), "-f", "mpegts", chunkPath)
+}
+
+// renderChunked renders the segments in chunks, each one streamed into the
+// output and deleted before the next one is rendered so that the temporary
+// files stay under sizeLimit bytes. Chunks hold at most maxSegments segments,
+// 0 for no limits.
+func renderChunked(segments []segment, effects []string, originalVideoPath string, outputPath string, sizeLimit int64, maxSegments int) error {
+	if err := chunkStreamable(); err != nil {
+		return err
+	}
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "syncToBeat-chunks-*")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// the chunks are concatenated by a single ffmpeg reading them from stdin
+	concatArgs := []string{"-y", "-f", "mpegts", "-i", "pipe:0", "-map", "0:v", "-c", "copy"}
+	concatArgs = append(concatArgs, containerArgs(outputPath)...)
+	concat := exec.Command(ffmpegPath, append(concatArgs, outputPath)...)
+	var concatErrors strings.Builder
+	concat.Stderr = &concatErrors
+	stdin, err := concat.StdinPipe()
+	if err != nil {
+		return err
+	}
+	started := time.Now()
+	if err := concat.Start(); err != nil {
+		return err
+	}
+	abort := func(err error) error {
+		stdin.Close()
+		concat.Process.Kill()
+		concat.Wait()
+		return err
+	}
+
+	bitrate := float64(initialChunkBitrate)
+	var rendered float64
+	var renderedBytes int64
+	for first, n := 0, 1; first < len(segments); n++ {
+		// the chunk keeps a margin below the limit as the bitrate varies
+		chunkDuration := math.Inf(1)
+		if sizeLimit > 0 {
+			chunkDuration = 0.8 * float64(sizeLimit) / bitrate
+		}
+		outputStart := 0.0
+		if first > 0 {
+			outputStart = segments[first-1].NearestBeatTime
+		}
+		last := first
+		for last+1 < len(segments) && segments[last+1].NearestBeatTime-outputStart <= chunkDuration && (maxSegments == 0 || last+1-first < maxSegments) {
+			last++
+		}
+		chunk := segments[first : last+1]
+		chunkPath := filepath.Join(dir, fmt.Sprintf("chunk%d.ts", n))
+
+		say("chunk.start", n, outputStart, chunk[len(chunk)-1].NearestBeatTime)
+		if err := runFFmpeg(fmt.Sprintf("sync chunk %d", n), chunkArgs(chunk, effects, originalVideoPath, outputStart, chunkPath)); err != nil {
+			return abort(err)
+		}
+
+		info, err := os.Stat(chunkPath)
+		if err != nil {
+			return abort(err)
+		}
+		file, err := os.Open(chunkPath)
+		if err != nil {
+			return abort(err)
+		}
+		_, err = io.Copy(stdin, file)
+		file.Close()
+		if err != nil {
+			return abort(fmt.Errorf("failed to stream chunk %d: %v\n%s", n, err, concatErrors.String()))
+		}
+		os.Remove(chunkPath)
+
+		rendered += chunk[len(chunk)-1].NearestBeatTime - outputStart
+		renderedBytes += info.Size()
+		if rendered > 0 {
+			bitrate = float64(renderedBytes) / rendered
+		}
+		first = last + 1
+	}
+
+	stdin.Close()
+	err = concat.Wait()
+	recordPhaseUsage("chunk concat", concat.ProcessState, time.Since(started))
+	if err != nil {
+		return fmt.Errorf("failed to concatenate the chunks: %v\n%s", err, concatErrors.String())
+	}
+	return nil
+}
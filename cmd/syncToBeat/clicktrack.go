@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+// ClickTrack exports the beat grid as a click track next to the synced
+// video, to import in a DAW and check the grid against the music: wav for
+// clicks, the downbeats clicking higher, or mid for metronome notes with the
+// tempo map of the grid. Empty disables it.
+var ClickTrack = ""
+
+const (
+	clickSampleRate = 48000
+	// clickDuration is the length, in seconds, of a click of the WAV.
+	clickDuration = 0.03
+	// midiTicksPerQuarter is the resolution of the MIDI file.
+	midiTicksPerQuarter = 480
+	// the General MIDI percussion notes of the clicks
+	midiMetronomeClick = 33
+	midiMetronomeBell  = 34
+)
+
+// validateClickTrack checks the format of the click track.
+func validateClickTrack(format string) error {
+	switch format {
+	case "", "wav", "mid":
+		return nil
+	}
+	return fmt.Errorf("invalid click track format %q, expected wav or mid", format)
+}
+
+// writeClickWAV writes the beats as clicks in a 16 bit mono WAV file lasting
+// duration.
+func writeClickWAV(beats []beatgrid.Beat, duration float64, outputPath string) error {
+	samples := make([]int16, int(math.Ceil(duration*clickSampleRate)))
+	clickLength := int(clickDuration * clickSampleRate)
+	for _, beat := range beats {
+		frequency := 1000.0
+		if beat.Downbeat {
+			frequency = 1500
+		}
+		start := int(math.Round(beat.Time * clickSampleRate))
+		for i := 0; i < clickLength && start+i < len(samples); i++ {
+			// a short fade out keeps the end of the click from popping
+			envelope := 1 - float64(i)/float64(clickLength)
+			samples[start+i] = int16(0.8 * envelope * math.MaxInt16 * math.Sin(2*math.Pi*frequency*float64(i)/clickSampleRate))
+		}
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	dataSize := uint32(len(samples) * 2)
+	w.WriteString("RIFF")
+	binary.Write(w, binary.LittleEndian, 36+dataSize)
+	w.WriteString("WAVEfmt ")
+	// 16 bytes of PCM format, mono
+	binary.Write(w, binary.LittleEndian, struct {
+		Size           uint32
+		Format         uint16
+		Channels       uint16
+		SampleRate     uint32
+		BytesPerSecond uint32
+		BlockAlign     uint16
+		BitsPerSample  uint16
+	}{16, 1, 1, clickSampleRate, clickSampleRate * 2, 2, 16})
+	w.WriteString("data")
+	binary.Write(w, binary.LittleEndian, dataSize)
+	binary.Write(w, binary.LittleEndian, samples)
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// midiTrack writes the events of a MIDI track.
+type midiTrack struct {
+	bytes.Buffer
+	tick int
+}
+
+// event writes an event at the tick, after the previous one.
+func (t *midiTrack) event(tick int, data ...byte) {
+	delta := uint32(tick - t.tick)
+	t.tick = tick
+	// variable length quantity, 7 bits at a time with the highest first
+	var quantity []byte
+	quantity = append(quantity, byte(delta&0x7f))
+	for delta >>= 7; delta > 0; delta >>= 7 {
+		quantity = append([]byte{byte(delta&0x7f) | 0x80}, quantity...)
+	}
+	t.Write(quantity)
+	t.Write(data)
+}
+
+// tempo writes the tempo at the tick, in microseconds per quarter note.
+func (t *midiTrack) tempo(tick int, microseconds int) {
+	t.event(tick, 0xff, 0x51, 3, byte(microseconds>>16), byte(microseconds>>8), byte(microseconds))
+}
+
+// writeClickMIDI writes the beats as metronome notes in a MIDI file, the
+// tempo following the grid from beat to beat. The bars of the file match the
+// bars of the grid, a lead-in before the first beat filling the start of its
+// bar.
+func writeClickMIDI(grid beatGrid, beats []beatgrid.Beat, outputPath string) error {
+	if len(beats) == 0 {
+		return fmt.Errorf("the grid has no beat in the music")
+	}
+	m := grid.Meter.OrDefault()
+	unit := m.Unit
+	if unit <= 0 || unit > 32 || bits.OnesCount(uint(unit)) != 1 {
+		unit = 4
+	}
+	ticksPerBeat := midiTicksPerQuarter * 4 / unit
+	// the microseconds per quarter note of a beat lasting the duration
+	quarter := func(beatSeconds float64) int {
+		return min(int(math.Round(beatSeconds*1e6*float64(unit)/4)), 1<<24-1)
+	}
+
+	var track midiTrack
+	beatsPerBar := m.BeatsPerBar()
+	track.event(0, 0xff, 0x58, 4, byte(min(beatsPerBar, 255)), byte(bits.TrailingZeros(uint(unit))), 24, 8)
+	tick := 0
+	if first := beats[0]; first.Time > 0 {
+		leadIn := int(math.Mod(first.Position, float64(beatsPerBar))+float64(beatsPerBar)) % beatsPerBar
+		if leadIn == 0 {
+			leadIn = beatsPerBar
+		}
+		track.tempo(0, quarter(first.Time/float64(leadIn)))
+		tick = leadIn * ticksPerBeat
+	}
+	lastTempo := -1
+	for i, beat := range beats {
+		beatSeconds := grid.BeatTime(beat.Position+1) - grid.BeatTime(beat.Position)
+		if i+1 < len(beats) {
+			beatSeconds = beats[i+1].Time - beat.Time
+		}
+		if tempo := quarter(beatSeconds); tempo != lastTempo {
+			track.tempo(tick, tempo)
+			lastTempo = tempo
+		}
+		note := byte(midiMetronomeClick)
+		if beat.Downbeat {
+			note = midiMetronomeBell
+		}
+		// notes on the percussion channel
+		track.event(tick, 0x99, note, 100)
+		track.event(tick+ticksPerBeat/4, 0x89, note, 0)
+		tick += ticksPerBeat
+	}
+	track.event(tick, 0xff, 0x2f, 0)
+
+	var file bytes.Buffer
+	file.WriteString("MThd")
+	// a single track file
+	binary.Write(&file, binary.BigEndian, struct {
+		Size     uint32
+		Format   uint16
+		Tracks   uint16
+		Division uint16
+	}{6, 0, 1, midiTicksPerQuarter})
+	file.WriteString("MTrk")
+	binary.Write(&file, binary.BigEndian, uint32(track.Len()))
+	file.Write(track.Bytes())
+	return os.WriteFile(outputPath, file.Bytes(), 0644)
+}
+
+// exportClickTrack writes the click track of the grid, lasting duration, in
+// the format of ClickTrack.
+func exportClickTrack(grid beatGrid, duration float64, outputPath string) error {
+	beats := grid.Beats(duration)
+	var err error
+	if ClickTrack == "mid" {
+		err = writeClickMIDI(grid, beats, outputPath)
+	} else {
+		err = writeClickWAV(beats, duration, outputPath)
+	}
+	if err != nil {
+		return err
+	}
+	say("click.saved", outputPath, len(beats))
+	recordOutput(outputPath)
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strings"
+)
+
+// Clip is one entry of a clip manifest: a section of a video filling a
+// number of bars of the edit.
+type Clip struct {
+	Path string `json:"path"`
+	// In and Out are the section of the video used, in seconds. Out 0 is
+	// the end of the video.
+	In  float64 `json:"in"`
+	Out float64 `json:"out"`
+	// Bars is the number of bars the clip fills, it's retimed to last
+	// exactly that long. 0 picks the number of bars closest to the length
+	// of the section at its original speed.
+	Bars int `json:"bars"`
+	// Filter is an ffmpeg filter chain applied to the clip only, overriding
+	// the look of the edit for it (e.g. "hue=s=0" for black and white).
+	Filter string `json:"filter,omitempty"`
+}
+
+// plannedClip is a clip placed on the music timeline.
+type plannedClip struct {
+	Clip
+	// Start and End are the times of the clip in the music.
+	Start float64
+	End   float64
+	// Beats is the number of beats the clip fills.
+	Beats float64
+	// SpeedFactor stretches the section to the bars, above 1 slows it down.
+	SpeedFactor float64
+}
+
+// readClips reads the clips from a JSON manifest.
+func readClips(filePath string) ([]Clip, error) {
+	var clips []Clip
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &clips); err != nil {
+		return nil, err
+	}
+	if len(clips) == 0 {
+		return nil, fmt.Errorf("no clips found in %s", filePath)
+	}
+	for i, clip := range clips {
+		if clip.Path == "" {
+			return nil, fmt.Errorf("clip %d has no path", i)
+		}
+		if clip.In < 0 || (clip.Out != 0 && clip.Out <= clip.In) {
+			return nil, fmt.Errorf("clip %d (%s) has invalid in and out points %.2fs - %.2fs", i, clip.Path, clip.In, clip.Out)
+		}
+		if clip.Bars < 0 {
+			return nil, fmt.Errorf("clip %d (%s) has a negative number of bars", i, clip.Path)
+		}
+	}
+	return clips, nil
+}
+
+// planClips places the clips one after the other on the bars of the grid,
+// starting on its first beat. durations are the durations of the videos of
+// the clips. The recipe, when not nil, sets the length of the clips and
+// repeats them until musicDuration if it fills the music.
+func planClips(clips []Clip, grid beatGrid, durations []float64, recipe *Recipe, musicDuration float64) ([]plannedClip, error) {
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	var planned []plannedClip
+	beat := 0.0
+	fill := recipe != nil && recipe.Fill && musicDuration > 0
+	for i := 0; ; i++ {
+		if fill && grid.BeatTime(beat) >= musicDuration {
+			break
+		}
+		if i >= len(clips) {
+			if !fill {
+				break
+			}
+			// start over with the first clip
+			i = 0
+		}
+		clip := clips[i]
+		if clip.Out == 0 || clip.Out > durations[i] {
+			clip.Out = durations[i]
+		}
+		if clip.In >= clip.Out {
+			return nil, fmt.Errorf("clip %d (%s) starts at %.2fs, after the end of the %.2fs video", i, clip.Path, clip.In, durations[i])
+		}
+		start := grid.BeatTime(beat)
+		var beats float64
+		switch {
+		case recipe != nil:
+			beats = recipe.clipBars(grid, start) * beatsPerBar
+		case clip.Bars > 0:
+			beats = float64(clip.Bars) * beatsPerBar
+		default:
+			barDuration := grid.BeatTime(beat+beatsPerBar) - start
+			beats = max(1, math.Round((clip.Out-clip.In)/barDuration)) * beatsPerBar
+		}
+		beat += beats
+		end := grid.BeatTime(beat)
+		if fill && end > musicDuration {
+			// the last clip stops with the music
+			end = musicDuration
+		}
+		planned = append(planned, plannedClip{
+			Clip:        clip,
+			Start:       start,
+			End:         end,
+			Beats:       beats,
+			SpeedFactor: (end - start) / (clip.Out - clip.In),
+		})
+	}
+	return planned, nil
+}
+
+// renderClips renders the planned clips into a single video, each one
+// retimed to its bars and normalized to the canvas, effects are applied to
+// the whole edit.
+func renderClips(planned []plannedClip, effects []string, audioPath string, outputPath string) error {
+	dimensions, err := getVideoDimensions(planned[0].Path)
+	if err != nil {
+		return fmt.Errorf("failed to get dimensions of %s: %v", planned[0].Path, err)
+	}
+	canvas := canvasSettings{Width: dimensions.Width, Height: dimensions.Height}
+	if Canvas != "" {
+		if canvas, err = parseCanvas(Canvas); err != nil {
+			return err
+		}
+	}
+	if canvas.FPS == 0 {
+		if canvas.FPS = getVideoFrameRate(planned[0].Path); canvas.FPS == 0 {
+			canvas.FPS = slideshowFPS
+		}
+	}
+
+	// the clips are converted to the colors of the first one
+	detectSourceColor(planned[0].Path)
+	var colors string
+	if SourceColor != (colorSettings{}) {
+		colors = "," + colorConvertFilter(SourceColor, "")
+	}
+
+	var filterComplexParts []string
+	var concatParts []string
+	for i, clip := range planned {
+		var override string
+		if clip.Filter != "" {
+			override = "," + clip.Filter
+		}
+		filterComplexParts = append(filterComplexParts, fmt.Sprintf(
+			"[%d:v]trim=start=%s:end=%s,setpts=(PTS-STARTPTS)*%s,%s%s%s[v%d]; ",
+			i, seconds(clip.In), seconds(clip.Out), exact(clip.SpeedFactor), normalizeFilter(canvas, ""), override, colors, i,
+		))
+		concatParts = append(concatParts, fmt.Sprintf("[v%d]", i))
+	}
+	var effectsChain string
+	if len(effects) > 0 {
+		effectsChain = "," + strings.Join(effects, ",")
+	}
+	filterComplexParts = append(filterComplexParts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0%s%s[outv]", strings.Join(concatParts, ""), len(concatParts), effectsChain, videoOffsetFilter()))
+	filterComplex := strings.Join(filterComplexParts, "")
+
+	cmdArgs := []string{"-y"}
+	for _, clip := range planned {
+		cmdArgs = append(cmdArgs, "-i", clip.Path)
+	}
+	start := planned[0].Start
+	totalDuration := planned[len(planned)-1].End - start
+	if audioPath != "" {
+		// the edit starts on the first beat, skip the music up to that point
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-ss", seconds(start).timestamp(), "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", filterComplex,
+		"-map", "[outv]",
+	)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("%d:a", len(planned)))
+		cmdArgs = append(cmdArgs, audioMuxArgs()...)
+	} else {
+		cmdArgs = append(cmdArgs, "-an")
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	cmdArgs = append(cmdArgs,
+		"-t", seconds(totalDuration+max(AVOffset, 0)).timestamp(),
+		outputPath,
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("clips.start", len(planned), totalDuration)
+	if err := runFFmpeg("clips", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("clips.saved", outputPath)
+	recordOutput(outputPath)
+
+	return nil
+}
+
+// syncClips builds the edit described by the clips manifest at clipsPath,
+// each clip filling its bars of the music or following the recipe when it's
+// not nil.
+func syncClips(clipsPath string, grid beatGrid, audioPath string, outputPath string, recipe *Recipe) error {
+	clips, err := readClips(clipsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read the clips: %v", err)
+	}
+	durations := make([]float64, len(clips))
+	for i, clip := range clips {
+		if durations[i], err = getVideoDuration(clip.Path); err != nil {
+			return fmt.Errorf("failed to get duration of %s: %v", clip.Path, err)
+		}
+	}
+	var musicDuration float64
+	if audioPath != "" {
+		if musicDuration, err = getVideoDuration(audioPath); err != nil {
+			return fmt.Errorf("failed to get audio duration: %v", err)
+		}
+	}
+	planned, err := planClips(clips, grid, durations, recipe, musicDuration)
+	if err != nil {
+		return err
+	}
+	for i, clip := range planned {
+		say("clips.clip", i, clip.Start, clip.End, clip.Beats, clip.Path, clip.In, clip.Out, clip.SpeedFactor)
+	}
+	var effects []string
+	if recipe != nil {
+		if recipe.Flash != "" {
+			// the edit starts on the first planned beat of the music
+			effects = append(effects, flashFilter(grid, recipe.Flash, planned[0].Start))
+		}
+		say("recipe.applied", recipe.Name, len(planned))
+	}
+	return renderClips(planned, effects, audioPath, outputPath)
+}
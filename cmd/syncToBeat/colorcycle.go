@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// colorCycleOptions configures the color changes happening on the bars.
+type colorCycleOptions struct {
+	// Mode is "hue" to rotate the hue or "tints" to switch between Tints,
+	// the effect is disabled when empty.
+	Mode string
+	// EveryBars is the number of bars between color changes.
+	EveryBars int
+	// HueStep is the hue rotation, in degrees, added at every change.
+	HueStep float64
+	// Tints are the names of the colorTints cycled through.
+	Tints []string
+}
+
+// ColorCycle configures the color changes applied to the synced video.
+var ColorCycle = colorCycleOptions{EveryBars: 1, HueStep: 60, Tints: []string{"warm", "cool"}}
+
+// colorTints maps the tint names to colorbalance settings.
+var colorTints = map[string]string{
+	"none":    "",
+	"warm":    "rs=0.15:rm=0.1:bs=-0.15:bm=-0.1",
+	"cool":    "rs=-0.15:rm=-0.1:bs=0.15:bm=0.1",
+	"magenta": "rm=0.15:gm=-0.15:bm=0.15",
+	"green":   "rm=-0.1:gm=0.15:bm=-0.1",
+	"teal":    "rs=-0.2:gs=0.05:bs=0.1:rh=0.1:bh=-0.1",
+	"sepia":   "rs=0.2:gs=0.1:bs=-0.2:rm=0.15:bm=-0.15",
+}
+
+// parseTints parses a comma separated list of tint names.
+func parseTints(value string) ([]string, error) {
+	var tints []string
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := colorTints[name]; !ok {
+			var names []string
+			for known := range colorTints {
+				names = append(names, known)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown tint %q, expected one of %s", name, strings.Join(names, ", "))
+		}
+		tints = append(tints, name)
+	}
+	return tints, nil
+}
+
+// colorCycleFilter returns the filter chain changing the colors of the video
+// every opts.EveryBars bars of the grid.
+func colorCycleFilter(grid beatGrid, opts colorCycleOptions) (string, error) {
+	if opts.EveryBars < 1 {
+		return "", fmt.Errorf("invalid color cycle length: %d bars", opts.EveryBars)
+	}
+	// the number of color changes since the first beat
+	step := fmt.Sprintf("floor(%s/%d)", positionExpression(grid, "t"), grid.Meter.BeatsPerBar()*opts.EveryBars)
+
+	switch opts.Mode {
+	case "hue":
+		return fmt.Sprintf("hue=h='%s*%s'", exact(opts.HueStep), step), nil
+	case "tints":
+		if len(opts.Tints) == 0 {
+			return "", fmt.Errorf("no tints to cycle through")
+		}
+		var filters []string
+		for i, tint := range opts.Tints {
+			settings, ok := colorTints[tint]
+			if !ok {
+				return "", fmt.Errorf("unknown tint %q", tint)
+			}
+			if settings == "" {
+				continue
+			}
+			filters = append(filters, fmt.Sprintf("colorbalance=%s:enable='eq(mod(%s,%d),%d)'", settings, step, len(opts.Tints), i))
+		}
+		return strings.Join(filters, ","), nil
+	}
+	return "", fmt.Errorf("invalid color cycle %q, expected hue or tints", opts.Mode)
+}
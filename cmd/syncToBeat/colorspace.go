@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// colorSettings are the color properties of a video, as named by ffmpeg.
+type colorSettings struct {
+	Primaries string
+	Transfer  string
+	// Matrix is the YUV matrix (the colorspace in ffmpeg's terms).
+	Matrix string
+	// Range is tv for limited range or pc for full range.
+	Range string
+}
+
+// SourceColor holds the color properties of the source, propagated to the
+// filter graphs and the encoder. The zero value leaves the colors to ffmpeg.
+var SourceColor colorSettings
+
+// colorFamilies are the color properties implied by a YUV matrix.
+var colorFamilies = map[string]colorSettings{
+	"bt709":     {Primaries: "bt709", Transfer: "bt709", Matrix: "bt709", Range: "tv"},
+	"smpte170m": {Primaries: "smpte170m", Transfer: "smpte170m", Matrix: "smpte170m", Range: "tv"},
+	"bt470bg":   {Primaries: "bt470bg", Transfer: "smpte170m", Matrix: "bt470bg", Range: "tv"},
+	"bt2020nc":  {Primaries: "bt2020", Transfer: "bt2020-10", Matrix: "bt2020nc", Range: "tv"},
+	"bt2020c":   {Primaries: "bt2020", Transfer: "bt2020-10", Matrix: "bt2020c", Range: "tv"},
+}
+
+// guessColor returns the color properties players assume for a video with
+// no color metadata: BT.709 for HD, BT.601 for SD.
+func guessColor(height int) colorSettings {
+	switch {
+	case height >= 720:
+		return colorFamilies["bt709"]
+	case height == 576:
+		return colorFamilies["bt470bg"]
+	}
+	return colorFamilies["smpte170m"]
+}
+
+// videoColor returns the color properties of the video stream, the missing
+// ones are taken from its matrix or guessed from its height.
+func videoColor(video probe.Stream) colorSettings {
+	color := colorSettings{
+		Primaries: video.ColorPrimaries,
+		Transfer:  video.ColorTransfer,
+		Matrix:    video.ColorSpace,
+		Range:     video.ColorRange,
+	}
+	defaults, ok := colorFamilies[color.Matrix]
+	if !ok {
+		defaults = guessColor(video.Height)
+	}
+	if color.Primaries == "" && color.Transfer == "" && color.Matrix == "" {
+		say("color.guessed", defaults.Matrix, video.Height)
+	}
+	if color.Primaries == "" {
+		color.Primaries = defaults.Primaries
+	}
+	if color.Transfer == "" {
+		color.Transfer = defaults.Transfer
+	}
+	if color.Matrix == "" {
+		color.Matrix = defaults.Matrix
+	}
+	if color.Range == "" {
+		color.Range = defaults.Range
+	}
+	return color
+}
+
+// detectSourceColor sets SourceColor from the video, it's left as is when the
+// video can't be probed.
+func detectSourceColor(videoPath string) {
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return
+	}
+	if video, ok := info.Video(); ok {
+		SourceColor = videoColor(video)
+	}
+}
+
+// colorArgs returns the output options tagging the encoded video with the
+// color properties.
+func colorArgs(color colorSettings) []string {
+	if color == (colorSettings{}) {
+		return nil
+	}
+	return []string{
+		"-color_primaries", color.Primaries,
+		"-color_trc", color.Transfer,
+		"-colorspace", color.Matrix,
+		"-color_range", color.Range,
+	}
+}
+
+// colorTagFilter returns the filter tagging the frames with the color
+// properties so the filters drawing colors (drawtext, drawbox, overlay...)
+// convert them with the right matrix, empty when they're unknown.
+func colorTagFilter(color colorSettings) string {
+	if color == (colorSettings{}) {
+		return ""
+	}
+	return fmt.Sprintf("setparams=range=%s:color_primaries=%s:color_trc=%s:colorspace=%s", color.Range, color.Primaries, color.Transfer, color.Matrix)
+}
+
+// scaleMatrix returns the name of the matrix for the scale filter, empty
+// when it can't convert to it.
+func scaleMatrix(matrix string) string {
+	switch matrix {
+	case "bt709", "smpte170m", "smpte240m", "fcc":
+		return matrix
+	case "bt470bg":
+		return "bt470"
+	case "bt2020nc", "bt2020c":
+		return "bt2020"
+	}
+	return ""
+}
+
+// colorConvertFilter returns the filters converting frames, such as photos,
+// lavfi color sources or footage from other cameras, to the YUV matrix and
+// range of color instead of ffmpeg's BT.601 default. The frames are converted
+// to pixFmt unless it's empty.
+func colorConvertFilter(color colorSettings, pixFmt string) string {
+	var filters []string
+	if matrix := scaleMatrix(color.Matrix); matrix != "" {
+		filters = append(filters, fmt.Sprintf("scale=out_color_matrix=%s:out_range=%s", matrix, color.Range))
+	}
+	if pixFmt != "" {
+		filters = append(filters, "format="+pixFmt)
+	}
+	if tag := colorTagFilter(color); tag != "" {
+		filters = append(filters, tag)
+	}
+	return strings.Join(filters, ",")
+}
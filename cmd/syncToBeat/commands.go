@@ -0,0 +1,226 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const commandsUsage = `Usage:
+  <program> sync -bpm BPM|auto|-beat-times beats.json -video video -keyframes keyframes [-audio music] [-o output] [flags]
+        retime the video so its keyframes land on the beats of the music
+  <program> plan -bpm BPM|auto -video video -keyframes keyframes [-audio music] [-o plan.json] [flags]
+        print the plan report without rendering, writing the plan to -o when set
+  <program> pulse -bpm BPM|auto -video video [-audio music] [-o output] [flags]
+        flash the video on the beats of the grid, without retiming it
+  <program> analyze -audio music [flags]
+        detect the tempo of the music, its beats and the grid fitted to them
+
+The keyframes (.json, .csv or .txt) or the music can be - to read them from stdin.
+With -bpm auto, the tempo and the first beat are detected in the music. With
+-beat-times, the grid follows the times of the beats listed in a JSON array.
+The flags can come before or after the command. Without a command, the
+arguments are read as BPM video keyframes [music].`
+
+// commands are the subcommands taking the media of the run as flags.
+var commands = []string{"sync", "plan", "pulse", "analyze"}
+
+// runInputs are the media of a run given by flags.
+type runInputs struct {
+	// BPM is the tempo of the grid.
+	BPM string
+	// Video is the source to retime.
+	Video string
+	// Keyframes is the file listing the keyframes of the video.
+	Keyframes string
+	// Audio is the music, the output is silent without it.
+	Audio string
+}
+
+// Inputs are the media of the run.
+var Inputs runInputs
+
+// parseCommand returns the command starting the positional arguments, if
+// any, and the arguments left once the flags following it are parsed.
+func parseCommand(args []string) (string, []string) {
+	if len(args) == 0 || !slices.Contains(commands, args[0]) {
+		return "", args
+	}
+	// the flags can come before or after the command
+	flag.CommandLine.Parse(args[1:])
+	return args[0], flag.Args()
+}
+
+// resolveInputs returns the command of the run and its inputs, read from the
+// positional arguments when no command is given.
+func resolveInputs(command string, args []string) (string, runInputs, error) {
+	inputs := Inputs
+	if command != "" && len(args) > 0 {
+		return "", inputs, fmt.Errorf("unexpected argument %q, the %s command takes its inputs as flags", args[0], command)
+	}
+	if command == "" {
+		command = "sync"
+		if len(args) > 0 {
+			if inputs != (runInputs{}) {
+				return "", inputs, fmt.Errorf("the inputs are given either as flags or as arguments, not both")
+			}
+			if len(args) < 3 {
+				return "", inputs, fmt.Errorf("expected BPM video keyframes [music], %d arguments given", len(args))
+			}
+			inputs = runInputs{BPM: args[0], Video: args[1], Keyframes: args[2]}
+			if len(args) >= 4 {
+				inputs.Audio = args[3]
+			}
+		}
+	}
+
+	var missing []string
+	need := func(value string, name string) {
+		if value == "" {
+			missing = append(missing, "-"+name)
+		}
+	}
+	// the beat times set the tempo
+	if BeatTimesPath == "" && (command == "sync" || command == "plan" || command == "pulse") {
+		need(inputs.BPM, "bpm")
+	}
+	switch command {
+	case "sync", "plan":
+		need(inputs.Video, "video")
+		need(inputs.Keyframes, "keyframes")
+	case "pulse":
+		need(inputs.Video, "video")
+	case "analyze":
+		need(inputs.Audio, "audio")
+	}
+	if len(missing) > 0 {
+		return "", inputs, fmt.Errorf("the %s command needs %s", command, strings.Join(missing, ", "))
+	}
+	if inputs.BPM == AutoBPM && BeatTimesPath == "" && inputs.Audio == "" {
+		return "", inputs, fmt.Errorf("-bpm %s needs the music to detect the tempo from", AutoBPM)
+	}
+	if inputs.Keyframes == StdinPath && inputs.Audio == StdinPath {
+		return "", inputs, fmt.Errorf("the keyframes and the music can't both be read from stdin")
+	}
+	return command, inputs, nil
+}
+
+// field returns the input of the flag named name.
+func (in *runInputs) field(name string) *string {
+	switch name {
+	case "bpm":
+		return &in.BPM
+	case "video":
+		return &in.Video
+	case "keyframes":
+		return &in.Keyframes
+	default:
+		return &in.Audio
+	}
+}
+
+// resolveModeInputs returns the inputs of the modes set by a flag, -angles or
+// -clips for instance, read without a command from the positional arguments
+// named by fields, the first required of them needed.
+func resolveModeInputs(mode string, command string, args []string, fields []string, required int) (runInputs, error) {
+	inputs := Inputs
+	if len(args) > 0 {
+		if command != "" {
+			return inputs, fmt.Errorf("unexpected argument %q, the %s command takes its inputs as flags", args[0], command)
+		}
+		if inputs != (runInputs{}) {
+			return inputs, fmt.Errorf("the inputs are given either as flags or as arguments, not both")
+		}
+		for i, name := range fields[:min(len(args), len(fields))] {
+			*inputs.field(name) = args[i]
+		}
+	}
+	var missing []string
+	for _, name := range fields[:required] {
+		// the beat times set the tempo
+		if *inputs.field(name) == "" && (name != "bpm" || BeatTimesPath == "") {
+			missing = append(missing, "-"+name)
+		}
+	}
+	if len(missing) > 0 {
+		return inputs, fmt.Errorf("%s needs %s", mode, strings.Join(missing, ", "))
+	}
+	if inputs.BPM == AutoBPM && BeatTimesPath == "" && inputs.Audio == "" {
+		return inputs, fmt.Errorf("-bpm %s needs the music to detect the tempo from", AutoBPM)
+	}
+	return inputs, nil
+}
+
+// parseBPM parses the tempo of the grid, 0 when not given or detected.
+func parseBPM(value string) (float64, error) {
+	if value == "" || value == AutoBPM {
+		return 0, nil
+	}
+	bpm, err := strconv.ParseFloat(value, 64)
+	if err != nil || bpm <= 0 {
+		return 0, fmt.Errorf("invalid BPM %q", value)
+	}
+	return bpm, nil
+}
+
+// runPulse flashes the video on the beats of the grid.
+func runPulse(grid beatGrid, videoPath string, audioPath string) error {
+	detectSourceColor(videoPath)
+	outputPath := Output.Path
+	if outputPath == "" {
+		name, extension := splitExtension(filepath.Base(videoPath))
+		var err error
+		if outputPath, err = Output.path(filepath.Dir(videoPath), name, outputExtension(extension), "pulse", grid.BPM); err != nil {
+			return err
+		}
+	}
+	outputPath, err := checkContainer(outputPath, audioPath)
+	if err != nil {
+		return err
+	}
+	return addPulseToVideo(videoPath, grid, audioPath, outputPath)
+}
+
+// runPlan prints the plan report of the video, and writes the plan when an
+// output path is set.
+func runPlan(grid beatGrid, videoPath string, keyframes []Keyframe) error {
+	segments, err := planVideo(grid, videoPath, keyframes)
+	if err != nil {
+		return err
+	}
+	if Output.Path == "" {
+		return nil
+	}
+	if err := writePlan(Output.Path, grid, keyframes, segments); err != nil {
+		return fmt.Errorf("failed to write the plan: %v", err)
+	}
+	recordOutput(Output.Path)
+	return nil
+}
+
+// analyzeMusic reports the tempo of the music, its beats, found by the beats
+// plugin or the onset detection, and the grid fitted to them.
+func analyzeMusic(grid beatGrid, audioPath string) error {
+	if _, err := detectTempo(audioPath); err != nil {
+		return err
+	}
+	beats, err := pluginBeats(grid, audioPath)
+	if err != nil {
+		return err
+	}
+	if beats == nil {
+		if beats, err = detectBeats(audioPath); err != nil {
+			return err
+		}
+	}
+	times := make([]string, len(beats))
+	for i, beat := range beats {
+		times[i] = strconv.FormatFloat(beat, 'f', 3, 64)
+	}
+	say("analyze.beats", len(beats), strings.Join(times, ","))
+	say("analyze.grid", fitGridToBeats(grid, beats).String())
+	return nil
+}
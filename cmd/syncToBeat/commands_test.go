@@ -0,0 +1,163 @@
+package main
+
+import "testing"
+
+func TestParseBPM(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    float64
+		wantErr bool
+	}{
+		{value: "120", want: 120},
+		{value: "92.5", want: 92.5},
+		{value: "", want: 0},
+		{value: AutoBPM, want: 0},
+		{value: "0", wantErr: true},
+		{value: "-90", wantErr: true},
+		{value: "fast", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseBPM(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBPM(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBPM(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// withInputs sets the inputs given by flags and the beat times for the test.
+func withInputs(t *testing.T, inputs runInputs, beatTimes string) {
+	savedInputs, savedBeatTimes := Inputs, BeatTimesPath
+	Inputs, BeatTimesPath = inputs, beatTimes
+	t.Cleanup(func() { Inputs, BeatTimesPath = savedInputs, savedBeatTimes })
+}
+
+func TestResolveInputs(t *testing.T) {
+	tests := []struct {
+		name        string
+		flags       runInputs
+		beatTimes   string
+		command     string
+		args        []string
+		wantCommand string
+		want        runInputs
+		wantErr     bool
+	}{
+		{
+			name:        "positional arguments",
+			args:        []string{"120", "video.mp4", "keyframes.json"},
+			wantCommand: "sync",
+			want:        runInputs{BPM: "120", Video: "video.mp4", Keyframes: "keyframes.json"},
+		},
+		{
+			name:        "positional arguments with music",
+			args:        []string{"auto", "video.mp4", "keyframes.json", "music.wav"},
+			wantCommand: "sync",
+			want:        runInputs{BPM: "auto", Video: "video.mp4", Keyframes: "keyframes.json", Audio: "music.wav"},
+		},
+		{
+			name:        "flags",
+			flags:       runInputs{BPM: "120", Video: "video.mp4"},
+			command:     "pulse",
+			wantCommand: "pulse",
+			want:        runInputs{BPM: "120", Video: "video.mp4"},
+		},
+		{
+			name:        "beat times instead of the tempo",
+			flags:       runInputs{Video: "video.mp4", Keyframes: "keyframes.json"},
+			beatTimes:   "beats.json",
+			command:     "plan",
+			wantCommand: "plan",
+			want:        runInputs{Video: "video.mp4", Keyframes: "keyframes.json"},
+		},
+		{
+			name:        "analyze",
+			flags:       runInputs{Audio: "music.wav"},
+			command:     "analyze",
+			wantCommand: "analyze",
+			want:        runInputs{Audio: "music.wav"},
+		},
+		{name: "arguments after a command", command: "sync", args: []string{"120"}, wantErr: true},
+		{name: "flags and arguments", flags: runInputs{BPM: "120"}, args: []string{"120", "video.mp4", "keyframes.json"}, wantErr: true},
+		{name: "too few arguments", args: []string{"120", "video.mp4"}, wantErr: true},
+		{name: "missing keyframes", flags: runInputs{BPM: "120", Video: "video.mp4"}, command: "sync", wantErr: true},
+		{name: "missing music", command: "analyze", wantErr: true},
+		{name: "auto without music", args: []string{"auto", "video.mp4", "keyframes.json"}, wantErr: true},
+		{name: "keyframes and music from stdin", args: []string{"120", "video.mp4", StdinPath, StdinPath}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withInputs(t, tt.flags, tt.beatTimes)
+			command, got, err := resolveInputs(tt.command, tt.args)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if command != tt.wantCommand || got != tt.want {
+				t.Errorf("resolveInputs() = %q, %+v, want %q, %+v", command, got, tt.wantCommand, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveModeInputs(t *testing.T) {
+	fields := []string{"bpm", "video", "keyframes", "audio"}
+	tests := []struct {
+		name      string
+		flags     runInputs
+		beatTimes string
+		command   string
+		args      []string
+		required  int
+		want      runInputs
+		wantErr   bool
+	}{
+		{
+			name:     "positional arguments",
+			args:     []string{"120", "video.mp4"},
+			required: 2,
+			want:     runInputs{BPM: "120", Video: "video.mp4"},
+		},
+		{
+			name:     "optional arguments",
+			args:     []string{"120", "video.mp4", "keyframes.json", "music.wav"},
+			required: 2,
+			want:     runInputs{BPM: "120", Video: "video.mp4", Keyframes: "keyframes.json", Audio: "music.wav"},
+		},
+		{
+			name:     "flags",
+			flags:    runInputs{BPM: "auto", Video: "video.mp4", Audio: "music.wav"},
+			required: 2,
+			want:     runInputs{BPM: "auto", Video: "video.mp4", Audio: "music.wav"},
+		},
+		{
+			name:      "beat times instead of the tempo",
+			flags:     runInputs{Video: "video.mp4"},
+			beatTimes: "beats.json",
+			required:  2,
+			want:      runInputs{Video: "video.mp4"},
+		},
+		{name: "arguments after a command", command: "sync", args: []string{"120", "video.mp4"}, required: 2, wantErr: true},
+		{name: "flags and arguments", flags: runInputs{Video: "video.mp4"}, args: []string{"120"}, required: 1, wantErr: true},
+		{name: "missing video", flags: runInputs{BPM: "120"}, required: 2, wantErr: true},
+		{name: "auto without music", args: []string{"auto", "video.mp4"}, required: 2, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withInputs(t, tt.flags, tt.beatTimes)
+			got, err := resolveModeInputs("-test", tt.command, tt.args, fields, tt.required)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveModeInputs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("resolveModeInputs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// RemapContainer switches the output to a compatible container when the
+// codecs can't be stored in the requested one, instead of failing.
+var RemapContainer = false
+
+// containerCodecs lists the codecs each container can store, nil accepts
+// every codec.
+var containerCodecs = map[string]struct {
+	video []string
+	audio []string
+}{
+	".mp4":  {video: []string{"h264", "hevc", "av1", "vp9", "mpeg4"}, audio: []string{"aac", "mp3", "opus", "ac3", "eac3", "alac", "flac"}},
+	".m4v":  {video: []string{"h264", "hevc", "mpeg4"}, audio: []string{"aac", "mp3", "ac3", "eac3", "alac"}},
+	".mov":  {video: []string{"h264", "hevc", "prores", "mpeg4", "mjpeg"}, audio: []string{"aac", "mp3", "alac", "pcm", "ac3"}},
+	".webm": {video: []string{"vp8", "vp9", "av1"}, audio: []string{"opus", "vorbis"}},
+	".avi":  {video: []string{"h264", "mpeg4", "mjpeg"}, audio: []string{"mp3", "pcm", "ac3"}},
+	".mkv":  {},
+}
+
+// OutputContainer is the container of the outputs (mp4, mov, mkv or webm),
+// empty keeps the container of the source.
+var OutputContainer = ""
+
+// containerDefaults are the flags adjusted for a container when they aren't
+// set explicitly.
+var containerDefaults = map[string]map[string]string{
+	"mp4":  {},
+	"mov":  {},
+	"mkv":  {},
+	"webm": {"codec": "libvpx-vp9", "encoder-preset": "", "crf": "32", "audio-codec": "libopus"},
+}
+
+// validateContainer checks the output container setting.
+func validateContainer(container string) error {
+	if _, ok := containerDefaults[container]; container != "" && !ok {
+		return fmt.Errorf("invalid container %q, expected mp4, mov, mkv or webm", container)
+	}
+	return nil
+}
+
+// applyContainerDefaults adjusts the encoder flags that weren't set
+// explicitly to the output container.
+func applyContainerDefaults(container string, explicit map[string]bool) error {
+	defaults := containerDefaults[container]
+	for _, name := range sortedKeys(defaults) {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, defaults[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputExtension returns the extension of the outputs made from a source
+// with the given extension.
+func outputExtension(sourceExtension string) string {
+	if OutputContainer == "" {
+		return sourceExtension
+	}
+	return "." + OutputContainer
+}
+
+// containerArgs returns the muxer options of the output: MP4 and MOV files
+// get their index at the start so they play while downloading, and MOV files
+// a timecode track for editing software.
+func containerArgs(outputPath string) []string {
+	_, extension := splitExtension(outputPath)
+	switch strings.ToLower(extension) {
+	case ".mp4", ".m4v":
+		return []string{"-movflags", "+faststart"}
+	case ".mov":
+		return []string{"-movflags", "+faststart", "-timecode", "00:00:00:00"}
+	}
+	return nil
+}
+
+// containerPreference is the order in which the containers are tried when
+// remapping.
+var containerPreference = []string{".mp4", ".mov", ".mkv"}
+
+// codecFamily returns the codec produced by an ffmpeg encoder, or the codec
+// itself for names reported by ffprobe.
+func codecFamily(encoder string) string {
+	switch {
+	case encoder == "libx264" || strings.HasPrefix(encoder, "h264"):
+		return "h264"
+	case encoder == "libx265" || strings.HasPrefix(encoder, "hevc"):
+		return "hevc"
+	case encoder == "libvpx" || encoder == "vp8":
+		return "vp8"
+	case encoder == "libvpx-vp9" || strings.HasPrefix(encoder, "vp9"):
+		return "vp9"
+	case encoder == "libaom-av1" || encoder == "libsvtav1" || encoder == "librav1e" || strings.HasPrefix(encoder, "av1"):
+		return "av1"
+	case strings.HasPrefix(encoder, "prores"):
+		return "prores"
+	case encoder == "libxvid" || encoder == "mpeg4":
+		return "mpeg4"
+	case encoder == "libfdk_aac" || encoder == "aac":
+		return "aac"
+	case encoder == "libmp3lame" || encoder == "mp3":
+		return "mp3"
+	case encoder == "libopus" || encoder == "opus":
+		return "opus"
+	case encoder == "libvorbis" || encoder == "vorbis":
+		return "vorbis"
+	case strings.HasPrefix(encoder, "pcm_"):
+		return "pcm"
+	}
+	return encoder
+}
+
+// containerAccepts reports whether the container can store the codec, codecs
+// the table doesn't know about are accepted.
+func containerAccepts(accepted []string, codec string) bool {
+	if accepted == nil || codec == "" {
+		return true
+	}
+	for _, c := range accepted {
+		if c == codec {
+			return true
+		}
+	}
+	return false
+}
+
+// muxedAudioCodec returns the codec of the audio muxed from audioPath, empty
+// when there's no audio or it can't be determined.
+func muxedAudioCodec(audioPath string) string {
+	if audioPath == "" {
+		return ""
+	}
+	if !AudioEncoder.Copy || limitAudio {
+		return codecFamily(AudioEncoder.Codec)
+	}
+	info, err := probe.ProbeMedia(audioPath)
+	if err != nil {
+		return ""
+	}
+	audio, ok := info.Audio()
+	if !ok {
+		return ""
+	}
+	return codecFamily(audio.Codec)
+}
+
+// checkContainer validates that the encoded video and the audio muxed from
+// audioPath can be stored in the container of outputPath. When they can't,
+// the path is switched to a compatible container if RemapContainer is set,
+// otherwise an error explains the problem.
+func checkContainer(outputPath string, audioPath string) (string, error) {
+	name, extension := splitExtension(outputPath)
+	extension = strings.ToLower(extension)
+	videoCodec := codecFamily(Encoder.Codec)
+	audioCodec := muxedAudioCodec(audioPath)
+
+	fits := func(extension string) bool {
+		codecs, ok := containerCodecs[extension]
+		if !ok {
+			// unknown containers are left to ffmpeg
+			return true
+		}
+		return containerAccepts(codecs.video, videoCodec) && containerAccepts(codecs.audio, audioCodec)
+	}
+	if fits(extension) {
+		return outputPath, nil
+	}
+
+	if RemapContainer {
+		for _, candidate := range containerPreference {
+			if fits(candidate) {
+				remapped := name + candidate
+				say("container.remapped", outputPath, remapped)
+				return remapped, nil
+			}
+		}
+	}
+	description := videoCodec + " video"
+	if audioCodec != "" {
+		description += " with " + audioCodec + " audio"
+	}
+	return "", fmt.Errorf("%s can't be stored in a %s file, pick other codecs or use -remap-container to switch to a compatible container", description, extension)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// coverWindow is the duration, in seconds, of the music after each downbeat
+// whose bass energy rates the downbeat.
+const coverWindow = 0.1
+
+// coverOptions holds the settings of the still exported as the cover of the
+// synced video.
+type coverOptions struct {
+	// At picks the frame: "downbeat" for the downbeat with the strongest bass,
+	// or the label of a keyframe for the beat it lands on. Empty disables
+	// the export.
+	At string
+	// Format is the image format: png or jpg.
+	Format string
+}
+
+// Cover is the cover exported with the synced video.
+var Cover = coverOptions{Format: "png"}
+
+// validateCover checks the cover settings.
+func validateCover(opts coverOptions) error {
+	if opts.Format != "png" && opts.Format != "jpg" {
+		return fmt.Errorf("invalid cover format %q, expected png or jpg", opts.Format)
+	}
+	return nil
+}
+
+// strongestDownbeat returns the time of the downbeat, within the first
+// duration seconds, followed by the most bass energy in the music.
+func strongestDownbeat(grid beatGrid, audioPath string, duration float64) (float64, error) {
+	samples, err := decodeAudio(audioPath, bounceSampleRate, duration)
+	if err != nil {
+		return 0, err
+	}
+	bass := lowPass(samples, bounceSampleRate, bounceCutoff)
+	window := int(coverWindow * bounceSampleRate)
+
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	best, bestEnergy := -1.0, -1.0
+	first := math.Ceil(grid.BeatPosition(0) / beatsPerBar)
+	for bar := first; ; bar++ {
+		t := grid.BeatTime(bar * beatsPerBar)
+		start := int(t * bounceSampleRate)
+		if t >= duration || start >= len(bass) {
+			break
+		}
+		if e := energy(bass[start:min(start+window, len(bass))]); e > bestEnergy {
+			best, bestEnergy = t, e
+		}
+	}
+	if best < 0 {
+		return 0, fmt.Errorf("no downbeat within the %.2fs video", duration)
+	}
+	return best, nil
+}
+
+// coverTime returns the time of the synced video the cover is taken at.
+func coverTime(opts coverOptions, grid beatGrid, keyframes []Keyframe, audioPath string, duration float64) (float64, error) {
+	if opts.At == "downbeat" {
+		if audioPath == "" {
+			return 0, fmt.Errorf("the strongest downbeat is found in the music, none was given")
+		}
+		return strongestDownbeat(grid, audioPath, duration)
+	}
+
+	// the plan is computed again, its warnings were already reported
+	quiet = true
+	segments, err := planSegments(grid, keyframes)
+	quiet = false
+	if err != nil {
+		return 0, err
+	}
+	for _, seg := range segments {
+		if keyframes[seg.Keyframe].Label == opts.At {
+			return math.Min(seg.NearestBeatTime, duration), nil
+		}
+	}
+	return 0, fmt.Errorf("no keyframe labeled %q", opts.At)
+}
+
+// exportCover extracts the frame of the synced video picked by opts, at full
+// quality, to outputPath.
+func exportCover(opts coverOptions, grid beatGrid, keyframes []Keyframe, syncedPath string, audioPath string, outputPath string) error {
+	duration, err := getVideoDuration(syncedPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+	t, err := coverTime(opts, grid, keyframes, audioPath, duration)
+	if err != nil {
+		return err
+	}
+
+	cmdArgs := []string{
+		"-y",
+		"-ss", seconds(t).timestamp(),
+		"-i", syncedPath,
+		"-frames:v", "1",
+	}
+	if opts.Format == "jpg" {
+		cmdArgs = append(cmdArgs, "-q:v", "1")
+	}
+	cmdArgs = append(cmdArgs, outputPath)
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+	if err := runFFmpeg("cover", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("cover.saved", outputPath, t)
+	recordOutput(outputPath)
+	return nil
+}
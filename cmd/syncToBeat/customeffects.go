@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// customEffectRate is the number of parameter updates per second of the
+// custom effects.
+const customEffectRate = 25
+
+// customEffectVariables are the variables available in the expressions of
+// the custom effects:
+//
+//	t            time in seconds
+//	beat         position in beats on the grid
+//	bar          number of the bar, starting at 0
+//	beat_phase   progress within the beat, from 0 to 1
+//	bar_phase    progress within the bar, from 0 to 1
+//	beat_in_bar  number of the beat within the bar, starting at 1
+//	energy       loudness of the music, from 0 to 1
+//	bass         loudness of the bass of the music, from 0 to 1
+var customEffectVariables = []string{"t", "beat", "bar", "beat_phase", "bar_phase", "beat_in_bar", "energy", "bass"}
+
+// CustomEffect is an ffmpeg filter whose parameters follow expressions of the
+// beat grid and the music. The parameters must support runtime commands
+// (see the Commands section of the filter in the ffmpeg documentation).
+type CustomEffect struct {
+	Filter string `json:"filter"`
+	// Options are the fixed options of the filter.
+	Options map[string]string `json:"options,omitempty"`
+	// Params maps parameters of the filter to the expressions computing
+	// them, for instance {"s": "1+energy*(1-beat_phase)"} for the hue filter.
+	Params map[string]string `json:"params"`
+	// Input is a video composited picture in picture over the synced
+	// video, for instance a performer cam. Its params are x, y and size, as
+	// fractions of the synced frame, and its options the ones of the
+	// overlay filter.
+	Input string `json:"input,omitempty"`
+}
+
+// CustomEffects are the custom effects applied to the synced video.
+var CustomEffects []CustomEffect
+
+// readCustomEffects reads the custom effects from a JSON file and checks
+// their expressions.
+func readCustomEffects(filePath string) ([]CustomEffect, error) {
+	var effects []CustomEffect
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &effects); err != nil {
+		return nil, err
+	}
+	for _, effect := range effects {
+		if effect.Input != "" {
+			if err := validateOverlay(effect); err != nil {
+				return nil, err
+			}
+		} else if effect.Filter == "" {
+			return nil, fmt.Errorf("custom effect without a filter")
+		}
+		for param, source := range effect.Params {
+			if _, _, err := parseExpression(source, customEffectVariables); err != nil {
+				return nil, fmt.Errorf("%s %s: %v", effect.Filter, param, err)
+			}
+		}
+	}
+	return effects, nil
+}
+
+// sortedKeys returns the keys of the map in order, so the generated filters
+// are stable.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// evaluateEffects evaluates the parameters of the effects over a video of the
+// given duration. It returns the sendcmd script updating the parameters of
+// the effect instances (see effectInstance) and the options of each effect,
+// starting with the initial values of its parameters.
+func evaluateEffects(effects []CustomEffect, grid beatGrid, audioPath string, duration float64) (string, [][]string, error) {
+	type param struct {
+		effect int
+		name   string
+		expr   expression
+		// initial is the value of the parameter at the start of the video
+		initial string
+		last    string
+	}
+	var params []*param
+	uses := map[string]bool{}
+	for i, effect := range effects {
+		for _, name := range sortedKeys(effect.Params) {
+			expr, used, err := parseExpression(effect.Params[name], customEffectVariables)
+			if err != nil {
+				return "", nil, fmt.Errorf("%s %s: %v", effect.Filter, name, err)
+			}
+			for variable := range used {
+				uses[variable] = true
+			}
+			params = append(params, &param{effect: i, name: name, expr: expr})
+		}
+	}
+
+	var energy, bass []float64
+	if uses["energy"] || uses["bass"] {
+		if audioPath == "" {
+			return "", nil, fmt.Errorf("the custom effects use the music energy but no audio was given")
+		}
+		samples, err := decodeAudio(audioPath, bounceSampleRate, duration)
+		if err != nil {
+			return "", nil, err
+		}
+		energy = rmsEnvelope(samples, bounceSampleRate, customEffectRate)
+		bass = rmsEnvelope(lowPass(samples, bounceSampleRate, bounceCutoff), bounceSampleRate, customEffectRate)
+	}
+	level := func(envelope []float64, frame int) float64 {
+		if frame < len(envelope) {
+			return envelope[frame]
+		}
+		return 0
+	}
+
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	vars := map[string]float64{}
+	var commands strings.Builder
+	for frame := 0; float64(frame)/customEffectRate < duration; frame++ {
+		t := float64(frame) / customEffectRate
+		beat := grid.BeatPosition(t)
+		bar := math.Floor(beat / beatsPerBar)
+		vars["t"] = t
+		vars["beat"] = beat
+		vars["bar"] = bar
+		vars["beat_phase"] = beat - math.Floor(beat)
+		vars["bar_phase"] = (beat - bar*beatsPerBar) / beatsPerBar
+		vars["beat_in_bar"] = math.Floor(beat-bar*beatsPerBar) + 1
+		vars["energy"] = level(energy, frame)
+		vars["bass"] = level(bass, frame)
+
+		var updates []string
+		for _, p := range params {
+			value := exact(p.expr(vars))
+			if value == p.last {
+				continue
+			}
+			// the first values are the initial options of the filters
+			if frame == 0 {
+				p.initial = value
+			} else {
+				updates = append(updates, fmt.Sprintf("%s %s %s", effectInstance(effects[p.effect], p.effect), p.name, value))
+			}
+			p.last = value
+		}
+		if len(updates) > 0 {
+			fmt.Fprintf(&commands, "%s %s;\n", seconds(t), strings.Join(updates, ", "))
+		}
+	}
+
+	options := make([][]string, len(effects))
+	for i, effect := range effects {
+		for _, name := range sortedKeys(effect.Options) {
+			options[i] = append(options[i], name+"="+effect.Options[name])
+		}
+	}
+	for _, p := range params {
+		options[p.effect] = append(options[p.effect], p.name+"="+p.initial)
+	}
+	return commands.String(), options, nil
+}
+
+// effectInstance returns the name of the filter instance of the i-th effect,
+// the target of its commands.
+func effectInstance(effect CustomEffect, i int) string {
+	return fmt.Sprintf("%s@custom%d", effect.Filter, i)
+}
+
+// effectFilter returns the filter of the i-th effect with its options.
+func effectFilter(effect CustomEffect, i int, options []string) string {
+	filter := effectInstance(effect, i)
+	if len(options) > 0 {
+		filter += "=" + strings.Join(options, ":")
+	}
+	return filter
+}
+
+// customEffectsFilter returns the filter chain applying the custom effects to
+// a video of the given duration, along with the path of the sendcmd script
+// updating their parameters, which the caller removes once done.
+func customEffectsFilter(effects []CustomEffect, grid beatGrid, audioPath string, duration float64) (string, string, error) {
+	commands, options, err := evaluateEffects(effects, grid, audioPath, duration)
+	if err != nil {
+		return "", "", err
+	}
+	commandsPath, err := writeFilterCommands("custom", commands)
+	if err != nil {
+		return "", "", err
+	}
+
+	filters := []string{"sendcmd=f=" + escapeFilterText(commandsPath)}
+	for i, effect := range effects {
+		filters = append(filters, effectFilter(effect, i, options[i]))
+	}
+	return strings.Join(filters, ","), commandsPath, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadCustomEffects(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{name: "filter", content: `[{"filter": "hue", "params": {"s": "1 + energy * (1 - beat_phase)"}}]`},
+		{name: "overlay", content: `[{"input": "cam.mp4", "params": {"size": "0.3"}}]`},
+		{name: "no filter", content: `[{"params": {"s": "1"}}]`, wantErr: true},
+		{name: "unknown variable", content: `[{"filter": "hue", "params": {"s": "tempo"}}]`, wantErr: true},
+		{name: "invalid expression", content: `[{"filter": "hue", "params": {"s": "1 +"}}]`, wantErr: true},
+		{name: "overlay with another filter", content: `[{"input": "cam.mp4", "filter": "hue"}]`, wantErr: true},
+		{name: "unknown overlay parameter", content: `[{"input": "cam.mp4", "params": {"alpha": "1"}}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "effects.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := readCustomEffects(path); (err != nil) != tt.wantErr {
+				t.Errorf("readCustomEffects() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluateEffects(t *testing.T) {
+	effects := []CustomEffect{
+		{Filter: "hue", Options: map[string]string{"b": "0"}, Params: map[string]string{"h": "floor(t * 2) * 90", "s": "beat_in_bar"}},
+		{Filter: "eq", Params: map[string]string{"contrast": "1 + bar"}},
+	}
+	// a beat a second, the second bar starts at 4s
+	commands, options, err := evaluateEffects(effects, beatGrid{BPM: 60}, "", 4.1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantOptions := [][]string{{"b=0", "h=0", "s=1"}, {"contrast=1"}}
+	if !reflect.DeepEqual(options, wantOptions) {
+		t.Errorf("evaluateEffects() options = %v, want %v", options, wantOptions)
+	}
+	wantCommands := `0.52 hue@custom0 h 90;
+1 hue@custom0 h 180, hue@custom0 s 2;
+1.52 hue@custom0 h 270;
+2 hue@custom0 h 360, hue@custom0 s 3;
+2.52 hue@custom0 h 450;
+3 hue@custom0 h 540, hue@custom0 s 4;
+3.52 hue@custom0 h 630;
+4 hue@custom0 h 720, hue@custom0 s 1, eq@custom1 contrast 2;
+`
+	if commands != wantCommands {
+		t.Errorf("evaluateEffects() commands =\n%s\nwant\n%s", commands, wantCommands)
+	}
+
+	if got := effectFilter(effects[0], 0, options[0]); got != "hue@custom0=b=0:h=0:s=1" {
+		t.Errorf("effectFilter() = %q", got)
+	}
+	if _, _, err := evaluateEffects([]CustomEffect{{Filter: "eq", Params: map[string]string{"brightness": "energy"}}}, beatGrid{BPM: 60}, "", 1); err == nil {
+		t.Error("evaluateEffects() of the energy without music didn't fail")
+	}
+}
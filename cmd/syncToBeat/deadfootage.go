@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// footageInterval is a portion of a video detected as dead footage.
+type footageInterval struct {
+	// Kind is "black", "freeze" or "silence".
+	Kind  string
+	Start float64
+	End   float64
+}
+
+// deadFootageKinds are the kinds of dead footage that can be detected.
+var deadFootageKinds = []string{"black", "freeze", "silence"}
+
+// parseDeadFootageKinds parses a comma separated list of dead footage kinds.
+func parseDeadFootageKinds(value string) ([]string, error) {
+	var kinds []string
+	for _, kind := range strings.Split(value, ",") {
+		kind = strings.TrimSpace(kind)
+		known := false
+		for _, k := range deadFootageKinds {
+			known = known || k == kind
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown dead footage kind %q, expected black, freeze or silence", kind)
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, nil
+}
+
+// deadFootageKeys maps the frame metadata set by the detection filters to
+// the kind of interval they start or end.
+var deadFootageKeys = map[string]struct {
+	kind  string
+	start bool
+}{
+	"lavfi.black_start":               {"black", true},
+	"lavfi.black_end":                 {"black", false},
+	"lavfi.freezedetect.freeze_start": {"freeze", true},
+	"lavfi.freezedetect.freeze_end":   {"freeze", false},
+	"lavfi.silence_start":             {"silence", true},
+	"lavfi.silence_end":               {"silence", false},
+}
+
+// detectDeadFootage returns the black, frozen and silent portions of the
+// video, sorted by start time. The result is cached in the store.
+func detectDeadFootage(videoPath string) ([]footageInterval, error) {
+	var intervals []footageInterval
+	err := cachedAnalysis("dead-footage", videoPath, &intervals, func() (err error) {
+		intervals, err = analyzeDeadFootage(videoPath)
+		return err
+	})
+	return intervals, err
+}
+
+// analyzeDeadFootage runs the detection filters of detectDeadFootage.
+func analyzeDeadFootage(videoPath string) ([]footageInterval, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %v", err)
+	}
+
+	cmdArgs := []string{
+		"-v", "error",
+		"-i", videoPath,
+		"-vf", "blackdetect=d=0.5:pix_th=0.10,freezedetect=n=-60dB:d=1,metadata=print:file=-",
+	}
+	if _, ok := info.Audio(); ok {
+		cmdArgs = append(cmdArgs, "-af", "silencedetect=n=-50dB:d=1,ametadata=print:file=-")
+	}
+	cmdArgs = append(cmdArgs, "-f", "null", "-")
+
+	cmd := exec.Command(ffmpegPath, cmdArgs...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error analyzing %s: %v", videoPath, err)
+	}
+
+	// the metadata filters print the keys of the frames starting or ending
+	// an interval, intervals still open at the end last until the end.
+	var intervals []footageInterval
+	open := map[string]float64{}
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		meaning, ok := deadFootageKeys[key]
+		if !ok {
+			continue
+		}
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		if meaning.start {
+			open[meaning.kind] = t
+			continue
+		}
+		if start, ok := open[meaning.kind]; ok {
+			intervals = append(intervals, footageInterval{Kind: meaning.kind, Start: start, End: t})
+			delete(open, meaning.kind)
+		}
+	}
+	for kind, start := range open {
+		intervals = append(intervals, footageInterval{Kind: kind, Start: start, End: info.Duration})
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].Start < intervals[j].Start })
+	return intervals, nil
+}
+
+// mergeIntervals returns the union of the intervals of the given kinds.
+func mergeIntervals(intervals []footageInterval, kinds []string) []footageInterval {
+	var merged []footageInterval
+	for _, interval := range intervals {
+		selected := false
+		for _, kind := range kinds {
+			selected = selected || interval.Kind == kind
+		}
+		if !selected {
+			continue
+		}
+		if last := len(merged) - 1; last >= 0 && interval.Start <= merged[last].End {
+			merged[last].End = max(merged[last].End, interval.End)
+			continue
+		}
+		merged = append(merged, footageInterval{Kind: "dead", Start: interval.Start, End: interval.End})
+	}
+	return merged
+}
+
+// remapKeyframes moves the keyframes to their time once the removed
+// intervals are cut out, dropping the keyframes inside them.
+func remapKeyframes(keyframes []Keyframe, removed []footageInterval) []Keyframe {
+	var remapped []Keyframe
+	for _, kf := range keyframes {
+		shift := 0.0
+		dropped := false
+		for _, interval := range removed {
+			if kf.Time >= interval.End {
+				shift += interval.End - interval.Start
+			} else if kf.Time > interval.Start {
+				dropped = true
+			}
+		}
+		if dropped {
+			continue
+		}
+		kf.Time -= shift
+		remapped = append(remapped, kf)
+	}
+	return remapped
+}
+
+// removeDeadFootage cuts the dead footage of the given kinds out of the
+// video and returns the keyframes remapped to the cleaned video.
+func removeDeadFootage(videoPath string, kinds []string, outputPath string, keyframes []Keyframe) ([]Keyframe, error) {
+	intervals, err := detectDeadFootage(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	removed := mergeIntervals(intervals, kinds)
+	say("dead.detected", len(removed), videoPath)
+	if len(removed) == 0 {
+		return keyframes, nil
+	}
+
+	var kept []string
+	for _, interval := range removed {
+		kept = append(kept, fmt.Sprintf("between(t,%s,%s)", seconds(interval.Start), seconds(interval.End)))
+	}
+	condition := "not(" + strings.Join(kept, "+") + ")"
+
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe video: %v", err)
+	}
+	cmdArgs := []string{
+		"-y",
+		"-i", videoPath,
+		"-vf", fmt.Sprintf("select='%s',setpts=N/FRAME_RATE/TB", condition),
+	}
+	if _, ok := info.Audio(); ok {
+		cmdArgs = append(cmdArgs, "-af", fmt.Sprintf("aselect='%s',asetpts=N/SR/TB", condition))
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	cmdArgs = append(cmdArgs, outputPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+	if err := runFFmpeg("dead footage", cmdArgs); err != nil {
+		return nil, fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputPath)
+
+	return remapKeyframes(keyframes, removed), nil
+}
+
+// DeadSegments picks what happens to the segments made mostly of black or
+// frozen frames: "warn" raises a warning, "cut" drops them from the edit.
+// They're left alone when empty.
+var DeadSegments = ""
+
+// deadSegmentRatio is the portion of a segment that has to be black or frozen
+// for the segment to be considered dead.
+const deadSegmentRatio = 0.5
+
+// coverage returns the portion of [start, end] covered by the intervals of
+// the given kind.
+func coverage(intervals []footageInterval, kind string, start, end float64) float64 {
+	if end <= start {
+		return 0
+	}
+	var covered float64
+	for _, interval := range mergeIntervals(intervals, []string{kind}) {
+		covered += max(0, min(end, interval.End)-max(start, interval.Start))
+	}
+	return covered / (end - start)
+}
+
+// checkDeadSegments warns about the segments made mostly of black or frozen
+// frames and, in "cut" mode, drops them from the edit, moving the following
+// segments earlier.
+func checkDeadSegments(videoPath string, segments []segment, mode string) ([]segment, error) {
+	if mode != "warn" && mode != "cut" {
+		return nil, fmt.Errorf("invalid dead segments mode %q, expected warn or cut", mode)
+	}
+	intervals, err := detectDeadFootage(videoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []segment
+	var shift, previousTime float64
+	// the same in frames, for the plans counted in frames
+	var shiftFrames, previousFrame int64
+	for _, seg := range segments {
+		outputDuration := seg.NearestBeatTime - previousTime
+		previousTime = seg.NearestBeatTime
+		outputFrames := seg.BeatFrame - previousFrame
+		previousFrame = seg.BeatFrame
+
+		dead := false
+		if ratio := coverage(intervals, "black", seg.Start, seg.End); ratio > deadSegmentRatio {
+			warn(WarnBlackSegment, seg.Keyframe, ratio*100)
+			dead = true
+		}
+		if ratio := coverage(intervals, "freeze", seg.Start, seg.End); ratio > deadSegmentRatio {
+			warn(WarnFrozenSegment, seg.Keyframe, ratio*100)
+			dead = true
+		}
+		if dead && mode == "cut" && len(segments) > 1 {
+			shift += outputDuration
+			shiftFrames += outputFrames
+			continue
+		}
+		if shift > 0 {
+			seg.NearestBeatTime -= shift
+			seg.BeatFrame -= shiftFrames
+			seg.Explain("reason.dead_cut", shift)
+		}
+		kept = append(kept, seg)
+	}
+	if len(kept) == 0 {
+		return nil, fmt.Errorf("every segment is dead footage")
+	}
+	return kept, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// Deinterlace is how interlaced sources are handled: auto deinterlaces the
+// sources whose field order is interlaced with bwdif, yadif or bwdif always
+// deinterlace with that filter and off leaves the fields as is. Retiming
+// interlaced frames shows combing.
+var Deinterlace = "auto"
+
+// deinterlaceFilter is the filter deinterlacing the source, empty for
+// progressive sources.
+var deinterlaceFilter string
+
+// validateDeinterlace checks the deinterlace setting.
+func validateDeinterlace(mode string) error {
+	switch mode {
+	case "auto", "yadif", "bwdif", "off":
+		return nil
+	}
+	return fmt.Errorf("invalid deinterlace mode %q, expected auto, yadif, bwdif or off", mode)
+}
+
+// interlaced reports whether the field order of the video is interlaced.
+func interlaced(video probe.Stream) bool {
+	switch video.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// fieldParity returns the parity option of the deinterlacers for the field
+// order, the first field of tt and tb frames is the top one.
+func fieldParity(fieldOrder string) string {
+	switch fieldOrder {
+	case "tt", "tb":
+		return "tff"
+	case "bb", "bt":
+		return "bff"
+	}
+	return "auto"
+}
+
+// planDeinterlace sets deinterlaceFilter for the video, one output frame per
+// input frame so the frame rate and the timestamps are kept.
+func planDeinterlace(videoPath string) {
+	deinterlaceFilter = ""
+	if Deinterlace == "off" {
+		return
+	}
+	var video probe.Stream
+	if info, err := probe.ProbeMedia(videoPath); err == nil {
+		video, _ = info.Video()
+	}
+	filter := Deinterlace
+	if Deinterlace == "auto" {
+		if !interlaced(video) {
+			return
+		}
+		filter = "bwdif"
+	}
+	deinterlaceFilter = fmt.Sprintf("%s=mode=send_frame:parity=%s:deint=all", filter, fieldParity(video.FieldOrder))
+	say("video.deinterlaced", videoPath, filter)
+}
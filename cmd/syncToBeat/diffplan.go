@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+)
+
+const diffPlanUsage = `Usage:
+  <program> diff-plan a.json b.json [n]    compare two plans, or a plan and its keyframes, listing the n keyframes (10 by default) moved the most and with the largest speed changes`
+
+// plannedKeyframe is where a keyframe lands in a plan and the speed of the
+// segment it ends.
+type plannedKeyframe struct {
+	Label   string
+	Landing float64
+	Speed   float64
+}
+
+// readPlannedKeyframes reads a plan saved in the workspace or, for a
+// keyframe file, the keyframes as they are before the sync. The keyframes
+// are indexed as in the keyframe file.
+func readPlannedKeyframes(path string) (map[int]plannedKeyframe, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan savedPlan
+	if json.Unmarshal(data, &plan) == nil && len(plan.Segments) > 0 {
+		planned := map[int]plannedKeyframe{}
+		for _, seg := range plan.Segments {
+			var label string
+			if seg.Keyframe < len(plan.Keyframes) {
+				label = plan.Keyframes[seg.Keyframe].Label
+			}
+			planned[seg.Keyframe] = plannedKeyframe{Label: label, Landing: seg.NearestBeatTime, Speed: seg.SpeedFactor}
+		}
+		return planned, nil
+	}
+
+	keyframes, err := readKeyframes(path, "")
+	if err != nil {
+		return nil, err
+	}
+	planned := map[int]plannedKeyframe{}
+	for i, kf := range keyframes {
+		planned[i] = plannedKeyframe{Label: kf.Label, Landing: kf.Time, Speed: 1}
+	}
+	return planned, nil
+}
+
+// keyframeChange is how a keyframe changed between two plans.
+type keyframeChange struct {
+	Keyframe int
+	Label    string
+	A, B     plannedKeyframe
+}
+
+// moved returns the displacement of the keyframe, in seconds.
+func (c keyframeChange) moved() float64 {
+	return c.B.Landing - c.A.Landing
+}
+
+// speedChange returns the change of speed in octaves, so that halving and
+// doubling the speed count the same.
+func (c keyframeChange) speedChange() float64 {
+	return math.Log2(c.B.Speed / c.A.Speed)
+}
+
+// runDiffPlanCommand compares two plans and prints the keyframes moved the
+// most and the ones whose segment changed speed the most.
+func runDiffPlanCommand(args []string) error {
+	if len(args) < 2 {
+		say("usage.diff_plan")
+		return nil
+	}
+	top := 10
+	if len(args) > 2 {
+		var err error
+		if top, err = strconv.Atoi(args[2]); err != nil || top < 1 {
+			say("usage.diff_plan")
+			return fmt.Errorf("invalid number of keyframes %q", args[2])
+		}
+	}
+	a, err := readPlannedKeyframes(args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	b, err := readPlannedKeyframes(args[1])
+	if err != nil {
+		return fmt.Errorf("%s: %v", args[1], err)
+	}
+
+	var changes []keyframeChange
+	for i, plannedA := range a {
+		plannedB, ok := b[i]
+		if !ok {
+			continue
+		}
+		label := plannedB.Label
+		if label == "" {
+			label = plannedA.Label
+		}
+		changes = append(changes, keyframeChange{Keyframe: i, Label: label, A: plannedA, B: plannedB})
+	}
+	if len(changes) == 0 {
+		return fmt.Errorf("the plans have no keyframes in common")
+	}
+	say("diff_plan.summary", len(changes), len(a)-len(changes), args[0], len(b)-len(changes), args[1])
+
+	printChanges := func(titleID string, size func(c keyframeChange) float64) {
+		sort.Slice(changes, func(i, j int) bool {
+			if size(changes[i]) != size(changes[j]) {
+				return size(changes[i]) > size(changes[j])
+			}
+			return changes[i].Keyframe < changes[j].Keyframe
+		})
+		say(titleID)
+		say("diff_plan.header")
+		for _, c := range changes[:min(top, len(changes))] {
+			say("diff_plan.change", c.Keyframe, c.Label, c.A.Landing, c.B.Landing, c.moved(), c.A.Speed, c.B.Speed)
+		}
+	}
+	printChanges("diff_plan.displacements", func(c keyframeChange) float64 { return math.Abs(c.moved()) })
+	printChanges("diff_plan.speed_changes", func(c keyframeChange) float64 { return math.Abs(c.speedChange()) })
+	return nil
+}
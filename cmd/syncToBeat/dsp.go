@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// nextPowerOfTwo returns the smallest power of two greater or equal to n.
+func nextPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+// fft computes in place the discrete Fourier transform of x, the length of x
+// must be a power of two. When inverse is true, the inverse transform is
+// computed (including the 1/n scaling).
+func fft(x []complex128, inverse bool) {
+	n := len(x)
+	if n <= 1 {
+		return
+	}
+
+	// bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	sign := -1.0
+	if inverse {
+		sign = 1.0
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Rect(1, sign*2*math.Pi/float64(size))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				even := x[start+k]
+				odd := x[start+k+size/2] * w
+				x[start+k] = even + odd
+				x[start+k+size/2] = even - odd
+				w *= step
+			}
+		}
+	}
+
+	if inverse {
+		scale := complex(1/float64(n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
+
+// crossCorrelate returns the cross-correlation of a and b for every lag, the
+// value at index i is the correlation of a[n] with b[n+i]. Negative lags wrap
+// around and are found at the end of the slice, the returned slice has a
+// length of at least len(a)+len(b).
+func crossCorrelate(a, b []float64) []float64 {
+	n := nextPowerOfTwo(len(a) + len(b))
+	fa := make([]complex128, n)
+	fb := make([]complex128, n)
+	for i, v := range a {
+		fa[i] = complex(v, 0)
+	}
+	for i, v := range b {
+		fb[i] = complex(v, 0)
+	}
+	fft(fa, false)
+	fft(fb, false)
+	for i := range fa {
+		fa[i] = cmplx.Conj(fa[i]) * fb[i]
+	}
+	fft(fa, true)
+
+	out := make([]float64, n)
+	for i, v := range fa {
+		out[i] = real(v)
+	}
+	return out
+}
+
+// energy returns the sum of the squared samples.
+func energy(samples []float64) float64 {
+	var total float64
+	for _, v := range samples {
+		total += v * v
+	}
+	return total
+}
+
+// lowPass filters out the frequencies above cutoff with a one pole filter.
+func lowPass(samples []float64, sampleRate int, cutoff float64) []float64 {
+	alpha := 1 - math.Exp(-2*math.Pi*cutoff/float64(sampleRate))
+	filtered := make([]float64, len(samples))
+	var y float64
+	for i, x := range samples {
+		y += alpha * (x - y)
+		filtered[i] = y
+	}
+	return filtered
+}
+
+// rmsEnvelope returns the RMS level of the samples over windows of
+// 1/envelopeRate seconds, normalized so the loudest window is 1.
+func rmsEnvelope(samples []float64, sampleRate int, envelopeRate float64) []float64 {
+	window := max(int(float64(sampleRate)/envelopeRate), 1)
+	var envelope []float64
+	var peak float64
+	for start := 0; start < len(samples); start += window {
+		end := min(start+window, len(samples))
+		level := math.Sqrt(energy(samples[start:end]) / float64(end-start))
+		envelope = append(envelope, level)
+		peak = math.Max(peak, level)
+	}
+	if peak > 0 {
+		for i := range envelope {
+			envelope[i] /= peak
+		}
+	}
+	return envelope
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// encoderSettings are the video encoder settings of the rendered videos.
+type encoderSettings struct {
+	Codec  string `json:"codec"`
+	Preset string `json:"preset"`
+	CRF    int    `json:"crf"`
+}
+
+// Encoder is the video encoder used for every render.
+var Encoder = encoderSettings{Codec: "libx264", Preset: "medium", CRF: 22}
+
+// audioSettings are the settings of the audio muxed with the videos.
+type audioSettings struct {
+	// Copy muxes the audio as is, which can break the playback of MP4 files
+	// when the audio codec is unusual.
+	Copy       bool   `json:"copy"`
+	Codec      string `json:"codec"`
+	Bitrate    string `json:"bitrate"`
+	SampleRate int    `json:"sample_rate"`
+	Channels   int    `json:"channels"`
+	// Downmix folds multichannel music to stereo: "matrix" uses ffmpeg's
+	// mixing matrix, "itu" the ITU-R BS.775 coefficients and "front" keeps
+	// the front pair only.
+	Downmix string `json:"downmix"`
+	// Upmix spreads stereo music over more channels: "front" leaves the
+	// other channels silent and "surround" uses ffmpeg's surround filter.
+	Upmix string `json:"upmix"`
+}
+
+// AudioEncoder normalizes the muxed audio, 48kHz stereo AAC by default.
+var AudioEncoder = audioSettings{Codec: "aac", Bitrate: "192k", SampleRate: 48000, Channels: 2, Downmix: "matrix", Upmix: "front"}
+
+// encoderArgs returns the output options encoding the video with Encoder.
+func encoderArgs() []string {
+	args := []string{"-c:v", Encoder.Codec}
+	if Encoder.Preset != "" {
+		args = append(args, "-preset", Encoder.Preset)
+	}
+	if Encoder.CRF > 0 {
+		args = append(args, "-crf", fmt.Sprintf("%d", Encoder.CRF))
+		// the VP9 and AV1 encoders only use the CRF in constant quality mode
+		if family := codecFamily(Encoder.Codec); family == "vp9" || family == "av1" {
+			args = append(args, "-b:v", "0")
+		}
+	}
+	return append(args, colorArgs(SourceColor)...)
+}
+
+// audioMuxArgs returns the output options of the audio muxed with the video.
+// The audio is encoded with AudioEncoder unless it's copied as is, the
+// limiter forces the encoding.
+func audioMuxArgs() []string {
+	if AudioEncoder.Copy && !limitAudio {
+		return []string{"-c:a", "copy"}
+	}
+	var filters []string
+	if channelMix != "" {
+		filters = append(filters, channelMix)
+	}
+	if limitAudio {
+		filters = append(filters, limiterFilter())
+	}
+	var args []string
+	if len(filters) > 0 {
+		args = append(args, "-af", strings.Join(filters, ","))
+	}
+	return append(args, audioEncodeArgs()...)
+}
+
+// limiterFilter returns the filter keeping the audio under truePeakCeiling.
+func limiterFilter() string {
+	return "alimiter=limit=" + exact(dbToAmplitude(truePeakCeiling)) + ":level=disabled"
+}
+
+// audioEncodeArgs returns the output options encoding the audio with
+// AudioEncoder.
+func audioEncodeArgs() []string {
+	args := []string{"-c:a", AudioEncoder.Codec}
+	if AudioEncoder.Bitrate != "" {
+		args = append(args, "-b:a", AudioEncoder.Bitrate)
+	}
+	if AudioEncoder.SampleRate > 0 {
+		args = append(args, "-ar", fmt.Sprintf("%d", AudioEncoder.SampleRate))
+	}
+	if AudioEncoder.Channels > 0 {
+		args = append(args, "-ac", fmt.Sprintf("%d", AudioEncoder.Channels))
+	}
+	return args
+}
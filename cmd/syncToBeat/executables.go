@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// checkFFmpegAvailable checks if FFmpeg is installed and available in the PATH
+// or set with the FFMPEG_PATH environment variable.
+// It returns the path to the FFmpeg executable if found, or an error if not found.
+func checkFFmpegAvailable() (string, error) {
+	ffmpegPath, err := probe.FindExecutable("ffmpeg", "FFMPEG_PATH")
+	if err != nil {
+		return "", fmt.Errorf("FFmpeg is not available: %v", err)
+	}
+	return ffmpegPath, nil
+}
+
+// checkFFplayAvailable checks if FFplay is installed and available in the PATH
+// or set with the FFPLAY_PATH environment variable.
+// It returns the path to the FFplay executable if found, or an error if not found.
+func checkFFplayAvailable() (string, error) {
+	ffplayPath, err := probe.FindExecutable("ffplay", "FFPLAY_PATH")
+	if err != nil {
+		return "", fmt.Errorf("FFplay is not available: %v", err)
+	}
+	return ffplayPath, nil
+}
@@ -0,0 +1,5 @@
+package main
+
+// Explain adds to the plan report the decisions of the planner about each
+// keyframe: how it snapped to the grid and why it moved from there.
+var Explain = false
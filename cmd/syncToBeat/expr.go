@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expression is a compiled arithmetic expression of named variables.
+type expression func(vars map[string]float64) float64
+
+// expressionFunctions are the functions available in the expressions.
+var expressionFunctions = map[string]struct {
+	args int
+	fn   func(args []float64) float64
+}{
+	"sin":   {1, func(a []float64) float64 { return math.Sin(a[0]) }},
+	"cos":   {1, func(a []float64) float64 { return math.Cos(a[0]) }},
+	"abs":   {1, func(a []float64) float64 { return math.Abs(a[0]) }},
+	"floor": {1, func(a []float64) float64 { return math.Floor(a[0]) }},
+	"ceil":  {1, func(a []float64) float64 { return math.Ceil(a[0]) }},
+	"round": {1, func(a []float64) float64 { return math.Round(a[0]) }},
+	"exp":   {1, func(a []float64) float64 { return math.Exp(a[0]) }},
+	"sqrt":  {1, func(a []float64) float64 { return math.Sqrt(a[0]) }},
+	"min":   {2, func(a []float64) float64 { return math.Min(a[0], a[1]) }},
+	"max":   {2, func(a []float64) float64 { return math.Max(a[0], a[1]) }},
+	"pow":   {2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"mod":   {2, func(a []float64) float64 { return a[0] - math.Floor(a[0]/a[1])*a[1] }},
+	"clamp": {3, func(a []float64) float64 { return math.Max(a[1], math.Min(a[2], a[0])) }},
+	"if": {3, func(a []float64) float64 {
+		if a[0] != 0 {
+			return a[1]
+		}
+		return a[2]
+	}},
+}
+
+// expressionConstants are the named constants of the expressions.
+var expressionConstants = map[string]float64{
+	"pi": math.Pi,
+}
+
+// expressionParser is a recursive descent parser of arithmetic expressions
+// supporting + - * / ^, parentheses, the expressionFunctions and variables.
+type expressionParser struct {
+	source string
+	pos    int
+	// vars lists the variables the expression may use.
+	vars map[string]bool
+	// used records the variables the expression uses.
+	used map[string]bool
+}
+
+// parseExpression compiles the expression, only allowing the given
+// variables. It returns the names of the variables used by the expression.
+func parseExpression(source string, vars []string) (expression, map[string]bool, error) {
+	p := &expressionParser{source: source, vars: map[string]bool{}, used: map[string]bool{}}
+	for _, name := range vars {
+		p.vars[name] = true
+	}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, nil, err
+	}
+	p.skipSpaces()
+	if p.pos < len(p.source) {
+		return nil, nil, p.errorf("unexpected %q", p.source[p.pos:])
+	}
+	return expr, p.used, nil
+}
+
+func (p *expressionParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("invalid expression %q at position %d: %s", p.source, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.source) && p.source[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// accept consumes the next character when it's c.
+func (p *expressionParser) accept(c byte) bool {
+	p.skipSpaces()
+	if p.pos < len(p.source) && p.source[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *expressionParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept('+'):
+			right, err := p.parseProduct()
+			if err != nil {
+				return nil, err
+			}
+			l := left
+			left = func(v map[string]float64) float64 { return l(v) + right(v) }
+		case p.accept('-'):
+			right, err := p.parseProduct()
+			if err != nil {
+				return nil, err
+			}
+			l := left
+			left = func(v map[string]float64) float64 { return l(v) - right(v) }
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *expressionParser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept('*'):
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			l := left
+			left = func(v map[string]float64) float64 { return l(v) * right(v) }
+		case p.accept('/'):
+			right, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			l := left
+			left = func(v map[string]float64) float64 { return l(v) / right(v) }
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if p.accept('-') {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(v map[string]float64) float64 { return -operand(v) }, nil
+	}
+	return p.parsePower()
+}
+
+func (p *expressionParser) parsePower() (expression, error) {
+	base, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept('^') {
+		return base, nil
+	}
+	// ^ is right associative
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return func(v map[string]float64) float64 { return math.Pow(base(v), exponent(v)) }, nil
+}
+
+func (p *expressionParser) parseOperand() (expression, error) {
+	p.skipSpaces()
+	if p.accept('(') {
+		inner, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("missing )")
+		}
+		return inner, nil
+	}
+
+	start := p.pos
+	if p.pos < len(p.source) && (unicode.IsDigit(rune(p.source[p.pos])) || p.source[p.pos] == '.') {
+		for p.pos < len(p.source) && (unicode.IsDigit(rune(p.source[p.pos])) || p.source[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(p.source[start:p.pos], 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.source[start:p.pos])
+		}
+		return func(map[string]float64) float64 { return value }, nil
+	}
+
+	for p.pos < len(p.source) && (unicode.IsLetter(rune(p.source[p.pos])) || unicode.IsDigit(rune(p.source[p.pos])) || p.source[p.pos] == '_') {
+		p.pos++
+	}
+	name := strings.ToLower(p.source[start:p.pos])
+	if name == "" {
+		if p.pos >= len(p.source) {
+			return nil, p.errorf("unexpected end")
+		}
+		return nil, p.errorf("unexpected %q", p.source[p.pos])
+	}
+
+	if function, ok := expressionFunctions[name]; ok {
+		if !p.accept('(') {
+			return nil, p.errorf("missing ( after %s", name)
+		}
+		var args []expression
+		for {
+			arg, err := p.parseSum()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if !p.accept(',') {
+				break
+			}
+		}
+		if !p.accept(')') {
+			return nil, p.errorf("missing ) after the arguments of %s", name)
+		}
+		if len(args) != function.args {
+			return nil, p.errorf("%s expects %d arguments, got %d", name, function.args, len(args))
+		}
+		return func(v map[string]float64) float64 {
+			values := make([]float64, len(args))
+			for i, arg := range args {
+				values[i] = arg(v)
+			}
+			return function.fn(values)
+		}, nil
+	}
+	if value, ok := expressionConstants[name]; ok {
+		return func(map[string]float64) float64 { return value }, nil
+	}
+	if !p.vars[name] {
+		return nil, p.errorf("unknown variable %q", name)
+	}
+	p.used[name] = true
+	return func(v map[string]float64) float64 { return v[name] }, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseExpression(t *testing.T) {
+	vars := map[string]float64{"beat": 2.5, "energy": 0.5}
+	tests := []struct {
+		source   string
+		want     float64
+		wantUsed []string
+	}{
+		{source: "1 + 2 * 3", want: 7},
+		{source: "(1 + 2) * 3", want: 9},
+		{source: "10 - 4 - 3", want: 3},
+		{source: "12 / 3 / 2", want: 2},
+		{source: "-2 ^ 2", want: -4},
+		{source: "2 ^ 3 ^ 2", want: 512},
+		{source: "2 ^ -1", want: 0.5},
+		{source: "mod(-1, 4)", want: 3},
+		{source: "clamp(5, 0, 1)", want: 1},
+		{source: "if(0, 1, 2)", want: 2},
+		{source: "max(min(3, 4), 2)", want: 3},
+		{source: "cos(pi)", want: -1},
+		{source: "1 + energy * (1 - BEAT)", want: 0.25, wantUsed: []string{"beat", "energy"}},
+		{source: "floor(beat) + beat_phase", want: 2.25, wantUsed: []string{"beat", "beat_phase"}},
+	}
+	vars["beat_phase"] = 0.25
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			expr, used, err := parseExpression(tt.source, []string{"beat", "beat_phase", "energy"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr(vars); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("parseExpression(%q) = %v, want %v", tt.source, got, tt.want)
+			}
+			wantUsed := map[string]bool{}
+			for _, name := range tt.wantUsed {
+				wantUsed[name] = true
+			}
+			if !reflect.DeepEqual(used, wantUsed) {
+				t.Errorf("parseExpression(%q) uses %v, want %v", tt.source, used, wantUsed)
+			}
+		})
+	}
+}
+
+func TestParseExpressionErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"bass",
+		"sin 1",
+		"min(1)",
+		"max(1, 2",
+		"1..2",
+		"2 $ 3",
+	}
+	for _, source := range tests {
+		t.Run(source, func(t *testing.T) {
+			if _, _, err := parseExpression(source, []string{"beat"}); err == nil {
+				t.Errorf("parseExpression(%q) didn't fail", source)
+			}
+		})
+	}
+}
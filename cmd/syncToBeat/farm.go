@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FarmDir is a directory shared by the machines of a render farm, such as a
+// network mount. When set, the chunks of the synced video are queued in it
+// and rendered by the workers running the worker subcommand, as well as by
+// the run itself. The source video and the files read by the effects must
+// have the same paths on every machine.
+var FarmDir string
+
+const (
+	// farmPollInterval is how often the queue is checked for jobs and
+	// finished chunks.
+	farmPollInterval = 2 * time.Second
+	// farmStaleClaim is how long a claimed job can go without a heartbeat
+	// before it's queued again, its worker being presumed dead.
+	farmStaleClaim = 2 * time.Minute
+)
+
+// farmJob is a chunk of the synced video queued on the farm. A job goes
+// through the files of its batch directory: NNNN.job while queued,
+// NNNN.claim while rendered, then NNNN.ts, or NNNN.err when it failed.
+type farmJob struct {
+	// Args are the ffmpeg arguments rendering the chunk, without the output.
+	Args []string `json:"args"`
+}
+
+// queueFarmChunks splits the segments in chunks queued as jobs in a new batch
+// directory of the farm.
+func queueFarmChunks(segments []segment, effects []string, originalVideoPath string) (string, int, error) {
+	dir, err := os.MkdirTemp(FarmDir, "sync-*")
+	if err != nil {
+		return "", 0, err
+	}
+	n := 0
+	for first := 0; first < len(segments); first += segmentsPerChunk {
+		chunk := segments[first:min(first+segmentsPerChunk, len(segments))]
+		outputStart := 0.0
+		if first > 0 {
+			outputStart = segments[first-1].NearestBeatTime
+		}
+		args := chunkArgs(chunk, effects, originalVideoPath, outputStart, "")
+		data, err := json.Marshal(farmJob{Args: args[:len(args)-1]})
+		if err != nil {
+			return dir, n, err
+		}
+		// the job only appears once complete
+		base := filepath.Join(dir, fmt.Sprintf("%04d", n))
+		if err := os.WriteFile(base+".tmp", data, 0644); err != nil {
+			return dir, n, err
+		}
+		if err := os.Rename(base+".tmp", base+".job"); err != nil {
+			return dir, n, err
+		}
+		n++
+	}
+	return dir, n, nil
+}
+
+// claimFarmJob claims one of the queued jobs of the batch directory, it
+// returns the path of the claim or an empty string when no job is queued.
+func claimFarmJob(dir string) string {
+	jobs, _ := filepath.Glob(filepath.Join(dir, "*.job"))
+	sort.Strings(jobs)
+	for _, job := range jobs {
+		// renaming is atomic, when machines race for a job only one of
+		// them gets it
+		claim := strings.TrimSuffix(job, ".job") + ".claim"
+		if err := os.Rename(job, claim); err == nil {
+			return claim
+		}
+	}
+	return ""
+}
+
+// runFarmJob renders a claimed job, touching the claim while ffmpeg runs so
+// that the other machines know it's still worked on.
+func runFarmJob(claim string) error {
+	base := strings.TrimSuffix(claim, ".claim")
+	name := filepath.Base(filepath.Dir(base)) + "/" + filepath.Base(base)
+	data, err := os.ReadFile(claim)
+	if err != nil {
+		return err
+	}
+	var job farmJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return fmt.Errorf("invalid job %s: %v", name, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(farmStaleClaim / 4)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				os.Chtimes(claim, now, now)
+			}
+		}
+	}()
+	say("farm.chunk", name)
+	part := base + ".ts.part"
+	err = runFFmpeg("farm chunk "+name, append(job.Args, part))
+	close(done)
+	if err != nil {
+		os.Remove(part)
+		os.WriteFile(base+".err", []byte(err.Error()), 0644)
+		os.Remove(claim)
+		return fmt.Errorf("chunk %s failed: %v", name, err)
+	}
+	if err := os.Rename(part, base+".ts"); err != nil {
+		return err
+	}
+	return os.Remove(claim)
+}
+
+// requeueStaleClaims queues again the jobs of the batch directory whose
+// worker stopped sending heartbeats.
+func requeueStaleClaims(dir string) {
+	claims, _ := filepath.Glob(filepath.Join(dir, "*.claim"))
+	for _, claim := range claims {
+		if info, err := os.Stat(claim); err == nil && time.Since(info.ModTime()) > farmStaleClaim {
+			os.Rename(claim, strings.TrimSuffix(claim, ".claim")+".job")
+		}
+	}
+}
+
+// renderOnFarm renders the segments as chunks queued on the farm, rendering
+// chunks itself until none are queued, then waits for the workers to finish
+// theirs and concatenates them into the output.
+func renderOnFarm(segments []segment, effects []string, originalVideoPath string, outputPath string) error {
+	if err := chunkStreamable(); err != nil {
+		return err
+	}
+	dir, n, err := queueFarmChunks(segments, effects, originalVideoPath)
+	if dir != "" {
+		defer os.RemoveAll(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to queue the chunks: %v", err)
+	}
+	say("farm.queued", n, dir)
+
+	var chunks []string
+	for {
+		if claim := claimFarmJob(dir); claim != "" {
+			if err := runFarmJob(claim); err != nil {
+				return err
+			}
+			continue
+		}
+		if failures, _ := filepath.Glob(filepath.Join(dir, "*.err")); len(failures) > 0 {
+			message, _ := os.ReadFile(failures[0])
+			return fmt.Errorf("chunk %s failed on a worker: %s", strings.TrimSuffix(filepath.Base(failures[0]), ".err"), message)
+		}
+		if chunks, _ = filepath.Glob(filepath.Join(dir, "*.ts")); len(chunks) == n {
+			break
+		}
+		requeueStaleClaims(dir)
+		time.Sleep(farmPollInterval)
+	}
+
+	sort.Strings(chunks)
+	cmdArgs := []string{"-y", "-f", "mpegts", "-i", "concat:" + strings.Join(chunks, "|"), "-map", "0:v", "-c", "copy"}
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	if err := runFFmpeg("farm concat", append(cmdArgs, outputPath)); err != nil {
+		return fmt.Errorf("failed to concatenate the chunks: %v", err)
+	}
+	return nil
+}
+
+const workerUsage = `Usage:
+  <program> worker dir    render the chunks queued in the farm directory dir by runs with -farm dir, until interrupted`
+
+// runWorkerCommand renders the jobs queued on the farm, forever.
+func runWorkerCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.worker")
+		return nil
+	}
+	FarmDir = args[0]
+	if info, err := os.Stat(FarmDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("the farm directory %s doesn't exist", FarmDir)
+	}
+	if _, err := checkFFmpegAvailable(); err != nil {
+		return err
+	}
+
+	say("worker.start", FarmDir)
+	for {
+		worked := false
+		batches, _ := filepath.Glob(filepath.Join(FarmDir, "sync-*"))
+		for _, batch := range batches {
+			claim := claimFarmJob(batch)
+			if claim == "" {
+				continue
+			}
+			worked = true
+			if err := runFarmJob(claim); err != nil {
+				say("worker.failed", err)
+			}
+		}
+		if !worked {
+			time.Sleep(farmPollInterval)
+		}
+	}
+}
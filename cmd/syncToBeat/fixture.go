@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// fixtureSpread is how much slower than the click the flashes of the
+	// fixture video are, so that syncing them actually moves them.
+	fixtureSpread = 1.1
+	// fixtureLead is the time, in seconds, before the first flash.
+	fixtureLead = 0.5
+	// fixtureFlashDuration is how long each flash stays on screen.
+	fixtureFlashDuration = 0.1
+	// fixtureTail is the time, in seconds, kept after the last flash.
+	fixtureTail = 2.0
+)
+
+const fixtureUsage = `Usage:
+  <program> gen-fixture dir [BPM [flashes]]    write a test video with numbered flashes, their keyframes and a click track (120 BPM and 8 flashes by default)`
+
+// fixtureFlashTimes returns the times of the flashes of the fixture video.
+func fixtureFlashTimes(bpm float64, flashes int) []float64 {
+	times := make([]float64, flashes)
+	for i := range times {
+		times[i] = fixtureLead + float64(i)*fixtureSpread*60/bpm
+	}
+	return times
+}
+
+// fixtureVideoFilter returns the filters drawing the numbered flashes on a
+// black video, with the running time in a corner.
+func fixtureVideoFilter(times []float64) string {
+	filters := []string{
+		fmt.Sprintf("drawtext=text='%%{pts\\:hms}':fontfile='%s':fontsize=24:fontcolor=white:x=20:y=20", defaultTitleFont),
+	}
+	for i, t := range times {
+		enable := fmt.Sprintf("between(t,%s,%s)", seconds(t), seconds(t+fixtureFlashDuration))
+		filters = append(filters,
+			fmt.Sprintf("drawbox=x=0:y=0:w=iw:h=ih:color=white:t=fill:enable='%s'", enable),
+			fmt.Sprintf("drawtext=text='%d':fontfile='%s':fontsize=160:fontcolor=black:x=(w-tw)/2:y=(h-th)/2:enable='%s'", i+1, defaultTitleFont, enable),
+		)
+	}
+	return strings.Join(filters, ",")
+}
+
+// fixtureClickSource returns the lavfi source of a click on every beat, the
+// downbeats of 4/4 bars clicking higher.
+func fixtureClickSource(bpm float64, duration float64) string {
+	beat := 60 / bpm
+	return fmt.Sprintf("aevalsrc='0.8*sin(2*PI*if(lt(mod(t,%[1]f),0.03),1500,1000)*t)*lt(mod(t,%[2]f),0.03)':s=48000:d=%[3]f", 4*beat, beat, duration)
+}
+
+// fixture holds the files written by writeFixture.
+type fixture struct {
+	BPM           float64
+	VideoPath     string
+	KeyframesPath string
+	ClickPath     string
+	Keyframes     []Keyframe
+}
+
+// writeFixture writes to dir a video flashing numbers at known times, the
+// keyframes of the flashes and a click track at the BPM.
+func writeFixture(dir string, bpm float64, flashes int) (fixture, error) {
+	f := fixture{
+		BPM:           bpm,
+		VideoPath:     filepath.Join(dir, "fixture.mp4"),
+		KeyframesPath: filepath.Join(dir, "keyframes.json"),
+		ClickPath:     filepath.Join(dir, "click.wav"),
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return f, err
+	}
+
+	times := fixtureFlashTimes(bpm, flashes)
+	duration := times[len(times)-1] + fixtureTail
+	for i, t := range times {
+		f.Keyframes = append(f.Keyframes, Keyframe{Time: t, Label: fmt.Sprintf("flash %d", i+1)})
+	}
+	data, err := json.MarshalIndent(f.Keyframes, "", "  ")
+	if err != nil {
+		return f, err
+	}
+	if err := os.WriteFile(f.KeyframesPath, data, 0o644); err != nil {
+		return f, err
+	}
+
+	err = runFFmpeg("fixture video", []string{
+		"-y",
+		"-f", "lavfi", "-i", fmt.Sprintf("color=c=black:s=640x360:r=30:d=%s", seconds(duration)),
+		"-vf", fixtureVideoFilter(times),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p",
+		f.VideoPath,
+	})
+	if err != nil {
+		return f, fmt.Errorf("error rendering the fixture video: %v", err)
+	}
+
+	err = runFFmpeg("fixture audio", []string{
+		"-y",
+		"-f", "lavfi", "-i", fixtureClickSource(bpm, duration),
+		f.ClickPath,
+	})
+	if err != nil {
+		return f, fmt.Errorf("error rendering the click track: %v", err)
+	}
+	return f, nil
+}
+
+// runFixtureCommand writes a fixture to verify a setup or build end to end
+// tests.
+func runFixtureCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.fixture")
+		return nil
+	}
+	dir := args[0]
+	bpm := 120.0
+	flashes := 8
+	var err error
+	if len(args) > 1 {
+		if bpm, err = strconv.ParseFloat(args[1], 64); err != nil || bpm <= 0 {
+			say("usage.fixture")
+			return fmt.Errorf("invalid BPM %q", args[1])
+		}
+	}
+	if len(args) > 2 {
+		if flashes, err = strconv.Atoi(args[2]); err != nil || flashes < 1 {
+			say("usage.fixture")
+			return fmt.Errorf("invalid number of flashes %q", args[2])
+		}
+	}
+	f, err := writeFixture(dir, bpm, flashes)
+	if err != nil {
+		return err
+	}
+	say("fixture.saved", f.VideoPath, f.KeyframesPath, f.ClickPath, flashes, bpm)
+	return nil
+}
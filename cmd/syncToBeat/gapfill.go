@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+var (
+	// MusicStart is the time, in seconds of the synced video, at which the
+	// music starts playing.
+	MusicStart = 0.0
+	// GapFill fills the parts of the synced video the music doesn't play
+	// over with the retimed original audio, crossfading on the beats.
+	GapFill = false
+)
+
+// gapFadeBeats is the length, in beats, of the crossfades between the music
+// and the original audio.
+const gapFadeBeats = 1.0
+
+// musicSpan returns the part of the synced video, in seconds, the music plays
+// over. When the music ends before the video, the span ends on the last beat
+// it reaches so the original audio comes back on a beat.
+func musicSpan(grid beatGrid, musicDuration float64, totalDuration float64) (start float64, end float64) {
+	start = MusicStart
+	end = MusicStart + musicDuration
+	if end >= totalDuration {
+		return start, totalDuration
+	}
+	end = math.Max(grid.BeatTime(math.Floor(grid.BeatPosition(end))), start)
+	return start, end
+}
+
+// gapFillFilterComplex builds the filter graph mixing the music (input 1)
+// with the original audio (input 2) where the music doesn't play, the mix
+// is labeled [outa].
+func gapFillFilterComplex(grid beatGrid, musicDuration float64, totalDuration float64) string {
+	start, end := musicSpan(grid, musicDuration, totalDuration)
+	fade := gapFadeBeats * grid.BeatDuration()
+
+	// the gain of the music, the original audio gets the rest
+	var gains []string
+	if start > 0 {
+		gains = append(gains, fmt.Sprintf("clip((t-%s)/%s,0,1)", seconds(start), seconds(fade)))
+	}
+	if end < totalDuration {
+		gains = append(gains, fmt.Sprintf("clip((%s-t)/%s,0,1)", seconds(end), seconds(fade)))
+	}
+	musicGain := "1"
+	if len(gains) > 0 {
+		musicGain = strings.Join(gains, "*")
+	}
+
+	var music []string
+	if channelMix != "" {
+		music = append(music, channelMix)
+	}
+	if MusicStart > 0 {
+		music = append(music, fmt.Sprintf("adelay=delays=%d:all=1", int(math.Round(MusicStart*1000))))
+	}
+	music = append(music, fmt.Sprintf("volume='%s':eval=frame", musicGain))
+
+	mix := "[music][original]amix=inputs=2:duration=longest:normalize=0"
+	if limitAudio {
+		mix += "," + limiterFilter()
+	}
+	return strings.Join([]string{
+		"[1:a]" + strings.Join(music, ",") + "[music]",
+		fmt.Sprintf("[2:a]volume='1-%s':eval=frame[original]", musicGain),
+		mix + "[outa]",
+	}, "; ")
+}
+
+// delayedMusicFilterComplex builds the filter graph starting the music
+// (input 1) at MusicStart with silence before it, labeled [outa].
+func delayedMusicFilterComplex() string {
+	filters := []string{fmt.Sprintf("adelay=delays=%d:all=1", int(math.Round(MusicStart*1000)))}
+	if channelMix != "" {
+		filters = append([]string{channelMix}, filters...)
+	}
+	if limitAudio {
+		filters = append(filters, limiterFilter())
+	}
+	return "[1:a]" + strings.Join(filters, ",") + "[outa]"
+}
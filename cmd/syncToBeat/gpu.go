@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// HWAccel keeps the frames on the GPU while compositing the pulse: cuda,
+// videotoolbox, or empty to composite on the CPU.
+var HWAccel = ""
+
+// gpuFilterSet is the family of filters of a hardware acceleration.
+type gpuFilterSet struct {
+	// Device is the ffmpeg device type.
+	Device string
+	// Upload moves frames from the CPU to the GPU.
+	Upload string
+	// Scale converts the decoded frames to the format composited on.
+	Scale   string
+	Overlay string
+	// Format is the pixel format of the frames downloaded back to the CPU.
+	Format string
+	// EncoderSuffix identifies the encoders reading the GPU frames directly.
+	EncoderSuffix string
+}
+
+var gpuFilterSets = map[string]gpuFilterSet{
+	"cuda": {
+		Device:        "cuda",
+		Upload:        "hwupload_cuda",
+		Scale:         "scale_cuda=format=yuv420p",
+		Overlay:       "overlay_cuda",
+		Format:        "yuv420p",
+		EncoderSuffix: "_nvenc",
+	},
+	"videotoolbox": {
+		Device:        "videotoolbox",
+		Upload:        "hwupload",
+		Scale:         "scale_vt",
+		Overlay:       "overlay_videotoolbox",
+		Format:        "nv12",
+		EncoderSuffix: "_videotoolbox",
+	},
+}
+
+// validateHWAccel checks the hardware acceleration setting.
+func validateHWAccel(accel string) error {
+	if _, ok := gpuFilterSets[accel]; accel != "" && !ok {
+		return fmt.Errorf("invalid hardware acceleration %q, expected cuda or videotoolbox", accel)
+	}
+	return nil
+}
+
+var (
+	ffmpegFiltersOnce sync.Once
+	// ffmpegFilters maps the filters of the ffmpeg build to their flags,
+	// T meaning the filter supports the enable option.
+	ffmpegFilters map[string]string
+)
+
+// availableFilters returns the filters of the ffmpeg build, listed once per
+// process.
+func availableFilters() map[string]string {
+	ffmpegFiltersOnce.Do(func() {
+		ffmpegFilters = map[string]string{}
+		ffmpegPath, err := checkFFmpegAvailable()
+		if err != nil {
+			return
+		}
+		output, err := exec.Command(ffmpegPath, "-hide_banner", "-filters").Output()
+		if err != nil {
+			return
+		}
+		// the filters are listed as: " TSC name  V->V  description"
+		for _, line := range strings.Split(string(output), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) < 3 || len(fields[0]) != 3 || !strings.Contains(fields[2], "->") {
+				continue
+			}
+			ffmpegFilters[fields[1]] = fields[0]
+		}
+	})
+	return ffmpegFilters
+}
+
+var gpuFallbackOnce sync.Once
+
+// gpuFilters returns the GPU filters of the hardware acceleration, false when
+// it's disabled or when the ffmpeg build misses some of them, in which case
+// the compositing falls back to the CPU.
+func gpuFilters() (gpuFilterSet, bool) {
+	if HWAccel == "" {
+		return gpuFilterSet{}, false
+	}
+	set := gpuFilterSets[HWAccel]
+	filters := availableFilters()
+	var missing []string
+	for _, filter := range []string{set.Upload, set.Scale, set.Overlay} {
+		name, _, _ := strings.Cut(filter, "=")
+		if _, ok := filters[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	// the pulse toggles the overlay with the enable option
+	if flags, ok := filters[set.Overlay]; ok && !strings.HasPrefix(flags, "T") {
+		missing = append(missing, set.Overlay+" with timeline support")
+	}
+	if len(missing) > 0 {
+		gpuFallbackOnce.Do(func() { say("gpu.fallback", HWAccel, strings.Join(missing, ", ")) })
+		return gpuFilterSet{}, false
+	}
+	return set, true
+}
+
+// inputArgs returns the options, preceding the first input, decoding it on
+// the GPU and setting up the device the other inputs get uploaded to.
+func (s gpuFilterSet) inputArgs() []string {
+	return []string{
+		"-init_hw_device", s.Device + "=gpu", "-filter_hw_device", "gpu",
+		"-hwaccel", s.Device, "-hwaccel_output_format", s.Device,
+	}
+}
+
+// download returns the filters, to append to a chain, moving the frames back
+// to the CPU unless the encoder reads them from the GPU.
+func (s gpuFilterSet) download() string {
+	if strings.HasSuffix(Encoder.Codec, s.EncoderSuffix) {
+		return ""
+	}
+	return ",hwdownload,format=" + s.Format
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+// the grid lives in the beatgrid package, shared with the programs embedding
+// the sync.
+type (
+	beatGrid    = beatgrid.Grid
+	GridSection = beatgrid.Section
+	meter       = beatgrid.Meter
+)
+
+// BeatTimesPath is the JSON array of the times of the beats the grid follows
+// instead of a steady tempo.
+var BeatTimesPath = ""
+
+// pulseExpression returns an ffmpeg expression of t that is true for
+// pulseDuration seconds after every pulse of the meter, which is every beat
+// in simple meters.
+func pulseExpression(g beatGrid, pulseDuration float64) string {
+	// simple meters pulse on every beat, others on the start of each group
+	pulseEvery := float64(g.Meter.BeatsPerBar())
+	pulseStarts := g.Meter.PulseStarts()
+	if len(pulseStarts) == g.Meter.BeatsPerBar() {
+		pulseEvery = 1
+		pulseStarts = []int{0}
+	}
+
+	// the grid is split in spans of constant tempo, the phase of each span is
+	// the position in the pulse pattern when it starts.
+	spans := g.Spans()
+	expression := ""
+	for i := len(spans) - 1; i >= 0; i-- {
+		s := spans[i]
+		phase := g.BeatPosition(s.Start)
+		phase -= math.Floor(phase/pulseEvery) * pulseEvery
+		position := fmt.Sprintf("mod((t-%s)*%s+%s,%s)", seconds(s.Start), exact(s.BPM/60), exact(phase), exact(pulseEvery))
+		pulseBeats := pulseDuration * s.BPM / 60
+		var terms []string
+		for _, start := range pulseStarts {
+			terms = append(terms, fmt.Sprintf("gte(%[1]s,%[2]d)*lt(%[1]s,%[3]s)", position, start, exact(float64(start)+pulseBeats)))
+		}
+		spanExpression := strings.Join(terms, "+")
+		if expression == "" {
+			expression = spanExpression
+		} else {
+			expression = fmt.Sprintf("if(lt(t,%s),%s,%s)", seconds(spans[i+1].Start), spanExpression, expression)
+		}
+	}
+	return expression
+}
+
+// positionExpression returns an ffmpeg expression of the variable v, a time
+// in seconds, evaluating to its position in beats on the grid.
+func positionExpression(g beatGrid, v string) string {
+	spans := g.Spans()
+	expression := ""
+	for i := len(spans) - 1; i >= 0; i-- {
+		s := spans[i]
+		spanExpression := fmt.Sprintf("((%s-%s)*%s+%s)", v, seconds(s.Start), exact(s.BPM/60), exact(g.BeatPosition(s.Start)))
+		if expression == "" {
+			expression = spanExpression
+		} else {
+			expression = fmt.Sprintf("if(lt(%s,%s),%s,%s)", v, seconds(spans[i+1].Start), spanExpression, expression)
+		}
+	}
+	return expression
+}
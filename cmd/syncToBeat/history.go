@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HistoryFile is the log of the runs of the project, relative to the working
+// directory, empty disables the history.
+var HistoryFile = ".syncToBeat_history.jsonl"
+
+// historyEntry records a run in the history.
+type historyEntry struct {
+	Time time.Time `json:"time"`
+	// Args is the complete command line of the run, every flag included, so
+	// that it renders the same even if the defaults or presets change.
+	Args     []string `json:"args"`
+	Outputs  []string `json:"outputs"`
+	Warnings int      `json:"warnings"`
+}
+
+// runArgs is the command line of the current run, set once the flags are
+// parsed.
+var runArgs []string
+
+// captureRunArgs records the complete command line of the run, with the value
+// of every flag followed by the positional arguments.
+func captureRunArgs(positional []string) {
+	runArgs = nil
+	flag.VisitAll(func(f *flag.Flag) {
+		if f.Name == "history" {
+			return
+		}
+		runArgs = append(runArgs, fmt.Sprintf("-%s=%s", f.Name, f.Value.String()))
+	})
+	runArgs = append(runArgs, positional...)
+}
+
+// appendHistory adds the current run to the history file, or to the store
+// when there is one.
+func appendHistory() error {
+	if HistoryFile == "" || runArgs == nil {
+		return nil
+	}
+	entry := historyEntry{
+		Time:     time.Now(),
+		Args:     runArgs,
+		Outputs:  recordedOutputs(),
+		Warnings: len(recordedWarnings()),
+	}
+	if StorePath != "" {
+		return storeAppendRun(entry)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(HistoryFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// readHistory reads the runs recorded in the history file, or in the store
+// when there is one, oldest first.
+func readHistory() ([]historyEntry, error) {
+	if StorePath != "" {
+		return storeRuns()
+	}
+	file, err := os.Open(HistoryFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s line %d: %v", HistoryFile, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// summary returns the positional arguments of the run, followed by the flags
+// that changed from their current defaults.
+func (e historyEntry) summary() string {
+	var positional, changed []string
+	for _, arg := range e.Args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || !strings.HasPrefix(name, "-") {
+			positional = append(positional, arg)
+			continue
+		}
+		if f := flag.Lookup(strings.TrimPrefix(name, "-")); f != nil && f.DefValue == value {
+			continue
+		}
+		changed = append(changed, arg)
+	}
+	return strings.Join(append(positional, changed...), " ")
+}
+
+const historyUsage = `Usage:
+  <program> history list          list the runs of the project
+  <program> history show n        print the complete command line of run n
+  <program> history rerun n       run n again with the exact same settings`
+
+// runHistoryCommand runs the history subcommands.
+func runHistoryCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.history")
+		return nil
+	}
+	entries, err := readHistory()
+	if err != nil {
+		return err
+	}
+
+	if args[0] == "list" {
+		for i, entry := range entries {
+			say("history.entry", i+1, entry.Time.Format("2006-01-02 15:04"), entry.summary())
+			for _, output := range entry.Outputs {
+				say("history.output", output)
+			}
+		}
+		return nil
+	}
+
+	if (args[0] != "show" && args[0] != "rerun") || len(args) < 2 {
+		say("usage.history")
+		return nil
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 1 || n > len(entries) {
+		return fmt.Errorf("invalid run %q, expected a number between 1 and %d", args[1], len(entries))
+	}
+	entry := entries[n-1]
+	if args[0] == "show" {
+		fmt.Println(strings.Join(entry.Args, " "))
+		return nil
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, entry.Args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		// the run already reported its error
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			os.Exit(exitErr.ExitCode())
+		}
+		return err
+	}
+	return nil
+}
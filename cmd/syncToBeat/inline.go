@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// StdinPath reads the keyframes or the music from the standard input instead
+// of a file, so other programs can pipe them in.
+const StdinPath = "-"
+
+// inputName names a path in the messages.
+func inputName(path string) string {
+	if path == StdinPath {
+		return "stdin"
+	}
+	return path
+}
+
+// readInput reads a file, or the standard input for StdinPath.
+func readInput(path string) ([]byte, error) {
+	if path == StdinPath {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// spoolStdin copies the standard input to a temporary file, the music being
+// read by several ffmpeg passes. The caller removes the file, with atExit
+// when the run can fail.
+func spoolStdin() (string, error) {
+	file, err := os.CreateTemp("", "syncToBeat-stdin-*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(file, os.Stdin); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to read stdin: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// parseBeatTimes parses a comma separated list of beat times, in seconds,
+// returned sorted.
+func parseBeatTimes(value string) ([]float64, error) {
+	var beats []float64
+	for _, part := range strings.Split(value, ",") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil || t < 0 {
+			return nil, fmt.Errorf("invalid beat time %q, expected seconds", part)
+		}
+		beats = append(beats, t)
+	}
+	if len(beats) < 2 {
+		return nil, fmt.Errorf("at least 2 beats are needed, %d given", len(beats))
+	}
+	sort.Float64s(beats)
+	return beats, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBeatTimes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []float64
+		wantErr bool
+	}{
+		{value: "0.5,1,1.5", want: []float64{0.5, 1, 1.5}},
+		{value: "2, 1.5 ,0.5", want: []float64{0.5, 1.5, 2}},
+		{value: "1", wantErr: true},
+		{value: "1,-2", wantErr: true},
+		{value: "1,two", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseBeatTimes(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBeatTimes(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseBeatTimes(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
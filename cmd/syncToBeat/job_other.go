@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detachJob isn't supported on this platform, the process of the job gets
+// the signals of the server and canceling it only kills the process itself.
+func detachJob(cmd *exec.Cmd) {}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// jobFlagKind is how a flag of a job submitted with an API key is checked.
+type jobFlagKind int
+
+const (
+	// jobSetting is a flag taking a value that only tunes the run.
+	jobSetting jobFlagKind = iota
+	// jobSwitch is a boolean flag.
+	jobSwitch
+	// jobInput is a flag naming a file the run reads, which must have been
+	// uploaded.
+	jobInput
+)
+
+// jobFlags are the flags the jobs submitted with an API key may set. The
+// flags running other programs (-plugins), writing to arbitrary paths (-o,
+// -store, -history, -farm, -result, -output-template, -workspace), injecting
+// filters (-effects, -titles and its font paths) or starting interactive and
+// other modes are left out.
+var jobFlags = map[string]jobFlagKind{
+	"video":               jobInput,
+	"keyframes":           jobInput,
+	"audio":               jobInput,
+	"beat-times":          jobInput,
+	"sections":            jobInput,
+	"ass":                 jobInput,
+	"split-with":          jobInput,
+	"audio-copy":          jobSwitch,
+	"auto-tune":           jobSwitch,
+	"debug":               jobSwitch,
+	"detect-beats":        jobSwitch,
+	"explain":             jobSwitch,
+	"gap-fill":            jobSwitch,
+	"html-report":         jobSwitch,
+	"json":                jobSwitch,
+	"keep-original-audio": jobSwitch,
+	"overwrite":           jobSwitch,
+	"reaper":              jobSwitch,
+	"remap-container":     jobSwitch,
+	"strict":              jobSwitch,
+	"audio-bitrate":       jobSetting,
+	"audio-channels":      jobSetting,
+	"audio-codec":         jobSetting,
+	"audio-peaks":         jobSetting,
+	"audio-rate":          jobSetting,
+	"av-offset":           jobSetting,
+	"beats":               jobSetting,
+	"bounce":              jobSetting,
+	"bpm":                 jobSetting,
+	"chunk-limit":         jobSetting,
+	"click-track":         jobSetting,
+	"codec":               jobSetting,
+	"color-cycle":         jobSetting,
+	"color-every":         jobSetting,
+	"container":           jobSetting,
+	"cover":               jobSetting,
+	"cover-format":        jobSetting,
+	"crf":                 jobSetting,
+	"cut-transition":      jobSetting,
+	"dead-segments":       jobSetting,
+	"deinterlace":         jobSetting,
+	"denoise":             jobSetting,
+	"denoise-strength":    jobSetting,
+	"downmix":             jobSetting,
+	"encoder-preset":      jobSetting,
+	"hue-step":            jobSetting,
+	"hwaccel":             jobSetting,
+	"keyframe-scale":      jobSetting,
+	"keyframe-units":      jobSetting,
+	"keyframes-past-end":  jobSetting,
+	"lang":                jobSetting,
+	"letterbox-pulse":     jobSetting,
+	"meter":               jobSetting,
+	"music-start":         jobSetting,
+	"musical-timecode":    jobSetting,
+	"offset":              jobSetting,
+	"onset-band":          jobSetting,
+	"onset-min-interval":  jobSetting,
+	"onset-quality":       jobSetting,
+	"onset-threshold":     jobSetting,
+	"preset":              jobSetting,
+	"probe-backend":       jobSetting,
+	"pulse-envelope":      jobSetting,
+	"remove-dead":         jobSetting,
+	"render-mode":         jobSetting,
+	"retime-curve":        jobSetting,
+	"seed":                jobSetting,
+	"shake":               jobSetting,
+	"shake-decay":         jobSetting,
+	"shake-on":            jobSetting,
+	"sharpen":             jobSetting,
+	"snap-to":             jobSetting,
+	"speed-limit":         jobSetting,
+	"split-every":         jobSetting,
+	"split-layout":        jobSetting,
+	"split-mode":          jobSetting,
+	"stall-retries":       jobSetting,
+	"stall-timeout":       jobSetting,
+	"sticker-count":       jobSetting,
+	"sticker-every":       jobSetting,
+	"sticker-size":        jobSetting,
+	"subdivision":         jobSetting,
+	"swing":               jobSetting,
+	"target":              jobSetting,
+	"target-bars":         jobSetting,
+	"target-duration":     jobSetting,
+	"timeout":             jobSetting,
+	"tints":               jobSetting,
+	"trim-fade":           jobSetting,
+	"trim-phrase":         jobSetting,
+	"upmix":               jobSetting,
+	"vignette-pulse":      jobSetting,
+	"wobble":              jobSetting,
+}
+
+// checkJobArgs checks the arguments of a job submitted with an API key: one
+// of the commands taking their inputs as flags, the flags of jobFlags and
+// input files within uploadDir, where the outputs are written next to them.
+func checkJobArgs(args []string, uploadDir string) error {
+	command := ""
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return fmt.Errorf("unexpected argument %q", arg)
+		}
+		if !strings.HasPrefix(arg, "-") || arg == StdinPath {
+			if command != "" {
+				return fmt.Errorf("unexpected argument %q, jobs take their inputs as flags", arg)
+			}
+			if !slices.Contains(commands, arg) {
+				return fmt.Errorf("invalid command %q, jobs run one of %s", arg, strings.Join(commands, ", "))
+			}
+			command = arg
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		kind, ok := jobFlags[name]
+		if !ok {
+			return fmt.Errorf("flag -%s isn't allowed in jobs", name)
+		}
+		if kind == jobSwitch {
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return fmt.Errorf("flag -%s needs a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		if kind == jobInput && !withinDir(value, uploadDir) {
+			return fmt.Errorf("-%s %q isn't an uploaded file", name, value)
+		}
+	}
+	if command == "" {
+		return fmt.Errorf("jobs run one of %s", strings.Join(commands, ", "))
+	}
+	return nil
+}
+
+// withinDir reports whether the file at path, links resolved, is in dir.
+func withinDir(path string, dir string) bool {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	if resolved, err = filepath.Abs(resolved); err != nil {
+		return false
+	}
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(root, resolved)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
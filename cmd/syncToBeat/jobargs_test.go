@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckJobArgs(t *testing.T) {
+	uploads := t.TempDir()
+	video := filepath.Join(uploads, "video.mp4")
+	keyframes := filepath.Join(uploads, "keyframes.json")
+	for _, path := range []string{video, keyframes} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	titles := filepath.Join(uploads, "titles.json")
+	if err := os.WriteFile(titles, []byte(`[{"label": "drop", "text": "Drop", "font": "x.ttf':text=a,movie=/etc/passwd[leak];[leak]"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.json")
+	if err := os.WriteFile(outside, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(uploads, "link.json")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "sync", args: []string{"sync", "-bpm", "120", "-video", video, "-keyframes", keyframes}},
+		{name: "flags before the command", args: []string{"-bpm=120", "--video", video, "-keyframes=" + keyframes, "plan"}},
+		{name: "switches", args: []string{"pulse", "-bpm", "120", "-video", video, "-json", "-overwrite"}},
+		{name: "no command", args: []string{"-bpm", "120", "-video", video}, wantErr: true},
+		{name: "positional inputs", args: []string{"120", video, keyframes}, wantErr: true},
+		{name: "unknown command", args: []string{"history", "rerun"}, wantErr: true},
+		{name: "two commands", args: []string{"sync", "plan"}, wantErr: true},
+		{name: "output path", args: []string{"sync", "-o", "/etc/passwd"}, wantErr: true},
+		{name: "title cards", args: []string{"sync", "-bpm", "120", "-video", video, "-keyframes", keyframes, "-titles", titles}, wantErr: true},
+		{name: "plugins", args: []string{"sync", "-plugins", "/tmp/plugins.json"}, wantErr: true},
+		{name: "input outside of the uploads", args: []string{"sync", "-keyframes", outside}, wantErr: true},
+		{name: "input escaping the uploads", args: []string{"sync", "-keyframes", filepath.Join(uploads, "..", filepath.Base(filepath.Dir(outside)), "secret.json")}, wantErr: true},
+		{name: "link out of the uploads", args: []string{"sync", "-keyframes", link}, wantErr: true},
+		{name: "missing input", args: []string{"sync", "-video", filepath.Join(uploads, "missing.mp4")}, wantErr: true},
+		{name: "stdin", args: []string{"sync", "-keyframes", StdinPath}, wantErr: true},
+		{name: "missing value", args: []string{"sync", "-bpm"}, wantErr: true},
+		{name: "end of the flags", args: []string{"sync", "--", "-o"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkJobArgs(tt.args, uploads); (err != nil) != tt.wantErr {
+				t.Errorf("checkJobArgs(%q) error = %v, wantErr %v", tt.args, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		path string
+		want bool
+	}{
+		{name: "file", path: file, want: true},
+		{name: "cleaned path", path: filepath.Join(dir, ".", "file"), want: true},
+		{name: "the directory itself", path: dir, want: false},
+		{name: "parent", path: filepath.Dir(dir), want: false},
+		{name: "missing", path: filepath.Join(dir, "missing"), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withinDir(tt.path, dir); got != tt.want {
+				t.Errorf("withinDir(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
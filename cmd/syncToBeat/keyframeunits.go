@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// KeyframeUnits is the unit of the keyframe times: seconds, ms, frames,
+// timecode (HH:MM:SS:FF, or HH:MM:SS;FF for drop-frame) or auto to detect it.
+var KeyframeUnits = "auto"
+
+// rawKeyframe is a keyframe as written in a keyframe file, its time being a
+// number or a timecode string.
+type rawKeyframe struct {
+	Time     json.RawMessage `json:"time"`
+	Label    string          `json:"label,omitempty"`
+	Priority int             `json:"priority,omitempty"`
+}
+
+// validateKeyframeUnits checks the unit of the keyframe times.
+func validateKeyframeUnits(units string) error {
+	switch units {
+	case "auto", "seconds", "ms", "frames", "timecode":
+		return nil
+	}
+	return fmt.Errorf("invalid keyframe units %q, expected auto, seconds, ms, frames or timecode", units)
+}
+
+// parseTimecode converts a HH:MM:SS:FF timecode to seconds. The frames are
+// counted at the nominal rate of fps, and drop-frame timecodes (separated by
+// a semicolon) skip the frame numbers dropped every minute but every tenth.
+func parseTimecode(timecode string, fps float64) (float64, error) {
+	dropFrame := strings.Contains(timecode, ";")
+	parts := strings.FieldsFunc(timecode, func(r rune) bool { return r == ':' || r == ';' })
+	if len(parts) != 4 {
+		return 0, fmt.Errorf("invalid timecode %q, expected HH:MM:SS:FF", timecode)
+	}
+	var fields [4]int
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return 0, fmt.Errorf("invalid timecode %q, expected HH:MM:SS:FF", timecode)
+		}
+		fields[i] = value
+	}
+	nominal := int(math.Round(fps))
+	if fields[3] >= nominal {
+		return 0, fmt.Errorf("invalid timecode %q, frame %d at %d fps", timecode, fields[3], nominal)
+	}
+	totalMinutes := fields[0]*60 + fields[1]
+	frames := (totalMinutes*60+fields[2])*nominal + fields[3]
+	if dropFrame {
+		dropped := nominal / 15 // 2 frames at 29.97 fps, 4 at 59.94
+		frames -= dropped * (totalMinutes - totalMinutes/10)
+	}
+	return float64(frames) / fps, nil
+}
+
+// detectKeyframeUnits guesses the unit of the keyframe times: strings are
+// timecodes, and whole numbers too large for seconds are frames or
+// milliseconds when they fit in the video.
+func detectKeyframeUnits(raw []rawKeyframe, fps float64, duration float64) string {
+	var last float64
+	whole := true
+	for _, kf := range raw {
+		var value float64
+		if err := json.Unmarshal(kf.Time, &value); err != nil {
+			return "timecode"
+		}
+		last = math.Max(last, value)
+		whole = whole && value == math.Trunc(value)
+	}
+	if !whole || duration <= 0 || last <= duration {
+		return "seconds"
+	}
+	if fps > 0 && last/fps <= duration {
+		return "frames"
+	}
+	if last/1000 <= duration {
+		return "ms"
+	}
+	return "seconds"
+}
+
+// convertKeyframes converts the raw keyframes of a file to keyframes in
+// seconds, probing the frame rate and duration of the video when the units
+// need them.
+func convertKeyframes(raw []rawKeyframe, units string, videoPath string) ([]Keyframe, error) {
+	var fps, duration float64
+	if units != "seconds" && units != "ms" {
+		info, err := probe.ProbeMedia(videoPath)
+		if err != nil && units != "auto" {
+			return nil, fmt.Errorf("failed to probe the frame rate of %s: %v", videoPath, err)
+		}
+		// detecting the units works without the video, as long as the times
+		// are in seconds
+		if err == nil {
+			duration = info.Duration
+			if video, ok := info.Video(); ok {
+				fps = video.FPS
+			}
+		}
+	}
+	if units == "auto" {
+		if units = detectKeyframeUnits(raw, fps, duration); units != "seconds" {
+			say("keyframes.units", units)
+		}
+	}
+	if (units == "frames" || units == "timecode") && fps <= 0 {
+		return nil, fmt.Errorf("keyframes in %s need the frame rate of %s", units, videoPath)
+	}
+
+	keyframes := make([]Keyframe, len(raw))
+	for i, kf := range raw {
+		keyframes[i] = Keyframe{Label: kf.Label, Priority: kf.Priority}
+		if units == "timecode" {
+			var timecode string
+			if err := json.Unmarshal(kf.Time, &timecode); err != nil {
+				return nil, fmt.Errorf("keyframe %d: expected a timecode string, got %s", i, kf.Time)
+			}
+			t, err := parseTimecode(timecode, fps)
+			if err != nil {
+				return nil, fmt.Errorf("keyframe %d: %v", i, err)
+			}
+			keyframes[i].Time = t
+			continue
+		}
+		var value float64
+		if err := json.Unmarshal(kf.Time, &value); err != nil {
+			return nil, fmt.Errorf("keyframe %d: expected a number of %s, got %s", i, units, kf.Time)
+		}
+		switch units {
+		case "ms":
+			value /= 1000
+		case "frames":
+			value /= fps
+		}
+		keyframes[i].Time = value
+	}
+	return keyframes, nil
+}
+
+// rawTime returns the JSON time of a keyframe read from a text file, a
+// number or, for timecodes, a string.
+func rawTime(value string) json.RawMessage {
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return json.RawMessage(value)
+	}
+	data, _ := json.Marshal(value)
+	return data
+}
+
+// validateRawTime checks a time read from a text file, which must be a
+// number or a timecode.
+func validateRawTime(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty time")
+	}
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(number) || math.IsInf(number, 0) || number < 0 {
+			return fmt.Errorf("invalid time %q", value)
+		}
+		return nil
+	}
+	if strings.Count(value, ":")+strings.Count(value, ";") != 3 {
+		return fmt.Errorf("invalid time %q, expected a number or a HH:MM:SS:FF timecode", value)
+	}
+	return nil
+}
+
+// parseTextKeyframes parses a list of keyframe times, one per line. Blank
+// lines and lines starting with # are skipped.
+func parseTextKeyframes(data []byte) ([]rawKeyframe, error) {
+	var keyframes []rawKeyframe
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := validateRawTime(line); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		keyframes = append(keyframes, rawKeyframe{Time: rawTime(line)})
+	}
+	return keyframes, nil
+}
+
+// parseCSVKeyframes parses keyframes as time,label[,priority] rows, with an
+// optional header row.
+func parseCSVKeyframes(data []byte) ([]rawKeyframe, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var keyframes []rawKeyframe
+	for i, record := range records {
+		if i == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "time") {
+			continue
+		}
+		line := i + 1
+		if len(record) > 3 {
+			return nil, fmt.Errorf("row %d: %d columns, expected time,label[,priority]", line, len(record))
+		}
+		value := strings.TrimSpace(record[0])
+		if err := validateRawTime(value); err != nil {
+			return nil, fmt.Errorf("row %d: %v", line, err)
+		}
+		kf := rawKeyframe{Time: rawTime(value)}
+		if len(record) > 1 {
+			kf.Label = strings.TrimSpace(record[1])
+		}
+		if len(record) > 2 {
+			if priority := strings.TrimSpace(record[2]); priority != "" {
+				if kf.Priority, err = strconv.Atoi(priority); err != nil {
+					return nil, fmt.Errorf("row %d: invalid priority %q", line, priority)
+				}
+			}
+		}
+		keyframes = append(keyframes, kf)
+	}
+	return keyframes, nil
+}
+
+// chapterLine matches the timestamps of chapter lists such as video
+// descriptions: "0:00 Intro", "1:23 - Verse" or "(1:02:03) Outro".
+var chapterLine = regexp.MustCompile(`^\(?((?:\d+:)?\d{1,2}:\d{2})\)?(?:\s+[-–—:|]?\s*(.*))?$`)
+
+// parseChapters parses the labeled timestamps of a chapter list, ignoring
+// the other lines of the text. The chapter starting the video isn't a cut
+// and is skipped. ok is false when the text has no chapters.
+func parseChapters(data []byte) (keyframes []Keyframe, ok bool) {
+	for _, line := range strings.Split(string(data), "\n") {
+		match := chapterLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		ok = true
+		var seconds float64
+		for _, field := range strings.Split(match[1], ":") {
+			value, _ := strconv.Atoi(field)
+			seconds = seconds*60 + float64(value)
+		}
+		if seconds == 0 {
+			continue
+		}
+		keyframes = append(keyframes, Keyframe{Time: seconds, Label: strings.TrimSpace(match[2])})
+	}
+	return keyframes, ok
+}
@@ -0,0 +1,1438 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+var (
+	Debug = false
+)
+
+// Keyframe represents the JSON structure for keyframes.
+type Keyframe = plan.Keyframe
+
+// readKeyframes reads the keyframe data from a JSON file, a CSV file of
+// time,label rows or a text file of one time per line. The times, in
+// KeyframeUnits, are converted to seconds using the frame rate of the video.
+// Text files listing chapters ("1:23 Verse") give labeled keyframes in
+// seconds. StdinPath reads JSON keyframes from the standard input.
+func readKeyframes(filePath string, videoPath string) ([]Keyframe, error) {
+	var keyframes []rawKeyframe
+	fileBytes, err := readInput(filePath)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".csv":
+		keyframes, err = parseCSVKeyframes(fileBytes)
+	case ".txt":
+		if chapters, ok := parseChapters(fileBytes); ok {
+			if len(chapters) == 0 {
+				return nil, fmt.Errorf("no chapters after the start of the video in %s", filePath)
+			}
+			say("keyframes.chapters", len(chapters))
+			return chapters, nil
+		}
+		keyframes, err = parseTextKeyframes(fileBytes)
+	default:
+		err = json.Unmarshal(fileBytes, &keyframes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyframes in %s: %v", inputName(filePath), err)
+	}
+	if len(keyframes) == 0 {
+		return nil, fmt.Errorf("no keyframes in %s", inputName(filePath))
+	}
+	return convertKeyframes(keyframes, KeyframeUnits, videoPath)
+}
+
+func addPulseToVideo(inputVideoPath string, grid beatGrid, audioPath string, outputVideoPath string) error {
+	info, err := probe.ProbeMedia(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %v", err)
+	}
+	video, ok := info.Video()
+	if !ok {
+		return fmt.Errorf("failed to get video dimensions: no video streams found")
+	}
+	totalDuration := info.Duration
+	dimensions := VideoDimensions{Width: video.Width, Height: video.Height}
+
+	// Correctly configure filter complex depending on whether an audio file is provided
+	var filterComplex string
+	whiteInputIndex := 1
+	if audioPath != "" {
+		whiteInputIndex = 2 // Adjust index if audio is present
+	}
+	pulseDuration := 0.1 // Duration of the pulse in seconds
+
+	gpu, onGPU := gpuFilters()
+	if onGPU {
+		// there's no GPU blend filter, the pulse overlays a translucent white
+		// frame instead.
+		filterComplex = fmt.Sprintf(
+			"[0:v]%s[base]; [%d:v]format=yuva420p,colorchannelmixer=aa=0.5,%s[flash]; "+
+				"[base][flash]%s=enable='if(%s,1,0)'%s%s[output]",
+			gpu.Scale, whiteInputIndex, gpu.Upload, gpu.Overlay, pulseExpression(grid, pulseDuration), gpu.download(), videoOffsetFilter(),
+		)
+	} else {
+		filterComplex = fmt.Sprintf(
+			"[0:v]format=yuva420p[base]; "+
+				"[base][%d:v]blend=all_mode=overlay:all_opacity=1:enable='if(%s,1,0)'%s[output]",
+			whiteInputIndex, pulseExpression(grid, pulseDuration), videoOffsetFilter(),
+		)
+	}
+
+	cmdArgs := []string{"-y"}
+	if onGPU {
+		cmdArgs = append(cmdArgs, gpu.inputArgs()...)
+	}
+	cmdArgs = append(cmdArgs, "-i", inputVideoPath)
+
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-i", audioPath)
+	}
+
+	whiteSource := fmt.Sprintf("color=c=white:s=%dx%d:d=%s:r=25", dimensions.Width, dimensions.Height, seconds(totalDuration))
+	if SourceColor != (colorSettings{}) {
+		whiteSource += "," + colorConvertFilter(SourceColor, "yuv420p")
+	}
+	cmdArgs = append(cmdArgs,
+		"-f", "lavfi", "-i", whiteSource,
+		"-filter_complex", filterComplex,
+		"-map", "[output]",
+	)
+
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", "1:a") // Correctly map audio stream
+		cmdArgs = append(cmdArgs, audioMuxArgs()...)
+	}
+
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs,
+		"-t", seconds(totalDuration+max(AVOffset, 0)).timestamp(),
+		outputVideoPath,
+	)
+
+	say("pulse.start", inputVideoPath)
+	if err := runFFmpeg("pulse", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
+
+// planVideo plans the segments of the video, moved onto its frames, and
+// prints the plan report.
+func planVideo(grid beatGrid, originalVideoPath string, keyframes []Keyframe) ([]segment, error) {
+	if duration, err := getVideoDuration(originalVideoPath); err == nil {
+		if err := checkKeyframesDuration(keyframes, duration); err != nil {
+			return nil, err
+		}
+	}
+	var video probe.Stream
+	hasVideo := false
+	if info, err := probe.ProbeMedia(originalVideoPath); err == nil {
+		video, hasVideo = info.Video()
+	}
+	segments, err := planFrames(grid, keyframes, video.FrameRate)
+	if err != nil {
+		return nil, err
+	}
+	if DeadSegments != "" {
+		if segments, err = checkDeadSegments(originalVideoPath, segments, DeadSegments); err != nil {
+			return nil, err
+		}
+	}
+	if hasVideo {
+		segments = plan.Quantize(segments, video)
+	}
+	if err := checkStrictSegments(keyframes, segments); err != nil {
+		return nil, err
+	}
+	printPlanReport(grid, keyframes, segments, getVideoFrameRate(originalVideoPath))
+	return segments, nil
+}
+
+func ffmpegAdjustSpeed(grid beatGrid, originalVideoPath string, audioPath string, outputPath string, keyframes []Keyframe) error {
+	segments, err := planVideo(grid, originalVideoPath, keyframes)
+	if err != nil {
+		return err
+	}
+	if err := saveWorkspacePlan(outputPath, grid, keyframes, segments); err != nil {
+		return fmt.Errorf("failed to save the plan: %v", err)
+	}
+	var effects []string
+	// the cleanup comes first so the overlays stay sharp
+	if post := postFilter(PostFilters); post != "" {
+		effects = append(effects, post)
+	}
+	if Wobble > 0 {
+		if err := validateWobble(grid, Wobble); err != nil {
+			return err
+		}
+		effects = append(effects, wobbleFilter(grid, Wobble))
+	}
+	if ColorCycle.Mode != "" {
+		colors, err := colorCycleFilter(grid, ColorCycle)
+		if err != nil {
+			return err
+		}
+		if colors != "" {
+			effects = append(effects, colors)
+		}
+	}
+	if BeatPulse.Vignette > 0 || BeatPulse.Letterbox > 0 {
+		pulses, err := beatPulseFilter(grid, BeatPulse)
+		if err != nil {
+			return err
+		}
+		effects = append(effects, pulses)
+	}
+	// the picture in picture effects are composited once the video is synced
+	if filters, _ := splitOverlays(CustomEffects); len(filters) > 0 {
+		custom, commandsPath, err := customEffectsFilter(filters, grid, audioPath, segments[len(segments)-1].NearestBeatTime)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(commandsPath)
+		effects = append(effects, custom)
+	}
+	if CutTransition != "" {
+		var cuts []float64
+		for _, seg := range segments[:len(segments)-1] {
+			cuts = append(cuts, seg.NearestBeatTime)
+		}
+		transitions, err := cutTransitionsFilter(CutTransition, grid, cuts, CutTransitionBeats)
+		if err != nil {
+			return err
+		}
+		if transitions != "" {
+			effects = append(effects, transitions)
+		}
+	}
+	plugins, err := pluginEffects(grid, originalVideoPath, audioPath, keyframes, segments)
+	if err != nil {
+		return err
+	}
+	effects = append(effects, plugins...)
+	titles, err := titlesFilter(Titles, grid, keyframes, segments)
+	if err != nil {
+		return err
+	}
+	if titles != "" {
+		effects = append(effects, titles)
+	}
+	if ASSOverlay != "" {
+		assPath, err := expandASS(ASSOverlay, grid, segments[len(segments)-1].NearestBeatTime)
+		if err != nil {
+			return fmt.Errorf("failed to read the ASS overlay: %v", err)
+		}
+		defer os.Remove(assPath)
+		effects = append(effects, "ass=f="+escapeFilterText(assPath))
+	}
+	if MusicalTimecode == "burn" {
+		effects = append(effects, musicalTimecodeFilter(grid))
+	}
+
+	segmentFiles, err := useSegmentFiles(segments)
+	if err != nil {
+		return err
+	}
+
+	say("sync.start", originalVideoPath, grid.BPM)
+	if FarmDir != "" {
+		if err := renderOnFarm(segments, effects, originalVideoPath, outputPath); err != nil {
+			say("ffmpeg.failed", "sync", err)
+			return err
+		}
+	} else if ChunkLimit > 0 || segmentFiles {
+		maxSegments := 0
+		if segmentFiles {
+			maxSegments = segmentsPerChunk
+		}
+		if err := renderChunked(segments, effects, originalVideoPath, outputPath, ChunkLimit, maxSegments); err != nil {
+			say("ffmpeg.failed", "sync", err)
+			return err
+		}
+	} else {
+		filterComplex := speedFilterComplex(segments)
+		outputLabel := "[outv]"
+		if len(effects) > 0 {
+			filterComplex += "; [outv]" + strings.Join(effects, ",") + "[effects]"
+			outputLabel = "[effects]"
+		}
+
+		// Assemble the FFmpeg command
+		cmdArgs := []string{
+			"-y", // Add this line to automatically overwrite files without asking
+			"-i", originalVideoPath,
+			"-filter_complex", filterComplex,
+			"-map", outputLabel,
+			"-an", // This line ensures no audio tracks are included
+		}
+		cmdArgs = append(cmdArgs, encoderArgs()...)
+		cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+		cmdArgs = append(cmdArgs, outputPath)
+
+		if Debug {
+			log.Println("Running FFmpeg with arguments:", cmdArgs)
+		}
+
+		// Execute the FFmpeg command
+		if err := runFFmpeg("sync", cmdArgs); err != nil {
+			say("ffmpeg.failed", "sync", err)
+			return err
+		}
+	}
+	if MusicalTimecode == "track" {
+		if err := addMusicalTimecodeTrack(outputPath, grid); err != nil {
+			return fmt.Errorf("failed to add the timecode track: %v", err)
+		}
+	}
+	say("sync.saved", outputPath)
+	recordOutput(outputPath)
+
+	if audioPath != "" {
+		totalDuration, err := getVideoDuration(outputPath)
+		if err != nil {
+			return fmt.Errorf("failed to get video duration: %v", err)
+		}
+
+		var originalAudioPath string
+		if KeepOriginalAudio || GapFill {
+			if originalAudioPath, err = renderOriginalAudio(originalVideoPath, segments); err != nil {
+				return fmt.Errorf("failed to retime the original audio: %v", err)
+			}
+			if originalAudioPath != "" {
+				defer os.Remove(originalAudioPath)
+			}
+		}
+
+		cmdArgs := []string{"-y"}
+		cmdArgs = append(cmdArgs, videoOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-i", outputPath) // Add the video input
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-i", audioPath) // Add the audio input
+		if originalAudioPath != "" {
+			// the original audio follows the video
+			cmdArgs = append(cmdArgs, videoOffsetArgs()...)
+			cmdArgs = append(cmdArgs, "-i", originalAudioPath)
+		}
+		cmdArgs = append(cmdArgs, "-c:v", "copy") // Use the same video codec to avoid re-encoding video
+		musicStream := "1:a:0"
+		switch {
+		case GapFill && originalAudioPath != "":
+			musicDuration, err := getVideoDuration(audioPath)
+			if err != nil {
+				return fmt.Errorf("failed to get audio duration: %v", err)
+			}
+			cmdArgs = append(cmdArgs, "-filter_complex", gapFillFilterComplex(grid, musicDuration, totalDuration))
+			cmdArgs = append(cmdArgs, audioEncodeArgs()...)
+			musicStream = "[outa]"
+		case MusicStart > 0:
+			cmdArgs = append(cmdArgs, "-filter_complex", delayedMusicFilterComplex())
+			cmdArgs = append(cmdArgs, audioEncodeArgs()...)
+			musicStream = "[outa]"
+		default:
+			cmdArgs = append(cmdArgs, audioMuxArgs()...)
+		}
+		cmdArgs = append(cmdArgs,
+			"-strict", "experimental", // This may be required for certain audio codecs/formats
+			"-map", "0:v:0", // Map the video stream from the first input (the modified video)
+			"-map", musicStream, // Map the audio stream from the second input (the provided audio file)
+		)
+		if KeepOriginalAudio && originalAudioPath != "" {
+			cmdArgs = append(cmdArgs, "-map", "2:a:0")
+			cmdArgs = append(cmdArgs, dualAudioArgs()...)
+		}
+		cmdArgs = append(cmdArgs, "-t", seconds(totalDuration+max(AVOffset, 0)).timestamp())
+
+		withAudioOutputPath := audioOutputPath(outputPath)
+		cmdArgs = append(cmdArgs, containerArgs(withAudioOutputPath)...)
+		cmdArgs = append(cmdArgs, withAudioOutputPath)
+
+		say("audio.start", audioPath, outputPath)
+		// Then execute the FFmpeg command as before
+		if err := runFFmpeg("audio mux", cmdArgs); err != nil {
+			say("ffmpeg.failed", "audio mux", err)
+			return err
+		}
+		if MusicalTimecode == "track" {
+			if err := addMusicalTimecodeTrack(withAudioOutputPath, grid); err != nil {
+				return fmt.Errorf("failed to add the timecode track: %v", err)
+			}
+		}
+		recordOutput(withAudioOutputPath)
+		if err := verifyMuxedAudio(audioPath, withAudioOutputPath); err != nil {
+			say("audio.verify_failed", err)
+		}
+	}
+
+	return nil
+}
+
+// audioOutputPath returns the path of the synced video with the audio
+// injected.
+func audioOutputPath(outputPath string) string {
+	dir := filepath.Dir(outputPath)
+	name, extension := splitExtension(filepath.Base(outputPath))
+	return filepath.Join(dir, name+"_audio_"+extension)
+}
+
+func addTextOverlay(text string, inputVideoPath string) error {
+	ext := filepath.Ext(inputVideoPath)
+	outputVideoPath := "tempOutput" + ext
+
+	// Define the drawtext filter settings
+	fontColor := "white"
+	fontSize := "24"
+	x := "10"                            // 10 pixels from the left
+	y := "h-th-10"                       // 10 pixels from the bottom edge of the video
+	fontFile := "fonts/Roboto-Light.ttf" // Specify the path to your font file
+
+	drawText := fmt.Sprintf(
+		"drawtext=text='%s':fontcolor=%s:fontsize=%s:x=%s:y=%s:fontfile='%s'",
+		text, fontColor, fontSize, x, y, fontFile,
+	)
+
+	// Construct the FFmpeg command with the drawtext filter
+	cmdArgs := []string{
+		"-y",
+		"-i", inputVideoPath,
+		"-vf", drawText,
+		"-codec:a", "copy", // Copy audio without re-encoding, if present
+		outputVideoPath,
+	}
+
+	say("text.start", inputVideoPath)
+
+	if err := runFFmpeg("text overlay", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	// delete the original file and rename the new file
+	if err := os.Remove(inputVideoPath); err != nil {
+		return fmt.Errorf("text overlay error while replacing the original file: %v", err)
+	}
+	if err := os.Rename(outputVideoPath, inputVideoPath); err != nil {
+		return fmt.Errorf("text overlay error while renaming new file: %v", err)
+	}
+
+	return nil
+}
+
+func roundToBeat(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// estimateBPM calculates the estimated BPM from a slice of Keyframe structs, adjusting for potential whole bar durations
+func estimateBPM(keyframes []Keyframe) float64 {
+	if len(keyframes) < 2 {
+		say("bpm.not_enough_keyframes")
+		return 0
+	}
+
+	// Calculate intervals between consecutive keyframes
+	var totalInterval float64
+	for i := 1; i < len(keyframes); i++ {
+		interval := keyframes[i].Time - keyframes[i-1].Time
+		totalInterval += interval
+	}
+
+	// Compute average interval
+	averageInterval := totalInterval / float64(len(keyframes)-1)
+
+	// Initial BPM estimation (assuming the interval is per beat)
+	initialEstimate := 60 / averageInterval
+
+	// Adjust for 4/4 rhythm if necessary (considering common multipliers for beats per bar)
+	multipliers := []float64{1, 2, 4} // Represents single beat, 2 beats (half-note), and whole bar (4 beats) in 4/4 time
+	closestBPM := initialEstimate
+	for _, multiplier := range multipliers {
+		adjustedBPM := initialEstimate * multiplier
+		if adjustedBPM >= 50 && adjustedBPM <= 200 {
+			closestBPM = adjustedBPM
+			break
+		}
+	}
+
+	return closestBPM
+}
+
+// renderSync writes the beat synced video along with the debug videos
+// showing the beat grid pulse on the synced and original videos.
+func renderSync(grid beatGrid, estimatedBPM float64, originalVideoPath string, audioPath string, keyframes []Keyframe) error {
+	bpm := grid.BPM
+	detectSourceColor(originalVideoPath)
+	planDeinterlace(originalVideoPath)
+	dir := filepath.Dir(originalVideoPath)
+	name, extension := splitExtension(filepath.Base(originalVideoPath))
+	extension = outputExtension(extension)
+	outputName := func(kind string, bpm float64) (string, error) {
+		return Output.path(dir, name, extension, kind, bpm)
+	}
+
+	outputPath := Output.Path
+	var err error
+	if outputPath == "" {
+		if outputPath, err = outputName("sync", bpm); err != nil {
+			return err
+		}
+	}
+	if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+		return err
+	}
+	_, extension = splitExtension(outputPath)
+	err = ffmpegAdjustSpeed(grid, originalVideoPath, audioPath, outputPath, keyframes)
+	if err != nil {
+		say("sync.failed", err)
+		return err
+	}
+
+	if Cover.At != "" {
+		coverPath, err := Output.path(dir, name, "."+Cover.Format, "cover", bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportCover(Cover, grid, keyframes, outputPath, audioPath, coverPath); err != nil {
+			return fmt.Errorf("failed to export the cover: %v", err)
+		}
+	}
+
+	if RetimeCurve != "" {
+		curvePath, err := Output.path(dir, name, "."+RetimeCurve, "retime", bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportRetimeCurve(grid, keyframes, curvePath); err != nil {
+			return fmt.Errorf("failed to export the retime curve: %v", err)
+		}
+	}
+
+	if ClickTrack != "" {
+		// the clicks cover the music, or the synced video without one
+		musicPath := audioPath
+		if musicPath == "" {
+			musicPath = outputPath
+		}
+		duration, err := getVideoDuration(musicPath)
+		if err != nil {
+			return fmt.Errorf("failed to get the duration of %s: %v", musicPath, err)
+		}
+		clickPath, err := Output.path(dir, name, "."+ClickTrack, "click", bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportClickTrack(grid, duration, clickPath); err != nil {
+			return fmt.Errorf("failed to export the click track: %v", err)
+		}
+	}
+
+	if ReaperProject {
+		projectPath, err := Output.path(dir, name, ".rpp", "reaper", bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportReaperProject(grid, keyframes, outputPath, audioPath, projectPath); err != nil {
+			return fmt.Errorf("failed to export the Reaper project: %v", err)
+		}
+	}
+
+	if HTMLReport {
+		reportPath, err := Output.path(dir, name, ".html", "report", bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportHTMLReport(grid, keyframes, originalVideoPath, outputPath, audioPath, reportPath); err != nil {
+			return fmt.Errorf("failed to export the report: %v", err)
+		}
+	}
+
+	if Platform.Name != "" {
+		source := outputPath
+		if audioPath != "" {
+			source = audioOutputPath(outputPath)
+		}
+		outputPlatformPath, err := outputName(Platform.Name, bpm)
+		if err != nil {
+			return err
+		}
+		if err := exportForPlatform(source, audioPath != "", outputPlatformPath, Platform); err != nil {
+			return fmt.Errorf("failed to export for %s: %v", Platform.Name, err)
+		}
+	}
+
+	if PhraseTrim.Bars > 0 {
+		source := outputPath
+		if audioPath != "" {
+			source = audioOutputPath(outputPath)
+		}
+		outputTrimmedPath, err := outputName("phrases", bpm)
+		if err != nil {
+			return err
+		}
+		if err := trimToPhrases(source, grid, outputTrimmedPath, PhraseTrim); err != nil {
+			return fmt.Errorf("failed to trim to phrases: %v", err)
+		}
+	}
+
+	if Stickers.Dir != "" {
+		outputStickersPath, err := outputName("stickers", bpm)
+		if err != nil {
+			return err
+		}
+		if err := addStickersToVideo(outputPath, grid, outputStickersPath, Stickers); err != nil {
+			return fmt.Errorf("failed to add stickers to video: %v", err)
+		}
+	}
+
+	if BounceAmount > 0 && audioPath != "" {
+		outputBouncePath, err := outputName("bounce", bpm)
+		if err != nil {
+			return err
+		}
+		if err := addBounceToVideo(outputPath, audioPath, outputBouncePath, BounceAmount); err != nil {
+			return fmt.Errorf("failed to add bounce to video: %v", err)
+		}
+	}
+
+	if SplitScreen.With != "" {
+		outputSplitPath, err := outputName("split", bpm)
+		if err != nil {
+			return err
+		}
+		if err := addSplitScreen(outputPath, grid, SplitScreen, outputSplitPath); err != nil {
+			return fmt.Errorf("failed to add the split screen: %v", err)
+		}
+	}
+
+	if _, overlays := splitOverlays(CustomEffects); len(overlays) > 0 {
+		outputPipPath, err := outputName("pip", bpm)
+		if err != nil {
+			return err
+		}
+		if err := addPictureInPicture(outputPath, grid, audioPath, overlays, outputPipPath); err != nil {
+			return fmt.Errorf("failed to add the picture in picture: %v", err)
+		}
+	}
+
+	if Shake.Amplitude > 0 {
+		outputShakePath, err := outputName("shake", bpm)
+		if err != nil {
+			return err
+		}
+		if err := addShakeToVideo(outputPath, grid, keyframes, outputShakePath, Shake); err != nil {
+			return fmt.Errorf("failed to add shake to video: %v", err)
+		}
+	}
+
+	outputPulsePath, err := outputName("debug", bpm)
+	if err != nil {
+		return err
+	}
+	if err := addPulseToVideo(outputPath, grid, audioPath, outputPulsePath); err != nil {
+		return fmt.Errorf("failed to add pulse to video: %v", err)
+	}
+	addTextOverlay(fmt.Sprintf("syncd @ %.0f BPM", bpm), outputPulsePath)
+
+	outputNotSyncedPath, err := outputName("not_synced", 0)
+	if err != nil {
+		return err
+	}
+	if err := addPulseToVideo(originalVideoPath, beatGrid{BPM: estimatedBPM}, audioPath, outputNotSyncedPath); err != nil {
+		return fmt.Errorf("failed to add pulse to original video: %v", err)
+	}
+	addTextOverlay(fmt.Sprintf("unsyncd - %.0f BPM", bpm), outputNotSyncedPath)
+
+	return nil
+}
+
+func main() {
+	anglesPath := flag.String("angles", "", "JSON manifest of synchronized camera angles to switch between on the beat")
+	recipeName := flag.String("recipe", "", "themed edit applied to the -clips manifest: bar-cuts, chorus-rush, strobe or a JSON recipe file")
+	clipsPath := flag.String("clips", "", "JSON manifest of clips with in and out points, each filling a number of bars of the edit")
+	switchBeats := flag.Int("switch-every", 0, "number of beats between angle switches in multicam mode, defaults to a bar")
+	flag.Int64Var(&Seed, "seed", Seed, "seed of the random choices, the same seed and settings render the same video")
+	detectOffsets := flag.Bool("detect-offsets", false, "detect the multicam angle offsets by cross-correlating their audio with the music")
+	slate := flag.String("slate", "", "align the multicam angles on their first clap (audio) or flash frame (video)")
+	slateAt := flag.Float64("slate-at", 0, "time in seconds in the music where the slate happens")
+	flag.StringVar(&Canvas, "canvas", Canvas, "size and frame rate the multicam angles are normalized to, as WIDTHxHEIGHT[@FPS] (e.g. 1920x1080@30), defaults to the first angle")
+	flag.StringVar(&Upscale, "upscale", Upscale, "upscale the multicam angles smaller than the largest one to its size: lanczos, or plugin to use the upscale plugin (e.g. Real-ESRGAN)")
+	slideshowDir := flag.String("slideshow", "", "folder of photos to turn into a slideshow synced to the beat")
+	photoBeats := flag.Int("photo-beats", 4, "number of beats each photo of the slideshow stays on screen")
+	tapOutput := flag.String("tap", "", "play the audio and write the times of the spacebar taps as keyframes to this JSON file")
+	tapMIDI := flag.String("tap-midi", "", "raw MIDI device (e.g. /dev/snd/midiC1D0) whose notes mark keyframes labeled with the note during -tap")
+	tapOSC := flag.String("tap-osc", "", "UDP address (e.g. :9000) whose OSC messages mark keyframes labeled with their address during -tap")
+	boomerangBars := flag.String("boomerang", "", "render forward-backward loops of a range of 1 or 2 bars (e.g. 9 or 9-10), each loop lasting one bar")
+	boomerangLoops := flag.Int("boomerang-loops", 4, "number of one bar loops of the boomerang")
+	transition := flag.String("transition", "fade", "transition between the photos of the slideshow: "+strings.Join(transitionNames(), ", "))
+	transitionBeats := flag.Float64("transition-beats", 0.5, "duration of the transitions in beats, 0 for hard cuts in slideshows")
+	flag.StringVar(&CutTransition, "cut-transition", CutTransition, "transition applied on the cuts between the synced segments: glitch")
+	play := flag.Bool("play", false, "play the synced video with ffplay instead of writing files")
+	offset := flag.Float64("offset", 0, "time in seconds of the first beat of the grid")
+	subdivision := flag.Int("subdivision", beatgrid.DefaultSubdivision, "number of snapping targets per beat")
+	swing := flag.Float64("swing", 0, "swing percentage applied to the off-beat subdivisions (50 is straight, 66 a triplet feel)")
+	meterStr := flag.String("meter", "4/4", "time signature of the song, the beat grouping can be explicit (e.g. 7/8 or 3+2+2/8)")
+	flag.StringVar(&PostFilters.Denoise, "denoise", PostFilters.Denoise, "denoise the retimed video: hqdn3d (fast) or nlmeans (slow, keeps more details)")
+	flag.Float64Var(&PostFilters.DenoiseStrength, "denoise-strength", PostFilters.DenoiseStrength, "factor scaling the default strength of the denoiser")
+	flag.Float64Var(&PostFilters.Sharpen, "sharpen", PostFilters.Sharpen, "sharpen the retimed video by this amount, between 0 and 1.5 (e.g. 0.5)")
+	flag.StringVar(&Deinterlace, "deinterlace", Deinterlace, "deinterlace the source before retiming it: auto (when ffprobe reports interlaced fields), yadif, bwdif or off")
+	flag.StringVar(&Cover.At, "cover", Cover.At, "export a still of the synced video as its cover: downbeat (the downbeat with the strongest bass) or the label of a keyframe")
+	flag.StringVar(&Cover.Format, "cover-format", Cover.Format, "image format of the cover: png or jpg")
+	flag.StringVar(&ClickTrack, "click-track", ClickTrack, "export the beat grid as a click track to check it against the music in a DAW: wav (clicks, higher on the downbeats) or mid (metronome notes with the tempo map of the grid)")
+	flag.BoolVar(&HTMLReport, "html-report", HTMLReport, "export a standalone HTML report to view in a browser or share: a preview player, the retime curve, the keyframes with thumbnails of the source and synced video, and the warnings")
+	flag.BoolVar(&ReaperProject, "reaper", ReaperProject, "export a Reaper project (.rpp) of the music and the synced video, with the tempo of the grid and markers on every bar and keyframe")
+	flag.StringVar(&RetimeCurve, "retime-curve", RetimeCurve, "export the retime curve plotting the original time against the output time of the synced video: csv or svg")
+	flag.StringVar(&KeyframeUnits, "keyframe-units", KeyframeUnits, "unit of the keyframe times: seconds, ms, frames, timecode (HH:MM:SS:FF) or auto to detect it")
+	flag.StringVar(&KeyframesPastEnd, "keyframes-past-end", KeyframesPastEnd, "what to do with the keyframes after the end of the video: warn, clamp (move them to the end) or drop")
+	flag.StringVar(&KeyframeScale, "keyframe-scale", KeyframeScale, "rescale the keyframe times: fit (the last keyframe lands on the end of the video) or a factor, for keyframes authored on a proxy of another length")
+	flag.Float64Var(&SpeedLimit, "speed-limit", SpeedLimit, "maximum speed factor of the segments (e.g. 3), keyframes move to a neighboring beat to stay within it, 0 for no limit")
+	sweepSpec := flag.String("sweep", "", "render previews across a parameter grid into a contact sheet, e.g. \"subdivision=1,2,4;offset=0,0.05;shake=0,0.02\"")
+	montageSpec := flag.String("montage", "", "render candidate edits side by side to pick from, a comma separated list of preset@seed (e.g. punchy@1,punchy@2,dreamy)")
+	montageColumns := flag.Int("montage-columns", 0, "number of columns of the montage, 0 makes it as square as possible")
+	autoTuneGrid := flag.Bool("auto-tune", false, "search the subdivision, offset and speed limit giving the best sync score")
+	snapTo := flag.String("snap-to", "beat", "what keyframes snap to: beat, pulse (the groups of the meter) or bar")
+	sectionsPath := flag.String("sections", "", "JSON file overriding the BPM for labeled sections of the song")
+	flag.StringVar(&BeatTimesPath, "beat-times", BeatTimesPath, "JSON array of the times, in seconds, of the beats of the song the keyframes snap to instead of a steady BPM, for songs whose tempo drifts")
+	flag.BoolVar(&DetectBeats, "detect-beats", DetectBeats, "fit the tempo and first beat of the grid to the onsets detected in the music, unless a beats plugin is registered")
+	flag.Float64Var(&Onsets.Threshold, "onset-threshold", Onsets.Threshold, "how far, relative to the strongest onset, an onset has to rise above its surroundings to be detected, lower it for soft attacks (e.g. 0.05 for ambient music)")
+	flag.Float64Var(&Onsets.MinInterval, "onset-min-interval", Onsets.MinInterval, "shortest time in seconds between two detected onsets, the weaker one being dropped")
+	flag.StringVar(&Onsets.Band, "onset-band", Onsets.Band, "frequency band the onsets are detected in: full, low (kicks and bass), mid, high (hats) or a range of Hz (e.g. 40-150)")
+	flag.StringVar(&Onsets.Quality, "onset-quality", Onsets.Quality, "quality of the onset detection: standard, or high to separate the drums from the sustained notes first, slower but more accurate on dense mixes")
+	beatTimes := flag.String("beats", "", "comma separated times, in seconds, of beats of the music (e.g. 0.48,0.98,1.49) to fit the tempo and first beat of the grid to, instead of the BPM argument")
+	flag.IntVar(&Target.Bars, "target-bars", Target.Bars, "plan the edit to last exactly this many bars, dropping the lowest priority segments when needed")
+	flag.Float64Var(&Target.Duration, "target-duration", Target.Duration, "plan the edit to last exactly this many seconds, dropping the lowest priority segments when needed")
+	flag.IntVar(&PhraseTrim.Bars, "trim-phrase", PhraseTrim.Bars, "trim the synced video to start on a downbeat and end on a phrase of this many bars (e.g. 4 or 8)")
+	flag.Float64Var(&PhraseTrim.Fade, "trim-fade", PhraseTrim.Fade, "fade the audio of the trimmed video in and out over this many seconds")
+	removeDead := flag.String("remove-dead", "", "cut the dead footage out of the video before syncing: a comma separated list of black, freeze and silence")
+	flag.StringVar(&DeadSegments, "dead-segments", DeadSegments, "check the segments for black or frozen footage: warn, or cut to drop them")
+	flag.StringVar(&AudioPeaks, "audio-peaks", AudioPeaks, "check the audio for peaks that would distort: warn, limit to also limit the muxed audio, or empty to skip the analysis")
+	flag.StringVar(&ASSOverlay, "ass", ASSOverlay, "ASS subtitle file composited over the synced video, events starting with \"every N [from M]\" and ending with \"+D\" (in beats) are repeated on the grid")
+	titlesPath := flag.String("titles", "", "JSON manifest of title cards shown at labeled keyframes")
+	flag.StringVar(&Stickers.Dir, "stickers", "", "folder of PNG stickers popping on the beats of the synced video")
+	flag.IntVar(&Stickers.Every, "sticker-every", Stickers.Every, "number of beats between sticker bursts")
+	flag.IntVar(&Stickers.Count, "sticker-count", Stickers.Count, "number of stickers popping in each burst")
+	flag.Float64Var(&Stickers.Size, "sticker-size", Stickers.Size, "width of the stickers relative to the video width")
+	flag.Float64Var(&BounceAmount, "bounce", BounceAmount, "zoom the synced video along with the bass of the music by up to this fraction (e.g. 0.05), requires the audio")
+	flag.StringVar(&SplitScreen.With, "split-with", SplitScreen.With, "second video laid out next to the synced video in a split screen changing on the beat")
+	flag.StringVar(&SplitScreen.Layout, "split-layout", SplitScreen.Layout, "halves of the split screen: lr (left and right) or tb (top and bottom)")
+	flag.StringVar(&SplitScreen.Every, "split-every", SplitScreen.Every, "how often the split screen changes: beat or bar")
+	flag.StringVar(&SplitScreen.Mode, "split-mode", SplitScreen.Mode, "how the split screen changes: swap (the halves exchange places) or wipe (the second video sweeps across and back)")
+	flag.Float64Var(&Shake.Amplitude, "shake", Shake.Amplitude, "shake the synced video on impacts by up to this fraction of its width (e.g. 0.02)")
+	flag.StringVar(&Shake.On, "shake-on", Shake.On, "impacts triggering the shake: downbeats or labels (the labeled keyframes)")
+	flag.Float64Var(&Shake.DecayBeats, "shake-decay", Shake.DecayBeats, "number of beats the shake takes to settle")
+	flag.Float64Var(&Wobble, "wobble", Wobble, "oscillate the speed of the synced video on every beat by up to this fraction (e.g. 0.2)")
+	flag.StringVar(&ColorCycle.Mode, "color-cycle", ColorCycle.Mode, "change the colors of the synced video on the bars: hue or tints")
+	flag.IntVar(&ColorCycle.EveryBars, "color-every", ColorCycle.EveryBars, "number of bars between color changes")
+	flag.Float64Var(&ColorCycle.HueStep, "hue-step", ColorCycle.HueStep, "hue rotation in degrees added at every color change")
+	tints := flag.String("tints", strings.Join(ColorCycle.Tints, ","), "comma separated tints cycled through with -color-cycle tints")
+	flag.Float64Var(&BeatPulse.Vignette, "vignette-pulse", BeatPulse.Vignette, "darken the edges of the synced video on the beats, angle in radians added to the vignette (e.g. 0.3)")
+	flag.Float64Var(&BeatPulse.Letterbox, "letterbox-pulse", BeatPulse.Letterbox, "close letterbox bars on the beats, height of each bar relative to the frame (e.g. 0.08)")
+	flag.StringVar(&BeatPulse.Envelope, "pulse-envelope", BeatPulse.Envelope, "intensity of the vignette and letterbox pulses within a beat: decay, linear, sine or rise")
+	effectsPath := flag.String("effects", "", "JSON file of custom effects whose filter parameters follow beat, bar and energy expressions")
+	pluginsPath := flag.String("plugins", "", "JSON manifest of external plugins providing effects or beat detection")
+	interactive := flag.Bool("interactive", false, "start an interactive session to tweak the beat grid before rendering")
+	flag.DurationVar(&PhaseTimeout, "timeout", PhaseTimeout, "maximum duration of each ffmpeg encode, 0 for no limit")
+	flag.DurationVar(&StallTimeout, "stall-timeout", StallTimeout, "abort an ffmpeg encode reporting no progress for this long, 0 disables the watchdog")
+	flag.IntVar(&StallRetries, "stall-retries", StallRetries, "number of times a stalled ffmpeg encode is restarted")
+	resultPath := flag.String("result", "", "write a JSON summary of the run (outputs, resource usage) to this path")
+	avOffset := flag.Float64("av-offset", 0, "shift the video relative to the audio by this many milliseconds, positive values show the video later")
+	flag.StringVar(&FarmDir, "farm", FarmDir, "shared directory of a render farm: the synced video is rendered in chunks queued there for the machines running the worker subcommand, the source video must have the same path on every machine")
+	flag.StringVar(&RenderMode, "render-mode", RenderMode, "how the synced video is rendered: graph (one filter graph), segments (segment files concatenated) or auto to pick segments for large edits")
+	chunkLimit := flag.String("chunk-limit", "", "render the synced video in chunks using at most this much temporary disk space (e.g. 2GB), empty renders it in one pass")
+	flag.StringVar(&HWAccel, "hwaccel", HWAccel, "composite the pulse on the GPU: cuda or videotoolbox, falls back to the CPU when ffmpeg lacks the filters")
+	flag.StringVar(&Encoder.Codec, "codec", Encoder.Codec, "video encoder used for the renders")
+	flag.StringVar(&Encoder.Preset, "encoder-preset", Encoder.Preset, "speed/quality preset of the video encoder")
+	flag.IntVar(&Encoder.CRF, "crf", Encoder.CRF, "constant rate factor of the video encoder, lower is better quality")
+	flag.Float64Var(&MusicStart, "music-start", MusicStart, "time, in seconds of the synced video, at which the music starts")
+	flag.BoolVar(&GapFill, "gap-fill", GapFill, "play the original audio, retimed with the video, where the music doesn't play, crossfading on the beats")
+	flag.BoolVar(&KeepOriginalAudio, "keep-original-audio", KeepOriginalAudio, "add the audio of the source, retimed with the video, as a second track next to the music")
+	flag.StringVar(&MusicalTimecode, "musical-timecode", MusicalTimecode, "add the bar and beat of the grid to the synced video: burn (drawn on the frames) or track (a subtitle track)")
+	flag.StringVar(&OutputContainer, "container", OutputContainer, "container of the outputs: mp4, mov, mkv or webm (picking the VP9 and Opus encoders unless set), empty keeps the container of the source")
+	flag.BoolVar(&RemapContainer, "remap-container", RemapContainer, "switch the output to a compatible container when the codecs can't be stored in the source one")
+	flag.BoolVar(&AudioEncoder.Copy, "audio-copy", AudioEncoder.Copy, "mux the audio as is instead of encoding it")
+	flag.StringVar(&AudioEncoder.Codec, "audio-codec", AudioEncoder.Codec, "audio encoder of the muxed audio")
+	flag.StringVar(&AudioEncoder.Bitrate, "audio-bitrate", AudioEncoder.Bitrate, "bitrate of the muxed audio")
+	flag.IntVar(&AudioEncoder.SampleRate, "audio-rate", AudioEncoder.SampleRate, "sample rate of the muxed audio, 0 keeps the original")
+	flag.IntVar(&AudioEncoder.Channels, "audio-channels", AudioEncoder.Channels, "number of channels of the muxed audio, 0 keeps the original")
+	flag.StringVar(&AudioEncoder.Downmix, "downmix", AudioEncoder.Downmix, "how multichannel music is folded to stereo: matrix, itu (center and surrounds at -3dB) or front")
+	flag.StringVar(&AudioEncoder.Upmix, "upmix", AudioEncoder.Upmix, "how stereo music fills more channels: front (silent surrounds) or surround")
+	flag.StringVar(&Output.Template, "output-template", Output.Template, "file name template of the outputs, with the {name}, {ext}, {kind}, {bpm}, {date}, {profile} and {seed} variables")
+	flag.BoolVar(&Output.Overwrite, "overwrite", Output.Overwrite, "overwrite existing outputs instead of numbering the new files")
+	platformName := flag.String("target", "", "export the synced video for a platform, with its resolution, frame rate, bitrate and loudness: youtube, tiktok or instagram")
+	presetName := flag.String("preset", "", "named preset of settings to apply, flags set explicitly take precedence")
+	flag.BoolVar(&JSONOutput, "json", JSONOutput, "write the messages as line delimited JSON events")
+	flag.StringVar(&Language, "lang", detectLanguage(), "language of the messages (en, fr)")
+	flag.BoolVar(&UseWorkspace, "workspace", UseWorkspace, "keep the plans, caches, previews and versioned renders in the .avsync project directory")
+	flag.StringVar(&HistoryFile, "history", HistoryFile, "file logging the runs of the project, empty to disable it")
+	flag.StringVar(&StorePath, "store", StorePath, "SQLite database (path.db) caching the analyses and recording the history and the batches of previews so interrupted sweeps and montages resume, requires sqlite3 (defaults to $SYNCTOBEAT_STORE)")
+	probeBackend := flag.String("probe-backend", "exec", "how the media files are probed: exec (runs ffprobe, MP4 and MOV files being read natively when possible) or libav (in process, requires a build with -tags libav)")
+	flag.StringVar(&Inputs.BPM, "bpm", Inputs.BPM, "tempo of the grid, in beats per minute, or auto to detect it in the music")
+	flag.StringVar(&Inputs.Video, "video", Inputs.Video, "video to retime")
+	flag.StringVar(&Inputs.Keyframes, "keyframes", Inputs.Keyframes, "keyframes of the video: .json, .csv or .txt, - for JSON on stdin")
+	flag.StringVar(&Inputs.Audio, "audio", Inputs.Audio, "music of the output, - for stdin")
+	flag.StringVar(&Output.Path, "o", Output.Path, "file the synced video, the pulse video or the plan of the plan command is written to, the other outputs being named by -output-template")
+	flag.BoolVar(&Debug, "debug", Debug, "print the ffmpeg commands and their output")
+	flag.BoolVar(&Strict, "strict", Strict, "fail instead of working around keyframes sharing a time, out of order, past the end of the video, landing on the beat of the previous one or beyond the speed limit, and segments shorter than a frame")
+	flag.BoolVar(&Explain, "explain", Explain, "explain in the plan report why each keyframe lands where it does: the grid target it snapped to and the speed limit, target duration or dead footage cuts that moved it")
+
+	// the global flags, such as -json and -lang, come before the subcommands
+	flag.Parse()
+	subcommand, subArgs := flag.Arg(0), flag.Args()[min(flag.NArg(), 1):]
+	if subcommand == "clean" || subcommand == "gc" {
+		run := runCleanCommand
+		if subcommand == "gc" {
+			run = runGCCommand
+		}
+		if err := run(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "gen-fixture" {
+		if err := runFixtureCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "diff-plan" {
+		if err := runDiffPlanCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "benchmark" {
+		if err := runBenchmarkCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "history" {
+		if err := runHistoryCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "scan" {
+		if err := runScanCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "store" {
+		if err := runStoreCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "worker" {
+		if err := runWorkerCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "serve" {
+		if err := runServeCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "preset" {
+		if err := runPresetCommand(subArgs); err != nil {
+			fail("error", err)
+		}
+		return
+	}
+	if subcommand == "help" {
+		say("usage.commands")
+		return
+	}
+
+	command, args := parseCommand(flag.Args())
+	montage := montageBase{Flags: flagValues(), Explicit: setFlags(), Preset: *presetName}
+	if *presetName != "" {
+		preset, err := findPreset(*presetName)
+		if err != nil {
+			fail("error", err)
+		}
+		if err := applyPreset(preset, nil); err != nil {
+			fail("error", err)
+		}
+	}
+	if *platformName != "" {
+		target, err := findPlatform(*platformName)
+		if err != nil {
+			fail("error", err)
+		}
+		if err := applyPlatformDefaults(target, setFlags()); err != nil {
+			fail("error", err)
+		}
+		Platform = target
+	}
+	var recipe *Recipe
+	if *recipeName != "" {
+		if *clipsPath == "" {
+			fail("error", fmt.Errorf("recipes apply to the clips of a -clips manifest"))
+		}
+		r, err := findRecipe(*recipeName)
+		if err != nil {
+			fail("error", err)
+		}
+		if err := applyPreset(Preset{Name: r.Name, Flags: r.Flags}, nil); err != nil {
+			fail("error", err)
+		}
+		recipe = &r
+	}
+	CutTransitionBeats = *transitionBeats
+	Output.Profile = *presetName
+	if err := validateOutputTemplate(Output.Template); err != nil {
+		fail("error", err)
+	}
+	if err := validateHWAccel(HWAccel); err != nil {
+		fail("error", err)
+	}
+	if err := validateMusicalTimecode(MusicalTimecode); err != nil {
+		fail("error", err)
+	}
+	if err := validateContainer(OutputContainer); err != nil {
+		fail("error", err)
+	}
+	if OutputContainer != "" {
+		if err := applyContainerDefaults(OutputContainer, setFlags()); err != nil {
+			fail("error", err)
+		}
+	}
+	if err := validateChannelMix(AudioEncoder); err != nil {
+		fail("error", err)
+	}
+	if Canvas != "" {
+		if _, err := parseCanvas(Canvas); err != nil {
+			fail("error", err)
+		}
+	}
+	switch *probeBackend {
+	case "exec":
+	case "libav":
+		if err := probe.UseLibav(true); err != nil {
+			fail("error", err)
+		}
+	default:
+		fail("error", fmt.Errorf("invalid probe backend %q, expected exec or libav", *probeBackend))
+	}
+	if err := validateSplitScreen(SplitScreen); err != nil {
+		fail("error", err)
+	}
+	if err := validateUpscale(Upscale); err != nil {
+		fail("error", err)
+	}
+	if err := validatePostFilters(PostFilters); err != nil {
+		fail("error", err)
+	}
+	if err := validateDeinterlace(Deinterlace); err != nil {
+		fail("error", err)
+	}
+	if err := validateCover(Cover); err != nil {
+		fail("error", err)
+	}
+	if err := validateRetimeCurve(RetimeCurve); err != nil {
+		fail("error", err)
+	}
+	if err := validateClickTrack(ClickTrack); err != nil {
+		fail("error", err)
+	}
+	if err := validateOnsets(Onsets); err != nil {
+		fail("error", err)
+	}
+	if err := validateKeyframeUnits(KeyframeUnits); err != nil {
+		fail("error", err)
+	}
+	if err := validateKeyframeFit(); err != nil {
+		fail("error", err)
+	}
+	if err := validateRenderMode(RenderMode); err != nil {
+		fail("error", err)
+	}
+	if *chunkLimit != "" {
+		limit, err := parseSize(*chunkLimit)
+		if err != nil {
+			fail("error", err)
+		}
+		ChunkLimit = limit
+	}
+	if command != "" {
+		captureRunArgs(append([]string{command}, args...))
+	} else {
+		captureRunArgs(args)
+	}
+	AVOffset = *avOffset / 1000
+
+	timeSignature, err := beatgrid.ParseMeter(*meterStr)
+	if err != nil {
+		fail("error", err)
+	}
+	// withTempo sets the tempo of the grid from the -beat-times, or detects
+	// it in the music with -bpm auto
+	withTempo := func(grid beatGrid, inputs runInputs) beatGrid {
+		if BeatTimesPath != "" {
+			if setFlags()["offset"] {
+				fail("error", fmt.Errorf("-beat-times and -offset can't be combined, the first beat time is the offset of the grid"))
+			}
+			beats, err := beatgrid.ReadBeatTimes(BeatTimesPath)
+			if err != nil {
+				fail("error.beat_times", err)
+			}
+			grid = grid.WithBeatTimes(beats)
+			say("grid.beat_times", grid.String())
+		} else if inputs.BPM == AutoBPM {
+			tempo, err := detectTempo(inputs.Audio)
+			if err != nil {
+				fail("error", err)
+			}
+			grid.BPM = tempo.BPM
+			if !setFlags()["offset"] {
+				grid.Offset = tempo.Offset
+			}
+		}
+		return grid
+	}
+	// modeGrid returns the grid of the modes set by a flag, -angles or
+	// -clips for instance
+	modeGrid := func(inputs runInputs) beatGrid {
+		bpm, err := parseBPM(inputs.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		grid := beatGrid{BPM: bpm, Offset: *offset, Meter: timeSignature}
+		if *sectionsPath != "" {
+			if grid.Sections, err = beatgrid.ReadSections(*sectionsPath); err != nil {
+				fail("error.sections", err)
+			}
+		}
+		if inputs.Audio != "" {
+			checkAudio(inputs.Audio)
+		}
+		return withTempo(grid, inputs)
+	}
+
+	if *anglesPath != "" {
+		if len(args) == 0 && Inputs == (runInputs{}) {
+			say("usage.multicam")
+			os.Exit(1)
+		}
+		inputs, err := resolveModeInputs("-angles", command, args, []string{"bpm", "audio"}, 1)
+		if err != nil {
+			fail("error", err)
+		}
+		grid := modeGrid(inputs)
+		audioPath := inputs.Audio
+		outputPath, err := Output.path(filepath.Dir(*anglesPath), "multicam", outputExtension(".mp4"), "sync", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		opts := multicamOptions{
+			SwitchBeats:   *switchBeats,
+			DetectOffsets: *detectOffsets,
+			Slate:         *slate,
+			SlateAt:       *slateAt,
+		}
+		if err := syncMulticam(*anglesPath, grid, audioPath, outputPath, opts); err != nil {
+			fail("error.multicam", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *clipsPath != "" {
+		if len(args) == 0 && Inputs == (runInputs{}) {
+			say("usage.clips")
+			os.Exit(1)
+		}
+		inputs, err := resolveModeInputs("-clips", command, args, []string{"bpm", "audio"}, 1)
+		if err != nil {
+			fail("error", err)
+		}
+		grid := modeGrid(inputs)
+		audioPath := inputs.Audio
+		outputPath, err := Output.path(filepath.Dir(*clipsPath), "clips", outputExtension(".mp4"), "sync", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		if err := syncClips(*clipsPath, grid, audioPath, outputPath, recipe); err != nil {
+			fail("error.clips", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *slideshowDir != "" {
+		if len(args) == 0 && Inputs == (runInputs{}) {
+			say("usage.slideshow")
+			os.Exit(1)
+		}
+		inputs, err := resolveModeInputs("-slideshow", command, args, []string{"bpm", "audio"}, 2)
+		if err != nil {
+			fail("error", err)
+		}
+		grid := modeGrid(inputs)
+		outputPath, err := Output.path(*slideshowDir, "slideshow", outputExtension(".mp4"), "sync", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, inputs.Audio); err != nil {
+			fail("error", err)
+		}
+		opts := slideshowOptions{
+			PhotoBeats:      *photoBeats,
+			Transition:      *transition,
+			TransitionBeats: *transitionBeats,
+			Width:           1920,
+			Height:          1080,
+		}
+		if err := syncSlideshow(*slideshowDir, grid, inputs.Audio, outputPath, opts); err != nil {
+			fail("error.slideshow", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *tapOutput != "" {
+		if len(args) == 0 && Inputs == (runInputs{}) {
+			say("usage.tap")
+			os.Exit(1)
+		}
+		inputs, err := resolveModeInputs("-tap", command, args, []string{"audio"}, 1)
+		if err != nil {
+			fail("error", err)
+		}
+		sources := tapSources{MIDIDevice: *tapMIDI, OSCAddress: *tapOSC}
+		if err := captureTaps(inputs.Audio, *tapOutput, sources); err != nil {
+			fail("error.tap", err)
+		}
+		return
+	}
+
+	if *boomerangBars != "" {
+		if len(args) == 0 && Inputs == (runInputs{}) {
+			say("usage.boomerang")
+			os.Exit(1)
+		}
+		inputs, err := resolveModeInputs("-boomerang", command, args, []string{"bpm", "video", "audio"}, 2)
+		if err != nil {
+			fail("error", err)
+		}
+		first, bars, err := parseBarRange(*boomerangBars)
+		if err != nil {
+			fail("error", err)
+		}
+		grid := modeGrid(inputs)
+		audioPath := inputs.Audio
+		name, extension := splitExtension(filepath.Base(inputs.Video))
+		outputPath, err := Output.path(filepath.Dir(inputs.Video), name, outputExtension(extension), "boomerang", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		opts := boomerangOptions{FirstBar: first, Bars: bars, Loops: *boomerangLoops}
+		if err := renderBoomerang(grid, inputs.Video, audioPath, outputPath, opts); err != nil {
+			fail("error.boomerang", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if command == "" && len(args) == 0 && Inputs == (runInputs{}) {
+		say("usage.sync")
+		os.Exit(1)
+	}
+	command, inputs, err := resolveInputs(command, args)
+	if err != nil {
+		fail("error", err)
+	}
+	bpm, err := parseBPM(inputs.BPM)
+	if err != nil {
+		fail("error", err)
+	}
+
+	originalVideoPath := inputs.Video
+	keyframeJsonPath := inputs.Keyframes
+	audioPath := inputs.Audio
+	if audioPath == StdinPath {
+		if audioPath, err = spoolStdin(); err != nil {
+			fail("error", err)
+		}
+		spooled := audioPath
+		atExit(func() { os.Remove(spooled) })
+		defer runCleanups()
+	}
+	if audioPath != "" && command != "analyze" {
+		checkAudio(audioPath)
+	}
+	if KeepOriginalAudio && audioPath == "" {
+		fail("error", fmt.Errorf("-keep-original-audio needs the music to mux the original audio next to"))
+	}
+	if GapFill || MusicStart > 0 {
+		if audioPath == "" {
+			fail("error", fmt.Errorf("-gap-fill and -music-start need the music"))
+		}
+		if AudioEncoder.Copy {
+			fail("error", fmt.Errorf("-gap-fill and -music-start mix the music, it can't be copied with -audio-copy"))
+		}
+	}
+
+	if err := beatgrid.ValidateSwing(*swing); err != nil {
+		fail("error", err)
+	}
+	if err := beatgrid.ValidateSnapTo(*snapTo); err != nil {
+		fail("error", err)
+	}
+	grid := beatGrid{BPM: bpm, Offset: *offset, Subdivision: *subdivision, Swing: *swing, Meter: timeSignature, SnapTo: *snapTo}
+	if *sectionsPath != "" {
+		if grid.Sections, err = beatgrid.ReadSections(*sectionsPath); err != nil {
+			fail("error.sections", err)
+		}
+	}
+
+	if ColorCycle.Tints, err = parseTints(*tints); err != nil {
+		fail("error", err)
+	}
+
+	if *titlesPath != "" {
+		if Titles, err = readTitles(*titlesPath); err != nil {
+			fail("error.titles", err)
+		}
+	}
+
+	if *effectsPath != "" {
+		if CustomEffects, err = readCustomEffects(*effectsPath); err != nil {
+			fail("error.effects", err)
+		}
+	}
+	if *pluginsPath != "" {
+		if Plugins, err = readPlugins(*pluginsPath); err != nil {
+			fail("error.plugins", err)
+		}
+	}
+
+	if command == "analyze" {
+		if err := analyzeMusic(grid, audioPath); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if BeatTimesPath != "" && *beatTimes != "" {
+		fail("error", fmt.Errorf("-beat-times and -beats can't be combined"))
+	}
+	// the music spooled from stdin is read from its temporary file
+	inputs.Audio = audioPath
+	grid = withTempo(grid, inputs)
+	if *beatTimes != "" {
+		beats, err := parseBeatTimes(*beatTimes)
+		if err != nil {
+			fail("error", err)
+		}
+		grid = fitGridToBeats(grid, beats)
+		say("grid.fitted_given", len(beats), grid.String())
+	} else if audioPath != "" && BeatTimesPath == "" {
+		beats, err := pluginBeats(grid, audioPath)
+		if err != nil {
+			fail("error", err)
+		}
+		if beats == nil && DetectBeats {
+			if beats, err = detectBeats(audioPath); err != nil {
+				fail("error", err)
+			}
+		}
+		if beats != nil {
+			grid = fitGridToBeats(grid, beats)
+			say("grid.fitted", len(beats), grid.String())
+		}
+	}
+
+	if MusicStart < 0 {
+		fail("error", fmt.Errorf("invalid music start %.2fs, expected a positive time", MusicStart))
+	}
+	if MusicStart > 0 {
+		// the keyframes are synced to the beats as they play in the video
+		grid = grid.Delayed(MusicStart)
+	}
+
+	if command == "pulse" {
+		if err := runPulse(grid, originalVideoPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	keyframes, err := readKeyframes(keyframeJsonPath, originalVideoPath)
+	if err != nil {
+		fail("error", err)
+	}
+
+	if *removeDead != "" {
+		kinds, err := parseDeadFootageKinds(*removeDead)
+		if err != nil {
+			fail("error", err)
+		}
+		name, extension := splitExtension(originalVideoPath)
+		cleanedPath := name + "_cleaned" + extension
+		if UseWorkspace {
+			if cleanedPath, err = workspacePath(areaCache, filepath.Base(name)+"_cleaned", extension); err != nil {
+				fail("error", err)
+			}
+		}
+		if keyframes, err = removeDeadFootage(originalVideoPath, kinds, cleanedPath, keyframes); err != nil {
+			fail("error.dead", err)
+		}
+		originalVideoPath = cleanedPath
+	}
+
+	if KeyframeScale != "" || KeyframesPastEnd != "warn" {
+		duration, err := getVideoDuration(originalVideoPath)
+		if err != nil {
+			fail("error", fmt.Errorf("failed to get video duration: %v", err))
+		}
+		keyframes = fitKeyframes(keyframes, duration)
+		if len(keyframes) == 0 {
+			fail("error", fmt.Errorf("no keyframes left within the %.2fs video", duration))
+		}
+	}
+
+	estimatedBPM := estimateBPM(keyframes)
+	say("bpm.estimated", estimatedBPM)
+
+	if *autoTuneGrid {
+		var score syncScore
+		if grid, score, err = autoTune(grid, keyframes); err != nil {
+			fail("error", err)
+		}
+		subdivision := grid.Subdivision
+		if subdivision <= 0 {
+			subdivision = beatgrid.DefaultSubdivision
+		}
+		say("autotune.chosen", subdivision, grid.Offset, SpeedLimit, score.Score)
+	}
+
+	if command == "plan" {
+		if err := runPlan(grid, originalVideoPath, keyframes); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *sweepSpec != "" {
+		parameters, err := parseSweep(*sweepSpec)
+		if err != nil {
+			fail("error", err)
+		}
+		name, extension := splitExtension(filepath.Base(originalVideoPath))
+		outputPath, err := Output.path(filepath.Dir(originalVideoPath), name, outputExtension(extension), "sweep", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		if err := runSweep(parameters, grid, originalVideoPath, audioPath, keyframes, outputPath); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *montageSpec != "" {
+		candidates, err := parseMontage(*montageSpec)
+		if err != nil {
+			fail("error", err)
+		}
+		name, extension := splitExtension(filepath.Base(originalVideoPath))
+		outputPath, err := Output.path(filepath.Dir(originalVideoPath), name, outputExtension(extension), "montage", grid.BPM)
+		if err != nil {
+			fail("error", err)
+		}
+		if outputPath, err = checkContainer(outputPath, audioPath); err != nil {
+			fail("error", err)
+		}
+		if err := runMontage(candidates, *montageColumns, montage, grid, originalVideoPath, audioPath, keyframes, outputPath); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *interactive {
+		if err := runInteractive(grid, estimatedBPM, originalVideoPath, audioPath, keyframes); err != nil {
+			fail("error", err)
+		}
+		finishRun(*resultPath)
+		return
+	}
+
+	if *play {
+		if err := playSynced(grid, originalVideoPath, audioPath, keyframes, 0, 0); err != nil {
+			fail("error.play", err)
+		}
+		return
+	}
+
+	if err := renderSync(grid, estimatedBPM, originalVideoPath, audioPath, keyframes); err != nil {
+		fail("error", err)
+	}
+	finishRun(*resultPath)
+}
+
+// checkAudio analyzes the audio before it gets muxed, a failed analysis
+// doesn't stop the run.
+func checkAudio(audioPath string) {
+	if AudioPeaks != "" {
+		if err := checkAudioPeaks(audioPath, AudioPeaks); err != nil {
+			say("audio.peaks_failed", err)
+		}
+	}
+	// the layout is planned once the limiter is known, it needs the audio
+	// to be encoded
+	if err := planChannelMix(audioPath); err != nil {
+		fail("error.channel_layout", err)
+	}
+}
+
+// finishRun reports the resources used by the run and its warnings, and
+// writes its summary when a result path is set.
+func finishRun(resultPath string) {
+	printUsageReport()
+	printWarningsSummary()
+	if err := appendHistory(); err != nil {
+		say("history.failed", err)
+	}
+	if resultPath == "" {
+		return
+	}
+	if err := writeResult(resultPath); err != nil {
+		fail("error.result", err)
+	}
+}
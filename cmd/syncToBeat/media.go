@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// VideoDimensions holds the width and height of a video.
+type VideoDimensions struct {
+	Width  int
+	Height int
+}
+
+// getVideoDuration retrieves the duration of the given video file in seconds.
+func getVideoDuration(videoPath string) (float64, error) {
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return 0, err
+	}
+	return info.Duration, nil
+}
+
+// getVideoFrameRate retrieves the frame rate of the given video file, 0 when
+// unknown.
+func getVideoFrameRate(videoPath string) float64 {
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return 0
+	}
+	video, ok := info.Video()
+	if !ok {
+		return 0
+	}
+	return video.FPS
+}
+
+// getVideoDimensions retrieves the width and height of the given video file.
+func getVideoDimensions(videoPath string) (VideoDimensions, error) {
+	info, err := probe.ProbeMedia(videoPath)
+	if err != nil {
+		return VideoDimensions{}, err
+	}
+	video, ok := info.Video()
+	if !ok {
+		return VideoDimensions{}, fmt.Errorf("no video streams found")
+	}
+	return VideoDimensions{Width: video.Width, Height: video.Height}, nil
+}
@@ -0,0 +1,1508 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// JSONOutput writes the messages as line delimited JSON events on stdout
+	// instead of text.
+	JSONOutput = false
+	// Language is the language of the text messages, English is used for the
+	// messages not translated.
+	Language = "en"
+)
+
+// messageFormat describes a message of the catalog.
+type messageFormat struct {
+	// Fields names the arguments of the message in the JSON events.
+	Fields []string
+	// Text maps the languages to the format of the message.
+	Text map[string]string
+}
+
+// messages is the catalog of the user facing messages, keyed by their stable
+// identifiers.
+var messages = map[string]messageFormat{
+	"align.offset": {
+		Fields: []string{"angle", "path", "offset", "confidence"},
+		Text: map[string]string{
+			"en": "Detected offset for angle %d (%s): %.3fs (confidence %.2f)",
+			"fr": "Décalage détecté pour l'angle %d (%s) : %.3fs (confiance %.2f)",
+		},
+	},
+	"slate.detected": {
+		Fields: []string{"angle", "path", "time", "offset"},
+		Text: map[string]string{
+			"en": "Detected slate for angle %d (%s) at %.3fs, offset: %.3fs",
+			"fr": "Clap détecté pour l'angle %d (%s) à %.3fs, décalage : %.3fs",
+		},
+	},
+	"bpm.estimated": {
+		Fields: []string{"bpm"},
+		Text: map[string]string{
+			"en": "Estimated original BPM based on keyframes: %.2f",
+			"fr": "BPM d'origine estimé d'après les images clés : %.2f",
+		},
+	},
+	"bpm.not_enough_keyframes": {
+		Text: map[string]string{
+			"en": "Need at least two keyframes to estimate BPM.",
+			"fr": "Il faut au moins deux images clés pour estimer le BPM.",
+		},
+	},
+	"grid.fitted": {
+		Fields: []string{"beats", "grid"},
+		Text: map[string]string{
+			"en": "Grid fitted to %d detected beats: %s",
+			"fr": "Grille ajustée sur %d temps détectés : %s",
+		},
+	},
+	"tempo.detected": {
+		Fields: []string{"bpm", "confidence", "offset"},
+		Text: map[string]string{
+			"en": "Detected tempo: %.2f BPM (%.0f%% confidence), first beat at %.3fs",
+			"fr": "Tempo détecté : %.2f BPM (confiance de %.0f%%), premier temps à %.3fs",
+		},
+	},
+	"analyze.beats": {
+		Fields: []string{"count", "beats"},
+		Text: map[string]string{
+			"en": "%d beats found: %s",
+			"fr": "%d temps trouvés : %s",
+		},
+	},
+	"analyze.grid": {
+		Fields: []string{"grid"},
+		Text: map[string]string{
+			"en": "Fitted grid: %s",
+			"fr": "Grille ajustée : %s",
+		},
+	},
+	"grid.beat_times": {
+		Fields: []string{"grid"},
+		Text: map[string]string{
+			"en": "Grid following the beat times: %s",
+			"fr": "Grille suivant les temps : %s",
+		},
+	},
+	"grid.fitted_given": {
+		Fields: []string{"beats", "grid"},
+		Text: map[string]string{
+			"en": "Grid fitted to the %d given beats: %s",
+			"fr": "Grille ajustée sur les %d temps donnés : %s",
+		},
+	},
+	"plan.skip_first": {
+		Text: map[string]string{
+			"en": "Skipping first keyframe at time 0.",
+			"fr": "Première image clé à 0 ignorée.",
+		},
+	},
+	"plan.keyframe": {
+		Fields: []string{"keyframe", "time", "position", "beat_time", "beat_position", "bar", "beat", "speed_factor", "section"},
+		Text: map[string]string{
+			"en": "Keyframe %d: %.2fs/%.2f, Nearest Beat: %.2fs/%.2f (bar %d, beat %.2f), Speed Factor = %f%s",
+			"fr": "Image clé %d : %.2fs/%.2f, temps le plus proche : %.2fs/%.2f (mesure %d, temps %.2f), facteur de vitesse = %f%s",
+		},
+	},
+	"reason.snap_beat": {
+		Fields: []string{"keyframe", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d snapped to the nearest beat (%+.3fs)",
+			"fr": "  Image clé %d calée sur le temps le plus proche (%+.3fs)",
+		},
+	},
+	"reason.snap_subdivision": {
+		Fields: []string{"keyframe", "subdivision", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d snapped to the nearest 1/%d of a beat (%+.3fs)",
+			"fr": "  Image clé %d calée sur le 1/%d de temps le plus proche (%+.3fs)",
+		},
+	},
+	"reason.snap_swing": {
+		Fields: []string{"keyframe", "subdivision", "swing", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d snapped to the nearest 1/%d of a beat swung at %.0f%% (%+.3fs)",
+			"fr": "  Image clé %d calée sur le 1/%d de temps le plus proche avec un swing de %.0f %% (%+.3fs)",
+		},
+	},
+	"reason.snap_bar": {
+		Fields: []string{"keyframe", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d snapped to the nearest downbeat (%+.3fs)",
+			"fr": "  Image clé %d calée sur le premier temps de mesure le plus proche (%+.3fs)",
+		},
+	},
+	"reason.snap_pulse": {
+		Fields: []string{"keyframe", "meter", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d snapped to the nearest pulse of %s (%+.3fs)",
+			"fr": "  Image clé %d calée sur la pulsation de %s la plus proche (%+.3fs)",
+		},
+	},
+	"reason.speed_limit": {
+		Fields: []string{"keyframe", "limit", "beats", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d moved to fit the speed limit of %gx: %+g beats (%+.3fs) from its nearest target",
+			"fr": "  Image clé %d déplacée pour respecter la vitesse limite de %gx : %+g temps (%+.3fs) de sa cible la plus proche",
+		},
+	},
+	"reason.speed_limit_missed": {
+		Fields: []string{"keyframe", "limit"},
+		Text: map[string]string{
+			"en": "  Keyframe %d kept on its nearest target, no beat within two fits the speed limit of %gx",
+			"fr": "  Image clé %d gardée sur sa cible la plus proche, aucun temps à moins de deux ne respecte la vitesse limite de %gx",
+		},
+	},
+	"reason.speed_clamped": {
+		Fields: []string{"keyframe"},
+		Text: map[string]string{
+			"en": "  Keyframe %d lands on the same beat as the previous one, its segment lasts 0.01s",
+			"fr": "  Image clé %d sur le même temps que la précédente, son segment dure 0,01s",
+		},
+	},
+	"reason.target": {
+		Fields: []string{"keyframe", "beats", "target"},
+		Text: map[string]string{
+			"en": "  Keyframe %d moved by %+g beats of its segment to fit the %.2fs target",
+			"fr": "  Image clé %d déplacée de %+g temps de son segment pour tenir dans la cible de %.2fs",
+		},
+	},
+	"reason.target_cut": {
+		Fields: []string{"keyframe", "cut", "priority"},
+		Text: map[string]string{
+			"en": "  Keyframe %d follows keyframe %d (priority %d), cut to fit the target",
+			"fr": "  Image clé %d suit l'image clé %d (priorité %d), coupée pour tenir dans la cible",
+		},
+	},
+	"reason.dead_cut": {
+		Fields: []string{"keyframe", "shift"},
+		Text: map[string]string{
+			"en": "  Keyframe %d moved %.2fs earlier, the dead footage before it was cut",
+			"fr": "  Image clé %d avancée de %.2fs, les plans morts avant elle ont été coupés",
+		},
+	},
+	"plan.keyframe_frames": {
+		Fields: []string{"frame", "beat_frame", "fps"},
+		Text: map[string]string{
+			"en": "  cut at frame %d, lands on frame %d (%.3f fps)",
+			"fr": "  coupe à l'image %d, placée à l'image %d (%.3f i/s)",
+		},
+	},
+	"plan.score": {
+		Fields: []string{"score", "residual", "speed_variance"},
+		Text: map[string]string{
+			"en": "Sync score: %.1f/100 (beat residual %.3f, speed variance %.3f)",
+			"fr": "Score de synchronisation : %.1f/100 (écart aux temps %.3f, variance de vitesse %.3f)",
+		},
+	},
+	"autotune.chosen": {
+		Fields: []string{"subdivision", "offset", "speed_limit", "score"},
+		Text: map[string]string{
+			"en": "Auto-tuned: subdivision %d, offset %.3fs, speed limit %.1f (0 is none), sync score %.1f/100",
+			"fr": "Réglage automatique : subdivision %d, décalage %.3fs, limite de vitesse %.1f (0 pour aucune), score de synchronisation %.1f/100",
+		},
+	},
+	"warning": {
+		Fields: []string{"code", "message"},
+		Text: map[string]string{
+			"en": "warning %s: %s",
+			"fr": "avertissement %s : %s",
+		},
+	},
+	"warnings.summary": {
+		Fields: []string{"count", "codes"},
+		Text: map[string]string{
+			"en": "%d warnings: %s",
+			"fr": "%d avertissements : %s",
+		},
+	},
+	"warning.speed_clamp": {
+		Fields: []string{"keyframe"},
+		Text: map[string]string{
+			"en": "keyframe %d lands on the beat of the previous keyframe, its segment is squeezed to 0.01s",
+			"fr": "l'image clé %d tombe sur le temps de l'image clé précédente, son segment est réduit à 0.01s",
+		},
+	},
+	"warning.keyframe_beyond_duration": {
+		Fields: []string{"keyframe", "time", "duration"},
+		Text: map[string]string{
+			"en": "keyframe %d at %.2fs is beyond the end of the video (%.2fs)",
+			"fr": "l'image clé %d à %.2fs est après la fin de la vidéo (%.2fs)",
+		},
+	},
+	"keyframes.chapters": {
+		Fields: []string{"chapters"},
+		Text: map[string]string{
+			"en": "Read %d chapters as labeled keyframes",
+			"fr": "%d chapitres lus comme images clés nommées",
+		},
+	},
+	"keyframes.units": {
+		Fields: []string{"units"},
+		Text: map[string]string{
+			"en": "Reading the keyframe times in %s",
+			"fr": "Lecture des temps des images clés en %s",
+		},
+	},
+	"keyframes.scaled": {
+		Fields: []string{"keyframes", "factor"},
+		Text: map[string]string{
+			"en": "Rescaled the times of the %d keyframes by %.4f",
+			"fr": "Les temps des %d images clés sont multipliés par %.4f",
+		},
+	},
+	"keyframes.clamped": {
+		Fields: []string{"keyframe", "time", "duration"},
+		Text: map[string]string{
+			"en": "keyframe %d at %.2fs moved to the end of the video (%.2fs)",
+			"fr": "l'image clé %d à %.2fs est déplacée à la fin de la vidéo (%.2fs)",
+		},
+	},
+	"keyframes.dropped": {
+		Fields: []string{"keyframes"},
+		Text: map[string]string{
+			"en": "Dropped %d keyframes after the end of the video",
+			"fr": "%d images clés après la fin de la vidéo sont supprimées",
+		},
+	},
+	"warning.zero_duration_segment": {
+		Fields: []string{"keyframe"},
+		Text: map[string]string{
+			"en": "keyframe %d has the same time as the previous one, its segment is skipped",
+			"fr": "l'image clé %d a le même temps que la précédente, son segment est ignoré",
+		},
+	},
+	"warning.keyframes_out_of_order": {
+		Fields: []string{"keyframe", "time", "previous"},
+		Text: map[string]string{
+			"en": "keyframe %d at %.2fs comes before the previous keyframe at %.2fs",
+			"fr": "l'image clé %d à %.2fs précède l'image clé précédente à %.2fs",
+		},
+	},
+	"warning.extreme_speed": {
+		Fields: []string{"keyframe", "speed_factor"},
+		Text: map[string]string{
+			"en": "the segment ending at keyframe %d plays at %.2fx, far from its original speed",
+			"fr": "le segment se terminant à l'image clé %d est lu à %.2fx, loin de sa vitesse d'origine",
+		},
+	},
+	"warning.black_segment": {
+		Fields: []string{"keyframe", "percent"},
+		Text: map[string]string{
+			"en": "the segment ending at keyframe %d is %.0f%% black frames",
+			"fr": "le segment se terminant à l'image clé %d contient %.0f%% d'images noires",
+		},
+	},
+	"warning.frozen_segment": {
+		Fields: []string{"keyframe", "percent"},
+		Text: map[string]string{
+			"en": "the segment ending at keyframe %d is %.0f%% frozen frames",
+			"fr": "le segment se terminant à l'image clé %d contient %.0f%% d'images figées",
+		},
+	},
+	"warning.audio_peaks": {
+		Fields: []string{"audio", "true_peak", "ceiling"},
+		Text: map[string]string{
+			"en": "the audio of %s peaks at %.1f dBTP, above %.1f dBTP, it may distort on playback",
+			"fr": "l'audio de %s atteint %.1f dBTP, au-dessus de %.1f dBTP, il risque de saturer à la lecture",
+		},
+	},
+	"warning.audio_not_passed_through": {
+		Fields: []string{"output", "source_hash", "output_hash"},
+		Text: map[string]string{
+			"en": "the audio of %s was supposed to be copied but doesn't match the source (MD5 %s, got %s)",
+			"fr": "l'audio de %s devait être copié mais ne correspond pas à la source (MD5 %s, obtenu %s)",
+		},
+	},
+	"warning.audio_transcode": {
+		Fields: []string{"output", "property", "expected", "got"},
+		Text: map[string]string{
+			"en": "the audio of %s has an unexpected %s: %s requested, got %s",
+			"fr": "l'audio de %s a une propriété %s inattendue : %s demandé, obtenu %s",
+		},
+	},
+	"warning.audio_duration": {
+		Fields: []string{"output", "duration", "expected"},
+		Text: map[string]string{
+			"en": "the audio of %s lasts %.2fs instead of %.2fs, it may drift out of sync",
+			"fr": "l'audio de %s dure %.2fs au lieu de %.2fs, il risque de se désynchroniser",
+		},
+	},
+	"warning.store": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "failed to update the store: %v",
+			"fr": "impossible de mettre à jour le store : %v",
+		},
+	},
+	"warning.queue": {
+		Fields: []string{"file", "error"},
+		Text: map[string]string{
+			"en": "failed to save the queue in %s: %v",
+			"fr": "impossible d'enregistrer la file dans %s : %v",
+		},
+	},
+	"warning.queued_job_dropped": {
+		Fields: []string{"job", "user", "error"},
+		Text: map[string]string{
+			"en": "job %d of %s dropped from the queue: %v",
+			"fr": "tâche %d de %s retirée de la file : %v",
+		},
+	},
+	"warning.low_tempo_confidence": {
+		Fields: []string{"confidence"},
+		Text: map[string]string{
+			"en": "the detected tempo is unreliable, %.0f%% confidence, pass the BPM or the beats with -beats",
+			"fr": "le tempo détecté est peu fiable, confiance de %.0f%%, indiquez le BPM ou les temps avec -beats",
+		},
+	},
+	"batch.resumed": {
+		Fields: []string{"batch", "item"},
+		Text: map[string]string{
+			"en": "Resuming the %s, %s is already rendered",
+			"fr": "Reprise du %s, %s est déjà rendu",
+		},
+	},
+	"farm.queued": {
+		Fields: []string{"chunks", "dir"},
+		Text: map[string]string{
+			"en": "Queued %d chunks on the farm in %s",
+			"fr": "%d morceaux mis en file d'attente sur la ferme dans %s",
+		},
+	},
+	"farm.chunk": {
+		Fields: []string{"chunk"},
+		Text: map[string]string{
+			"en": "Rendering chunk %s",
+			"fr": "Rendu du morceau %s",
+		},
+	},
+	"worker.start": {
+		Fields: []string{"dir"},
+		Text: map[string]string{
+			"en": "Waiting for chunks to render in %s",
+			"fr": "En attente de morceaux à rendre dans %s",
+		},
+	},
+	"worker.failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "%v, waiting for the next chunk",
+			"fr": "%v, en attente du morceau suivant",
+		},
+	},
+	"serve.start": {
+		Fields: []string{"addr", "jobs"},
+		Text: map[string]string{
+			"en": "Serving on %s, running %d jobs at once",
+			"fr": "Serveur sur %s, %d tâches à la fois",
+		},
+	},
+	"serve.finished": {
+		Fields: []string{"job", "user", "state"},
+		Text: map[string]string{
+			"en": "Job %d of %s %s",
+			"fr": "Tâche %d de %s : %s",
+		},
+	},
+	"serve.requeued": {
+		Fields: []string{"job", "user"},
+		Text: map[string]string{
+			"en": "Job %d of %s canceled, queued again",
+			"fr": "Tâche %d de %s annulée, remise en file",
+		},
+	},
+	"serve.draining": {
+		Fields: []string{"running"},
+		Text: map[string]string{
+			"en": "Shutting down, waiting for %d running jobs to finish (interrupt again to cancel them)",
+			"fr": "Arrêt en cours, attente de la fin de %d tâches (interrompez à nouveau pour les annuler)",
+		},
+	},
+	"serve.stopped": {
+		Fields: []string{"queued", "file"},
+		Text: map[string]string{
+			"en": "Stopped, %d queued jobs saved in %s",
+			"fr": "Arrêté, %d tâches en file enregistrées dans %s",
+		},
+	},
+	"serve.restored": {
+		Fields: []string{"queued", "file"},
+		Text: map[string]string{
+			"en": "%d queued jobs restored from %s",
+			"fr": "%d tâches en file restaurées depuis %s",
+		},
+	},
+	"sync.start": {
+		Fields: []string{"video", "bpm"},
+		Text: map[string]string{
+			"en": "Adjusting speed of video %s to match BPM: %.0f",
+			"fr": "Ajustement de la vitesse de la vidéo %s au BPM : %.0f",
+		},
+	},
+	"render.segment_files": {
+		Fields: []string{"segments", "threshold"},
+		Text: map[string]string{
+			"en": "%d segments exceed the %d a single filter graph handles well, rendering segment files instead",
+			"fr": "%d segments dépassent les %d qu'un seul graphe de filtres gère bien, rendu en fichiers de segments",
+		},
+	},
+	"render.graph_kept": {
+		Fields: []string{"segments", "error"},
+		Text: map[string]string{
+			"en": "Rendering %d segments in a single filter graph, segment files aren't possible: %v",
+			"fr": "Rendu de %d segments dans un seul graphe de filtres, les fichiers de segments sont impossibles : %v",
+		},
+	},
+	"chunk.start": {
+		Fields: []string{"chunk", "start", "end"},
+		Text: map[string]string{
+			"en": "Rendering chunk %d (%.2fs - %.2fs)",
+			"fr": "Rendu du morceau %d (%.2fs - %.2fs)",
+		},
+	},
+	"sync.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Speed adjusted video saved to %s",
+			"fr": "Vidéo ajustée enregistrée dans %s",
+		},
+	},
+	"sync.failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to sync to beat: %v",
+			"fr": "Échec de la synchronisation sur le temps : %v",
+		},
+	},
+	"audio.peaks_failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to check the audio peaks: %v",
+			"fr": "Impossible de vérifier les crêtes audio : %v",
+		},
+	},
+	"container.remapped": {
+		Fields: []string{"requested", "output"},
+		Text: map[string]string{
+			"en": "The codecs can't be stored in %s, writing %s instead",
+			"fr": "Les codecs ne peuvent pas être stockés dans %s, écriture de %s à la place",
+		},
+	},
+	"audio.verify_failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to verify the muxed audio: %v",
+			"fr": "Impossible de vérifier l'audio multiplexé : %v",
+		},
+	},
+	"audio.layout_guessed": {
+		Fields: []string{"audio", "channels", "layout"},
+		Text: map[string]string{
+			"en": "%s has %d channels without a layout, assuming %s",
+			"fr": "%s a %d canaux sans disposition, %s est supposée",
+		},
+	},
+	"audio.layout": {
+		Fields: []string{"audio", "layout", "target"},
+		Text: map[string]string{
+			"en": "converting the audio of %s from %s to %s",
+			"fr": "conversion de l'audio de %s de %s en %s",
+		},
+	},
+	"error.channel_layout": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Error planning the channel layout of the music: %v",
+			"fr": "Erreur lors de la préparation de la disposition des canaux de la musique : %v",
+		},
+	},
+	"original_audio.missing": {
+		Fields: []string{"video"},
+		Text: map[string]string{
+			"en": "%s has no audio, only the music is muxed",
+			"fr": "%s n'a pas d'audio, seule la musique est multiplexée",
+		},
+	},
+	"audio.start": {
+		Fields: []string{"audio", "video"},
+		Text: map[string]string{
+			"en": "Injecting audio from %s into the video at %s",
+			"fr": "Ajout de l'audio de %s à la vidéo %s",
+		},
+	},
+	"ffmpeg.failed": {
+		Fields: []string{"phase", "error"},
+		Text: map[string]string{
+			"en": "Error running FFmpeg (%s): %v",
+			"fr": "Erreur d'exécution de FFmpeg (%s) : %v",
+		},
+	},
+	"ffmpeg.stalled": {
+		Fields: []string{"phase", "attempt", "retries"},
+		Text: map[string]string{
+			"en": "ffmpeg stalled during %s, retrying (%d/%d)",
+			"fr": "ffmpeg bloqué pendant %s, nouvel essai (%d/%d)",
+		},
+	},
+	"gpu.fallback": {
+		Fields: []string{"hwaccel", "missing"},
+		Text: map[string]string{
+			"en": "ffmpeg lacks the %s filters (%s), compositing on the CPU",
+			"fr": "ffmpeg n'a pas les filtres %s (%s), composition sur le processeur",
+		},
+	},
+	"pulse.start": {
+		Fields: []string{"video"},
+		Text: map[string]string{
+			"en": "Adding pulse to video at %s",
+			"fr": "Ajout de la pulsation à la vidéo %s",
+		},
+	},
+	"text.start": {
+		Fields: []string{"video"},
+		Text: map[string]string{
+			"en": "Adding text overlay to video at %s",
+			"fr": "Ajout du texte à la vidéo %s",
+		},
+	},
+	"dead.detected": {
+		Fields: []string{"intervals", "video"},
+		Text: map[string]string{
+			"en": "Found %d portions of dead footage in %s",
+			"fr": "%d passages sans contenu trouvés dans %s",
+		},
+	},
+	"trim.start": {
+		Fields: []string{"video", "start", "end"},
+		Text: map[string]string{
+			"en": "Trimming video at %s to the phrases between %.2fs and %.2fs",
+			"fr": "Découpe de la vidéo %s sur les phrases entre %.2fs et %.2fs",
+		},
+	},
+	"multicam.upscaled": {
+		Fields: []string{"video", "width", "height", "upscaled_width", "upscaled_height"},
+		Text: map[string]string{
+			"en": "Upscaled %s from %dx%d to %dx%d",
+			"fr": "%s agrandie de %dx%d à %dx%d",
+		},
+	},
+	"video.deinterlaced": {
+		Fields: []string{"video", "filter"},
+		Text: map[string]string{
+			"en": "Deinterlacing %s with %s",
+			"fr": "Désentrelacement de %s avec %s",
+		},
+	},
+	"color.guessed": {
+		Fields: []string{"matrix", "height"},
+		Text: map[string]string{
+			"en": "The video has no color metadata, assuming %s for its %dp frames",
+			"fr": "La vidéo n'a pas de métadonnées de couleur, %s est supposé pour ses images en %dp",
+		},
+	},
+	"target.start": {
+		Fields: []string{"target", "width", "height", "fps"},
+		Text: map[string]string{
+			"en": "Exporting for %s at %dx%d, %d fps",
+			"fr": "Export pour %s en %dx%d, %d ips",
+		},
+	},
+	"target.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Export saved to %s",
+			"fr": "Export enregistré dans %s",
+		},
+	},
+	"split.start": {
+		Fields: []string{"second_video", "video"},
+		Text: map[string]string{
+			"en": "Adding split screen with %s to video at %s",
+			"fr": "Ajout de l'écran partagé avec %s à la vidéo %s",
+		},
+	},
+	"pip.start": {
+		Fields: []string{"overlays", "video"},
+		Text: map[string]string{
+			"en": "Adding %d picture in picture overlays to video at %s",
+			"fr": "Ajout de %d incrustations à la vidéo %s",
+		},
+	},
+	"bounce.start": {
+		Fields: []string{"video"},
+		Text: map[string]string{
+			"en": "Adding bounce to video at %s",
+			"fr": "Ajout du rebond à la vidéo %s",
+		},
+	},
+	"shake.start": {
+		Fields: []string{"impacts", "video"},
+		Text: map[string]string{
+			"en": "Adding camera shake on %d impacts to video at %s",
+			"fr": "Ajout de tremblements sur %d impacts à la vidéo %s",
+		},
+	},
+	"stickers.start": {
+		Fields: []string{"bursts", "video"},
+		Text: map[string]string{
+			"en": "Adding %d sticker bursts to video at %s",
+			"fr": "Ajout de %d salves d'autocollants à la vidéo %s",
+		},
+	},
+	"sweep.preview": {
+		Fields: []string{"preview", "settings"},
+		Text: map[string]string{
+			"en": "Rendering sweep preview %d: %s",
+			"fr": "Rendu de l'aperçu %d du balayage : %s",
+		},
+	},
+	"sweep.start": {
+		Fields: []string{"previews", "output"},
+		Text: map[string]string{
+			"en": "Tiling %d previews into the contact sheet %s",
+			"fr": "Assemblage de %d aperçus dans la planche %s",
+		},
+	},
+	"sweep.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Contact sheet saved to %s",
+			"fr": "Planche enregistrée dans %s",
+		},
+	},
+	"montage.preview": {
+		Fields: []string{"preview", "candidate"},
+		Text: map[string]string{
+			"en": "Rendering montage candidate %d: %s",
+			"fr": "Rendu du candidat %d du montage : %s",
+		},
+	},
+	"montage.start": {
+		Fields: []string{"previews", "output"},
+		Text: map[string]string{
+			"en": "Tiling %d candidates into the montage %s",
+			"fr": "Assemblage de %d candidats dans le montage %s",
+		},
+	},
+	"montage.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Montage saved to %s",
+			"fr": "Montage enregistré dans %s",
+		},
+	},
+	"multicam.cut": {
+		Fields: []string{"cut", "start", "end", "angle", "path"},
+		Text: map[string]string{
+			"en": "Cut %d: %.2fs - %.2fs, angle %d (%s)",
+			"fr": "Coupe %d : %.2fs - %.2fs, angle %d (%s)",
+		},
+	},
+	"multicam.start": {
+		Fields: []string{"cuts", "angles"},
+		Text: map[string]string{
+			"en": "Rendering multicam edit with %d cuts across %d angles",
+			"fr": "Rendu du montage multicam avec %d coupes sur %d angles",
+		},
+	},
+	"multicam.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Multicam edit saved to %s",
+			"fr": "Montage multicam enregistré dans %s",
+		},
+	},
+	"clips.clip": {
+		Fields: []string{"clip", "start", "end", "beats", "path", "in", "out", "speed_factor"},
+		Text: map[string]string{
+			"en": "Clip %d: %.2fs - %.2fs, %g beats of %s (%.2fs - %.2fs) at x%.2f",
+			"fr": "Clip %d : %.2fs - %.2fs, %g temps de %s (%.2fs - %.2fs) à x%.2f",
+		},
+	},
+	"recipe.applied": {
+		Fields: []string{"recipe", "clips"},
+		Text: map[string]string{
+			"en": "Recipe %s planned %d clips",
+			"fr": "La recette %s a planifié %d clips",
+		},
+	},
+	"clips.start": {
+		Fields: []string{"clips", "duration"},
+		Text: map[string]string{
+			"en": "Rendering edit of %d clips (%.2fs)",
+			"fr": "Rendu du montage de %d clips (%.2fs)",
+		},
+	},
+	"clips.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Clip edit saved to %s",
+			"fr": "Montage des clips enregistré dans %s",
+		},
+	},
+	"slideshow.start": {
+		Fields: []string{"photos", "beats", "duration"},
+		Text: map[string]string{
+			"en": "Rendering slideshow of %d photos, %d beats each (%.2fs)",
+			"fr": "Rendu du diaporama de %d photos, %d temps chacune (%.2fs)",
+		},
+	},
+	"slideshow.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Slideshow saved to %s",
+			"fr": "Diaporama enregistré dans %s",
+		},
+	},
+	"tap.start": {
+		Fields: []string{"audio"},
+		Text: map[string]string{
+			"en": "Playing %s, tap the spacebar on the keyframes, q to stop",
+			"fr": "Lecture de %s, tapez sur la barre d'espace aux images clés, q pour arrêter",
+		},
+	},
+	"tap.line_mode": {
+		Text: map[string]string{
+			"en": "The terminal can't read single keys, press Enter to tap",
+			"fr": "Le terminal ne peut pas lire les touches une à une, appuyez sur Entrée pour taper",
+		},
+	},
+	"tap.recorded": {
+		Fields: []string{"tap", "time"},
+		Text: map[string]string{
+			"en": "tap %d at %.3fs",
+			"fr": "tap %d à %.3fs",
+		},
+	},
+	"tap.marked": {
+		Fields: []string{"tap", "time", "label"},
+		Text: map[string]string{
+			"en": "tap %d at %.3fs (%s)",
+			"fr": "tap %d à %.3fs (%s)",
+		},
+	},
+	"tap.midi": {
+		Fields: []string{"device"},
+		Text: map[string]string{
+			"en": "Listening to the notes of %s",
+			"fr": "Écoute des notes de %s",
+		},
+	},
+	"tap.osc": {
+		Fields: []string{"address"},
+		Text: map[string]string{
+			"en": "Listening to OSC messages on %s",
+			"fr": "Écoute des messages OSC sur %s",
+		},
+	},
+	"tap.saved": {
+		Fields: []string{"taps", "output"},
+		Text: map[string]string{
+			"en": "%d taps saved to %s",
+			"fr": "%d taps enregistrés dans %s",
+		},
+	},
+	"cover.saved": {
+		Fields: []string{"output", "time"},
+		Text: map[string]string{
+			"en": "Cover saved to %s (frame at %.2fs)",
+			"fr": "Couverture enregistrée dans %s (image à %.2fs)",
+		},
+	},
+	"retime.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Retime curve saved to %s",
+			"fr": "Courbe de retiming enregistrée dans %s",
+		},
+	},
+	"click.saved": {
+		Fields: []string{"output", "beats"},
+		Text: map[string]string{
+			"en": "Click track saved to %s (%d beats)",
+			"fr": "Piste de clics enregistrée dans %s (%d temps)",
+		},
+	},
+	"reaper.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Reaper project saved to %s",
+			"fr": "Projet Reaper enregistré dans %s",
+		},
+	},
+	"report.saved": {
+		Fields: []string{"output", "keyframes"},
+		Text: map[string]string{
+			"en": "Report saved to %s (%d keyframes)",
+			"fr": "Rapport enregistré dans %s (%d images clés)",
+		},
+	},
+	"boomerang.start": {
+		Fields: []string{"first_bar", "last_bar", "loops", "bar_duration"},
+		Text: map[string]string{
+			"en": "Rendering a boomerang of bars %d to %d, %d loops of one bar (%.2fs)",
+			"fr": "Rendu d'un boomerang des mesures %d à %d, %d boucles d'une mesure (%.2fs)",
+		},
+	},
+	"boomerang.saved": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "Boomerang saved to %s",
+			"fr": "Boomerang enregistré dans %s",
+		},
+	},
+	"fixture.saved": {
+		Fields: []string{"video", "keyframes", "audio", "flashes", "bpm"},
+		Text: map[string]string{
+			"en": "Fixture saved: %s, %s and %s (%d flashes, clicks at %.2f BPM)",
+			"fr": "Fixture enregistrée : %s, %s et %s (%d flashs, clics à %.2f BPM)",
+		},
+	},
+	"scan.failed": {
+		Fields: []string{"path", "error"},
+		Text: map[string]string{
+			"en": "Failed to probe %s: %s",
+			"fr": "Impossible d'analyser %s : %s",
+		},
+	},
+	"scan.saved": {
+		Fields: []string{"files", "dir", "probed", "failed", "index"},
+		Text: map[string]string{
+			"en": "Indexed %d media files of %s (%d probed, %d failed) in %s",
+			"fr": "%d fichiers médias de %s indexés (%d analysés, %d en échec) dans %s",
+		},
+	},
+	"scan.clips": {
+		Fields: []string{"clips", "manifest"},
+		Text: map[string]string{
+			"en": "Clip manifest of %d videos saved to %s",
+			"fr": "Manifeste de %d clips vidéo enregistré dans %s",
+		},
+	},
+	"scan.file": {
+		Fields: []string{"duration", "video", "bpm", "path"},
+		Text: map[string]string{
+			"en": "%9.2fs  %-18s %-11s %s",
+			"fr": "%9.2fs  %-18s %-11s %s",
+		},
+	},
+	"workspace.removed": {
+		Fields: []string{"files", "megabytes"},
+		Text: map[string]string{
+			"en": "Removed %d files, freed %.1f MB",
+			"fr": "%d fichiers supprimés, %.1f Mo libérés",
+		},
+	},
+	"benchmark.fixture": {
+		Fields: []string{"bpm"},
+		Text: map[string]string{
+			"en": "Generating the %.0f BPM fixture",
+			"fr": "Génération de la fixture à %.0f BPM",
+		},
+	},
+	"play.start": {
+		Text: map[string]string{
+			"en": "Playing preview, close the ffplay window or press q to stop",
+			"fr": "Lecture de l'aperçu, fermez la fenêtre ffplay ou appuyez sur q pour arrêter",
+		},
+	},
+	"plugin.run": {
+		Fields: []string{"plugin"},
+		Text: map[string]string{
+			"en": "Running plugin %s",
+			"fr": "Exécution du plugin %s",
+		},
+	},
+	"history.failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to record the run in the history: %v",
+			"fr": "Impossible d'enregistrer l'exécution dans l'historique : %v",
+		},
+	},
+	"usage.report": {
+		Text: map[string]string{
+			"en": "Resource usage:",
+			"fr": "Utilisation des ressources :",
+		},
+	},
+	"usage.sync": {
+		Text: map[string]string{
+			"en": "Usage: <program> sync|plan|pulse|analyze [flags], or <program> [flags] BPM originalVideoPath keyframesPath (.json, .csv or .txt, - for JSON on stdin) [audioPath (- for stdin)], see <program> help",
+			"fr": "Utilisation : <program> sync|plan|pulse|analyze [options], ou <program> [options] BPM vidéoOriginale imagesClés (.json, .csv ou .txt, - pour du JSON sur stdin) [audio (- pour stdin)], voir <program> help",
+		},
+	},
+	"usage.clips": {
+		Text: map[string]string{
+			"en": "Usage: <program> -clips clipsJsonPath -bpm BPM|auto|-beat-times beats.json [-audio audioPath], or <program> -clips clipsJsonPath BPM [audioPath]",
+			"fr": "Utilisation : <program> -clips clips.json -bpm BPM|auto|-beat-times temps.json [-audio audio], ou <program> -clips clips.json BPM [audio]",
+		},
+	},
+	"usage.multicam": {
+		Text: map[string]string{
+			"en": "Usage: <program> -angles anglesJsonPath -bpm BPM|auto|-beat-times beats.json [-audio audioPath], or <program> -angles anglesJsonPath BPM [audioPath]",
+			"fr": "Utilisation : <program> -angles angles.json -bpm BPM|auto|-beat-times temps.json [-audio audio], ou <program> -angles angles.json BPM [audio]",
+		},
+	},
+	"usage.slideshow": {
+		Text: map[string]string{
+			"en": "Usage: <program> -slideshow photosDir -bpm BPM|auto|-beat-times beats.json -audio audioPath, or <program> -slideshow photosDir BPM audioPath",
+			"fr": "Utilisation : <program> -slideshow dossierPhotos -bpm BPM|auto|-beat-times temps.json -audio audio, ou <program> -slideshow dossierPhotos BPM audio",
+		},
+	},
+	"usage.tap": {
+		Text: map[string]string{
+			"en": "Usage: <program> -tap keyframes.json -audio audioPath, or <program> -tap keyframes.json audioPath",
+			"fr": "Utilisation : <program> -tap imagesClés.json -audio audio, ou <program> -tap imagesClés.json audio",
+		},
+	},
+	"usage.boomerang": {
+		Text: map[string]string{
+			"en": "Usage: <program> -boomerang bars -bpm BPM|auto|-beat-times beats.json -video video [-audio audioPath], or <program> -boomerang bars BPM video [audioPath]",
+			"fr": "Utilisation : <program> -boomerang mesures -bpm BPM|auto|-beat-times temps.json -video vidéo [-audio audio], ou <program> -boomerang mesures BPM vidéo [audio]",
+		},
+	},
+	"usage.preset": {
+		Text: map[string]string{
+			"en": presetUsage,
+			"fr": `Utilisation :
+  <program> preset list                  lister les préréglages disponibles
+  <program> preset show nom              afficher un préréglage
+  <program> preset export nom [fichier]  écrire un préréglage dans un fichier (stdout par défaut)
+  <program> preset import fichier        ajouter un préréglage à la galerie`,
+		},
+	},
+	"usage.history": {
+		Text: map[string]string{
+			"en": historyUsage,
+			"fr": `Utilisation :
+  <program> history list          lister les exécutions du projet
+  <program> history show n        afficher la ligne de commande complète de l'exécution n
+  <program> history rerun n       relancer l'exécution n avec exactement les mêmes réglages`,
+		},
+	},
+	"usage.diff_plan": {
+		Text: map[string]string{
+			"en": diffPlanUsage,
+			"fr": `Utilisation :
+  <program> diff-plan a.json b.json [n]    comparer deux plans, ou un plan et ses images clés, en listant les n images clés (10 par défaut) les plus déplacées et dont la vitesse change le plus`,
+		},
+	},
+	"usage.benchmark": {
+		Text: map[string]string{
+			"en": benchmarkUsage,
+			"fr": `Utilisation :
+  <program> benchmark [balayage]    synchroniser des vidéos de test générées avec chaque combinaison de réglages (comme avec -sweep, "subdivision=1,2,4" par défaut) et afficher les écarts entre les coupes et les temps`,
+		},
+	},
+	"usage.store": {
+		Text: map[string]string{
+			"en": storeUsage,
+			"fr": `Utilisation :
+  <program> store info              compter les lignes de chaque table du store
+  <program> store clear [table...]  vider des tables du store (analyses, runs ou jobs, toutes par défaut)
+
+Le store est défini avec -store avant la sous-commande, comme dans
+<program> -store runs.db store info, ou avec la variable d'environnement
+SYNCTOBEAT_STORE. C'est une base SQLite qui peut aussi être interrogée avec sqlite3.`,
+		},
+	},
+	"usage.worker": {
+		Text: map[string]string{
+			"en": workerUsage,
+			"fr": `Utilisation :
+  <program> worker dossier    rendre les morceaux mis en file dans le dossier de la ferme par les exécutions avec -farm dossier, jusqu'à interruption`,
+		},
+	},
+	"usage.commands": {
+		Text: map[string]string{
+			"en": commandsUsage,
+			"fr": `Utilisation :
+  <program> sync -bpm BPM|auto|-beat-times temps.json -video vidéo -keyframes imagesClés [-audio musique] [-o sortie] [options]
+        recaler la vidéo pour que ses images clés tombent sur les temps de la musique
+  <program> plan -bpm BPM|auto -video vidéo -keyframes imagesClés [-audio musique] [-o plan.json] [options]
+        afficher le rapport du plan sans rendu, en écrivant le plan dans -o s'il est défini
+  <program> pulse -bpm BPM|auto -video vidéo [-audio musique] [-o sortie] [options]
+        faire clignoter la vidéo sur les temps de la grille, sans la recaler
+  <program> analyze -audio musique [options]
+        détecter le tempo de la musique, ses temps et la grille qui leur correspond
+
+Les images clés (.json, .csv ou .txt) ou la musique peuvent être - pour les lire sur stdin.
+Avec -bpm auto, le tempo et le premier temps sont détectés dans la musique. Avec
+-beat-times, la grille suit les temps listés dans un tableau JSON.
+Les options peuvent venir avant ou après la commande. Sans commande, les
+arguments sont lus comme BPM vidéo imagesClés [musique].`,
+		},
+	},
+	"usage.scan": {
+		Text: map[string]string{
+			"en": scanUsage,
+			"fr": `Utilisation :
+  <program> scan dossier                        indexer les fichiers média du dossier et de ses sous-dossiers
+  <program> scan query [filtre...]              lister les fichiers indexés correspondant à tous les filtres
+  <program> scan clips sortie.json [filtre...]  écrire les vidéos correspondantes dans un manifeste de clips pour -clips
+
+Les filtres sont de la forme propriété=intervalle, comme duration=3-8, height=1080-,
+fps=30, bpm=120-130 ou type=video. Les propriétés sont duration, fps, width,
+height, bpm (le tempo indiqué dans le fichier) et type (video ou audio).`,
+		},
+	},
+	"usage.workspace": {
+		Text: map[string]string{
+			"en": workspaceUsage,
+			"fr": `Utilisation :
+  <program> clean [zone...]    vider des zones du dossier du projet (cache et aperçus par défaut, ou all)
+  <program> gc [garder]        garder les dernières versions (3 par défaut) de chaque fichier du dossier du projet`,
+		},
+	},
+	"usage.fixture": {
+		Text: map[string]string{
+			"en": fixtureUsage,
+			"fr": `Utilisation :
+  <program> gen-fixture dossier [BPM [flashs]]    écrire une vidéo de test avec des flashs numérotés, leurs images clés et une piste de clics (120 BPM et 8 flashs par défaut)`,
+		},
+	},
+	"usage.serve": {
+		Text: map[string]string{
+			"en": serveUsage,
+			"fr": `Utilisation :
+  <program> serve [adresse] [jobs] [jobs-par-utilisateur]    exécuter les jobs soumis en HTTP sur l'adresse (localhost:8080 par défaut),
+                                                             jobs à la fois (1 par défaut) et jobs-par-utilisateur à la fois pour chaque utilisateur (sans limite par défaut)
+
+Les jobs sont soumis avec POST /jobs et un corps JSON comme
+  {"args": ["sync", "-bpm", "120", "-video", "video.mp4", "-keyframes", "keyframes.json"], "user": "ana", "priority": 10, "start_at": "2024-05-01T22:00:00Z"}
+le job de plus haute priorité dont l'heure de début est passée s'exécute en premier.
+GET /jobs liste les jobs, GET /jobs/{id} en décrit un et DELETE /jobs/{id} l'annule.
+GET /jobs/{id}/outputs/{n} télécharge la sortie n, comptée à partir de 0 dans les
+sorties du résultat du job, une fois qu'il a réussi. Les jobs terminés sont
+gardés 24 heures.
+POST /uploads/{nom} envoie un fichier et répond avec son chemin sur le serveur.
+GET /metrics donne les jobs par état, le temps d'encodage et les échecs de ffmpeg
+par catégorie pour Prometheus.
+
+Le serveur n'écoute que sur localhost sauf si SYNCTOBEAT_API_KEYS désigne un
+fichier JSON de clés d'API, envoyées comme jetons bearer ou dans l'en-tête X-API-Key :
+  [{"key": "...", "user": "ana", "jobs_per_day": 50, "max_upload": "2GB"}]
+Les jobs soumis avec une clé exécutent la commande sync, plan, pulse ou analyze,
+avec les fichiers envoyés comme entrées et seulement les options réglant
+l'exécution : les options écrivant à des chemins arbitraires ou lançant
+d'autres programmes sont refusées.
+
+Sur SIGTERM ou une interruption, le serveur n'accepte plus de jobs et attend la
+fin de ceux en cours, un second signal les arrête. Les jobs en file ou en cours
+sont enregistrés dans .syncToBeat_queue.json dans le dossier courant à chaque
+changement, et s'exécutent après un redémarrage ou un plantage.`,
+		},
+	},
+	"repl.help": {
+		Text: map[string]string{
+			"en": interactiveHelp,
+			"fr": `Commandes :
+  offset [+|-]secondes  régler le temps du premier temps, ou le décaler avec un signe
+  bpm valeur            régler le tempo de la grille
+  swing pourcentage     régler le swing des subdivisions à contretemps (50 est droit)
+  report                afficher où tombent les images clés sur la grille
+  preview [début-fin]   lire la vidéo synchronisée, éventuellement une partie seulement (par ex. 0:30-0:45)
+  render                écrire les vidéos synchronisée et de débogage
+  help                  afficher cette aide
+  quit                  quitter`,
+		},
+	},
+	"repl.grid": {
+		Fields: []string{"grid"},
+		Text: map[string]string{
+			"en": "Grid: %s",
+			"fr": "Grille : %s",
+		},
+	},
+	"repl.error": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Error: %v",
+			"fr": "Erreur : %v",
+		},
+	},
+	"repl.usage_offset": {
+		Text: map[string]string{
+			"en": "Usage: offset [+|-]seconds",
+			"fr": "Utilisation : offset [+|-]secondes",
+		},
+	},
+	"repl.invalid_offset": {
+		Fields: []string{"value"},
+		Text: map[string]string{
+			"en": "Invalid offset: %s",
+			"fr": "Décalage invalide : %s",
+		},
+	},
+	"repl.usage_bpm": {
+		Text: map[string]string{
+			"en": "Usage: bpm value",
+			"fr": "Utilisation : bpm valeur",
+		},
+	},
+	"repl.invalid_bpm": {
+		Fields: []string{"value"},
+		Text: map[string]string{
+			"en": "Invalid BPM: %s",
+			"fr": "BPM invalide : %s",
+		},
+	},
+	"repl.usage_swing": {
+		Text: map[string]string{
+			"en": "Usage: swing percent",
+			"fr": "Utilisation : swing pourcentage",
+		},
+	},
+	"repl.invalid_swing": {
+		Fields: []string{"value"},
+		Text: map[string]string{
+			"en": "Invalid swing: %s",
+			"fr": "Swing invalide : %s",
+		},
+	},
+	"repl.play_failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to play the synced video: %v",
+			"fr": "Impossible de lire la vidéo synchronisée : %v",
+		},
+	},
+	"repl.render_failed": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to render: %v",
+			"fr": "Échec du rendu : %v",
+		},
+	},
+	"repl.unknown_command": {
+		Fields: []string{"command"},
+		Text: map[string]string{
+			"en": "Unknown command %q, type help for the list of commands",
+			"fr": "Commande %q inconnue, tapez help pour la liste des commandes",
+		},
+	},
+	"preset.entry": {
+		Fields: []string{"name", "description"},
+		Text: map[string]string{
+			"en": "%-12s %s",
+		},
+	},
+	"preset.exported": {
+		Fields: []string{"preset", "file"},
+		Text: map[string]string{
+			"en": "Preset %s exported to %s",
+			"fr": "Préréglage %s exporté dans %s",
+		},
+	},
+	"preset.imported": {
+		Fields: []string{"preset", "file"},
+		Text: map[string]string{
+			"en": "Preset %s imported to %s",
+			"fr": "Préréglage %s importé dans %s",
+		},
+	},
+	"history.entry": {
+		Fields: []string{"run", "time", "summary"},
+		Text: map[string]string{
+			"en": "%3d  %s  %s",
+		},
+	},
+	"history.output": {
+		Fields: []string{"output"},
+		Text: map[string]string{
+			"en": "       -> %s",
+		},
+	},
+	"diff_plan.summary": {
+		Fields: []string{"common", "only_a", "a", "only_b", "b"},
+		Text: map[string]string{
+			"en": "%d keyframes in common, %d only in %s, %d only in %s",
+			"fr": "%d images clés en commun, %d seulement dans %s, %d seulement dans %s",
+		},
+	},
+	"diff_plan.displacements": {
+		Text: map[string]string{
+			"en": "\nLargest displacements:",
+			"fr": "\nPlus grands déplacements :",
+		},
+	},
+	"diff_plan.speed_changes": {
+		Text: map[string]string{
+			"en": "\nLargest speed changes:",
+			"fr": "\nPlus grands changements de vitesse :",
+		},
+	},
+	"diff_plan.header": {
+		Text: map[string]string{
+			"en": "  keyframe label                     a          b     moved  speed a  speed b",
+			"fr": "  image    libellé                   a          b  déplacée   vit. a   vit. b",
+		},
+	},
+	"diff_plan.change": {
+		Fields: []string{"keyframe", "label", "a", "b", "moved", "speed_a", "speed_b"},
+		Text: map[string]string{
+			"en": "  %-8d %-16s %9.3fs %9.3fs %+8.3fs %8.3f %8.3f",
+		},
+	},
+	"benchmark.header": {
+		Text: map[string]string{
+			"en": "settings                            cuts   mean ms    p50 ms    p95 ms    max ms",
+			"fr": "réglages                          coupes   moy. ms    p50 ms    p95 ms    max ms",
+		},
+	},
+	"benchmark.result": {
+		Fields: []string{"settings", "cuts", "mean_ms", "p50_ms", "p95_ms", "max_ms"},
+		Text: map[string]string{
+			"en": "%-32s %7s %9.1f %9.1f %9.1f %9.1f",
+		},
+	},
+	"store.table": {
+		Fields: []string{"table", "rows"},
+		Text: map[string]string{
+			"en": "%-10s %d",
+		},
+	},
+	"error": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "%v",
+		},
+	},
+	"error.sections": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to read the sections: %v",
+			"fr": "Impossible de lire les sections : %v",
+		},
+	},
+	"error.beat_times": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to read the beat times: %v",
+			"fr": "Impossible de lire les temps : %v",
+		},
+	},
+	"error.dead": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to remove the dead footage: %v",
+			"fr": "Impossible de retirer les passages sans contenu : %v",
+		},
+	},
+	"error.titles": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to read the titles: %v",
+			"fr": "Impossible de lire les titres : %v",
+		},
+	},
+	"error.effects": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to read the custom effects: %v",
+			"fr": "Impossible de lire les effets personnalisés : %v",
+		},
+	},
+	"error.plugins": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to read the plugins: %v",
+			"fr": "Impossible de lire les plugins : %v",
+		},
+	},
+	"error.clips": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to generate the clip edit: %v",
+			"fr": "Impossible de générer le montage des clips : %v",
+		},
+	},
+	"error.multicam": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to generate the multicam edit: %v",
+			"fr": "Impossible de générer le montage multicam : %v",
+		},
+	},
+	"error.slideshow": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to generate the slideshow: %v",
+			"fr": "Impossible de générer le diaporama : %v",
+		},
+	},
+	"error.tap": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to capture the taps: %v",
+			"fr": "Impossible d'enregistrer les taps : %v",
+		},
+	},
+	"error.boomerang": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to render the boomerang: %v",
+			"fr": "Impossible de générer le boomerang : %v",
+		},
+	},
+	"error.play": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to play the synced video: %v",
+			"fr": "Impossible de lire la vidéo synchronisée : %v",
+		},
+	},
+	"error.result": {
+		Fields: []string{"error"},
+		Text: map[string]string{
+			"en": "Failed to write the result: %v",
+			"fr": "Impossible d'écrire le résultat : %v",
+		},
+	},
+}
+
+// event is a JSON line written for every message with -json.
+type event struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+	ID    string    `json:"id"`
+	// Text is the message formatted in the selected language.
+	Text   string         `json:"text"`
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+var outputMu sync.Mutex
+
+// quiet silences the messages and warnings, while trying plans out for
+// instance.
+var quiet bool
+
+// detectLanguage returns the language set in the environment, English when
+// unset.
+func detectLanguage() string {
+	for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(env); value != "" && value != "C" && value != "POSIX" {
+			language, _, _ := strings.Cut(value, "_")
+			language, _, _ = strings.Cut(language, ".")
+			return strings.ToLower(language)
+		}
+	}
+	return "en"
+}
+
+// formatMessage formats the message in the selected language.
+func formatMessage(id string, args ...any) string {
+	format, ok := messages[id]
+	if !ok {
+		return fmt.Sprint(append([]any{id + ": "}, args...)...)
+	}
+	text, ok := format.Text[Language]
+	if !ok {
+		text = format.Text["en"]
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// writeEvent writes a JSON event for the message.
+func writeEvent(level string, id string, args ...any) {
+	writeJSONLine(event{
+		Time:   time.Now(),
+		Level:  level,
+		ID:     id,
+		Text:   formatMessage(id, args...),
+		Fields: messageFields(id, args...),
+	})
+}
+
+// messageFields names the arguments of the message after the fields of its
+// format.
+func messageFields(id string, args ...any) map[string]any {
+	format, ok := messages[id]
+	if !ok || len(format.Fields) == 0 {
+		return nil
+	}
+	fields := map[string]any{}
+	for i, name := range format.Fields {
+		if i >= len(args) {
+			break
+		}
+		value := args[i]
+		// errors don't serialize to JSON
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+// writeJSONLine writes a value as a JSON line on stdout.
+func writeJSONLine(v any) {
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		log.Printf("failed to encode event: %v", err)
+	}
+}
+
+// say writes the identified message of the catalog.
+func say(id string, args ...any) {
+	if quiet {
+		return
+	}
+	if JSONOutput {
+		writeEvent("info", id, args...)
+		return
+	}
+	outputMu.Lock()
+	defer outputMu.Unlock()
+	fmt.Println(formatMessage(id, args...))
+}
+
+var (
+	cleanupsMu sync.Mutex
+	// cleanups remove the temporary files of the run, fail exits without
+	// running the deferred calls.
+	cleanups []func()
+)
+
+// atExit registers a cleanup run by runCleanups, when the run ends or fails.
+func atExit(cleanup func()) {
+	cleanupsMu.Lock()
+	defer cleanupsMu.Unlock()
+	cleanups = append(cleanups, cleanup)
+}
+
+// runCleanups runs the registered cleanups, once.
+func runCleanups() {
+	cleanupsMu.Lock()
+	pending := cleanups
+	cleanups = nil
+	cleanupsMu.Unlock()
+	for _, cleanup := range pending {
+		cleanup()
+	}
+}
+
+// fail writes the identified error message, cleans up and exits.
+func fail(id string, args ...any) {
+	if JSONOutput {
+		writeEvent("error", id, args...)
+	} else {
+		log.Print(formatMessage(id, args...))
+	}
+	runCleanups()
+	os.Exit(1)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// jobStates are the states of the jobs, as reported by the metrics.
+var jobStates = []string{"queued", "running", "succeeded", "failed", "canceled"}
+
+// ffmpegFailureClasses are the classes of the ffmpeg failures: stalled
+// processes killed by the watchdog, phases over their timeout, ffmpeg not
+// found and any other error.
+var ffmpegFailureClasses = []string{"stalled", "timeout", "unavailable", "error"}
+
+// ffmpegFailureClass returns the class of the ffmpeg failure an event
+// reports, an empty string when it doesn't report one.
+func ffmpegFailureClass(e event) string {
+	if e.Level != "error" && e.ID != "ffmpeg.failed" {
+		return ""
+	}
+	switch {
+	case strings.Contains(e.Text, "ffmpeg is not available"):
+		return "unavailable"
+	case strings.Contains(e.Text, errStalled.Error()):
+		return "stalled"
+	case strings.Contains(e.Text, "timed out after"):
+		return "timeout"
+	case e.ID == "ffmpeg.failed":
+		return "error"
+	}
+	return ""
+}
+
+// failureWatcher reads the JSON events written by a job as they come,
+// counting the ffmpeg failures they report. The log of the job is capped,
+// failures at the end of long runs would be missing from it.
+type failureWatcher struct {
+	mu   sync.Mutex
+	line []byte
+	// stalls counts the stalled processes retried.
+	stalls int
+	// class is the class of the failure ending the run, reported both as
+	// ffmpeg.failed and as the error of the run.
+	class string
+}
+
+func (f *failureWatcher) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.line = append(f.line, p...)
+	for {
+		end := bytes.IndexByte(f.line, '\n')
+		if end < 0 {
+			break
+		}
+		var e event
+		if json.Unmarshal(f.line[:end], &e) == nil {
+			if e.ID == "ffmpeg.stalled" {
+				f.stalls++
+			} else if class := ffmpegFailureClass(e); class != "" && f.class == "" {
+				f.class = class
+			}
+		}
+		f.line = f.line[end+1:]
+	}
+	// progress lines can't be failures, only keep the start of long lines
+	if len(f.line) > maxJobLog {
+		f.line = f.line[:0]
+	}
+	return len(p), nil
+}
+
+// failures returns the ffmpeg failures of the job by class.
+func (f *failureWatcher) failures() map[string]int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	failures := map[string]int{}
+	if f.stalls > 0 {
+		failures["stalled"] = f.stalls
+	}
+	if f.class != "" {
+		failures[f.class]++
+	}
+	return failures
+}
+
+// jobTotals sums up the metrics of jobs.
+type jobTotals struct {
+	submitted     int
+	finished      int
+	jobSeconds    float64
+	encodeSeconds float64
+	cpuSeconds    float64
+}
+
+// add counts the job in the totals.
+func (t *jobTotals) add(job *serveJob) {
+	t.submitted++
+	if job.Started != nil && job.Finished != nil {
+		t.jobSeconds += job.Finished.Sub(*job.Started).Seconds()
+		t.finished++
+	}
+	if job.Result != nil {
+		for _, phase := range job.Result.Phases {
+			t.encodeSeconds += phase.WallSeconds
+			t.cpuSeconds += phase.UserCPU + phase.SystemCPU
+		}
+	}
+}
+
+// writeMetrics writes the metrics of the server in the Prometheus text
+// format, the pruned jobs still counting in the totals.
+func (s *jobServer) writeMetrics(w io.Writer) {
+	s.mu.Lock()
+	jobs := map[string]int{}
+	totals := s.pruned
+	for _, job := range s.jobs {
+		jobs[job.State]++
+		totals.add(job)
+	}
+	failures := make(map[string]int, len(s.failures))
+	for class, n := range s.failures {
+		failures[class] = n
+	}
+	s.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP syncToBeat_jobs Jobs of the server by state.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_jobs gauge")
+	for _, state := range jobStates {
+		fmt.Fprintf(w, "syncToBeat_jobs{state=%q} %d\n", state, jobs[state])
+	}
+	fmt.Fprintln(w, "# HELP syncToBeat_jobs_submitted_total Jobs submitted since the server started.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_jobs_submitted_total counter")
+	fmt.Fprintf(w, "syncToBeat_jobs_submitted_total %d\n", totals.submitted)
+	fmt.Fprintln(w, "# HELP syncToBeat_job_seconds Time the finished jobs ran.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_job_seconds summary")
+	fmt.Fprintf(w, "syncToBeat_job_seconds_sum %g\n", totals.jobSeconds)
+	fmt.Fprintf(w, "syncToBeat_job_seconds_count %d\n", totals.finished)
+	fmt.Fprintln(w, "# HELP syncToBeat_encode_seconds_total Wall time of the ffmpeg phases of the succeeded jobs.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_encode_seconds_total counter")
+	fmt.Fprintf(w, "syncToBeat_encode_seconds_total %g\n", totals.encodeSeconds)
+	fmt.Fprintln(w, "# HELP syncToBeat_encode_cpu_seconds_total CPU time of the ffmpeg phases of the succeeded jobs.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_encode_cpu_seconds_total counter")
+	fmt.Fprintf(w, "syncToBeat_encode_cpu_seconds_total %g\n", totals.cpuSeconds)
+	fmt.Fprintln(w, "# HELP syncToBeat_ffmpeg_failures_total FFmpeg failures of the jobs by class, stalls retried included.")
+	fmt.Fprintln(w, "# TYPE syncToBeat_ffmpeg_failures_total counter")
+	for _, class := range ffmpegFailureClasses {
+		fmt.Fprintf(w, "syncToBeat_ffmpeg_failures_total{class=%q} %d\n", class, failures[class])
+	}
+}
+
+// handleMetrics serves the metrics to Prometheus.
+func (s *jobServer) handleMetrics(w http.ResponseWriter, r *http.Request, key *apiKey) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.writeMetrics(w)
+}
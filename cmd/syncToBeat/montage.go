@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// montageCandidate is a candidate edit of a montage.
+type montageCandidate struct {
+	// Preset is the preset of the candidate, empty keeps the preset of the
+	// run.
+	Preset string
+	// Seed replaces the seed of the random choices when set.
+	Seed string
+}
+
+// label describes the candidate in the montage.
+func (c montageCandidate) label() string {
+	label := c.Preset
+	if label == "" {
+		label = "base"
+	}
+	if c.Seed != "" {
+		label += " seed " + c.Seed
+	}
+	return label
+}
+
+// parseMontage parses a comma separated list of candidates written
+// preset@seed, either part being optional, e.g. "punchy@1,punchy@2,dreamy".
+func parseMontage(spec string) ([]montageCandidate, error) {
+	var candidates []montageCandidate
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		preset, seed, _ := strings.Cut(part, "@")
+		if preset != "" {
+			if _, err := findPreset(preset); err != nil {
+				return nil, err
+			}
+		}
+		if seed != "" {
+			if _, err := strconv.ParseInt(seed, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid seed %q for the montage candidate %q", seed, part)
+			}
+		}
+		candidates = append(candidates, montageCandidate{Preset: preset, Seed: seed})
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("the montage has no candidates")
+	}
+	if len(candidates) > maxContactSheetTiles {
+		return nil, fmt.Errorf("the montage has %d candidates, at most %d are supported", len(candidates), maxContactSheetTiles)
+	}
+	return candidates, nil
+}
+
+// montageBase is the state of the settings the candidates start from.
+type montageBase struct {
+	// Flags are the values of the flags before the preset of the run got
+	// applied.
+	Flags map[string]string
+	// Explicit are the flags set on the command line, they take precedence
+	// over the presets of the candidates.
+	Explicit map[string]bool
+	// Preset is the preset of the run.
+	Preset string
+}
+
+// applyCandidate sets the settings of a candidate and returns its grid.
+func applyCandidate(base montageBase, candidate montageCandidate, grid beatGrid) (beatGrid, error) {
+	if err := restoreFlags(base.Flags); err != nil {
+		return grid, err
+	}
+	// drop the effects of the preset of the previous candidate
+	if !base.Explicit["effects"] {
+		CustomEffects = nil
+	}
+	presetName := candidate.Preset
+	if presetName == "" {
+		presetName = base.Preset
+	}
+	// the flags of the preset take precedence over the container defaults
+	explicit := map[string]bool{}
+	for name := range base.Explicit {
+		explicit[name] = true
+	}
+	if presetName != "" {
+		preset, err := findPreset(presetName)
+		if err != nil {
+			return grid, err
+		}
+		if err := applyPreset(preset, base.Explicit); err != nil {
+			return grid, err
+		}
+		for name := range preset.Flags {
+			explicit[name] = true
+		}
+	}
+	if OutputContainer != "" {
+		if err := applyContainerDefaults(OutputContainer, explicit); err != nil {
+			return grid, err
+		}
+	}
+	if candidate.Seed != "" {
+		if err := flag.Set("seed", candidate.Seed); err != nil {
+			return grid, err
+		}
+	}
+
+	// presets can change how the keyframes snap
+	grid.SnapTo = flag.Lookup("snap-to").Value.String()
+	var err error
+	if grid.Subdivision, err = strconv.Atoi(flag.Lookup("subdivision").Value.String()); err != nil {
+		return grid, err
+	}
+	if grid.Swing, err = strconv.ParseFloat(flag.Lookup("swing").Value.String(), 64); err != nil {
+		return grid, err
+	}
+	return grid, applyDerivedSettings()
+}
+
+// runMontage renders a preview of every candidate edit and tiles them in a
+// grid video written to outputPath, 0 columns makes the grid as square as
+// possible.
+func runMontage(candidates []montageCandidate, columns int, base montageBase, grid beatGrid, videoPath string, audioPath string, keyframes []Keyframe, outputPath string) error {
+	batch, err := startBatch("montage")
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() { batch.close(succeeded) }()
+
+	var previews, labels []string
+	for i, candidate := range candidates {
+		candidateGrid, err := applyCandidate(base, candidate, grid)
+		if err != nil {
+			return fmt.Errorf("candidate %s: %v", candidate.label(), err)
+		}
+		say("montage.preview", i+1, candidate.label())
+
+		if preview, ok := batch.finished(candidate.label()); ok {
+			previews = append(previews, preview)
+			labels = append(labels, candidate.label())
+			continue
+		}
+		previewDir := filepath.Join(batch.Dir, strconv.Itoa(i))
+		if err := os.MkdirAll(previewDir, 0755); err != nil {
+			return err
+		}
+		preview := filepath.Join(previewDir, "preview.mkv")
+		if err := renderPreview(candidateGrid, videoPath, audioPath, keyframes, preview); err != nil {
+			return fmt.Errorf("candidate %s: %v", candidate.label(), err)
+		}
+		batch.finish(candidate.label(), preview)
+		previews = append(previews, preview)
+		labels = append(labels, candidate.label())
+	}
+
+	say("montage.start", len(previews), outputPath)
+	if err := runFFmpeg("montage", contactSheetArgs(previews, labels, columns, audioPath, outputPath)); err != nil {
+		return err
+	}
+	succeeded = true
+	say("montage.saved", outputPath)
+	recordOutput(outputPath)
+	return nil
+}
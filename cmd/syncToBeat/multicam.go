@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strings"
+)
+
+// CameraAngle represents one camera angle of a multicam shoot as described in
+// the angles JSON manifest.
+type CameraAngle struct {
+	Path string `json:"path"`
+	// Offset is the time, in seconds, in the angle's own footage that lines up
+	// with the start of the music.
+	Offset float64 `json:"offset"`
+	// Weight biases how often the angle gets picked, 0 is treated as 1.
+	Weight float64 `json:"weight"`
+}
+
+// angleCut is a section of the music timeline shown from a single angle.
+type angleCut struct {
+	Angle int
+	Start float64
+	End   float64
+}
+
+// readAngles reads the camera angles from a JSON manifest.
+func readAngles(filePath string) ([]CameraAngle, error) {
+	var angles []CameraAngle
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &angles); err != nil {
+		return nil, err
+	}
+	if len(angles) == 0 {
+		return nil, fmt.Errorf("no camera angles found in %s", filePath)
+	}
+	for i := range angles {
+		if angles[i].Weight < 0 {
+			return nil, fmt.Errorf("camera angle %d (%s) has a negative weight", i, angles[i].Path)
+		}
+		if angles[i].Weight == 0 {
+			angles[i].Weight = 1
+		}
+	}
+	return angles, nil
+}
+
+// multicamRange returns the section of the music timeline covered by all the
+// angles. maxDuration caps the end of the range when it's above zero.
+func multicamRange(angles []CameraAngle, durations []float64, maxDuration float64) (float64, float64, error) {
+	start := 0.0
+	end := math.Inf(1)
+	for i, angle := range angles {
+		start = math.Max(start, -angle.Offset)
+		end = math.Min(end, durations[i]-angle.Offset)
+	}
+	if maxDuration > 0 {
+		end = math.Min(end, maxDuration)
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("the camera angles don't overlap on the music timeline")
+	}
+	return start, end, nil
+}
+
+// planAngleSwitches splits the [start, end] music range into cuts switching
+// angles every switchBeats beats of the grid. Cuts land on multiples of
+// switchBeats so that a switch every 4 beats happens on bar lines. Angles are
+// picked at random according to their weight, never showing the same angle
+// twice in a row when there is a choice.
+func planAngleSwitches(angles []CameraAngle, grid beatGrid, switchBeats int, start, end float64) []angleCut {
+	rng := newRand("multicam")
+	switchEvery := float64(switchBeats)
+
+	var cuts []angleCut
+	previous := -1
+	cutStart := start
+	for cutStart < end {
+		nextSwitch := (math.Floor(grid.BeatPosition(cutStart)/switchEvery+1e-9) + 1) * switchEvery
+		cutEnd := math.Min(grid.BeatTime(nextSwitch), end)
+		angle := pickAngle(angles, previous, rng)
+		cuts = append(cuts, angleCut{Angle: angle, Start: cutStart, End: cutEnd})
+		previous = angle
+		cutStart = cutEnd
+	}
+	return cuts
+}
+
+// pickAngle does a weighted random pick of an angle, excluding the previous
+// one when other angles are available.
+func pickAngle(angles []CameraAngle, previous int, rng *rand.Rand) int {
+	var total float64
+	for i, angle := range angles {
+		if i == previous && len(angles) > 1 {
+			continue
+		}
+		total += angle.Weight
+	}
+
+	target := rng.Float64() * total
+	picked := -1
+	for i, angle := range angles {
+		if i == previous && len(angles) > 1 {
+			continue
+		}
+		picked = i
+		target -= angle.Weight
+		if target < 0 {
+			break
+		}
+	}
+	return picked
+}
+
+// renderMulticam renders the angle cuts into a single video, normalizing
+// every angle to the canvas: the dimensions of the first angle and its frame
+// rate unless Canvas or Upscale say otherwise.
+func renderMulticam(angles []CameraAngle, cuts []angleCut, audioPath string, outputPath string) error {
+	if len(cuts) == 0 {
+		return fmt.Errorf("no cuts to render")
+	}
+
+	angleDimensions := make([]VideoDimensions, len(angles))
+	for i, angle := range angles {
+		d, err := getVideoDimensions(angle.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get dimensions of %s: %v", angle.Path, err)
+		}
+		angleDimensions[i] = d
+	}
+	canvas := canvasSettings{Width: angleDimensions[0].Width, Height: angleDimensions[0].Height}
+	if Upscale != "" {
+		largest := largestDimensions(angleDimensions)
+		canvas.Width, canvas.Height = largest.Width, largest.Height
+	}
+	if Canvas != "" {
+		var err error
+		if canvas, err = parseCanvas(Canvas); err != nil {
+			return err
+		}
+	}
+	if canvas.FPS == 0 {
+		if canvas.FPS = getVideoFrameRate(angles[0].Path); canvas.FPS == 0 {
+			canvas.FPS = slideshowFPS
+		}
+	}
+	dimensions := VideoDimensions{Width: canvas.Width, Height: canvas.Height}
+
+	scaleFlags := ""
+	switch Upscale {
+	case "lanczos":
+		scaleFlags = ":flags=lanczos"
+	case "plugin":
+		dir, err := os.MkdirTemp("", "syncToBeat-upscale-*")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		if angles, err = upscaleAngles(angles, angleDimensions, dimensions, dir); err != nil {
+			return fmt.Errorf("failed to upscale the angles: %v", err)
+		}
+	}
+
+	// the angles are converted to the colors of the first one
+	detectSourceColor(angles[0].Path)
+	var colors string
+	if SourceColor != (colorSettings{}) {
+		colors = "," + colorConvertFilter(SourceColor, "")
+	}
+
+	var filterComplexParts []string
+	var concatParts []string
+	for i, cut := range cuts {
+		angle := angles[cut.Angle]
+		filter := fmt.Sprintf(
+			"[%d:v]trim=start=%s:end=%s,setpts=PTS-STARTPTS,%s%s[v%d]; ",
+			cut.Angle, seconds(cut.Start+angle.Offset), seconds(cut.End+angle.Offset), normalizeFilter(canvas, scaleFlags), colors, i,
+		)
+		filterComplexParts = append(filterComplexParts, filter)
+		concatParts = append(concatParts, fmt.Sprintf("[v%d]", i))
+	}
+	filterComplexParts = append(filterComplexParts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0%s[outv]", strings.Join(concatParts, ""), len(concatParts), videoOffsetFilter()))
+	filterComplex := strings.Join(filterComplexParts, "")
+
+	cmdArgs := []string{"-y"}
+	for _, angle := range angles {
+		cmdArgs = append(cmdArgs, "-i", angle.Path)
+	}
+	start := cuts[0].Start
+	totalDuration := cuts[len(cuts)-1].End - start
+	if audioPath != "" {
+		// the edit starts where all the angles overlap, skip the music up to that point
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-ss", seconds(start).timestamp(), "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", filterComplex,
+		"-map", "[outv]",
+	)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("%d:a", len(angles)))
+		cmdArgs = append(cmdArgs, audioMuxArgs()...)
+	} else {
+		cmdArgs = append(cmdArgs, "-an")
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	cmdArgs = append(cmdArgs,
+		"-t", seconds(totalDuration+max(AVOffset, 0)).timestamp(),
+		outputPath,
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("multicam.start", len(cuts), len(angles))
+	if err := runFFmpeg("multicam", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("multicam.saved", outputPath)
+	recordOutput(outputPath)
+
+	return nil
+}
+
+// multicamOptions holds the settings of a multicam edit.
+type multicamOptions struct {
+	// SwitchBeats is the number of beats between angle switches, 0 switches
+	// on every bar.
+	SwitchBeats int
+	// DetectOffsets replaces the offsets from the manifest by the ones found
+	// by aligning the scratch audio of each angle with the music.
+	DetectOffsets bool
+	// Slate, when set to "audio" or "video", replaces the offsets from the
+	// manifest by aligning the first clap or flash of each angle on SlateAt.
+	Slate   string
+	SlateAt float64
+}
+
+// syncMulticam generates an edit switching between the camera angles listed
+// in the anglesPath manifest on the beat.
+func syncMulticam(anglesPath string, grid beatGrid, audioPath string, outputPath string, opts multicamOptions) error {
+	if opts.SwitchBeats < 0 {
+		return fmt.Errorf("invalid number of beats between angle switches: %d", opts.SwitchBeats)
+	}
+	if opts.SwitchBeats == 0 {
+		opts.SwitchBeats = grid.Meter.BeatsPerBar()
+	}
+
+	angles, err := readAngles(anglesPath)
+	if err != nil {
+		return fmt.Errorf("failed to read camera angles: %v", err)
+	}
+
+	switch {
+	case opts.DetectOffsets && opts.Slate != "":
+		return fmt.Errorf("the angle offsets can't be both detected from the audio and from a slate")
+	case opts.DetectOffsets:
+		if audioPath == "" {
+			return fmt.Errorf("detecting the angle offsets requires an audio file")
+		}
+		if err := detectAngleOffsets(angles, audioPath); err != nil {
+			return fmt.Errorf("failed to detect the angle offsets: %v", err)
+		}
+	case opts.Slate != "":
+		if err := alignAnglesOnSlate(angles, opts.Slate, opts.SlateAt); err != nil {
+			return fmt.Errorf("failed to align the angles on their slate: %v", err)
+		}
+	}
+
+	durations := make([]float64, len(angles))
+	for i, angle := range angles {
+		durations[i], err = getVideoDuration(angle.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get duration of %s: %v", angle.Path, err)
+		}
+	}
+
+	var maxDuration float64
+	if audioPath != "" {
+		maxDuration, err = getVideoDuration(audioPath)
+		if err != nil {
+			return fmt.Errorf("failed to get audio duration: %v", err)
+		}
+	}
+
+	start, end, err := multicamRange(angles, durations, maxDuration)
+	if err != nil {
+		return err
+	}
+
+	cuts := planAngleSwitches(angles, grid, opts.SwitchBeats, start, end)
+	for i, cut := range cuts {
+		say("multicam.cut", i, cut.Start, cut.End, cut.Angle, angles[cut.Angle].Path)
+	}
+
+	return renderMulticam(angles, cuts, audioPath, outputPath)
+}
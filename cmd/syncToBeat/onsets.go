@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// onsetSampleRate is the sample rate the music is analyzed at.
+	onsetSampleRate = 22050
+	// onsetFrameSize and onsetHop are the length and the spacing, in samples,
+	// of the spectra compared by the onset detection.
+	onsetFrameSize = 1024
+	onsetHop       = 256
+	// onsetMeanWindow is the duration, in seconds, of the moving average the
+	// onsets have to rise above.
+	onsetMeanWindow = 0.5
+	// percussiveFrames and percussiveBins are the lengths of the median
+	// filters of the high quality, across time keeping the sustained notes
+	// and across frequencies keeping the hits.
+	percussiveFrames = 17
+	percussiveBins   = 17
+)
+
+// onsetSettings tune the detection of the onsets of the music, the attacks of
+// its notes and drum hits the beats are found from.
+type onsetSettings struct {
+	// Threshold is how far, relative to the strongest onset, an onset has to
+	// rise above the average of its surroundings. Lower it for soft attacks
+	// such as ambient music.
+	Threshold float64 `json:"threshold"`
+	// MinInterval is the shortest time, in seconds, between two onsets, the
+	// weaker one being dropped.
+	MinInterval float64 `json:"min_interval"`
+	// Band is the frequency band the attacks are looked for in: full, low
+	// (the kicks and bass), mid, high (the hats) or a range of Hz like
+	// 40-150.
+	Band string `json:"band"`
+	// Quality is standard, or high to separate the percussive part of the
+	// music from its sustained notes first, slower but more accurate on
+	// dense mixes.
+	Quality string `json:"quality"`
+}
+
+// Onsets are the onset detection settings.
+var Onsets = onsetSettings{Threshold: 0.1, MinInterval: 0.1, Band: "full", Quality: "standard"}
+
+// DetectBeats fits the grid to the onsets detected in the music when no
+// beats plugin is registered.
+var DetectBeats = false
+
+// onsetBands are the named frequency bands of the onset detection, in Hz.
+var onsetBands = map[string][2]float64{
+	"full": {0, onsetSampleRate / 2},
+	"low":  {20, 200},
+	"mid":  {200, 2000},
+	"high": {2000, onsetSampleRate / 2},
+}
+
+// parseOnsetBand returns the frequency range, in Hz, of a band.
+func parseOnsetBand(band string) (float64, float64, error) {
+	if r, ok := onsetBands[band]; ok {
+		return r[0], r[1], nil
+	}
+	lowStr, highStr, ok := strings.Cut(band, "-")
+	if ok {
+		low, errLow := strconv.ParseFloat(lowStr, 64)
+		high, errHigh := strconv.ParseFloat(highStr, 64)
+		if errLow == nil && errHigh == nil && low >= 0 && high > low && low < onsetSampleRate/2 {
+			return low, math.Min(high, onsetSampleRate/2), nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid onset band %q, expected full, low, mid, high or a range of Hz like 40-150", band)
+}
+
+// validateOnsets checks the onset detection settings.
+func validateOnsets(opts onsetSettings) error {
+	if opts.Threshold < 0 || opts.Threshold >= 1 {
+		return fmt.Errorf("invalid onset threshold %g, expected a fraction between 0 and 1", opts.Threshold)
+	}
+	if opts.MinInterval < 0 {
+		return fmt.Errorf("invalid minimum onset interval %gs", opts.MinInterval)
+	}
+	if opts.Quality != "standard" && opts.Quality != "high" {
+		return fmt.Errorf("invalid onset quality %q, expected standard or high", opts.Quality)
+	}
+	_, _, err := parseOnsetBand(opts.Band)
+	return err
+}
+
+// bandSpectra calls visit with the magnitude of the frequencies of the
+// samples between the first and last bins, every onsetHop samples. The
+// magnitudes are only valid during the call, the spectra are never all kept
+// in memory.
+func bandSpectra(samples []float64, first int, last int, visit func(magnitudes []float64)) {
+	window := make([]float64, onsetFrameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/onsetFrameSize)
+	}
+	frame := make([]complex128, onsetFrameSize)
+	magnitudes := make([]float64, last-first+1)
+	for start := 0; start+onsetFrameSize <= len(samples); start += onsetHop {
+		for i := range frame {
+			frame[i] = complex(samples[start+i]*window[i], 0)
+		}
+		fft(frame, false)
+		for bin := first; bin <= last; bin++ {
+			magnitudes[bin-first] = cmplx.Abs(frame[bin])
+		}
+		visit(magnitudes)
+	}
+}
+
+// bandSpectrogram returns the spectra of bandSpectra, for the high quality
+// whose median filters look at the neighboring frames.
+func bandSpectrogram(samples []float64, first int, last int) [][]float64 {
+	var spectrogram [][]float64
+	bandSpectra(samples, first, last, func(magnitudes []float64) {
+		spectrogram = append(spectrogram, append([]float64(nil), magnitudes...))
+	})
+	return spectrogram
+}
+
+// median returns the median of the values, reordering them.
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	return values[len(values)/2]
+}
+
+// percussiveMask returns how much each magnitude of the spectrogram belongs
+// to its percussive part, from 0 to 1: the hits spread over many frequencies
+// in a frame while the notes hold a frequency over many frames. A magnitude
+// is percussive when the median of its neighboring bins is higher than the
+// median of its neighboring frames.
+func percussiveMask(spectrogram [][]float64) [][]float64 {
+	mask := make([][]float64, len(spectrogram))
+	values := make([]float64, 0, max(percussiveFrames, percussiveBins))
+	for t, magnitudes := range spectrogram {
+		mask[t] = make([]float64, len(magnitudes))
+		for bin := range magnitudes {
+			values = values[:0]
+			for i := max(t-percussiveFrames/2, 0); i < min(t+percussiveFrames/2+1, len(spectrogram)); i++ {
+				values = append(values, spectrogram[i][bin])
+			}
+			notes := median(values)
+			values = values[:0]
+			for i := max(bin-percussiveBins/2, 0); i < min(bin+percussiveBins/2+1, len(magnitudes)); i++ {
+				values = append(values, magnitudes[i])
+			}
+			hits := median(values)
+			if total := notes*notes + hits*hits; total > 0 {
+				mask[t][bin] = hits * hits / total
+			}
+		}
+	}
+	return mask
+}
+
+// onsetStrength returns the spectral flux of the samples within the band of
+// the settings, every onsetHop samples, normalized so the strongest is 1: how
+// much louder each frequency of the band gets from a frame to the next. The
+// strength of a frame is timed at its center. The sustained notes are
+// filtered out first with the high quality.
+func onsetStrength(samples []float64, opts onsetSettings) ([]float64, error) {
+	low, high, err := parseOnsetBand(opts.Band)
+	if err != nil {
+		return nil, err
+	}
+	binHz := float64(onsetSampleRate) / onsetFrameSize
+	first := max(int(math.Ceil(low/binHz)), 1)
+	last := min(int(high/binHz), onsetFrameSize/2)
+
+	var strength []float64
+	previous := make([]float64, last-first+1)
+	var peak float64
+	addFrame := func(magnitudes []float64, mask []float64) {
+		var flux float64
+		for bin, magnitude := range magnitudes {
+			// the log compression keeps the loud bass from hiding the rest
+			magnitude = math.Log1p(100 * magnitude)
+			if mask != nil {
+				magnitude *= mask[bin]
+			}
+			if rise := magnitude - previous[bin]; rise > 0 {
+				flux += rise
+			}
+			previous[bin] = magnitude
+		}
+		// the first frame rises from silence
+		if len(strength) == 0 {
+			flux = 0
+		}
+		strength = append(strength, flux)
+		peak = math.Max(peak, flux)
+	}
+	if opts.Quality == "high" {
+		spectrogram := bandSpectrogram(samples, first, last)
+		mask := percussiveMask(spectrogram)
+		for t, magnitudes := range spectrogram {
+			addFrame(magnitudes, mask[t])
+		}
+	} else {
+		bandSpectra(samples, first, last, func(magnitudes []float64) {
+			addFrame(magnitudes, nil)
+		})
+	}
+	if peak > 0 {
+		for i := range strength {
+			strength[i] /= peak
+		}
+	}
+	return strength, nil
+}
+
+// pickOnsets returns the times of the peaks of the onset strength rising
+// above the average of their surroundings by the threshold, the weaker of
+// two peaks closer than the minimum interval being dropped.
+func pickOnsets(strength []float64, opts onsetSettings) []float64 {
+	frameSeconds := float64(onsetHop) / onsetSampleRate
+	half := int(onsetMeanWindow / frameSeconds / 2)
+	var onsets []float64
+	var lastStrength float64
+	for i, value := range strength {
+		if (i > 0 && strength[i-1] >= value) || (i+1 < len(strength) && strength[i+1] > value) {
+			continue
+		}
+		var mean float64
+		from, to := max(i-half, 0), min(i+half+1, len(strength))
+		for _, s := range strength[from:to] {
+			mean += s
+		}
+		mean /= float64(to - from)
+		if value < mean+opts.Threshold {
+			continue
+		}
+		t := float64(i)*frameSeconds + onsetFrameSize/2/float64(onsetSampleRate)
+		if n := len(onsets); n > 0 && t-onsets[n-1] < opts.MinInterval {
+			if value > lastStrength {
+				onsets[n-1], lastStrength = t, value
+			}
+			continue
+		}
+		onsets = append(onsets, t)
+		lastStrength = value
+	}
+	return onsets
+}
+
+// onsetEnvelope returns the onset strength of the music.
+func onsetEnvelope(audioPath string, opts onsetSettings) ([]float64, error) {
+	samples, err := decodeAudio(audioPath, onsetSampleRate, 0)
+	if err != nil {
+		return nil, err
+	}
+	return onsetStrength(samples, opts)
+}
+
+// detectOnsets returns the times of the onsets of the music.
+func detectOnsets(audioPath string, opts onsetSettings) ([]float64, error) {
+	strength, err := onsetEnvelope(audioPath, opts)
+	if err != nil {
+		return nil, err
+	}
+	return pickOnsets(strength, opts), nil
+}
+
+// detectBeats returns the onsets of the music to fit the grid to.
+func detectBeats(audioPath string) ([]float64, error) {
+	onsets, err := detectOnsets(audioPath, Onsets)
+	if err != nil {
+		return nil, err
+	}
+	if len(onsets) < 2 {
+		return nil, fmt.Errorf("%d onsets found in %s, at least 2 are needed, lower -onset-threshold or change -onset-band", len(onsets), audioPath)
+	}
+	return onsets, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// clickTrack returns seconds of samples at onsetSampleRate with a short
+// burst of noise on every beat of the tempo from offset, over a quiet
+// sustained tone.
+func clickTrack(bpm float64, offset float64, seconds float64) []float64 {
+	random := rand.New(rand.NewSource(1))
+	samples := make([]float64, int(seconds*onsetSampleRate))
+	for i := range samples {
+		samples[i] = 0.05 * math.Sin(2*math.Pi*440*float64(i)/onsetSampleRate)
+	}
+	for beat := offset; beat < seconds; beat += 60 / bpm {
+		start := int(beat * onsetSampleRate)
+		for i := start; i < min(start+onsetSampleRate/100, len(samples)); i++ {
+			samples[i] += random.Float64()*2 - 1
+		}
+	}
+	return samples
+}
+
+func TestParseOnsetBand(t *testing.T) {
+	tests := []struct {
+		band     string
+		wantLow  float64
+		wantHigh float64
+		wantErr  bool
+	}{
+		{band: "full", wantLow: 0, wantHigh: onsetSampleRate / 2},
+		{band: "low", wantLow: 20, wantHigh: 200},
+		{band: "40-150", wantLow: 40, wantHigh: 150},
+		{band: "5000-40000", wantLow: 5000, wantHigh: onsetSampleRate / 2},
+		{band: "150-40", wantErr: true},
+		{band: "20000-30000", wantErr: true},
+		{band: "-10-100", wantErr: true},
+		{band: "bass", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.band, func(t *testing.T) {
+			low, high, err := parseOnsetBand(tt.band)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseOnsetBand(%q) error = %v, wantErr %v", tt.band, err, tt.wantErr)
+			}
+			if low != tt.wantLow || high != tt.wantHigh {
+				t.Errorf("parseOnsetBand(%q) = %v, %v, want %v, %v", tt.band, low, high, tt.wantLow, tt.wantHigh)
+			}
+		})
+	}
+}
+
+func TestValidateOnsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    onsetSettings
+		wantErr bool
+	}{
+		{name: "defaults", opts: Onsets},
+		{name: "high quality", opts: onsetSettings{Threshold: 0.2, Band: "low", Quality: "high"}},
+		{name: "threshold of 1", opts: onsetSettings{Threshold: 1, Band: "full", Quality: "standard"}, wantErr: true},
+		{name: "negative interval", opts: onsetSettings{MinInterval: -1, Band: "full", Quality: "standard"}, wantErr: true},
+		{name: "unknown quality", opts: onsetSettings{Band: "full", Quality: "best"}, wantErr: true},
+		{name: "unknown band", opts: onsetSettings{Band: "bass", Quality: "standard"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateOnsets(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("validateOnsets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMedian(t *testing.T) {
+	tests := []struct {
+		values []float64
+		want   float64
+	}{
+		{values: []float64{3}, want: 3},
+		{values: []float64{3, 1, 2}, want: 2},
+		{values: []float64{4, 1, 3, 2}, want: 3},
+	}
+	for _, tt := range tests {
+		if got := median(tt.values); got != tt.want {
+			t.Errorf("median() = %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestPercussiveMask(t *testing.T) {
+	// a note holding bin 5 and a hit spreading over all the bins of frame 10
+	spectrogram := make([][]float64, 20)
+	for frame := range spectrogram {
+		spectrogram[frame] = make([]float64, 30)
+		spectrogram[frame][5] = 1
+		if frame == 10 {
+			for bin := range spectrogram[frame] {
+				spectrogram[frame][bin] = 1
+			}
+		}
+	}
+	mask := percussiveMask(spectrogram)
+	tests := []struct {
+		name  string
+		frame int
+		bin   int
+		want  float64
+	}{
+		{name: "note", frame: 3, bin: 5, want: 0},
+		{name: "hit", frame: 10, bin: 20, want: 1},
+		{name: "hit over the note", frame: 10, bin: 5, want: 0.5},
+		{name: "silence", frame: 3, bin: 20, want: 0},
+	}
+	for _, tt := range tests {
+		if got := mask[tt.frame][tt.bin]; math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("percussiveMask() of the %s = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDetectOnsets(t *testing.T) {
+	const bpm, offset = 120.0, 0.5
+	samples := clickTrack(bpm, offset, 6)
+	frameSeconds := float64(onsetHop) / onsetSampleRate
+	for _, quality := range []string{"standard", "high"} {
+		t.Run(quality, func(t *testing.T) {
+			opts := Onsets
+			opts.Quality = quality
+			strength, err := onsetStrength(samples, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := (len(samples)-onsetFrameSize)/onsetHop + 1; len(strength) != want {
+				t.Fatalf("onsetStrength() has %d frames, want %d", len(strength), want)
+			}
+			onsets := pickOnsets(strength, opts)
+			if want := int(math.Ceil((6 - offset) * bpm / 60)); len(onsets) != want {
+				t.Fatalf("pickOnsets() = %v, want %d onsets", onsets, want)
+			}
+			for i, onset := range onsets {
+				// a frame is timed at its center, the click shows up in the
+				// first frame overlapping it
+				beat := offset + float64(i)*60/bpm
+				if onset < beat-onsetFrameSize/2/float64(onsetSampleRate)-frameSeconds || onset > beat+frameSeconds {
+					t.Errorf("onset %d at %.3fs, want the beat at %.3fs", i, onset, beat)
+				}
+			}
+		})
+	}
+}
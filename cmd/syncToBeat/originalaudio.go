@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// KeepOriginalAudio adds the audio of the source video, retimed along with
+// the video, as a second audio track next to the music.
+var KeepOriginalAudio = false
+
+// atempoChain returns the atempo filters changing the tempo by factor, each
+// atempo filter being limited to a factor between 0.5 and 2.
+func atempoChain(factor float64) string {
+	var filters []string
+	for factor > 2 {
+		filters = append(filters, "atempo=2")
+		factor /= 2
+	}
+	for factor < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		factor /= 0.5
+	}
+	return strings.Join(append(filters, "atempo="+exact(factor)), ",")
+}
+
+// originalAudioFilterComplex builds the filter graph retiming the audio of
+// the source like the video segments. The retimed audio is labeled [outa].
+func originalAudioFilterComplex(segments []segment) string {
+	var parts, labels []string
+	for _, seg := range segments {
+		label := fmt.Sprintf("[a%d]", seg.Keyframe)
+		parts = append(parts, fmt.Sprintf("[0:a]atrim=start=%s:end=%s,asetpts=PTS-STARTPTS,%s%s", seconds(seg.Start), seconds(seg.End), atempoChain(seg.SpeedFactor), label))
+		labels = append(labels, label)
+	}
+	parts = append(parts, fmt.Sprintf("%sconcat=n=%d:v=0:a=1[outa]", strings.Join(labels, ""), len(labels)))
+	return strings.Join(parts, "; ")
+}
+
+// renderOriginalAudio writes the audio of the source video retimed like the
+// segments to a temporary file. It returns an empty path when the source has
+// no audio, the caller removes the file.
+func renderOriginalAudio(originalVideoPath string, segments []segment) (string, error) {
+	info, err := probe.ProbeMedia(originalVideoPath)
+	if err != nil {
+		return "", err
+	}
+	if _, ok := info.Audio(); !ok {
+		say("original_audio.missing", originalVideoPath)
+		return "", nil
+	}
+
+	file, err := os.CreateTemp("", "original-audio-*.mka")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+
+	codec := AudioEncoder.Codec
+	if AudioEncoder.Copy {
+		// the retimed audio can't be copied, it's encoded in a format any
+		// container holds
+		codec = "aac"
+	}
+	cmdArgs := []string{
+		"-y",
+		"-i", originalVideoPath,
+		"-filter_complex", originalAudioFilterComplex(segments),
+		"-map", "[outa]",
+		"-c:a", codec,
+	}
+	if AudioEncoder.Bitrate != "" {
+		cmdArgs = append(cmdArgs, "-b:a", AudioEncoder.Bitrate)
+	}
+	cmdArgs = append(cmdArgs, file.Name())
+	if err := runFFmpeg("original audio", cmdArgs); err != nil {
+		os.Remove(file.Name())
+		return "", err
+	}
+	return file.Name(), nil
+}
+
+// dualAudioArgs returns the options labeling the music and original audio
+// tracks, the music being played by default.
+func dualAudioArgs() []string {
+	return []string{
+		"-metadata:s:a:0", "title=Music",
+		"-metadata:s:a:1", "title=Original audio",
+		"-disposition:a:0", "default",
+		"-disposition:a:1", "0",
+	}
+}
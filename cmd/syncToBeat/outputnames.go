@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// outputNaming names the videos written by a run.
+type outputNaming struct {
+	// Template is expanded into the file name of each output, see
+	// outputTemplateVariables.
+	Template string
+	// Profile is the name of the preset in use, if any.
+	Profile string
+	// Overwrite replaces existing files instead of numbering the new ones.
+	Overwrite bool
+	// Path, when set, is the file the main output of the run is written to
+	// instead of a name expanded from Template.
+	Path string
+}
+
+// Output names the videos written by the run.
+var Output = outputNaming{Template: "{name}_{kind}{bpm}{ext}"}
+
+// outputTemplateVariables describes the variables of the output templates.
+var outputTemplateVariables = map[string]string{
+	"name":    "file name of the source without its extension",
+	"ext":     "extension of the source, including the dot",
+	"kind":    "kind of output: sync, pulse, phrases, stickers, bounce, shake, split, pip, debug, not_synced, sweep, montage, boomerang, cover, or the target platform (youtube, tiktok, instagram)",
+	"bpm":     "tempo of the grid, rounded",
+	"date":    "date of the run, as YYYYMMDD",
+	"profile": "name of the preset in use",
+	"seed":    "seed of the random choices",
+}
+
+// splitExtension splits a file name into its name and extension. Unlike
+// filepath.Ext, a trailing dotted part is only considered an extension when
+// it looks like one, so "take.2024.01" or ".hidden" keep their full name.
+func splitExtension(filename string) (string, string) {
+	extension := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, extension)
+	if extension == filepath.Base(filename) || len(extension) < 2 || len(extension) > 6 {
+		return filename, ""
+	}
+	hasLetter := false
+	for _, r := range extension[1:] {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return filename, ""
+		}
+		hasLetter = hasLetter || unicode.IsLetter(r)
+	}
+	if !hasLetter {
+		return filename, ""
+	}
+	return name, extension
+}
+
+// validateOutputTemplate checks that a template only uses known variables.
+func validateOutputTemplate(template string) error {
+	_, err := expandOutputTemplate(template, map[string]string{})
+	return err
+}
+
+// expandOutputTemplate replaces the {variable} placeholders of template,
+// variables missing from values expand to nothing.
+func expandOutputTemplate(template string, values map[string]string) (string, error) {
+	var expanded strings.Builder
+	rest := template
+	for {
+		start := strings.IndexByte(rest, '{')
+		if start < 0 {
+			expanded.WriteString(rest)
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("invalid output template %q: unclosed {", template)
+		}
+		variable := rest[start+1 : start+end]
+		if _, ok := outputTemplateVariables[variable]; !ok {
+			return "", fmt.Errorf("invalid output template %q: unknown variable {%s}", template, variable)
+		}
+		expanded.WriteString(rest[:start])
+		expanded.WriteString(values[variable])
+		rest = rest[start+end+1:]
+	}
+	if strings.ContainsAny(expanded.String(), `/\`) || expanded.Len() == 0 {
+		return "", fmt.Errorf("invalid output template %q: expands to %q", template, expanded.String())
+	}
+	return expanded.String(), nil
+}
+
+// path returns the path, in dir, of the output of the given kind for a source
+// with the given name and extension. A bpm of 0 leaves the {bpm} variable
+// empty. Templates without {kind} get the kind appended so the outputs of a
+// run don't collide, and existing files are numbered unless Overwrite is set.
+// With UseWorkspace, the output is versioned in the project directory instead.
+func (o outputNaming) path(dir string, name string, extension string, kind string, bpm float64) (string, error) {
+	values := map[string]string{
+		"name":    name,
+		"ext":     extension,
+		"kind":    kind,
+		"date":    time.Now().Format("20060102"),
+		"profile": o.Profile,
+		"seed":    fmt.Sprint(Seed),
+	}
+	if bpm > 0 {
+		values["bpm"] = fmt.Sprintf("%.0f", bpm)
+	}
+	template := o.Template
+	if kind != "sync" && !strings.Contains(template, "{kind}") {
+		if strings.HasSuffix(template, "{ext}") {
+			template = strings.TrimSuffix(template, "{ext}") + "_{kind}{ext}"
+		} else {
+			templateName, templateExtension := splitExtension(template)
+			template = templateName + "_{kind}" + templateExtension
+		}
+	}
+	filename, err := expandOutputTemplate(template, values)
+	if err != nil {
+		return "", err
+	}
+	if UseWorkspace {
+		name, extension := splitExtension(filename)
+		return workspacePath(outputArea(kind), name, extension)
+	}
+	outputPath := filepath.Join(dir, filename)
+	if o.Overwrite {
+		return outputPath, nil
+	}
+	return availablePath(outputPath), nil
+}
+
+// availablePath returns path when no file exists there, otherwise the first
+// free path numbered _2, _3...
+func availablePath(path string) string {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return path
+	}
+	dir := filepath.Dir(path)
+	name, extension := splitExtension(filepath.Base(path))
+	for i := 2; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s_%d%s", name, i, extension))
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// truePeakCeiling is the true peak level, in dBTP, above which the audio is
+// likely to distort once encoded and played back.
+const truePeakCeiling = -1.0
+
+var (
+	// AudioPeaks picks what happens when the audio peaks above
+	// truePeakCeiling: "warn" raises a warning, "limit" also limits the
+	// muxed audio. The audio isn't analyzed when empty.
+	AudioPeaks = "warn"
+	// limitAudio is set when the muxed audio goes through the limiter.
+	limitAudio = false
+)
+
+// measureTruePeak returns the true peak level of the audio file, in dBTP. The
+// result is cached in the store.
+func measureTruePeak(audioPath string) (float64, error) {
+	var peak float64
+	err := cachedAnalysis("true-peak", audioPath, &peak, func() (err error) {
+		peak, err = analyzeTruePeak(audioPath)
+		return err
+	})
+	return peak, err
+}
+
+// analyzeTruePeak runs the loudness analysis of measureTruePeak.
+func analyzeTruePeak(audioPath string) (float64, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return 0, fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	cmd := exec.Command(ffmpegPath,
+		"-hide_banner", "-nostats",
+		"-i", audioPath,
+		"-vn",
+		"-af", "ebur128=peak=true:framelog=quiet",
+		"-f", "null", "-",
+	)
+	// the loudness summary is logged at the end of the analysis
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("error analyzing the audio of %s: %v", audioPath, err)
+	}
+
+	// the summary ends with:
+	//   True peak:
+	//     Peak:        -0.4 dBFS
+	inTruePeak := false
+	scanner := bufio.NewScanner(&stderr)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "True peak:" {
+			inTruePeak = true
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "Peak:"); ok && inTruePeak {
+			fields := strings.Fields(value)
+			if len(fields) == 0 {
+				break
+			}
+			if fields[0] == "-inf" {
+				return 0, fmt.Errorf("the audio of %s is silent", audioPath)
+			}
+			return strconv.ParseFloat(fields[0], 64)
+		}
+	}
+	return 0, fmt.Errorf("no true peak measured in %s", audioPath)
+}
+
+// checkAudioPeaks warns when the audio peaks above truePeakCeiling and, in
+// "limit" mode, enables the limiter on the muxed audio.
+func checkAudioPeaks(audioPath string, mode string) error {
+	if mode != "warn" && mode != "limit" {
+		return fmt.Errorf("invalid audio peaks mode %q, expected warn or limit", mode)
+	}
+	peak, err := measureTruePeak(audioPath)
+	if err != nil {
+		return err
+	}
+	if peak <= truePeakCeiling {
+		return nil
+	}
+	warn(WarnAudioPeaks, audioPath, peak, truePeakCeiling)
+	limitAudio = mode == "limit"
+	return nil
+}
+
+// dbToAmplitude converts a level in decibels to a linear amplitude.
+func dbToAmplitude(db float64) float64 {
+	return math.Pow(10, db/20)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// phraseTrimOptions configures the trimming of the synced video to musical
+// phrases.
+type phraseTrimOptions struct {
+	// Bars is the length of a phrase in bars, 0 disables the trimming.
+	Bars int
+	// Fade is the duration, in seconds, of the audio fades at both ends of
+	// the trimmed video.
+	Fade float64
+}
+
+// PhraseTrim configures the trimming of the synced video.
+var PhraseTrim phraseTrimOptions
+
+// phraseBounds returns the first downbeat of the video and the end of its
+// last complete phrase.
+func phraseBounds(grid beatGrid, duration float64, phraseBars int) (float64, float64, error) {
+	beatsPerBar := float64(grid.Meter.BeatsPerBar())
+	phraseBeats := beatsPerBar * float64(phraseBars)
+	startPosition := math.Ceil(grid.BeatPosition(0)/beatsPerBar) * beatsPerBar
+	phrases := math.Floor((grid.BeatPosition(duration) - startPosition) / phraseBeats)
+	if phrases < 1 {
+		return 0, 0, fmt.Errorf("the video is shorter than a phrase of %d bars", phraseBars)
+	}
+	return grid.BeatTime(startPosition), grid.BeatTime(startPosition + phrases*phraseBeats), nil
+}
+
+// trimToPhrases trims the video so it starts on a downbeat and ends at the
+// end of a phrase instead of stopping in the middle of a bar.
+func trimToPhrases(inputVideoPath string, grid beatGrid, outputVideoPath string, opts phraseTrimOptions) error {
+	if opts.Bars < 1 {
+		return fmt.Errorf("invalid phrase length: %d bars", opts.Bars)
+	}
+	info, err := probe.ProbeMedia(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %v", err)
+	}
+	start, end, err := phraseBounds(grid, info.Duration, opts.Bars)
+	if err != nil {
+		return err
+	}
+	length := end - start
+
+	cmdArgs := []string{
+		"-y",
+		"-ss", seconds(start).timestamp(),
+		"-i", inputVideoPath,
+		"-t", seconds(length).timestamp(),
+		"-map", "0:v",
+	}
+	if _, ok := info.Audio(); ok {
+		cmdArgs = append(cmdArgs, "-map", "0:a")
+		if opts.Fade > 0 {
+			fade := math.Min(opts.Fade, length/2)
+			cmdArgs = append(cmdArgs, "-af", fmt.Sprintf("afade=t=in:st=0:d=%s,afade=t=out:st=%s:d=%s", seconds(fade), seconds(length-fade), seconds(fade)))
+		}
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("trim.start", inputVideoPath, start, end)
+	if err := runFFmpeg("phrase trim", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// pipParams are the parameters of the picture in picture effects, fractions
+// of the synced frame: x and y place the top left corner of the overlay and
+// size is its width.
+var pipParams = []string{"x", "y", "size"}
+
+// pipDefaults are the expressions of the parameters a picture in picture
+// effect doesn't set: a third of the frame in the top left corner.
+var pipDefaults = map[string]string{"x": "0.03", "y": "0.03", "size": "0.3"}
+
+// splitOverlays separates the effects compositing another video, picture in
+// picture, from the filters applied to the synced video.
+func splitOverlays(effects []CustomEffect) (filters []CustomEffect, overlays []CustomEffect) {
+	for _, effect := range effects {
+		if effect.Input != "" {
+			overlays = append(overlays, effect)
+		} else {
+			filters = append(filters, effect)
+		}
+	}
+	return filters, overlays
+}
+
+// validateOverlay checks the parameters of a picture in picture effect.
+func validateOverlay(effect CustomEffect) error {
+	if effect.Filter != "" && effect.Filter != "overlay" {
+		return fmt.Errorf("the effect overlaying %s must use the overlay filter, not %s", effect.Input, effect.Filter)
+	}
+	for name := range effect.Params {
+		if !containsString(pipParams, name) {
+			return fmt.Errorf("the effect overlaying %s has an unknown parameter %q, expected %s", effect.Input, name, strings.Join(pipParams, ", "))
+		}
+	}
+	return nil
+}
+
+// containsString reports whether the list holds the value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// pipEffects returns the effects scaling an overlay and placing it on a
+// width x height frame, their parameters converted to pixels.
+func pipEffects(overlay CustomEffect, width, height int) (CustomEffect, CustomEffect) {
+	params := map[string]string{}
+	for _, name := range pipParams {
+		params[name] = pipDefaults[name]
+		if expr, ok := overlay.Params[name]; ok {
+			params[name] = expr
+		}
+	}
+	scale := CustomEffect{
+		Filter:  "scale",
+		Options: map[string]string{"h": "-2"},
+		// yuv420 frames need even dimensions
+		Params: map[string]string{"w": fmt.Sprintf("2*floor((%s)*%d/2)", params["size"], width)},
+	}
+	place := CustomEffect{
+		Filter:  "overlay",
+		Options: overlay.Options,
+		Params: map[string]string{
+			"x": fmt.Sprintf("(%s)*%d", params["x"], width),
+			"y": fmt.Sprintf("(%s)*%d", params["y"], height),
+		},
+	}
+	return scale, place
+}
+
+// addPictureInPicture composites the videos of the overlays over the synced
+// video, their position and size following their expressions of the grid.
+func addPictureInPicture(inputVideoPath string, grid beatGrid, audioPath string, overlays []CustomEffect, outputVideoPath string) error {
+	dimensions, err := getVideoDimensions(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video dimensions: %v", err)
+	}
+	duration, err := getVideoDuration(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+
+	var effects []CustomEffect
+	for _, overlay := range overlays {
+		scale, place := pipEffects(overlay, dimensions.Width, dimensions.Height)
+		effects = append(effects, scale, place)
+	}
+	commands, options, err := evaluateEffects(effects, grid, audioPath, duration)
+	if err != nil {
+		return err
+	}
+	commandsPath, err := writeFilterCommands("pip", commands)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(commandsPath)
+
+	// the effects come in pairs: the scale of the overlay, then its placement
+	filterComplexParts := []string{fmt.Sprintf("[0:v]sendcmd=f=%s[base0]", escapeFilterText(commandsPath))}
+	for i := range overlays {
+		scale, place := 2*i, 2*i+1
+		filterComplexParts = append(filterComplexParts,
+			fmt.Sprintf("[%d:v]setpts=PTS-STARTPTS,%s[pip%d]", i+1, effectFilter(effects[scale], scale, options[scale]), i),
+			fmt.Sprintf("[base%d][pip%d]%s[base%d]", i, i, effectFilter(effects[place], place, options[place]), i+1),
+		)
+	}
+	filterComplexParts = append(filterComplexParts, fmt.Sprintf("[base%d]setsar=1[output]", len(overlays)))
+
+	cmdArgs := []string{"-y", "-i", inputVideoPath}
+	for _, overlay := range overlays {
+		cmdArgs = append(cmdArgs, "-i", overlay.Input)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", strings.Join(filterComplexParts, "; "),
+		"-map", "[output]",
+		"-map", "0:a?",
+		"-c:a", "copy",
+		"-t", seconds(duration).timestamp(),
+	)
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("pip.start", len(overlays), inputVideoPath)
+	if err := runFFmpeg("pip", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/render"
+)
+
+// the planner lives in the plan package, shared with the programs embedding
+// the sync.
+type (
+	segment    = plan.Segment
+	planReason = plan.Reason
+	syncScore  = plan.SyncScore
+)
+
+// SpeedLimit bounds the speed factor of the segments, 0 means no limit. A
+// keyframe whose nearest target would need a faster (or slower) segment lands
+// on a neighboring beat instead when one fits.
+var SpeedLimit = 0.0
+
+// Target is the length of the synced edit.
+var Target plan.Target
+
+// planWarnings are the warnings raised for the notices of the planner.
+var planWarnings = map[string]string{
+	plan.ZeroDuration: WarnZeroDurationSegment,
+	plan.OutOfOrder:   WarnKeyframesOutOfOrder,
+	plan.SpeedClamp:   WarnSpeedClamp,
+	plan.ExtremeSpeed: WarnExtremeSpeed,
+}
+
+// reportPlanNotice reports a notice of the planner.
+func reportPlanNotice(n plan.Notice) {
+	if n.ID == plan.SkippedFirst {
+		say("plan.skip_first")
+		return
+	}
+	warn(planWarnings[n.ID], n.Args...)
+}
+
+// planSegments splits the video at each keyframe and computes the speed
+// factor needed for every keyframe to land on its nearest beat, following
+// the planner settings.
+func planSegments(grid beatGrid, keyframes []Keyframe) ([]segment, error) {
+	return planFrames(grid, keyframes, probe.Rational{})
+}
+
+// planFrames is planSegments counting the plan in frames of the frame rate,
+// when known, for the render to be frame exact.
+func planFrames(grid beatGrid, keyframes []Keyframe, frameRate probe.Rational) ([]segment, error) {
+	return plan.Plan(grid, keyframes, plan.Options{
+		SpeedLimit: SpeedLimit,
+		Target:     Target,
+		Strict:     Strict,
+		FrameRate:  frameRate,
+		Notify:     reportPlanNotice,
+	})
+}
+
+// KeyframesPastEnd picks what happens to the keyframes after the end of the
+// video: "warn" keeps them, "clamp" moves them to the end and "drop" removes
+// them.
+var KeyframesPastEnd = "warn"
+
+// KeyframeScale rescales the keyframe times, for keyframes authored against
+// a proxy of a different length: "fit" scales them so the last keyframe
+// lands on the end of the video when it's past it, a number multiplies the
+// times. Empty keeps the times as is.
+var KeyframeScale = ""
+
+// validateKeyframeFit checks the KeyframesPastEnd and KeyframeScale settings.
+func validateKeyframeFit() error {
+	switch KeyframesPastEnd {
+	case "warn", "clamp", "drop":
+	default:
+		return fmt.Errorf("invalid keyframes past end mode %q, expected warn, clamp or drop", KeyframesPastEnd)
+	}
+	if KeyframeScale == "" || KeyframeScale == "fit" {
+		return nil
+	}
+	if factor, err := strconv.ParseFloat(KeyframeScale, 64); err != nil || factor <= 0 {
+		return fmt.Errorf("invalid keyframe scale %q, expected fit or a positive factor", KeyframeScale)
+	}
+	return nil
+}
+
+// fitKeyframes rescales the keyframes following KeyframeScale, then clamps
+// or drops the ones still after the end of the video following
+// KeyframesPastEnd.
+func fitKeyframes(keyframes []Keyframe, duration float64) []Keyframe {
+	var last float64
+	for _, kf := range keyframes {
+		last = math.Max(last, kf.Time)
+	}
+	factor := 1.0
+	switch KeyframeScale {
+	case "":
+	case "fit":
+		if last > duration {
+			factor = duration / last
+		}
+	default:
+		factor, _ = strconv.ParseFloat(KeyframeScale, 64)
+	}
+	if factor != 1 {
+		say("keyframes.scaled", len(keyframes), factor)
+	}
+
+	fitted := make([]Keyframe, 0, len(keyframes))
+	for i, kf := range keyframes {
+		kf.Time *= factor
+		if kf.Time > duration {
+			switch KeyframesPastEnd {
+			case "clamp":
+				say("keyframes.clamped", i, kf.Time, duration)
+				kf.Time = duration
+			case "drop":
+				warn(WarnKeyframeBeyondDuration, i, kf.Time, duration)
+				continue
+			}
+		}
+		fitted = append(fitted, kf)
+	}
+	if dropped := len(keyframes) - len(fitted); dropped > 0 {
+		say("keyframes.dropped", dropped)
+	}
+	return fitted
+}
+
+// checkKeyframesDuration warns about the keyframes after the end of the
+// video, they are errors in strict mode.
+func checkKeyframesDuration(keyframes []Keyframe, duration float64) error {
+	for i, kf := range keyframes {
+		if kf.Time > duration {
+			if Strict {
+				return plan.StrictError(i, kf, "is beyond the end of the video at %s, clamp or drop it with -keyframes-past-end", seconds(duration).timestamp())
+			}
+			warn(WarnKeyframeBeyondDuration, i, kf.Time, duration)
+		}
+	}
+	return nil
+}
+
+// printPlanReport prints where each keyframe lands on the grid, along with the
+// frames of the cut before and after the sync when fps is known, and why it
+// lands there with Explain.
+func printPlanReport(grid beatGrid, keyframes []Keyframe, segments []segment, fps float64) {
+	for _, seg := range segments {
+		kf := keyframes[seg.Keyframe]
+		targetBeatPosition := roundToBeat(grid.BeatPosition(seg.NearestBeatTime))
+		var sectionLabel string
+		if section, ok := grid.SectionAt(seg.NearestBeatTime); ok {
+			sectionLabel = fmt.Sprintf(" [%s @ %.2f BPM]", section.Label, section.BPM)
+		}
+		bar, beat := grid.Meter.BarAndBeat(targetBeatPosition)
+		say("plan.keyframe", seg.Keyframe, kf.Time, grid.BeatPosition(kf.Time), seg.NearestBeatTime, targetBeatPosition, bar, beat, seg.SpeedFactor, sectionLabel)
+		if fps > 0 {
+			say("plan.keyframe_frames", int(math.Round(kf.Time*fps)), int(math.Round(seg.NearestBeatTime*fps)), fps)
+		}
+		if Explain {
+			for _, reason := range seg.Reasons {
+				say(reason.ID, append([]any{seg.Keyframe}, reason.Args...)...)
+			}
+		}
+	}
+	score := plan.Score(grid, keyframes, segments)
+	say("plan.score", score.Score, score.Residual, score.SpeedVariance)
+}
+
+// speedFilterComplex builds the filter graph retiming the video segments.
+// The retimed video is labeled [outv].
+func speedFilterComplex(segments []segment) string {
+	// the source is deinterlaced before being retimed and its frames are
+	// tagged with its colors for the effects
+	var tags []string
+	if deinterlaceFilter != "" {
+		tags = append(tags, deinterlaceFilter)
+	}
+	if colors := colorTagFilter(SourceColor); colors != "" {
+		tags = append(tags, colors)
+	}
+	filterComplex := render.SpeedFilterComplex(segments, strings.Join(tags, ","))
+	// on stderr, stdout carries the JSON events with -json
+	if Debug {
+		log.Println("Filter graph:", filterComplex)
+	}
+	return filterComplex
+}
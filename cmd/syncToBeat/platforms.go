@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// platformTarget holds the delivery specs of a video platform.
+type platformTarget struct {
+	Name        string
+	Description string
+	// Width and Height are the frame size, the video is letterboxed to keep
+	// its aspect ratio.
+	Width  int
+	Height int
+	FPS    int
+	// VideoBitrate caps the bitrate of the video, the platforms reencode
+	// bigger uploads.
+	VideoBitrate string
+	// Loudness is the integrated loudness, in LUFS, the audio is normalized
+	// to.
+	Loudness float64
+	// Flags are the encoder and container flags adjusted for the platform
+	// when they aren't set explicitly.
+	Flags map[string]string
+}
+
+// platformTargets are the platforms the synced video can be exported for.
+var platformTargets = []platformTarget{
+	{
+		Name:         "youtube",
+		Description:  "1080p landscape at 30 fps",
+		Width:        1920,
+		Height:       1080,
+		FPS:          30,
+		VideoBitrate: "12M",
+		Loudness:     -14,
+		Flags:        map[string]string{"container": "mp4", "codec": "libx264", "audio-codec": "aac", "audio-bitrate": "384k", "audio-rate": "48000", "audio-channels": "2"},
+	},
+	{
+		Name:         "tiktok",
+		Description:  "1080x1920 portrait at 30 fps",
+		Width:        1080,
+		Height:       1920,
+		FPS:          30,
+		VideoBitrate: "6M",
+		Loudness:     -14,
+		Flags:        map[string]string{"container": "mp4", "codec": "libx264", "audio-codec": "aac", "audio-bitrate": "128k", "audio-rate": "44100", "audio-channels": "2"},
+	},
+	{
+		Name:         "instagram",
+		Description:  "1080x1920 reel at 30 fps",
+		Width:        1080,
+		Height:       1920,
+		FPS:          30,
+		VideoBitrate: "5M",
+		Loudness:     -14,
+		Flags:        map[string]string{"container": "mp4", "codec": "libx264", "audio-codec": "aac", "audio-bitrate": "128k", "audio-rate": "44100", "audio-channels": "2"},
+	},
+}
+
+// Platform is the platform the synced video is exported for, the zero value
+// skips the export.
+var Platform platformTarget
+
+// findPlatform returns the named platform target.
+func findPlatform(name string) (platformTarget, error) {
+	var names []string
+	for _, target := range platformTargets {
+		if target.Name == name {
+			return target, nil
+		}
+		names = append(names, target.Name)
+	}
+	return platformTarget{}, fmt.Errorf("unknown target %q, expected %s", name, strings.Join(names, ", "))
+}
+
+// applyPlatformDefaults adjusts the encoder and container flags that weren't
+// set explicitly to the platform.
+func applyPlatformDefaults(target platformTarget, explicit map[string]bool) error {
+	for _, name := range sortedKeys(target.Flags) {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, target.Flags[name]); err != nil {
+			return fmt.Errorf("target %s: invalid value for -%s: %v", target.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// platformFilter returns the video filter fitting the frames to the size and
+// frame rate of the platform.
+func platformFilter(target platformTarget) string {
+	return fmt.Sprintf(
+		"scale=%d:%d:force_original_aspect_ratio=decrease,pad=%[1]d:%[2]d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%d,format=yuv420p",
+		target.Width, target.Height, target.FPS,
+	)
+}
+
+// exportForPlatform encodes the video to the specs of the platform, with its
+// audio, if any, normalized to the loudness the platform plays at.
+func exportForPlatform(inputVideoPath string, hasAudio bool, outputVideoPath string, target platformTarget) error {
+	cmdArgs := []string{
+		"-y",
+		"-i", inputVideoPath,
+		"-vf", platformFilter(target),
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	if target.VideoBitrate != "" {
+		cmdArgs = append(cmdArgs, "-maxrate", target.VideoBitrate, "-bufsize", target.VideoBitrate)
+	}
+	if hasAudio {
+		cmdArgs = append(cmdArgs, "-af", fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=11", target.Loudness, truePeakCeiling))
+		cmdArgs = append(cmdArgs, audioEncodeArgs()...)
+	}
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("target.start", target.Name, target.Width, target.Height, target.FPS)
+	if err := runFFmpeg("target", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("target.saved", outputVideoPath)
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// previewHeight is the height the video is scaled down to when streamed to
+// ffplay, keeping the pipe bandwidth reasonable.
+const previewHeight = 360
+
+// playFilterComplex renders the [outv] output of the filter graph applied to
+// the inputs and streams it to ffplay instead of writing a file. When audioPath
+// is set, the audio is added as the last input and played along. Only the
+// section between from and to (in seconds) of the output is played, a to of
+// 0 plays until the end.
+func playFilterComplex(inputs []string, filterComplex string, audioPath string, from, to float64) error {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+	ffplayPath, err := checkFFplayAvailable()
+	if err != nil {
+		return fmt.Errorf("ffplay is not available: %v", err)
+	}
+
+	cmdArgs := []string{"-v", "error"}
+	for _, input := range inputs {
+		cmdArgs = append(cmdArgs, "-i", input)
+	}
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", filterComplex+fmt.Sprintf("; [outv]scale=-2:'min(%d,ih)'%s[preview]", previewHeight, videoOffsetFilter()),
+		"-map", "[preview]",
+	)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("%d:a", len(inputs)), "-c:a", "pcm_s16le", "-shortest")
+	} else {
+		cmdArgs = append(cmdArgs, "-an")
+	}
+	if from > 0 {
+		cmdArgs = append(cmdArgs, "-ss", seconds(from).timestamp())
+	}
+	if to > 0 {
+		cmdArgs = append(cmdArgs, "-to", seconds(to).timestamp())
+	}
+	// raw frames are cheap to produce, which is what matters for playback
+	cmdArgs = append(cmdArgs,
+		"-c:v", "rawvideo",
+		"-pix_fmt", "yuv420p",
+		"-f", "nut",
+		"-",
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	ffmpegCmd := exec.Command(ffmpegPath, cmdArgs...)
+	ffmpegCmd.Stderr = os.Stderr
+	ffplayCmd := exec.Command(ffplayPath, "-v", "error", "-autoexit", "-window_title", "syncToBeat preview", "-")
+	ffplayCmd.Stdin, err = ffmpegCmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to pipe ffmpeg into ffplay: %v", err)
+	}
+	ffplayCmd.Stderr = os.Stderr
+
+	say("play.start")
+	if err := ffmpegCmd.Start(); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	if err := ffplayCmd.Run(); err != nil {
+		ffmpegCmd.Process.Kill()
+		ffmpegCmd.Wait()
+		return fmt.Errorf("error running ffplay: %v", err)
+	}
+
+	// ffplay might have been closed before the end of the stream
+	ffmpegCmd.Process.Kill()
+	ffmpegCmd.Wait()
+
+	return nil
+}
+
+// playSynced plays the beat synced video without writing it to disk, see
+// playFilterComplex for the meaning of from and to.
+func playSynced(grid beatGrid, originalVideoPath string, audioPath string, keyframes []Keyframe, from, to float64) error {
+	segments, err := planSegments(grid, keyframes)
+	if err != nil {
+		return err
+	}
+	return playFilterComplex([]string{originalVideoPath}, speedFilterComplex(segments), audioPath, from, to)
+}
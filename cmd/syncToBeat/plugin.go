@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+)
+
+// pluginProtocolVersion is the version of the JSON messages exchanged with
+// the plugins, bumped on breaking changes.
+const pluginProtocolVersion = 1
+
+// PluginTimeout bounds how long a plugin can run.
+var PluginTimeout = 2 * time.Minute
+
+// Plugin is an external executable extending the pipeline. Plugins receive a
+// pluginRequest as JSON on stdin and write a pluginResponse as JSON on
+// stdout.
+type Plugin struct {
+	Name string `json:"name"`
+	// Kind is the step the plugin takes part in: "effect" plugins return a
+	// filter chain applied to the synced video, "beats" plugins return the
+	// beats of the music the grid is built from and "upscale" plugins write
+	// the video upscaled to the requested size to the output path.
+	Kind    string   `json:"kind"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Plugins are the registered plugins, in the order they run.
+var Plugins []Plugin
+
+// pluginGrid describes the beat grid to the plugins.
+type pluginGrid struct {
+	BPM      float64       `json:"bpm"`
+	Offset   float64       `json:"offset"`
+	Meter    string        `json:"meter"`
+	Sections []GridSection `json:"sections,omitempty"`
+	// BeatTimes are the times of the beats when the grid follows them.
+	BeatTimes []float64 `json:"beat_times,omitempty"`
+}
+
+// pluginRequest is the message sent to the plugins.
+type pluginRequest struct {
+	Version   int        `json:"version"`
+	Kind      string     `json:"kind"`
+	Grid      pluginGrid `json:"grid"`
+	Video     string     `json:"video,omitempty"`
+	Audio     string     `json:"audio,omitempty"`
+	Keyframes []Keyframe `json:"keyframes,omitempty"`
+	// Cuts are the times, in the synced video, of the cuts between the
+	// segments.
+	Cuts []float64 `json:"cuts,omitempty"`
+	// Duration is the duration of the synced video.
+	Duration float64 `json:"duration,omitempty"`
+	// Width and Height are the size upscale plugins upscale the video to,
+	// and Output the path they write it to.
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+	Output string `json:"output,omitempty"`
+}
+
+// pluginEvent is a moment reported by a plugin.
+type pluginEvent struct {
+	Time  float64 `json:"time"`
+	Label string  `json:"label,omitempty"`
+}
+
+// pluginResponse is the message returned by the plugins.
+type pluginResponse struct {
+	// Filter is the filter chain returned by effect plugins, a comma
+	// separated list of filters without pad labels.
+	Filter string `json:"filter,omitempty"`
+	// Events are the moments returned by analysis plugins.
+	Events []pluginEvent `json:"events,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// readPlugins reads the plugins manifest from a JSON file.
+func readPlugins(filePath string) ([]Plugin, error) {
+	var plugins []Plugin
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &plugins); err != nil {
+		return nil, err
+	}
+	for _, plugin := range plugins {
+		if plugin.Command == "" {
+			return nil, fmt.Errorf("plugin %q has no command", plugin.Name)
+		}
+		switch plugin.Kind {
+		case "effect", "beats", "upscale":
+		default:
+			return nil, fmt.Errorf("plugin %q has an invalid kind %q, expected effect, beats or upscale", plugin.Name, plugin.Kind)
+		}
+	}
+	return plugins, nil
+}
+
+// newPluginRequest describes the grid and the media to a plugin of the
+// given kind.
+func newPluginRequest(kind string, grid beatGrid, videoPath, audioPath string) pluginRequest {
+	return pluginRequest{
+		Version: pluginProtocolVersion,
+		Kind:    kind,
+		Grid: pluginGrid{
+			BPM:       grid.BPM,
+			Offset:    grid.Offset,
+			Meter:     grid.Meter.String(),
+			Sections:  grid.Sections,
+			BeatTimes: grid.BeatTimes,
+		},
+		Video: videoPath,
+		Audio: audioPath,
+	}
+}
+
+// runPlugin sends the request to the plugin and decodes its response.
+func runPlugin(plugin Plugin, request pluginRequest) (pluginResponse, error) {
+	var response pluginResponse
+	input, err := json.Marshal(request)
+	if err != nil {
+		return response, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), PluginTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, plugin.Command, plugin.Args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	if Debug {
+		cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	say("plugin.run", plugin.Name)
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return response, fmt.Errorf("plugin %s timed out after %s", plugin.Name, PluginTimeout)
+		}
+		return response, fmt.Errorf("plugin %s failed: %v\n%s", plugin.Name, err, stderr.String())
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return response, fmt.Errorf("plugin %s returned an invalid response: %v", plugin.Name, err)
+	}
+	if response.Error != "" {
+		return response, fmt.Errorf("plugin %s: %s", plugin.Name, response.Error)
+	}
+	return response, nil
+}
+
+// pluginEffects runs the effect plugins and returns their filter chains.
+func pluginEffects(grid beatGrid, videoPath, audioPath string, keyframes []Keyframe, segments []segment) ([]string, error) {
+	var filters []string
+	for _, plugin := range Plugins {
+		if plugin.Kind != "effect" {
+			continue
+		}
+		request := newPluginRequest(plugin.Kind, grid, videoPath, audioPath)
+		request.Keyframes = keyframes
+		for _, seg := range segments[:len(segments)-1] {
+			request.Cuts = append(request.Cuts, seg.NearestBeatTime)
+		}
+		request.Duration = segments[len(segments)-1].NearestBeatTime
+		response, err := runPlugin(plugin, request)
+		if err != nil {
+			return nil, err
+		}
+		if response.Filter != "" {
+			filters = append(filters, response.Filter)
+		}
+	}
+	return filters, nil
+}
+
+// pluginBeats runs the first beats plugin and returns the beats it found in
+// the audio, or nil when no beats plugin is registered.
+func pluginBeats(grid beatGrid, audioPath string) ([]float64, error) {
+	for _, plugin := range Plugins {
+		if plugin.Kind != "beats" {
+			continue
+		}
+		response, err := runPlugin(plugin, newPluginRequest(plugin.Kind, grid, "", audioPath))
+		if err != nil {
+			return nil, err
+		}
+		var beats []float64
+		for _, event := range response.Events {
+			beats = append(beats, event.Time)
+		}
+		sort.Float64s(beats)
+		if len(beats) < 2 {
+			return nil, fmt.Errorf("plugin %s found %d beats, at least 2 are needed", plugin.Name, len(beats))
+		}
+		return beats, nil
+	}
+	return nil, nil
+}
+
+// fitGridToBeats sets the tempo and the first beat of the grid from a list of
+// detected beats, using the median interval so a few missed or extra beats
+// don't skew the tempo.
+func fitGridToBeats(grid beatGrid, beats []float64) beatGrid {
+	intervals := make([]float64, 0, len(beats)-1)
+	for i := 1; i < len(beats); i++ {
+		intervals = append(intervals, beats[i]-beats[i-1])
+	}
+	sort.Float64s(intervals)
+	median := intervals[len(intervals)/2]
+	if median > 0 {
+		grid.BPM = 60 / median
+	}
+	grid.Offset = beats[0]
+	return grid
+}
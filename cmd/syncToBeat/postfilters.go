@@ -0,0 +1,54 @@
+package main
+
+import "fmt"
+
+// postFilterOptions holds the cleanup filters applied to the retimed video,
+// slowed down segments otherwise look mushy.
+type postFilterOptions struct {
+	// Denoise is the denoiser: hqdn3d (fast) or nlmeans (slow, better at
+	// keeping details), empty disables it.
+	Denoise string
+	// DenoiseStrength scales the default strength of the denoiser.
+	DenoiseStrength float64
+	// Sharpen is the amount of luma sharpening, 0 disables it.
+	Sharpen float64
+}
+
+// PostFilters are the cleanup filters of the synced video.
+var PostFilters = postFilterOptions{DenoiseStrength: 1}
+
+// validatePostFilters checks the cleanup filter settings.
+func validatePostFilters(opts postFilterOptions) error {
+	switch opts.Denoise {
+	case "", "hqdn3d", "nlmeans":
+	default:
+		return fmt.Errorf("invalid denoiser %q, expected hqdn3d or nlmeans", opts.Denoise)
+	}
+	if opts.DenoiseStrength <= 0 {
+		return fmt.Errorf("invalid denoise strength %.2f, expected a positive factor", opts.DenoiseStrength)
+	}
+	if opts.Sharpen < 0 || opts.Sharpen > 1.5 {
+		return fmt.Errorf("invalid sharpen amount %.2f, expected a value between 0 and 1.5", opts.Sharpen)
+	}
+	return nil
+}
+
+// postFilter returns the filter chain denoising then sharpening the frames,
+// empty when both are disabled.
+func postFilter(opts postFilterOptions) string {
+	var filter string
+	switch opts.Denoise {
+	case "hqdn3d":
+		s := opts.DenoiseStrength
+		filter = fmt.Sprintf("hqdn3d=%g:%g:%g:%g", 4*s, 3*s, 6*s, 4.5*s)
+	case "nlmeans":
+		filter = fmt.Sprintf("nlmeans=s=%g", 3*opts.DenoiseStrength)
+	}
+	if opts.Sharpen > 0 {
+		if filter != "" {
+			filter += ","
+		}
+		filter += fmt.Sprintf("unsharp=5:5:%g:5:5:0", opts.Sharpen)
+	}
+	return filter
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Preset bundles settings under a name so they can be shared and reused.
+type Preset struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Flags maps command line flags, without the leading dash, to their
+	// values. Flags set on the command line take precedence.
+	Flags map[string]string `json:"flags"`
+	// Effects are the custom effects of the preset, used unless -effects is
+	// set.
+	Effects []CustomEffect `json:"effects,omitempty"`
+}
+
+// builtinPresets is the gallery of presets shipping with the tool.
+var builtinPresets = []Preset{
+	{
+		Name:        "clean",
+		Description: "no effects, high quality encode",
+		Flags:       map[string]string{"crf": "18", "encoder-preset": "slow"},
+	},
+	{
+		Name:        "crisp",
+		Description: "denoised and sharpened, for slowed down or low light footage",
+		Flags:       map[string]string{"denoise": "hqdn3d", "sharpen": "0.5", "crf": "18"},
+	},
+	{
+		Name:        "punchy",
+		Description: "camera shake on the downbeats, glitchy cuts and a vignette pulse",
+		Flags: map[string]string{
+			"shake":            "0.015",
+			"shake-on":         "downbeats",
+			"cut-transition":   "glitch",
+			"transition-beats": "0.5",
+			"vignette-pulse":   "0.3",
+			"pulse-envelope":   "decay",
+		},
+	},
+	{
+		Name:        "dreamy",
+		Description: "slow hue rotation every two bars, gentle speed wobble and letterbox swell",
+		Flags: map[string]string{
+			"color-cycle":     "hue",
+			"color-every":     "2",
+			"hue-step":        "30",
+			"wobble":          "0.1",
+			"letterbox-pulse": "0.05",
+			"pulse-envelope":  "sine",
+		},
+	},
+	{
+		Name:        "bars",
+		Description: "keyframes snapped to the downbeats with warm and cool tints alternating every bar",
+		Flags: map[string]string{
+			"snap-to":     "bar",
+			"color-cycle": "tints",
+			"tints":       "warm,cool",
+		},
+	},
+}
+
+// userPresetsDir returns the folder where the imported presets are stored.
+func userPresetsDir() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "syncToBeat", "presets"), nil
+}
+
+// readPreset reads a preset from a JSON file and checks its flags.
+func readPreset(filePath string) (Preset, error) {
+	var preset Preset
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return preset, err
+	}
+	if err := json.Unmarshal(fileBytes, &preset); err != nil {
+		return preset, fmt.Errorf("invalid preset %s: %v", filePath, err)
+	}
+	if preset.Name == "" {
+		return preset, fmt.Errorf("the preset in %s has no name", filePath)
+	}
+	for name := range preset.Flags {
+		if name == "preset" || flag.Lookup(name) == nil {
+			return preset, fmt.Errorf("preset %s sets an unknown flag %q", preset.Name, name)
+		}
+	}
+	return preset, nil
+}
+
+// listPresets returns the built-in and imported presets sorted by name, the
+// imported presets replace the built-in ones with the same name.
+func listPresets() ([]Preset, error) {
+	presets := map[string]Preset{}
+	for _, preset := range builtinPresets {
+		presets[preset.Name] = preset
+	}
+
+	dir, err := userPresetsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		preset, err := readPreset(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		presets[preset.Name] = preset
+	}
+
+	var list []Preset
+	for _, preset := range presets {
+		list = append(list, preset)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
+
+// findPreset returns the named preset.
+func findPreset(name string) (Preset, error) {
+	presets, err := listPresets()
+	if err != nil {
+		return Preset{}, err
+	}
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset, nil
+		}
+	}
+	return Preset{}, fmt.Errorf("unknown preset %q, run the preset list command to see the available presets", name)
+}
+
+// setFlags returns the names of the flags set on the command line, or with
+// flag.Set since.
+func setFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	return explicit
+}
+
+// flagValues returns the current value of every flag.
+func flagValues() map[string]string {
+	values := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) { values[f.Name] = f.Value.String() })
+	return values
+}
+
+// restoreFlags sets the flags back to values returned by flagValues.
+func restoreFlags(values map[string]string) error {
+	for _, name := range sortedKeys(values) {
+		if flag.Lookup(name).Value.String() == values[name] {
+			continue
+		}
+		if err := flag.Set(name, values[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyPreset sets the flags of the preset that aren't explicitly set, nil
+// uses the flags set on the command line. It must be called after the flags
+// are parsed.
+func applyPreset(preset Preset, explicit map[string]bool) error {
+	if explicit == nil {
+		explicit = setFlags()
+	}
+	for _, name := range sortedKeys(preset.Flags) {
+		if explicit[name] {
+			continue
+		}
+		if err := flag.Set(name, preset.Flags[name]); err != nil {
+			return fmt.Errorf("preset %s: invalid value for -%s: %v", preset.Name, name, err)
+		}
+	}
+	if len(CustomEffects) == 0 && !explicit["effects"] {
+		CustomEffects = preset.Effects
+	}
+	return nil
+}
+
+const presetUsage = `Usage:
+  <program> preset list                  list the available presets
+  <program> preset show name             print a preset
+  <program> preset export name [file]    write a preset to a file (stdout by default)
+  <program> preset import file           add a preset to the gallery`
+
+// runPresetCommand runs the preset subcommands.
+func runPresetCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.preset")
+		return nil
+	}
+
+	switch args[0] {
+	case "list":
+		presets, err := listPresets()
+		if err != nil {
+			return err
+		}
+		for _, preset := range presets {
+			say("preset.entry", preset.Name, preset.Description)
+		}
+		return nil
+	case "show", "export":
+		if len(args) < 2 {
+			say("usage.preset")
+			return nil
+		}
+		preset, err := findPreset(args[1])
+		if err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(preset, "", "  ")
+		if err != nil {
+			return err
+		}
+		if args[0] == "show" || len(args) < 3 {
+			fmt.Println(string(data))
+			return nil
+		}
+		if err := os.WriteFile(args[2], append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		say("preset.exported", preset.Name, args[2])
+		return nil
+	case "import":
+		if len(args) < 2 {
+			say("usage.preset")
+			return nil
+		}
+		preset, err := readPreset(args[1])
+		if err != nil {
+			return err
+		}
+		if strings.ContainsAny(preset.Name, `/\`) {
+			return fmt.Errorf("invalid preset name %q", preset.Name)
+		}
+		dir, err := userPresetsDir()
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(preset, "", "  ")
+		if err != nil {
+			return err
+		}
+		presetPath := filepath.Join(dir, preset.Name+".json")
+		if err := os.WriteFile(presetPath, append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		say("preset.imported", preset.Name, presetPath)
+		return nil
+	}
+	say("usage.preset")
+	return fmt.Errorf("unknown preset command %q", args[0])
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/rand"
+)
+
+// Seed seeds every random choice of the run (sticker positions, camera shake,
+// angle picks...), rendering again with the same seed and settings gives the
+// same video.
+var Seed int64 = 1
+
+// newRand returns the random source of a feature. Each feature draws from its
+// own sequence derived from Seed and its name, so that a feature making more
+// random choices doesn't change the choices of the others.
+func newRand(feature string) *rand.Rand {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	return rand.New(rand.NewSource(Seed ^ int64(h.Sum64())))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ReaperProject exports a Reaper project of the synced video and the music,
+// with markers on the bars of the grid and on the keyframes, for the sound
+// design to go on in Reaper.
+var ReaperProject = false
+
+// reaperSourceTypes are the Reaper sources of the audio files by extension,
+// the other files are read as video sources.
+var reaperSourceTypes = map[string]string{
+	".wav":  "WAVE",
+	".aif":  "WAVE",
+	".aiff": "WAVE",
+	".mp3":  "MP3",
+	".flac": "FLAC",
+	".ogg":  "VORBIS",
+}
+
+// rppString quotes a string for a Reaper project, which has no escapes but
+// quotes with ", ' or ` whichever the string doesn't contain.
+func rppString(value string) string {
+	for _, quote := range []string{`"`, `'`, "`"} {
+		if !strings.Contains(value, quote) {
+			return quote + value + quote
+		}
+	}
+	return `"` + strings.ReplaceAll(value, `"`, "'") + `"`
+}
+
+// writeRPPTrack writes a track holding a single media item from the start
+// of the project.
+func writeRPPTrack(b *strings.Builder, name string, mediaPath string, duration float64) error {
+	abs, err := filepath.Abs(mediaPath)
+	if err != nil {
+		return err
+	}
+	source, ok := reaperSourceTypes[strings.ToLower(filepath.Ext(mediaPath))]
+	if !ok {
+		source = "VIDEO"
+	}
+	fmt.Fprintf(b, "  <TRACK\n    NAME %s\n", rppString(name))
+	fmt.Fprintf(b, "    <ITEM\n      POSITION 0\n      LENGTH %.6f\n      NAME %s\n", duration, rppString(filepath.Base(mediaPath)))
+	fmt.Fprintf(b, "      <SOURCE %s\n        FILE %s\n      >\n    >\n  >\n", source, rppString(abs))
+	return nil
+}
+
+// writeReaperProject writes a Reaper project of the synced video and the
+// music, if any, with the tempo map of the grid and markers on its bars and
+// on the keyframes where they land.
+func writeReaperProject(grid beatGrid, keyframes []Keyframe, segments []segment, videoPath string, audioPath string, outputPath string) error {
+	videoDuration, err := getVideoDuration(videoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get the duration of %s: %v", videoPath, err)
+	}
+	duration := videoDuration
+	var audioDuration float64
+	if audioPath != "" {
+		if audioDuration, err = getVideoDuration(audioPath); err != nil {
+			return fmt.Errorf("failed to get the duration of %s: %v", audioPath, err)
+		}
+		duration = math.Max(duration, audioDuration)
+	}
+
+	m := grid.Meter.OrDefault()
+	var b strings.Builder
+	b.WriteString("<REAPER_PROJECT 0.1 \"6.0\" 0\n")
+	fmt.Fprintf(&b, "  TEMPO %g %d %d\n", grid.BPM, m.BeatsPerBar(), m.Unit)
+	if spans := grid.Spans(); len(spans) > 1 {
+		// square points, the tempo jumps at the sections and the beats like
+		// the grid does
+		b.WriteString("  <TEMPOENVEX\n    ACT 1 -1\n")
+		for _, span := range spans {
+			fmt.Fprintf(&b, "    PT %.6f %g 1\n", span.Start, span.BPM)
+		}
+		b.WriteString("  >\n")
+	}
+
+	marker := 1
+	beatsPerBar := float64(m.BeatsPerBar())
+	for _, beat := range grid.Beats(duration) {
+		if beat.Downbeat {
+			fmt.Fprintf(&b, "  MARKER %d %.6f %s 0\n", marker, beat.Time, rppString(fmt.Sprintf("Bar %d", int(beat.Position/beatsPerBar)+1)))
+			marker++
+		}
+	}
+	for _, seg := range segments {
+		name := keyframes[seg.Keyframe].Label
+		if name == "" {
+			name = fmt.Sprintf("Keyframe %d", seg.Keyframe)
+		}
+		fmt.Fprintf(&b, "  MARKER %d %.6f %s 0\n", marker, seg.NearestBeatTime, rppString(name))
+		marker++
+	}
+
+	if audioPath != "" {
+		if err := writeRPPTrack(&b, "Music", audioPath, audioDuration); err != nil {
+			return err
+		}
+	}
+	if err := writeRPPTrack(&b, "Video", videoPath, videoDuration); err != nil {
+		return err
+	}
+	b.WriteString(">\n")
+	return os.WriteFile(outputPath, []byte(b.String()), 0644)
+}
+
+// exportReaperProject writes the Reaper project of the synced video.
+func exportReaperProject(grid beatGrid, keyframes []Keyframe, videoPath string, audioPath string, outputPath string) error {
+	// the plan is computed again, its warnings were already reported
+	quiet = true
+	segments, err := planSegments(grid, keyframes)
+	quiet = false
+	if err != nil {
+		return err
+	}
+	if err := writeReaperProject(grid, keyframes, segments, videoPath, audioPath, outputPath); err != nil {
+		return err
+	}
+	say("reaper.saved", outputPath)
+	recordOutput(outputPath)
+	return nil
+}
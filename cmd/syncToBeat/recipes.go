@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// recipeFlashBeats is the length, in beats, of the flashes of the recipes.
+const recipeFlashBeats = 0.15
+
+// Recipe describes a themed edit declaratively, so it can be applied to any
+// set of clips and music.
+type Recipe struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// BarsPerClip is the length of each clip, in bars, 0.5 cuts every half
+	// bar.
+	BarsPerClip float64 `json:"bars_per_clip"`
+	// Sections maps labels of the sections of the grid (see -sections) to
+	// the length of the clips within them, e.g. {"chorus": 0.5} for double
+	// time cuts during the chorus.
+	Sections map[string]float64 `json:"sections,omitempty"`
+	// Flash flashes the frame white on the downbeats or on every beat.
+	Flash string `json:"flash,omitempty"`
+	// Fill repeats the clips until the end of the music.
+	Fill bool `json:"fill,omitempty"`
+	// Flags are the command line flags the recipe sets, like a preset, for
+	// the encoder or the canvas for instance.
+	Flags map[string]string `json:"flags,omitempty"`
+}
+
+// builtinRecipes are the recipes shipping with the tool.
+var builtinRecipes = []Recipe{
+	{
+		Name:        "bar-cuts",
+		Description: "one bar per clip until the music ends",
+		BarsPerClip: 1,
+		Fill:        true,
+	},
+	{
+		Name:        "chorus-rush",
+		Description: "one bar per clip, double time cuts during the chorus and flashes on the downbeats",
+		BarsPerClip: 1,
+		Sections:    map[string]float64{"chorus": 0.5},
+		Flash:       "downbeats",
+		Fill:        true,
+	},
+	{
+		Name:        "strobe",
+		Description: "a clip per beat flashing on every beat",
+		BarsPerClip: 0.25,
+		Flash:       "beats",
+		Fill:        true,
+	},
+}
+
+// validateRecipe checks the settings of a recipe.
+func validateRecipe(recipe Recipe) error {
+	if recipe.BarsPerClip <= 0 {
+		return fmt.Errorf("recipe %s: invalid bars per clip %.2f", recipe.Name, recipe.BarsPerClip)
+	}
+	for label, bars := range recipe.Sections {
+		if bars <= 0 {
+			return fmt.Errorf("recipe %s: invalid bars per clip %.2f in the %s sections", recipe.Name, bars, label)
+		}
+	}
+	switch recipe.Flash {
+	case "", "downbeats", "beats":
+	default:
+		return fmt.Errorf("recipe %s: invalid flash %q, expected downbeats or beats", recipe.Name, recipe.Flash)
+	}
+	return nil
+}
+
+// findRecipe returns the built-in recipe with the given name, or reads the
+// recipe from a JSON file.
+func findRecipe(nameOrPath string) (Recipe, error) {
+	var recipe Recipe
+	if !strings.HasSuffix(nameOrPath, ".json") {
+		var names []string
+		for _, builtin := range builtinRecipes {
+			if builtin.Name == nameOrPath {
+				return builtin, nil
+			}
+			names = append(names, builtin.Name)
+		}
+		return recipe, fmt.Errorf("unknown recipe %q, expected %s or a JSON file", nameOrPath, strings.Join(names, ", "))
+	}
+	fileBytes, err := os.ReadFile(nameOrPath)
+	if err != nil {
+		return recipe, err
+	}
+	if err := json.Unmarshal(fileBytes, &recipe); err != nil {
+		return recipe, fmt.Errorf("invalid recipe %s: %v", nameOrPath, err)
+	}
+	if recipe.Name == "" {
+		recipe.Name = nameOrPath
+	}
+	return recipe, validateRecipe(recipe)
+}
+
+// clipBars returns the length, in bars, of a clip starting at the time t.
+func (r Recipe) clipBars(grid beatGrid, t float64) float64 {
+	if section, ok := grid.SectionAt(t); ok {
+		if bars, ok := r.Sections[section.Label]; ok {
+			return bars
+		}
+	}
+	return r.BarsPerClip
+}
+
+// flashFilter returns the filter flashing the frames white on the downbeats
+// or on every beat of the grid, for a video starting at the time start of
+// the grid.
+func flashFilter(grid beatGrid, on string, start float64) string {
+	every := 1.0
+	if on == "downbeats" {
+		every = float64(grid.Meter.BeatsPerBar())
+	}
+	position := positionExpression(grid, fmt.Sprintf("(t+%s)", seconds(start)))
+	return fmt.Sprintf("drawbox=x=0:y=0:w=iw:h=ih:color=white@0.6:t=fill:enable='lt(mod(%s,%g),%g)'", position, every, recipeFlashBeats)
+}
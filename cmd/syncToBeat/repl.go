@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+const interactiveHelp = `Commands:
+  offset [+|-]seconds   set the time of the first beat, or nudge it with a sign
+  bpm value             set the tempo of the grid
+  swing percent         set the swing of the off-beat subdivisions (50 is straight)
+  report                print where the keyframes land on the grid
+  preview [from-to]     play the synced video, optionally only a section (e.g. 0:30-0:45)
+  render                write the synced and debug videos
+  help                  show this help
+  quit                  exit`
+
+// parseTimestamp parses a time expressed in seconds (12.5) or as minutes and
+// seconds (1:23.5) or hours, minutes and seconds (1:02:03).
+func parseTimestamp(value string) (float64, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", value)
+	}
+	var seconds float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", value)
+		}
+		seconds = seconds*60 + v
+	}
+	return seconds, nil
+}
+
+// parseTimeRange parses a from-to range of timestamps such as 0:30-0:45.
+func parseTimeRange(value string) (float64, float64, error) {
+	fromStr, toStr, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid time range %q, expected from-to", value)
+	}
+	from, err := parseTimestamp(fromStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := parseTimestamp(toStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if to <= from {
+		return 0, 0, fmt.Errorf("invalid time range %q, the end is before the start", value)
+	}
+	return from, to, nil
+}
+
+// runInteractive reads commands from stdin to adjust the beat grid and check
+// the result before rendering.
+func runInteractive(grid beatGrid, estimatedBPM float64, originalVideoPath string, audioPath string, keyframes []Keyframe) error {
+	fps := getVideoFrameRate(originalVideoPath)
+	report := func() {
+		segments, err := planSegments(grid, keyframes)
+		if err != nil {
+			say("repl.error", err)
+			return
+		}
+		say("repl.grid", grid.String())
+		printPlanReport(grid, keyframes, segments, fps)
+	}
+
+	say("repl.help")
+	report()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("> ")
+		if !scanner.Scan() {
+			fmt.Println()
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "offset":
+			if len(fields) != 2 {
+				say("repl.usage_offset")
+				continue
+			}
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				say("repl.invalid_offset", fields[1])
+				continue
+			}
+			// a sign nudges the current offset
+			if strings.HasPrefix(fields[1], "+") || strings.HasPrefix(fields[1], "-") {
+				value += grid.Offset
+			}
+			grid.Offset = value
+			report()
+		case "bpm":
+			if len(fields) != 2 {
+				say("repl.usage_bpm")
+				continue
+			}
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil || value <= 0 {
+				say("repl.invalid_bpm", fields[1])
+				continue
+			}
+			grid.BPM = value
+			report()
+		case "swing":
+			if len(fields) != 2 {
+				say("repl.usage_swing")
+				continue
+			}
+			value, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				say("repl.invalid_swing", fields[1])
+				continue
+			}
+			if err := beatgrid.ValidateSwing(value); err != nil {
+				say("repl.error", err)
+				continue
+			}
+			grid.Swing = value
+			report()
+		case "report":
+			report()
+		case "preview":
+			var from, to float64
+			if len(fields) > 1 {
+				var err error
+				from, to, err = parseTimeRange(fields[1])
+				if err != nil {
+					say("repl.error", err)
+					continue
+				}
+			}
+			if err := playSynced(grid, originalVideoPath, audioPath, keyframes, from, to); err != nil {
+				say("repl.play_failed", err)
+			}
+		case "render":
+			if err := renderSync(grid, estimatedBPM, originalVideoPath, audioPath, keyframes); err != nil {
+				say("repl.render_failed", err)
+			}
+		case "help":
+			say("repl.help")
+		case "quit", "exit":
+			return nil
+		default:
+			say("repl.unknown_command", fields[0])
+		}
+	}
+}
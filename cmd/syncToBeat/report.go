@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+)
+
+// HTMLReport exports a standalone HTML report of the plan next to the synced
+// video, to check it in a browser or share it: a preview player, the retime
+// curve, the keyframes with thumbnails of the source and of the synced video
+// to check which shot lands on which beat, and the warnings of the run.
+var HTMLReport = false
+
+const (
+	// thumbnailWidth is the width, in pixels, of the thumbnails of the report.
+	thumbnailWidth = 160
+	// maxEmbeddedPreview is the size of the largest preview embedded in the
+	// report, larger ones are linked next to it.
+	maxEmbeddedPreview = 16 << 20
+)
+
+// extractThumbnail returns a small JPEG of the frame of the video at t.
+func extractThumbnail(videoPath string, t float64) ([]byte, error) {
+	file, err := os.CreateTemp("", "syncToBeat-thumbnail-*.jpg")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	cmdArgs := []string{
+		"-y",
+		"-ss", seconds(t).timestamp(),
+		"-i", videoPath,
+		"-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:-2", thumbnailWidth),
+		"-q:v", "5",
+		"-f", "image2",
+		file.Name(),
+	}
+	if err := runFFmpeg("thumbnail", cmdArgs); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(file.Name())
+}
+
+// thumbnailURL returns the thumbnail of the frame at t as a data URL, the
+// time being kept within the video so that a frame is found.
+func thumbnailURL(videoPath string, t float64, duration float64) (template.URL, error) {
+	t = math.Max(0, math.Min(t, duration-0.05))
+	data, err := extractThumbnail(videoPath, t)
+	if err != nil {
+		return "", err
+	}
+	return template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// reportPreview returns the source of the player of the report: a small
+// encode of the synced video embedded as a data URL, or linked when too
+// large to embed.
+func reportPreview(syncedPath string, reportPath string) (template.URL, error) {
+	file, err := os.CreateTemp("", "syncToBeat-preview-*.mp4")
+	if err != nil {
+		return "", err
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	cmdArgs := []string{
+		"-y",
+		"-i", syncedPath,
+		"-vf", "scale=-2:360",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "32", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "96k",
+		"-movflags", "+faststart",
+		file.Name(),
+	}
+	if err := runFFmpeg("report preview", cmdArgs); err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", err
+	}
+	if len(data) <= maxEmbeddedPreview {
+		return template.URL("data:video/mp4;base64," + base64.StdEncoding.EncodeToString(data)), nil
+	}
+	relative, err := filepath.Rel(filepath.Dir(reportPath), syncedPath)
+	if err != nil {
+		return "", err
+	}
+	return template.URL((&url.URL{Path: filepath.ToSlash(relative)}).String()), nil
+}
+
+// reportRow is a keyframe of the report.
+type reportRow struct {
+	Keyframe   int
+	Label      string
+	Time       float64
+	BeatTime   float64
+	Bar        int
+	Beat       float64
+	Speed      float64
+	Source     template.URL
+	Synced     template.URL
+	SpeedClass string
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 4px 8px; border-bottom: 1px solid #ddd; text-align: right; }
+td.label { text-align: left; }
+tr[data-time] { cursor: pointer; }
+tr.current { background: #fff3c4; }
+img { display: block; }
+video { max-width: 100%; }
+svg { max-width: 100%; height: auto; }
+circle { cursor: pointer; }
+.faster { color: #d62728; }
+.slower { color: #1f77b4; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Grid: {{.Grid}}<br>Sync score: {{printf "%.1f" .Score.Score}}/100 (beat residual {{printf "%.3f" .Score.Residual}}, speed variance {{printf "%.3f" .Score.SpeedVariance}})</p>
+<video id="preview" src="{{.Preview}}" controls preload="metadata"></video>
+<h2>Warnings</h2>
+{{if .Warnings}}<ul>
+{{range .Warnings}}<li>{{.Code}}: {{.Message}}</li>
+{{end}}</ul>{{else}}<p>None.</p>{{end}}
+<h2>Retime curve</h2>
+<div id="curve">{{.Curve}}</div>
+<h2>Keyframes</h2>
+<p>Click a keyframe, or a point of the curve, to play the synced video from the beat it lands on.</p>
+<table>
+<tr><th>Keyframe</th><th>Label</th><th>Source</th><th>Time</th><th>Synced</th><th>Beat time</th><th>Bar</th><th>Beat</th><th>Speed</th></tr>
+{{range .Rows}}<tr data-time="{{printf "%.3f" .BeatTime}}">
+<td>{{.Keyframe}}</td>
+<td class="label">{{.Label}}</td>
+<td>{{if .Source}}<img src="{{.Source}}" width="{{$.Width}}" alt="source at {{printf "%.2f" .Time}}s">{{end}}</td>
+<td>{{printf "%.2f" .Time}}s</td>
+<td>{{if .Synced}}<img src="{{.Synced}}" width="{{$.Width}}" alt="synced at {{printf "%.2f" .BeatTime}}s">{{end}}</td>
+<td>{{printf "%.2f" .BeatTime}}s</td>
+<td>{{.Bar}}</td>
+<td>{{printf "%.2f" .Beat}}</td>
+<td class="{{.SpeedClass}}">{{printf "%.3f" .Speed}}x</td>
+</tr>
+{{end}}</table>
+<script>
+const preview = document.getElementById("preview");
+const rows = Array.from(document.querySelectorAll("tr[data-time]"));
+function seek(t) {
+  preview.currentTime = Math.max(0, t - 1);
+  preview.play();
+}
+rows.forEach(row => row.addEventListener("click", () => seek(parseFloat(row.dataset.time))));
+document.querySelectorAll("#curve circle").forEach(point => point.addEventListener("click", () => seek(parseFloat(point.dataset.time))));
+// highlight the last keyframe played
+preview.addEventListener("timeupdate", () => {
+  let current = null;
+  rows.forEach(row => {
+    if (parseFloat(row.dataset.time) <= preview.currentTime) {
+      current = row;
+    }
+  });
+  rows.forEach(row => row.classList.toggle("current", row === current));
+});
+</script>
+</body>
+</html>
+`))
+
+// exportHTMLReport writes the HTML report of the plan of the synced video,
+// with the music when given.
+func exportHTMLReport(grid beatGrid, keyframes []Keyframe, originalVideoPath string, syncedPath string, audioPath string, outputPath string) error {
+	// the plan is computed again, its warnings were already reported
+	quiet = true
+	segments, err := planSegments(grid, keyframes)
+	quiet = false
+	if err != nil {
+		return err
+	}
+	sourceDuration, err := getVideoDuration(originalVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+	syncedDuration, err := getVideoDuration(syncedPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+
+	var rows []reportRow
+	for _, seg := range segments {
+		kf := keyframes[seg.Keyframe]
+		bar, beat := grid.Meter.BarAndBeat(roundToBeat(grid.BeatPosition(seg.NearestBeatTime)))
+		row := reportRow{
+			Keyframe: seg.Keyframe,
+			Label:    kf.Label,
+			Time:     kf.Time,
+			BeatTime: seg.NearestBeatTime,
+			Bar:      bar,
+			Beat:     beat,
+			Speed:    seg.SpeedFactor,
+		}
+		switch {
+		case seg.SpeedFactor > 1.01:
+			row.SpeedClass = "faster"
+		case seg.SpeedFactor < 0.99:
+			row.SpeedClass = "slower"
+		}
+		if row.Source, err = thumbnailURL(originalVideoPath, kf.Time, sourceDuration); err != nil {
+			return fmt.Errorf("failed to extract the thumbnail of keyframe %d: %v", seg.Keyframe, err)
+		}
+		if row.Synced, err = thumbnailURL(syncedPath, seg.NearestBeatTime, syncedDuration); err != nil {
+			return fmt.Errorf("failed to extract the synced thumbnail of keyframe %d: %v", seg.Keyframe, err)
+		}
+		rows = append(rows, row)
+	}
+
+	previewSource := syncedPath
+	if audioPath != "" {
+		previewSource = audioOutputPath(syncedPath)
+	}
+	preview, err := reportPreview(previewSource, outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to encode the preview: %v", err)
+	}
+
+	var b bytes.Buffer
+	err = reportTemplate.Execute(&b, map[string]any{
+		"Title":    originalVideoPath,
+		"Grid":     grid.String(),
+		"Score":    plan.Score(grid, keyframes, segments),
+		"Preview":  preview,
+		"Warnings": recordedWarnings(),
+		// the SVG is generated, its labels escaped
+		"Curve": template.HTML(retimeSVG(retimeCurve(segments, keyframes))),
+		"Rows":  rows,
+		"Width": thumbnailWidth,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, b.Bytes(), 0644); err != nil {
+		return err
+	}
+	say("report.saved", outputPath, len(rows))
+	recordOutput(outputPath)
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// runResult is the machine readable summary of a run, written to the path
+// given with -result.
+type runResult struct {
+	// Seed is the seed of the random choices, to render the same video
+	// again.
+	Seed     int64        `json:"seed"`
+	Outputs  []string     `json:"outputs"`
+	Phases   []phaseUsage `json:"phases"`
+	Warnings []runWarning `json:"warnings"`
+}
+
+var (
+	outputsMu sync.Mutex
+	outputs   []string
+)
+
+// recordOutput records a file written by the run.
+func recordOutput(path string) {
+	outputsMu.Lock()
+	outputs = append(outputs, path)
+	outputsMu.Unlock()
+}
+
+// recordedOutputs returns the files written by the run so far.
+func recordedOutputs() []string {
+	outputsMu.Lock()
+	defer outputsMu.Unlock()
+	return append([]string{}, outputs...)
+}
+
+// writeResult writes the summary of the run as JSON.
+func writeResult(filePath string) error {
+	result := runResult{
+		Seed:     Seed,
+		Outputs:  recordedOutputs(),
+		Phases:   recordedPhaseUsages(),
+		Warnings: recordedWarnings(),
+	}
+	if result.Phases == nil {
+		result.Phases = []phaseUsage{}
+	}
+	if result.Warnings == nil {
+		result.Warnings = []runWarning{}
+	}
+
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filePath, data, 0644)
+}
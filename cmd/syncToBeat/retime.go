@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RetimeCurve exports the retime curve of the synced video, the time of the
+// original video plotted against the time of the output, so that editors see
+// where and how much the timing was warped: csv or svg, empty disables it.
+var RetimeCurve = ""
+
+// validateRetimeCurve checks the format of the retime curve.
+func validateRetimeCurve(format string) error {
+	switch format {
+	case "", "csv", "svg":
+		return nil
+	}
+	return fmt.Errorf("invalid retime curve format %q, expected csv or svg", format)
+}
+
+// retimePoint is a point of the retime curve, where a keyframe of the
+// original video lands in the output. The curve is linear between points.
+type retimePoint struct {
+	Output   float64
+	Original float64
+	// Speed is the speed factor of the segment ending at the point.
+	Speed float64
+	Label string
+}
+
+// retimeCurve returns the points of the retime curve of the segments, from
+// the start of the video.
+func retimeCurve(segments []segment, keyframes []Keyframe) []retimePoint {
+	points := []retimePoint{{Output: 0, Original: segments[0].Start, Speed: 1}}
+	for _, seg := range segments {
+		points = append(points, retimePoint{
+			Output:   seg.NearestBeatTime,
+			Original: seg.End,
+			Speed:    seg.SpeedFactor,
+			Label:    keyframes[seg.Keyframe].Label,
+		})
+	}
+	return points
+}
+
+// writeRetimeCSV writes the points of the curve as CSV, with the shift of
+// each keyframe from its original time.
+func writeRetimeCSV(points []retimePoint, outputPath string) error {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return err
+	}
+	w := csv.NewWriter(file)
+	w.Write([]string{"output_time", "original_time", "speed", "shift", "label"})
+	for _, p := range points {
+		w.Write([]string{
+			strconv.FormatFloat(p.Output, 'f', 3, 64),
+			strconv.FormatFloat(p.Original, 'f', 3, 64),
+			strconv.FormatFloat(p.Speed, 'f', 4, 64),
+			strconv.FormatFloat(p.Output-p.Original, 'f', 3, 64),
+			p.Label,
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// tickStep returns the spacing of the ticks of an axis up to max seconds,
+// for about 10 ticks at most.
+func tickStep(max float64) float64 {
+	for _, step := range []float64{1, 2, 5, 10, 15, 30, 60, 120, 300, 600, 1800} {
+		if max/step <= 10 {
+			return step
+		}
+	}
+	return 3600
+}
+
+// retimeColor is the color of a segment by its speed: red when sped up, blue
+// when slowed down, gray when about unchanged.
+func retimeColor(speed float64) string {
+	switch {
+	case speed > 1.01:
+		return "#d62728"
+	case speed < 0.99:
+		return "#1f77b4"
+	}
+	return "#7f7f7f"
+}
+
+// retimeSVG plots the curve as an SVG image, the diagonal showing the timing
+// of the original video. Hovering a segment shows its speed, the points of
+// the keyframes hold their output time in data-time.
+func retimeSVG(points []retimePoint) string {
+	const width, height, margin = 800.0, 600.0, 60.0
+	last := points[len(points)-1]
+	extent := math.Max(math.Max(last.Output, last.Original), 1)
+	x := func(t float64) float64 { return margin + t/extent*(width-2*margin) }
+	y := func(t float64) float64 { return height - margin - t/extent*(height-2*margin) }
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]g" height="%[2]g" viewBox="0 0 %[1]g %[2]g" font-family="sans-serif" font-size="12">`+"\n", width, height)
+	fmt.Fprintf(&b, `<rect width="%g" height="%g" fill="white"/>`+"\n", width, height)
+	step := tickStep(extent)
+	for t := 0.0; t <= extent; t += step {
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#eee"/>`+"\n", x(t), y(0), x(t), y(extent))
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#eee"/>`+"\n", x(0), y(t), x(extent), y(t))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%gs</text>`+"\n", x(t), y(0)+18, t)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="end">%gs</text>`+"\n", x(0)-6, y(t)+4, t)
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">output time</text>`+"\n", width/2, height-15)
+	fmt.Fprintf(&b, `<text transform="translate(15 %.1f) rotate(-90)" text-anchor="middle">original time</text>`+"\n", height/2)
+	fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#999" stroke-dasharray="4 4"/>`+"\n", x(0), y(0), x(extent), y(extent))
+
+	for i := 1; i < len(points); i++ {
+		from, to := points[i-1], points[i]
+		fmt.Fprintf(&b, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="3"><title>%.2fs-%.2fs: speed %.3fx</title></line>`+"\n",
+			x(from.Output), y(from.Original), x(to.Output), y(to.Original), retimeColor(to.Speed), from.Original, to.Original, to.Speed)
+	}
+	for _, p := range points[1:] {
+		label := fmt.Sprintf("%.2fs → %.2fs (%+.2fs)", p.Original, p.Output, p.Output-p.Original)
+		if p.Label != "" {
+			label = p.Label + ": " + label
+		}
+		fmt.Fprintf(&b, `<circle cx="%.1f" cy="%.1f" r="3" data-time="%.3f"><title>%s</title></circle>`+"\n", x(p.Output), y(p.Original), p.Output, html.EscapeString(label))
+	}
+	fmt.Fprintf(&b, `<text x="%.1f" y="25" fill="%s">sped up</text>`+"\n", margin, retimeColor(2))
+	fmt.Fprintf(&b, `<text x="%.1f" y="25" fill="%s">slowed down</text>`+"\n", margin+70, retimeColor(0.5))
+	fmt.Fprintf(&b, `<text x="%.1f" y="25" fill="%s">dashed: original timing</text>`+"\n", margin+170, retimeColor(1))
+	b.WriteString("</svg>\n")
+	return b.String()
+}
+
+// writeRetimeSVG writes the plot of the curve as an SVG file.
+func writeRetimeSVG(points []retimePoint, outputPath string) error {
+	return os.WriteFile(outputPath, []byte(retimeSVG(points)), 0644)
+}
+
+// exportRetimeCurve writes the retime curve of the keyframes in the format
+// of RetimeCurve.
+func exportRetimeCurve(grid beatGrid, keyframes []Keyframe, outputPath string) error {
+	// the plan is computed again, its warnings were already reported
+	quiet = true
+	segments, err := planSegments(grid, keyframes)
+	quiet = false
+	if err != nil {
+		return err
+	}
+	points := retimeCurve(segments, keyframes)
+	if RetimeCurve == "svg" {
+		err = writeRetimeSVG(points, outputPath)
+	} else {
+		err = writeRetimeCSV(points, outputPath)
+	}
+	if err != nil {
+		return err
+	}
+	say("retime.saved", outputPath)
+	recordOutput(outputPath)
+	return nil
+}
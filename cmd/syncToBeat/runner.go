@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// PhaseTimeout bounds how long each ffmpeg encode can run, 0 means no
+	// limit.
+	PhaseTimeout time.Duration
+	// StallTimeout is how long ffmpeg can go without reporting progress
+	// before it's considered stuck and killed, 0 disables the watchdog.
+	StallTimeout = 5 * time.Minute
+	// StallRetries is how many times a stalled encode is restarted before
+	// giving up.
+	StallRetries = 0
+)
+
+// stderrTailLines is the number of lines of ffmpeg errors kept for the
+// diagnostic of a failed encode.
+const stderrTailLines = 20
+
+// errStalled is returned when the watchdog killed an ffmpeg process that
+// stopped making progress.
+var errStalled = errors.New("ffmpeg stalled")
+
+// ffmpegProgress tracks the progress reported by ffmpeg's -progress output.
+type ffmpegProgress struct {
+	mu           sync.Mutex
+	lastUpdate   time.Time
+	fields       map[string]string
+	stderrTail   []string
+	stderrBuffer bytes.Buffer
+}
+
+func (p *ffmpegProgress) touch() {
+	p.mu.Lock()
+	p.lastUpdate = time.Now()
+	p.mu.Unlock()
+}
+
+func (p *ffmpegProgress) sinceLastUpdate() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Since(p.lastUpdate)
+}
+
+// readProgress parses the key=value lines written by -progress, each block
+// ending with a progress= line.
+func (p *ffmpegProgress) readProgress(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if Debug {
+			log.Println(line)
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		p.mu.Lock()
+		p.fields[key] = value
+		if key == "progress" {
+			p.lastUpdate = time.Now()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// Write keeps the last lines written to stderr.
+func (p *ffmpegProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stderrBuffer.Write(b)
+	for {
+		line, err := p.stderrBuffer.ReadString('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			p.stderrBuffer.WriteString(line)
+			break
+		}
+		p.stderrTail = append(p.stderrTail, strings.TrimRight(line, "\r\n"))
+		if len(p.stderrTail) > stderrTailLines {
+			p.stderrTail = p.stderrTail[1:]
+		}
+	}
+	return len(b), nil
+}
+
+// diagnostic describes the last known state of the encode.
+func (p *ffmpegProgress) diagnostic() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var lines []string
+	if len(p.fields) > 0 {
+		lines = append(lines, fmt.Sprintf("last progress: frame=%s out_time=%s speed=%s", p.fields["frame"], p.fields["out_time"], p.fields["speed"]))
+	}
+	if len(p.stderrTail) > 0 {
+		lines = append(lines, "ffmpeg output:")
+		lines = append(lines, p.stderrTail...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// runFFmpeg runs an ffmpeg encode for the named phase of the pipeline. The
+// encode is aborted when it runs longer than PhaseTimeout and restarted (up
+// to StallRetries times) when it stops reporting progress for StallTimeout.
+func runFFmpeg(phase string, cmdArgs []string) error {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := runFFmpegOnce(phase, ffmpegPath, cmdArgs)
+		if errors.Is(err, errStalled) && attempt < StallRetries {
+			say("ffmpeg.stalled", phase, attempt+1, StallRetries)
+			continue
+		}
+		return err
+	}
+}
+
+func runFFmpegOnce(phase string, ffmpegPath string, cmdArgs []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if PhaseTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, PhaseTimeout)
+		defer cancelTimeout()
+	}
+
+	// progress reports are written to stdout, the regular stats are disabled
+	// as they would be redundant.
+	args := append([]string{"-progress", "pipe:1", "-nostats"}, cmdArgs...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+
+	progress := &ffmpegProgress{fields: map[string]string{}}
+	progress.touch()
+	if Debug {
+		cmd.Stderr = io.MultiWriter(os.Stderr, progress)
+	} else {
+		cmd.Stderr = progress
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	progressDone := make(chan struct{})
+	go func() {
+		progress.readProgress(stdout)
+		close(progressDone)
+	}()
+
+	// the watchdog kills ffmpeg when it stops reporting progress
+	var stalled atomic.Bool
+	watchdogDone := make(chan struct{})
+	if StallTimeout > 0 {
+		go func() {
+			ticker := time.NewTicker(min(StallTimeout/4, time.Second))
+			defer ticker.Stop()
+			for {
+				select {
+				case <-watchdogDone:
+					return
+				case <-ticker.C:
+					if progress.sinceLastUpdate() > StallTimeout {
+						stalled.Store(true)
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	<-progressDone
+	err = cmd.Wait()
+	close(watchdogDone)
+	recordPhaseUsage(phase, cmd.ProcessState, time.Since(started))
+
+	switch {
+	case err == nil:
+		return nil
+	case stalled.Load():
+		return fmt.Errorf("%w during %s: no progress for %s\n%s", errStalled, phase, StallTimeout, progress.diagnostic())
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s timed out after %s\n%s", phase, PhaseTimeout, progress.diagnostic())
+	}
+	return fmt.Errorf("%v\n%s", err, progress.diagnostic())
+}
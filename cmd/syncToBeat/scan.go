@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// LibraryIndex is the index of the media files written by the scan command,
+// relative to the working directory.
+var LibraryIndex = ".syncToBeat_library.json"
+
+// libraryExtensions are the extensions of the files indexed by a scan.
+var libraryExtensions = map[string]bool{
+	".mp4": true, ".mov": true, ".m4v": true, ".mkv": true, ".webm": true,
+	".avi": true, ".mts": true, ".m2ts": true,
+	".mp3": true, ".wav": true, ".m4a": true, ".aac": true, ".flac": true,
+	".ogg": true, ".opus": true, ".aif": true, ".aiff": true,
+}
+
+// libraryFile is a media file of the index.
+type libraryFile struct {
+	// Path is the path of the file from the working directory of the scan.
+	Path string `json:"path"`
+	// Size and ModTime tell whether the file changed since it was probed.
+	Size     int64     `json:"size"`
+	ModTime  time.Time `json:"mod_time"`
+	Duration float64   `json:"duration"`
+	Video    bool      `json:"video"`
+	Audio    bool      `json:"audio"`
+	Width    int       `json:"width,omitempty"`
+	Height   int       `json:"height,omitempty"`
+	FPS      float64   `json:"fps,omitempty"`
+	// BPM is the tempo tagged in the file, 0 when it isn't tagged.
+	BPM float64 `json:"bpm,omitempty"`
+	// Error is set for the files that couldn't be probed, they are kept so
+	// that rescans don't probe them again until they change.
+	Error string `json:"error,omitempty"`
+}
+
+// readLibrary reads the files of the index, none when there is no index yet.
+func readLibrary() ([]libraryFile, error) {
+	data, err := os.ReadFile(LibraryIndex)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []libraryFile
+	if err := json.Unmarshal(data, &files); err != nil {
+		return nil, fmt.Errorf("%s: %v", LibraryIndex, err)
+	}
+	return files, nil
+}
+
+// writeLibrary writes the files of the index, sorted by path.
+func writeLibrary(files []libraryFile) error {
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	data, err := json.MarshalIndent(files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(LibraryIndex, append(data, '\n'), 0644)
+}
+
+// taggedBPM returns the tempo tagged in the file (TBPM in ID3, tmpo in MP4,
+// BPM in Vorbis comments), 0 when there is none.
+func taggedBPM(tags map[string]string) float64 {
+	for _, key := range []string{"tbpm", "bpm", "tmpo"} {
+		if bpm, err := strconv.ParseFloat(strings.TrimSpace(tags[key]), 64); err == nil && bpm > 0 {
+			return bpm
+		}
+	}
+	return 0
+}
+
+// indexFile probes a media file into an entry of the index.
+func indexFile(path string, info fs.FileInfo) libraryFile {
+	file := libraryFile{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+	media, err := probe.ProbeMedia(path)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	file.Duration = media.Duration
+	file.BPM = taggedBPM(media.Tags)
+	if video, ok := media.Video(); ok {
+		file.Video = true
+		file.Width, file.Height = video.Width, video.Height
+		if video.Rotation%180 != 0 {
+			file.Width, file.Height = file.Height, file.Width
+		}
+		file.FPS = video.FPS
+	}
+	_, file.Audio = media.Audio()
+	return file
+}
+
+// scanLibrary indexes the media files of dir and its subdirectories. Files
+// that didn't change since the last scan aren't probed again, and the
+// indexed files of other directories are kept.
+func scanLibrary(dir string) error {
+	indexed, err := readLibrary()
+	if err != nil {
+		return err
+	}
+	root := filepath.Clean(dir)
+	previous := map[string]libraryFile{}
+	var files []libraryFile
+	for _, file := range indexed {
+		if rel, err := filepath.Rel(root, file.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			previous[file.Path] = file
+			continue
+		}
+		files = append(files, file)
+	}
+
+	var scanned, probed, failed int
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			// skip the project directories and other hidden directories
+			if path != root && strings.HasPrefix(entry.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !libraryExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		scanned++
+		file, ok := previous[path]
+		if !ok || file.Size != info.Size() || !file.ModTime.Equal(info.ModTime()) {
+			file = indexFile(path, info)
+			probed++
+			if file.Error != "" {
+				say("scan.failed", path, file.Error)
+			}
+		}
+		if file.Error != "" {
+			failed++
+		}
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := writeLibrary(files); err != nil {
+		return err
+	}
+	say("scan.saved", scanned, root, probed, failed, LibraryIndex)
+	return nil
+}
+
+// libraryFilter keeps the indexed files whose property is within a range.
+type libraryFilter struct {
+	Property string
+	Min, Max float64
+	// Type, for the type property, is video or audio (audio only files).
+	Type string
+}
+
+// libraryProperties are the numeric properties filters apply to.
+var libraryProperties = map[string]func(libraryFile) float64{
+	"duration": func(f libraryFile) float64 { return f.Duration },
+	"fps":      func(f libraryFile) float64 { return f.FPS },
+	"width":    func(f libraryFile) float64 { return float64(f.Width) },
+	"height":   func(f libraryFile) float64 { return float64(f.Height) },
+	"bpm":      func(f libraryFile) float64 { return f.BPM },
+}
+
+// parseLibraryFilter parses filters such as duration=3-8, height=1080-,
+// fps=30 or type=video.
+func parseLibraryFilter(spec string) (libraryFilter, error) {
+	name, value, ok := strings.Cut(spec, "=")
+	if !ok || value == "" {
+		return libraryFilter{}, fmt.Errorf("invalid filter %q, expected property=range such as duration=3-8", spec)
+	}
+	filter := libraryFilter{Property: name, Min: math.Inf(-1), Max: math.Inf(1)}
+	if name == "type" {
+		if value != "video" && value != "audio" {
+			return libraryFilter{}, fmt.Errorf("invalid filter %q, the type is video or audio", spec)
+		}
+		filter.Type = value
+		return filter, nil
+	}
+	if libraryProperties[name] == nil {
+		return libraryFilter{}, fmt.Errorf("invalid filter %q, expected duration, fps, width, height, bpm or type", spec)
+	}
+
+	low, high, isRange := strings.Cut(value, "-")
+	var err error
+	if !isRange {
+		// single values match with a little tolerance, fps=30 matching 29.97
+		var v float64
+		if v, err = strconv.ParseFloat(value, 64); err != nil {
+			return libraryFilter{}, fmt.Errorf("invalid filter %q: %v", spec, err)
+		}
+		filter.Min, filter.Max = v*0.99, v*1.01
+		return filter, nil
+	}
+	if low != "" {
+		if filter.Min, err = strconv.ParseFloat(low, 64); err != nil {
+			return libraryFilter{}, fmt.Errorf("invalid filter %q: %v", spec, err)
+		}
+	}
+	if high != "" {
+		if filter.Max, err = strconv.ParseFloat(high, 64); err != nil {
+			return libraryFilter{}, fmt.Errorf("invalid filter %q: %v", spec, err)
+		}
+	}
+	return filter, nil
+}
+
+// matches reports whether the file passes the filter.
+func (f libraryFilter) matches(file libraryFile) bool {
+	switch f.Type {
+	case "video":
+		return file.Video
+	case "audio":
+		return file.Audio && !file.Video
+	}
+	v := libraryProperties[f.Property](file)
+	return v >= f.Min && v <= f.Max
+}
+
+// queryLibrary returns the indexed files matching all the filters, leaving
+// out the files that couldn't be probed and the ones that disappeared.
+func queryLibrary(specs []string) ([]libraryFile, error) {
+	var filters []libraryFilter
+	for _, spec := range specs {
+		filter, err := parseLibraryFilter(spec)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	files, err := readLibrary()
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		return nil, fmt.Errorf("no library index found in %s, run the scan command first", LibraryIndex)
+	}
+
+	var matching []libraryFile
+	for _, file := range files {
+		if file.Error != "" {
+			continue
+		}
+		if _, err := os.Stat(file.Path); err != nil {
+			continue
+		}
+		ok := true
+		for _, filter := range filters {
+			ok = ok && filter.matches(file)
+		}
+		if ok {
+			matching = append(matching, file)
+		}
+	}
+	return matching, nil
+}
+
+const scanUsage = `Usage:
+  <program> scan dir                            index the media files of dir and its subdirectories
+  <program> scan query [filter...]              list the indexed files matching all the filters
+  <program> scan clips out.json [filter...]     write the matching video files as a clip manifest for -clips
+
+Filters are property=range, such as duration=3-8, height=1080-, fps=30,
+bpm=120-130 or type=video. The properties are duration, fps, width, height,
+bpm (the tempo tagged in the file) and type (video or audio).`
+
+// runScanCommand runs the scan subcommands.
+func runScanCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.scan")
+		return nil
+	}
+
+	switch args[0] {
+	case "query":
+		files, err := queryLibrary(args[1:])
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			var video, bpm string
+			if file.Video {
+				video = fmt.Sprintf("%dx%d@%.2f", file.Width, file.Height, file.FPS)
+			}
+			if file.BPM > 0 {
+				bpm = fmt.Sprintf("%.2f BPM", file.BPM)
+			}
+			say("scan.file", file.Duration, video, bpm, file.Path)
+		}
+		return nil
+	case "clips":
+		if len(args) < 2 {
+			say("usage.scan")
+			return nil
+		}
+		files, err := queryLibrary(args[2:])
+		if err != nil {
+			return err
+		}
+		var clips []Clip
+		for _, file := range files {
+			if file.Video {
+				clips = append(clips, Clip{Path: file.Path})
+			}
+		}
+		if len(clips) == 0 {
+			return fmt.Errorf("no indexed video matches the filters")
+		}
+		data, err := json.MarshalIndent(clips, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[1], append(data, '\n'), 0644); err != nil {
+			return err
+		}
+		say("scan.clips", len(clips), args[1])
+		return nil
+	}
+
+	info, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		say("usage.scan")
+		return fmt.Errorf("%s is not a directory", args[0])
+	}
+	return scanLibrary(args[0])
+}
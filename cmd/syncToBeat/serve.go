@@ -0,0 +1,557 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveJob is a run queued on the server, its Args being the command line
+// of the run as with history rerun.
+type serveJob struct {
+	ID   int    `json:"id"`
+	User string `json:"user"`
+	// Priority orders the queued jobs, higher first.
+	Priority int      `json:"priority"`
+	Args     []string `json:"args"`
+	// StartAt holds the job until then, nil runs it as soon as possible.
+	StartAt *time.Time `json:"start_at,omitempty"`
+	// State is queued, running, succeeded, failed or canceled.
+	State     string     `json:"state"`
+	Submitted time.Time  `json:"submitted"`
+	Started   *time.Time `json:"started,omitempty"`
+	Finished  *time.Time `json:"finished,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Result    *runResult `json:"result,omitempty"`
+
+	// Keyed is set for the jobs submitted with an API key, whose arguments
+	// were checked.
+	Keyed bool `json:"keyed,omitempty"`
+
+	log *jobLog
+	// key is the API key the job was submitted with.
+	key *apiKey
+}
+
+// finishedJobRetention is how long the finished jobs are kept for their
+// status and outputs, as long as they count in the quotas.
+const finishedJobRetention = quotaWindow
+
+// maxJobLog is the number of bytes of the output of a job kept for its
+// status.
+const maxJobLog = 64 << 10
+
+// jobLog keeps the first maxJobLog bytes written to it.
+type jobLog struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if room := maxJobLog - len(l.data); room > 0 {
+		l.data = append(l.data, p[:min(len(p), room)]...)
+	}
+	return len(p), nil
+}
+
+func (l *jobLog) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return string(l.data)
+}
+
+// jobServer runs the jobs submitted over HTTP, at most MaxJobs at once and
+// MaxJobsPerUser at once for each user, 0 for no limit per user.
+type jobServer struct {
+	MaxJobs        int
+	MaxJobsPerUser int
+	// Keys are the API keys accepted, nil accepts every request.
+	Keys []apiKey
+	// UploadDir holds the uploaded files.
+	UploadDir string
+	// QueueFile keeps the jobs queued or running across restarts, none
+	// when empty.
+	QueueFile string
+
+	mu     sync.Mutex
+	jobs   []*serveJob
+	nextID int
+	// wake triggers the scheduling of the queued jobs.
+	wake chan struct{}
+	// failures counts the ffmpeg failures of the jobs by class.
+	failures map[string]int
+	// pruned sums up the metrics of the finished jobs dropped from jobs.
+	pruned jobTotals
+	// draining stops the server from starting and accepting jobs, as it
+	// shuts down.
+	draining bool
+	running  sync.WaitGroup
+	// ctx cancels the running jobs.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newJobServer(maxJobs, maxJobsPerUser int) *jobServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobServer{
+		MaxJobs:        maxJobs,
+		MaxJobsPerUser: maxJobsPerUser,
+		nextID:         1,
+		wake:           make(chan struct{}, 1),
+		failures:       map[string]int{},
+		ctx:            ctx,
+		cancel:         cancel,
+	}
+}
+
+// nudge wakes the scheduler up.
+func (s *jobServer) nudge() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// nextJob returns the queued job to start: the one of highest priority whose
+// start time passed and whose user is under the limit, the oldest first
+// among equals. The caller holds the lock.
+func (s *jobServer) nextJob(now time.Time) *serveJob {
+	running := 0
+	runningPerUser := map[string]int{}
+	for _, job := range s.jobs {
+		if job.State == "running" {
+			running++
+			runningPerUser[job.User]++
+		}
+	}
+	if running >= s.MaxJobs {
+		return nil
+	}
+	var next *serveJob
+	for _, job := range s.jobs {
+		if job.State != "queued" || (job.StartAt != nil && job.StartAt.After(now)) {
+			continue
+		}
+		if s.MaxJobsPerUser > 0 && runningPerUser[job.User] >= s.MaxJobsPerUser {
+			continue
+		}
+		if next == nil || job.Priority > next.Priority {
+			next = job
+		}
+	}
+	return next
+}
+
+// prune drops the jobs finished for longer than finishedJobRetention, the
+// caller holds the lock.
+func (s *jobServer) prune(now time.Time) {
+	kept := s.jobs[:0]
+	for _, job := range s.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) > finishedJobRetention {
+			s.pruned.add(job)
+			continue
+		}
+		kept = append(kept, job)
+	}
+	clear(s.jobs[len(kept):])
+	s.jobs = kept
+}
+
+// schedule starts the queued jobs as the limits allow and prunes the
+// finished ones, forever. Scheduled jobs are picked up within a second of
+// their start time.
+func (s *jobServer) schedule() {
+	for {
+		s.mu.Lock()
+		s.prune(time.Now())
+		for job := s.nextJob(time.Now()); job != nil && !s.draining; job = s.nextJob(time.Now()) {
+			now := time.Now()
+			job.State = "running"
+			job.Started = &now
+			s.running.Add(1)
+			go s.run(job)
+		}
+		s.mu.Unlock()
+		select {
+		case <-s.wake:
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// run runs a job in its own process, reading back its result. A job
+// canceled as the server shuts down is queued again.
+func (s *jobServer) run(job *serveJob) {
+	defer s.running.Done()
+	watcher := &failureWatcher{}
+	err := func() error {
+		executable, err := os.Executable()
+		if err != nil {
+			return err
+		}
+		resultFile, err := os.CreateTemp("", "syncToBeat-job-*.json")
+		if err != nil {
+			return err
+		}
+		resultFile.Close()
+		defer os.Remove(resultFile.Name())
+
+		cmd := exec.CommandContext(s.ctx, executable, append([]string{"-json", "-result", resultFile.Name()}, job.Args...)...)
+		detachJob(cmd)
+		cmd.Stdout = io.MultiWriter(job.log, watcher)
+		cmd.Stderr = job.log
+		if err := cmd.Run(); err != nil {
+			return err
+		}
+		data, err := os.ReadFile(resultFile.Name())
+		if err != nil {
+			return err
+		}
+		var result runResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			return fmt.Errorf("invalid result: %v", err)
+		}
+		s.mu.Lock()
+		job.Result = &result
+		s.mu.Unlock()
+		return nil
+	}()
+	s.finish(job, watcher.failures(), err)
+}
+
+// finish records the end of a job, err being why it failed, with the
+// failures its events reported. A job without a result because the server
+// shuts down is queued again.
+func (s *jobServer) finish(job *serveJob, failures map[string]int, err error) {
+	s.mu.Lock()
+	if s.ctx.Err() != nil && job.Result == nil {
+		job.State = "queued"
+		job.Started = nil
+		s.mu.Unlock()
+		say("serve.requeued", job.ID, job.User)
+		return
+	}
+	now := time.Now()
+	job.Finished = &now
+	job.State = "succeeded"
+	if err != nil {
+		job.State = "failed"
+		job.Error = err.Error()
+	}
+	for class, n := range failures {
+		s.failures[class] += n
+	}
+	s.persist()
+	s.mu.Unlock()
+	say("serve.finished", job.ID, job.User, job.State)
+	s.nudge()
+}
+
+// writeJSON writes the value as the JSON response.
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// writeError writes an error as the JSON response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// visible reports whether the job can be seen with the key, users only see
+// their own jobs.
+func visible(job *serveJob, key *apiKey) bool {
+	return key == nil || job.User == key.User
+}
+
+// findJob returns the job of the id in the path, the caller holds the lock.
+func (s *jobServer) findJob(r *http.Request, key *apiKey) *serveJob {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		return nil
+	}
+	for _, job := range s.jobs {
+		if job.ID == id && visible(job, key) {
+			return job
+		}
+	}
+	return nil
+}
+
+// handler returns the HTTP API of the server:
+//
+//	POST /jobs                  submit a job: {"args": [...], "user": "...", "priority": 0, "start_at": "2024-05-01T22:00:00Z"}
+//	GET /jobs                   list the jobs, in the order they run
+//	GET /jobs/{id}              status of a job, with its output
+//	DELETE /jobs/{id}           cancel a queued job
+//	GET /jobs/{id}/outputs/{n}  download the output n, from 0, of a succeeded job
+//	POST /uploads/{name}        upload a file, the response has its path for the arguments of jobs
+//	GET /metrics                metrics of the jobs in the Prometheus text format
+//
+// With API keys, the user of a job is the one of its key and users only see
+// their own jobs.
+func (s *jobServer) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs", s.authorized(func(w http.ResponseWriter, r *http.Request, key *apiKey) {
+		var job serveJob
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestSize)).Decode(&job); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %v", err))
+			return
+		}
+		if len(job.Args) == 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("the job has no arguments"))
+			return
+		}
+		// the keys open the server beyond the local machine
+		if key != nil {
+			if err := checkJobArgs(job.Args, s.UploadDir); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job: %v", err))
+				return
+			}
+			job.User = key.User
+			job.Keyed = true
+		}
+		if job.User == "" {
+			job.User = "anonymous"
+		}
+		s.mu.Lock()
+		if s.draining {
+			s.mu.Unlock()
+			writeError(w, http.StatusServiceUnavailable, fmt.Errorf("the server is shutting down"))
+			return
+		}
+		now := time.Now()
+		if err := s.checkQuota(key, now); err != nil {
+			s.mu.Unlock()
+			writeError(w, http.StatusTooManyRequests, err)
+			return
+		}
+		job.ID = s.nextID
+		s.nextID++
+		job.State = "queued"
+		job.Submitted = now
+		job.log = &jobLog{}
+		job.key = key
+		s.jobs = append(s.jobs, &job)
+		s.persist()
+		created := job
+		s.mu.Unlock()
+		s.nudge()
+		writeJSON(w, http.StatusCreated, created)
+	}))
+	mux.HandleFunc("GET /jobs", s.authorized(func(w http.ResponseWriter, r *http.Request, key *apiKey) {
+		s.mu.Lock()
+		jobs := []serveJob{}
+		for _, job := range s.jobs {
+			if visible(job, key) {
+				jobs = append(jobs, *job)
+			}
+		}
+		s.mu.Unlock()
+		// running and finished jobs first, then the queue in the order the
+		// jobs are picked when nothing holds them
+		sort.SliceStable(jobs, func(i, j int) bool {
+			queuedI, queuedJ := jobs[i].State == "queued", jobs[j].State == "queued"
+			if queuedI != queuedJ {
+				return queuedJ
+			}
+			return queuedI && jobs[i].Priority > jobs[j].Priority
+		})
+		writeJSON(w, http.StatusOK, jobs)
+	}))
+	mux.HandleFunc("GET /jobs/{id}", s.authorized(func(w http.ResponseWriter, r *http.Request, key *apiKey) {
+		s.mu.Lock()
+		job := s.findJob(r, key)
+		var status struct {
+			serveJob
+			Log string `json:"log"`
+		}
+		if job != nil {
+			status.serveJob = *job
+			status.Log = job.log.String()
+		}
+		s.mu.Unlock()
+		if job == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}))
+	mux.HandleFunc("DELETE /jobs/{id}", s.authorized(func(w http.ResponseWriter, r *http.Request, key *apiKey) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		job := s.findJob(r, key)
+		switch {
+		case job == nil:
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		case job.State != "queued":
+			writeError(w, http.StatusConflict, fmt.Errorf("job %d is %s, only queued jobs can be canceled", job.ID, job.State))
+		default:
+			now := time.Now()
+			job.State = "canceled"
+			job.Finished = &now
+			s.persist()
+			writeJSON(w, http.StatusOK, job)
+		}
+	}))
+	mux.HandleFunc("GET /jobs/{id}/outputs/{n}", s.authorized(s.handleOutput))
+	mux.HandleFunc("POST /uploads/{name}", s.authorized(s.handleUpload))
+	mux.HandleFunc("GET /metrics", s.authorized(s.handleMetrics))
+	return mux
+}
+
+// handleOutput sends an output of a succeeded job. The outputs of the jobs
+// submitted with a key are only sent from the uploads directory they are
+// written to.
+func (s *jobServer) handleOutput(w http.ResponseWriter, r *http.Request, key *apiKey) {
+	s.mu.Lock()
+	job := s.findJob(r, key)
+	var outputs []string
+	if job != nil && job.State == "succeeded" && job.Result != nil {
+		outputs = job.Result.Outputs
+	}
+	s.mu.Unlock()
+	if job == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no job %s", r.PathValue("id")))
+		return
+	}
+	n, err := strconv.Atoi(r.PathValue("n"))
+	if err != nil || n < 0 || n >= len(outputs) {
+		writeError(w, http.StatusNotFound, fmt.Errorf("job %d has no output %s", job.ID, r.PathValue("n")))
+		return
+	}
+	path := outputs[n]
+	if key != nil && !withinDir(path, s.UploadDir) {
+		writeError(w, http.StatusForbidden, fmt.Errorf("output %d of job %d isn't in the uploads", n, job.ID))
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("output %d of job %d: %v", n, job.ID, err))
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeContent(w, r, filepath.Base(path), info.ModTime(), file)
+}
+
+const serveUsage = `Usage:
+  <program> serve [addr] [jobs] [jobs-per-user]    run the jobs submitted over HTTP on addr (localhost:8080 by default),
+                                                   jobs at once (1 by default) and jobs-per-user at once for each user (no limit by default)
+
+Jobs are submitted with POST /jobs and a JSON body such as
+  {"args": ["sync", "-bpm", "120", "-video", "video.mp4", "-keyframes", "keyframes.json"], "user": "ana", "priority": 10, "start_at": "2024-05-01T22:00:00Z"}
+the highest priority job whose start time passed runs first. GET /jobs lists
+the jobs, GET /jobs/{id} reports one of them and DELETE /jobs/{id} cancels it.
+GET /jobs/{id}/outputs/{n} downloads the output n, counted from 0 in the
+outputs of the result of the job, once it succeeded. The finished jobs are
+kept for 24 hours.
+POST /uploads/{name} uploads a file and responds with its path on the server.
+GET /metrics reports the jobs by state, the encoding time and the ffmpeg
+failures by class for Prometheus.
+
+The server only listens on localhost unless SYNCTOBEAT_API_KEYS names a JSON
+file of API keys, sent as bearer tokens or in the X-API-Key header:
+  [{"key": "...", "user": "ana", "jobs_per_day": 50, "max_upload": "2GB"}]
+The jobs submitted with a key run the sync, plan, pulse or analyze command,
+with the uploaded files as inputs and the flags tuning the run only: the
+flags writing to arbitrary paths or running other programs are refused.
+
+On SIGTERM or an interrupt, the server stops accepting jobs and waits for the
+running ones to finish, a second signal kills them. The jobs queued or running
+are saved in .syncToBeat_queue.json in the working directory as they change,
+and run after a restart or a crash.`
+
+// runServeCommand runs the HTTP server.
+func runServeCommand(args []string) error {
+	addr := "localhost:8080"
+	limits := []int{1, 0}
+	if len(args) > 0 {
+		if args[0] == "help" {
+			say("usage.serve")
+			return nil
+		}
+		addr = args[0]
+	}
+	for i, arg := range args[min(len(args), 1):] {
+		if i >= len(limits) {
+			say("usage.serve")
+			return fmt.Errorf("too many arguments")
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || (i == 0 && n == 0) {
+			say("usage.serve")
+			return fmt.Errorf("invalid number of jobs %q", arg)
+		}
+		limits[i] = n
+	}
+
+	server := newJobServer(limits[0], limits[1])
+	server.UploadDir = filepath.Join(os.TempDir(), "syncToBeat-uploads")
+	if APIKeysPath != "" {
+		keys, err := readAPIKeys(APIKeysPath)
+		if err != nil {
+			return fmt.Errorf("failed to read the API keys: %v", err)
+		}
+		server.Keys = keys
+	} else if !loopback(addr) {
+		return fmt.Errorf("serving on %s beyond localhost requires API keys, set SYNCTOBEAT_API_KEYS", addr)
+	}
+	server.QueueFile = ServeQueueFile
+	queued, err := server.loadQueue()
+	if err != nil {
+		return fmt.Errorf("failed to read the queue: %v", err)
+	}
+	if queued > 0 {
+		say("serve.restored", queued, ServeQueueFile)
+	}
+
+	httpServer := &http.Server{Addr: addr, Handler: server.handler()}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+	listening := make(chan error, 1)
+	go func() {
+		listening <- httpServer.ListenAndServe()
+	}()
+	go server.schedule()
+	say("serve.start", addr, server.MaxJobs)
+	select {
+	case err := <-listening:
+		return err
+	case <-stop:
+	}
+
+	// the status of the jobs can still be followed while they finish
+	say("serve.draining", server.runningJobs())
+	server.drain(stop)
+	server.mu.Lock()
+	queued, err = server.saveQueue()
+	server.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to save the queue: %v", err)
+	}
+	say("serve.stopped", queued, ServeQueueFile)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return httpServer.Shutdown(ctx)
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextJob(t *testing.T) {
+	now := time.Now()
+	later := now.Add(time.Hour)
+	tests := []struct {
+		name           string
+		maxJobs        int
+		maxJobsPerUser int
+		jobs           []*serveJob
+		wantID         int
+	}{
+		{
+			name:    "oldest first",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "queued"}, {ID: 2, State: "queued"}},
+			wantID:  1,
+		},
+		{
+			name:    "highest priority first",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "queued"}, {ID: 2, State: "queued", Priority: 5}, {ID: 3, State: "queued", Priority: 5}},
+			wantID:  2,
+		},
+		{
+			name:    "finished jobs",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "succeeded"}, {ID: 2, State: "canceled"}, {ID: 3, State: "queued"}},
+			wantID:  3,
+		},
+		{
+			name:    "scheduled later",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "queued", Priority: 5, StartAt: &later}, {ID: 2, State: "queued"}},
+			wantID:  2,
+		},
+		{
+			name:    "scheduled now",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "queued", Priority: 5, StartAt: &now}, {ID: 2, State: "queued"}},
+			wantID:  1,
+		},
+		{
+			name:    "all running",
+			maxJobs: 1,
+			jobs:    []*serveJob{{ID: 1, State: "running"}, {ID: 2, State: "queued"}},
+			wantID:  0,
+		},
+		{
+			name:           "user limit",
+			maxJobs:        2,
+			maxJobsPerUser: 1,
+			jobs:           []*serveJob{{ID: 1, User: "ana", State: "running"}, {ID: 2, User: "ana", State: "queued", Priority: 5}, {ID: 3, User: "ben", State: "queued"}},
+			wantID:         3,
+		},
+		{
+			name:           "all users at their limit",
+			maxJobs:        3,
+			maxJobsPerUser: 1,
+			jobs:           []*serveJob{{ID: 1, User: "ana", State: "running"}, {ID: 2, User: "ana", State: "queued"}},
+			wantID:         0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newJobServer(tt.maxJobs, tt.maxJobsPerUser)
+			server.jobs = tt.jobs
+			id := 0
+			if job := server.nextJob(now); job != nil {
+				id = job.ID
+			}
+			if id != tt.wantID {
+				t.Errorf("nextJob() = job %d, want job %d", id, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestQueueFile(t *testing.T) {
+	queueFile := filepath.Join(t.TempDir(), "queue.json")
+	started := time.Now()
+	server := newJobServer(1, 0)
+	server.QueueFile = queueFile
+	server.jobs = []*serveJob{
+		{ID: 1, User: "ana", State: "succeeded", Args: []string{"sync"}, Keyed: true},
+		{ID: 2, User: "ana", State: "running", Args: []string{"plan"}, Started: &started, Keyed: true},
+		{ID: 3, User: "ben", State: "queued", Args: []string{"pulse"}, Priority: 2, Keyed: true},
+		// queued while the keys were disabled
+		{ID: 4, User: "cleo", State: "queued", Args: []string{"pulse"}},
+		// the effects aren't allowed anymore
+		{ID: 5, User: "ana", State: "queued", Args: []string{"sync", "-effects", "effects.json"}, Keyed: true},
+		{ID: 6, User: "dan", State: "queued", Args: []string{"pulse"}, Keyed: true},
+	}
+	saved, err := server.saveQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 5 {
+		t.Errorf("saveQueue() saved %d jobs, want 5", saved)
+	}
+
+	restarted := newJobServer(1, 0)
+	restarted.QueueFile = queueFile
+	restarted.Keys = []apiKey{{Key: "0123456789abcdef", User: "ana"}, {Key: "fedcba9876543210", User: "ben"}, {Key: "00112233445566778899", User: "cleo"}}
+	loaded, err := restarted.loadQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "loaded jobs", got: loaded, want: 2},
+		{name: "first id", got: restarted.jobs[0].ID, want: 2},
+		{name: "running job queued again", got: restarted.jobs[0].State, want: "queued"},
+		{name: "running job not started", got: restarted.jobs[0].Started == nil, want: true},
+		{name: "key of the user", got: restarted.jobs[0].key == &restarted.Keys[0], want: true},
+		{name: "key of the other user", got: restarted.jobs[1].key == &restarted.Keys[1], want: true},
+		{name: "priority", got: restarted.jobs[1].Priority, want: 2},
+		{name: "args", got: restarted.jobs[1].Args[0], want: "pulse"},
+		{name: "next id", got: restarted.nextID, want: 7},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("loadQueue() %s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	// the file keeps the jobs until they finish, without the dropped ones
+	reloaded := newJobServer(1, 0)
+	reloaded.QueueFile = queueFile
+	if loaded, err := reloaded.loadQueue(); err != nil || loaded != 2 {
+		t.Errorf("loadQueue() of the saved queue = %d, %v, want 2, nil", loaded, err)
+	}
+
+	// without keys, the jobs run as they were submitted
+	if _, err := server.saveQueue(); err != nil {
+		t.Fatal(err)
+	}
+	unkeyed := newJobServer(1, 0)
+	unkeyed.QueueFile = queueFile
+	if loaded, err := unkeyed.loadQueue(); err != nil || loaded != 5 {
+		t.Errorf("loadQueue() without keys = %d, %v, want 5, nil", loaded, err)
+	}
+
+	for _, job := range restarted.jobs {
+		job.State = "canceled"
+	}
+	if saved, err := restarted.saveQueue(); err != nil || saved != 0 {
+		t.Fatalf("saveQueue() = %d, %v, want 0, nil", saved, err)
+	}
+	if _, err := os.Stat(queueFile); !os.IsNotExist(err) {
+		t.Errorf("saveQueue() kept the queue file without jobs: %v", err)
+	}
+	if loaded, err := newJobServer(1, 0).loadQueue(); err != nil || loaded != 0 {
+		t.Errorf("loadQueue() without a file = %d, %v, want 0, nil", loaded, err)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	tests := []struct {
+		name      string
+		result    *runResult
+		err       error
+		canceled  bool
+		wantState string
+	}{
+		{name: "succeeded", result: &runResult{}, wantState: "succeeded"},
+		{name: "failed", err: errors.New("exit status 1"), wantState: "failed"},
+		{name: "canceled by the shutdown", err: errors.New("signal: killed"), canceled: true, wantState: "queued"},
+		// the job finished just before the shutdown, it doesn't run again
+		{name: "succeeded before the shutdown", result: &runResult{}, canceled: true, wantState: "succeeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newJobServer(1, 0)
+			started := time.Now()
+			job := &serveJob{ID: 1, User: "ana", State: "running", Started: &started, Result: tt.result, log: &jobLog{}}
+			server.jobs = []*serveJob{job}
+			if tt.canceled {
+				server.cancel()
+			}
+			server.finish(job, nil, tt.err)
+			if job.State != tt.wantState {
+				t.Errorf("finish() state = %q, want %q", job.State, tt.wantState)
+			}
+			if requeued := job.Started == nil && job.Finished == nil; requeued != (tt.wantState == "queued") {
+				t.Errorf("finish() started = %v, finished = %v", job.Started, job.Finished)
+			}
+		})
+	}
+}
+
+func TestJobOutputs(t *testing.T) {
+	server := newJobServer(1, 0)
+	server.UploadDir = t.TempDir()
+	server.Keys = []apiKey{{Key: "0123456789abcdef", User: "ana"}, {Key: "fedcba9876543210", User: "ben"}}
+	output := filepath.Join(server.UploadDir, "video_synced.mp4")
+	if err := os.WriteFile(output, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.mp4")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	server.jobs = []*serveJob{
+		{ID: 1, User: "ana", State: "succeeded", Result: &runResult{Outputs: []string{output, outside}}},
+		{ID: 2, User: "ana", State: "running", Result: &runResult{Outputs: []string{output}}},
+	}
+	handler := server.handler()
+
+	tests := []struct {
+		name       string
+		key        string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "output", key: "0123456789abcdef", path: "/jobs/1/outputs/0", wantStatus: http.StatusOK, wantBody: "video"},
+		{name: "job of another user", key: "fedcba9876543210", path: "/jobs/1/outputs/0", wantStatus: http.StatusNotFound},
+		{name: "no key", path: "/jobs/1/outputs/0", wantStatus: http.StatusUnauthorized},
+		{name: "past the outputs", key: "0123456789abcdef", path: "/jobs/1/outputs/2", wantStatus: http.StatusNotFound},
+		{name: "outside of the uploads", key: "0123456789abcdef", path: "/jobs/1/outputs/1", wantStatus: http.StatusForbidden},
+		{name: "running job", key: "0123456789abcdef", path: "/jobs/2/outputs/0", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("GET %s = %d, want %d: %s", tt.path, w.Code, tt.wantStatus, w.Body)
+			}
+			if body, _ := io.ReadAll(w.Body); tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("GET %s = %q, want %q", tt.path, body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	now := time.Now()
+	started := now.Add(-finishedJobRetention - 2*time.Hour)
+	expired := now.Add(-finishedJobRetention - time.Hour)
+	recent := now.Add(-time.Hour)
+	server := newJobServer(1, 0)
+	server.jobs = []*serveJob{
+		{ID: 1, State: "succeeded", Started: &started, Finished: &expired, Result: &runResult{Phases: []phaseUsage{{WallSeconds: 3}}}},
+		{ID: 2, State: "failed", Started: &started, Finished: &recent},
+		{ID: 3, State: "canceled", Finished: &expired},
+		{ID: 4, State: "queued"},
+		{ID: 5, State: "running", Started: &started},
+	}
+	server.prune(now)
+
+	var ids []int
+	for _, job := range server.jobs {
+		ids = append(ids, job.ID)
+	}
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != 4 || ids[2] != 5 {
+		t.Errorf("prune() kept jobs %v, want [2 4 5]", ids)
+	}
+	// the pruned jobs still count in the metrics
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "submitted", got: server.pruned.submitted, want: 2},
+		{name: "finished", got: server.pruned.finished, want: 1},
+		{name: "job seconds", got: server.pruned.jobSeconds, want: time.Hour.Seconds()},
+		{name: "encode seconds", got: server.pruned.encodeSeconds, want: 3.0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("prune() %s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
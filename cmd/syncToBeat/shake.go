@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// shakeRate is the number of camera moves per second during a shake.
+const shakeRate = 25
+
+// shakeOptions configures the camera shake triggered on impacts.
+type shakeOptions struct {
+	// On picks the impacts: "downbeats" or "labels" for the labeled
+	// keyframes.
+	On string
+	// Amplitude is the maximum offset of the frame relative to its width,
+	// 0 disables the effect.
+	Amplitude float64
+	// DecayBeats is how long, in beats, the shake takes to settle.
+	DecayBeats float64
+}
+
+// Shake configures the camera shake added to the synced video.
+var Shake = shakeOptions{On: "downbeats", DecayBeats: 1}
+
+// shakeImpacts returns the times, in the synced video, of the impacts
+// triggering a shake.
+func shakeImpacts(grid beatGrid, keyframes []Keyframe, duration float64, on string) ([]float64, error) {
+	var impacts []float64
+	switch on {
+	case "downbeats":
+		beatsPerBar := float64(grid.Meter.BeatsPerBar())
+		for bar := math.Ceil(grid.BeatPosition(0) / beatsPerBar); ; bar++ {
+			t := grid.BeatTime(bar * beatsPerBar)
+			if t >= duration {
+				break
+			}
+			impacts = append(impacts, t)
+		}
+	case "labels":
+		segments, err := planSegments(grid, keyframes)
+		if err != nil {
+			return nil, err
+		}
+		for _, kf := range keyframes {
+			if kf.Label == "" {
+				continue
+			}
+			t, err := keyframeOutputTime(kf.Label, keyframes, segments)
+			if err != nil {
+				return nil, err
+			}
+			impacts = append(impacts, t)
+		}
+		sort.Float64s(impacts)
+	default:
+		return nil, fmt.Errorf("invalid shake trigger %q, expected downbeats or labels", on)
+	}
+	return impacts, nil
+}
+
+// shakeCommands returns the sendcmd script moving the crop@shake filter
+// around after every impact, the moves decaying until the frame settles back
+// in the center after decayBeats. margin is the number of pixels the frame
+// can move in every direction.
+func shakeCommands(grid beatGrid, impacts []float64, decayBeats float64, margin int) string {
+	rng := newRand("shake")
+	var commands strings.Builder
+	for i, impact := range impacts {
+		end := grid.BeatTime(grid.BeatPosition(impact) + decayBeats)
+		if i+1 < len(impacts) {
+			end = min(end, impacts[i+1])
+		}
+		for t := impact; t < end; t += 1.0 / shakeRate {
+			decay := math.Pow(1-(t-impact)/(end-impact), 2)
+			x := margin + int(float64(margin)*decay*(2*rng.Float64()-1))
+			y := margin + int(float64(margin)*decay*(2*rng.Float64()-1))
+			fmt.Fprintf(&commands, "%s crop@shake x %d, crop@shake y %d;\n", seconds(t), x, y)
+		}
+		fmt.Fprintf(&commands, "%s crop@shake x %d, crop@shake y %d;\n", seconds(end), margin, margin)
+	}
+	return commands.String()
+}
+
+// addShakeToVideo shakes the frames of the video on every impact.
+func addShakeToVideo(inputVideoPath string, grid beatGrid, keyframes []Keyframe, outputVideoPath string, opts shakeOptions) error {
+	if opts.Amplitude <= 0 || opts.Amplitude >= 0.5 {
+		return fmt.Errorf("invalid shake amplitude %.2f, expected a fraction of the width below 0.5", opts.Amplitude)
+	}
+	if opts.DecayBeats <= 0 {
+		return fmt.Errorf("invalid shake decay: %.2f beats", opts.DecayBeats)
+	}
+	info, err := probe.ProbeMedia(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %v", err)
+	}
+	video, ok := info.Video()
+	if !ok {
+		return fmt.Errorf("failed to get video dimensions: no video streams found")
+	}
+
+	impacts, err := shakeImpacts(grid, keyframes, info.Duration, opts.On)
+	if err != nil {
+		return err
+	}
+	if len(impacts) == 0 {
+		return fmt.Errorf("no impacts to shake the video on")
+	}
+
+	margin := max(int(float64(video.Width)*opts.Amplitude), 1)
+	commandsPath, err := writeFilterCommands("shake", shakeCommands(grid, impacts, opts.DecayBeats, margin))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(commandsPath)
+
+	filterComplex := fmt.Sprintf(
+		"[0:v]sendcmd=f=%s,crop@shake=w=%d:h=%d:x=%d:y=%[4]d,scale=%d:%d,setsar=1[output]",
+		escapeFilterText(commandsPath), (video.Width-2*margin)&^1, (video.Height-2*margin)&^1, margin, video.Width, video.Height,
+	)
+
+	cmdArgs := []string{
+		"-y",
+		"-i", inputVideoPath,
+		"-filter_complex", filterComplex,
+		"-map", "[output]",
+		"-map", "0:a?",
+		"-c:a", "copy",
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("shake.start", len(impacts), inputVideoPath)
+	if err := runFFmpeg("shake", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ServeQueueFile keeps the queued jobs of the server across restarts, in the
+// working directory of the server.
+const ServeQueueFile = ".syncToBeat_queue.json"
+
+// drain stops the server from starting and accepting jobs, and waits for the
+// running jobs to finish. A signal on abort kills them instead, and queues
+// them again so that they run after a restart.
+func (s *jobServer) drain(abort <-chan os.Signal) {
+	s.mu.Lock()
+	s.draining = true
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-abort:
+		s.cancel()
+		<-done
+	}
+}
+
+// runningJobs returns the number of jobs running.
+func (s *jobServer) runningJobs() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	running := 0
+	for _, job := range s.jobs {
+		if job.State == "running" {
+			running++
+		}
+	}
+	return running
+}
+
+// saveQueue writes the jobs queued or running to the queue file, if any, so
+// that they run again after a restart or a crash, removing it when no job is
+// left. It returns the number of jobs saved. The caller holds the lock.
+func (s *jobServer) saveQueue() (int, error) {
+	if s.QueueFile == "" {
+		return 0, nil
+	}
+	pending := []serveJob{}
+	for _, job := range s.jobs {
+		if job.State == "queued" || job.State == "running" {
+			pending = append(pending, *job)
+		}
+	}
+	if len(pending) == 0 {
+		if err := os.Remove(s.QueueFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, err
+		}
+		return 0, nil
+	}
+	data, err := json.MarshalIndent(pending, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	// a crash while writing doesn't lose the queue saved before
+	if err := os.WriteFile(s.QueueFile+".tmp", data, 0644); err != nil {
+		return 0, err
+	}
+	return len(pending), os.Rename(s.QueueFile+".tmp", s.QueueFile)
+}
+
+// persist saves the queue after a change, the server goes on when it can't.
+// The caller holds the lock.
+func (s *jobServer) persist() {
+	if _, err := s.saveQueue(); err != nil {
+		warn(WarnQueue, s.QueueFile, err)
+	}
+}
+
+// loadQueue queues the jobs saved by the previous server, if any, the ones
+// that were running included. The file keeps them until they finish. The
+// jobs keep their ids and count towards the quotas of the keys of their
+// users. With API keys, the jobs submitted without one, by users without a
+// key or whose arguments aren't allowed anymore are dropped.
+func (s *jobServer) loadQueue() (int, error) {
+	data, err := os.ReadFile(s.QueueFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var queued []serveJob
+	if err := json.Unmarshal(data, &queued); err != nil {
+		return 0, fmt.Errorf("%s: %v", s.QueueFile, err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	loaded := 0
+	for i := range queued {
+		job := &queued[i]
+		job.State = "queued"
+		job.Started = nil
+		job.log = &jobLog{}
+		for k := range s.Keys {
+			if s.Keys[k].User == job.User {
+				job.key = &s.Keys[k]
+				break
+			}
+		}
+		s.nextID = max(s.nextID, job.ID+1)
+		if err := s.checkQueuedJob(job); err != nil {
+			warn(WarnQueuedJobDropped, job.ID, job.User, err)
+			continue
+		}
+		s.jobs = append(s.jobs, job)
+		loaded++
+	}
+	if loaded < len(queued) {
+		s.persist()
+	}
+	return loaded, nil
+}
+
+// checkQueuedJob checks that a job saved in the queue can run with the API
+// keys of the server, as when it's submitted.
+func (s *jobServer) checkQueuedJob(job *serveJob) error {
+	if s.Keys == nil {
+		return nil
+	}
+	if !job.Keyed {
+		return fmt.Errorf("submitted without an API key")
+	}
+	if job.key == nil {
+		return fmt.Errorf("no API key of user %s", job.User)
+	}
+	return checkJobArgs(job.Args, s.UploadDir)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// impulseWindow is the duration, in seconds, of the windows used to
+	// measure the audio energy when looking for claps.
+	impulseWindow = 0.01
+	// impulseRatio is how many times louder than the surrounding audio a
+	// window needs to be to count as a clap.
+	impulseRatio = 8
+	// flashThreshold is how much brighter (in average luma) than the median
+	// frame a frame needs to be to count as a flash.
+	flashThreshold = 60
+)
+
+// detectImpulses returns the times, in seconds, of the clap-like events found
+// in the samples: short windows much louder than the second around them.
+func detectImpulses(samples []float64, sampleRate int) []float64 {
+	windowSize := int(impulseWindow * float64(sampleRate))
+	if windowSize < 1 {
+		windowSize = 1
+	}
+	windowCount := len(samples) / windowSize
+	energies := make([]float64, windowCount)
+	for i := range energies {
+		energies[i] = energy(samples[i*windowSize:(i+1)*windowSize]) / float64(windowSize)
+	}
+
+	// compare each window to the average of the second around it
+	context := int(1 / impulseWindow)
+	var impulses []float64
+	lastImpulse := -context
+	for i, e := range energies {
+		from := max(0, i-context)
+		to := min(windowCount, i+context+1)
+		var surrounding float64
+		for j := from; j < to; j++ {
+			if j < i-1 || j > i+1 {
+				surrounding += energies[j]
+			}
+		}
+		surrounding /= math.Max(1, float64(to-from-3))
+
+		// only keep the first window of an impulse
+		if e > surrounding*impulseRatio && e > 1e-4 && i-lastImpulse > context/4 {
+			impulses = append(impulses, float64(i*windowSize)/float64(sampleRate))
+			lastImpulse = i
+		}
+	}
+	return impulses
+}
+
+// detectFlashFrames returns the times, in seconds, of the frames much brighter
+// than the rest of the video, like a camera flash or a light slate. The result
+// is cached in the store.
+func detectFlashFrames(videoPath string) ([]float64, error) {
+	var flashes []float64
+	err := cachedAnalysis("flash-frames", videoPath, &flashes, func() (err error) {
+		flashes, err = analyzeFlashFrames(videoPath)
+		return err
+	})
+	return flashes, err
+}
+
+// analyzeFlashFrames measures the brightness of the frames for
+// detectFlashFrames.
+func analyzeFlashFrames(videoPath string) ([]float64, error) {
+	ffmpegPath, err := checkFFmpegAvailable()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not available: %v", err)
+	}
+
+	cmdArgs := []string{
+		"-v", "error",
+		"-i", videoPath,
+		"-an",
+		"-vf", "scale=160:-2,signalstats,metadata=print:key=lavfi.signalstats.YAVG:file=-",
+		"-f", "null", "-",
+	}
+	cmd := exec.Command(ffmpegPath, cmdArgs...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if Debug {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("error analyzing the frames of %s: %v", videoPath, err)
+	}
+
+	// the metadata filter prints a "frame:N pts:N pts_time:T" line followed by
+	// the requested key=value lines.
+	var times, lumas []float64
+	var currentTime float64
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if idx := strings.Index(line, "pts_time:"); idx >= 0 {
+			currentTime, _ = strconv.ParseFloat(strings.Fields(line[idx+len("pts_time:"):])[0], 64)
+			continue
+		}
+		if value, ok := strings.CutPrefix(line, "lavfi.signalstats.YAVG="); ok {
+			luma, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			times = append(times, currentTime)
+			lumas = append(lumas, luma)
+		}
+	}
+	if len(lumas) == 0 {
+		return nil, fmt.Errorf("no frames analyzed in %s", videoPath)
+	}
+
+	sorted := append([]float64(nil), lumas...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var flashes []float64
+	for i, luma := range lumas {
+		if luma-median > flashThreshold && (i == 0 || lumas[i-1]-median <= flashThreshold) {
+			flashes = append(flashes, times[i])
+		}
+	}
+	return flashes, nil
+}
+
+// detectSlate returns the time of the first slate event in the given media
+// file, source is either "audio" to look for a clap or "video" to look for a
+// flash frame.
+func detectSlate(mediaPath string, source string) (float64, error) {
+	var events []float64
+	switch source {
+	case "audio":
+		samples, err := decodeAudio(mediaPath, alignmentSampleRate, alignmentMaxDuration)
+		if err != nil {
+			return 0, err
+		}
+		events = detectImpulses(samples, alignmentSampleRate)
+	case "video":
+		var err error
+		events, err = detectFlashFrames(mediaPath)
+		if err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown slate source %q, expected audio or video", source)
+	}
+
+	if len(events) == 0 {
+		return 0, fmt.Errorf("no slate found in %s", mediaPath)
+	}
+	return events[0], nil
+}
+
+// alignAnglesOnSlate sets the offset of every camera angle so that its slate
+// lands at slateAt seconds in the music.
+func alignAnglesOnSlate(angles []CameraAngle, source string, slateAt float64) error {
+	for i := range angles {
+		slateTime, err := detectSlate(angles[i].Path, source)
+		if err != nil {
+			return err
+		}
+		angles[i].Offset = slateTime - slateAt
+		say("slate.detected", i, angles[i].Path, slateTime, angles[i].Offset)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// slideshowFPS is the frame rate of the generated slideshows.
+const slideshowFPS = 30
+
+// slideshowOptions holds the settings of a photo slideshow.
+type slideshowOptions struct {
+	// PhotoBeats is the number of beats each photo stays on screen.
+	PhotoBeats int
+	// Transition is the name of the transition between photos and
+	// TransitionBeats its duration in beats, 0 for hard cuts.
+	Transition      string
+	TransitionBeats float64
+	Width           int
+	Height          int
+}
+
+// listPhotos returns the sorted paths of the pictures found in dir.
+func listPhotos(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var photos []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(entry.Name())) {
+		case ".jpg", ".jpeg", ".png", ".webp", ".bmp", ".tif", ".tiff":
+			photos = append(photos, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(photos) == 0 {
+		return nil, fmt.Errorf("no photos found in %s", dir)
+	}
+	sort.Strings(photos)
+	return photos, nil
+}
+
+// kenBurnsFilter returns a zoompan filter slowly zooming in on the photo for
+// the given number of frames, panning in a direction picked from the index of
+// the photo so consecutive photos move differently.
+func kenBurnsFilter(index int, frames int, width, height int) string {
+	zoom := fmt.Sprintf("1+0.2*on/%d", frames)
+	var x, y string
+	switch index % 4 {
+	case 0: // zoom on the center
+		x, y = "iw/2-(iw/zoom/2)", "ih/2-(ih/zoom/2)"
+	case 1: // pan left to right
+		x, y = fmt.Sprintf("(iw-iw/zoom)*on/%d", frames), "ih/2-(ih/zoom/2)"
+	case 2: // pan top to bottom
+		x, y = "iw/2-(iw/zoom/2)", fmt.Sprintf("(ih-ih/zoom)*on/%d", frames)
+	default: // pan right to left
+		x, y = fmt.Sprintf("(iw-iw/zoom)*(1-on/%d)", frames), "ih/2-(ih/zoom/2)"
+	}
+	return fmt.Sprintf("zoompan=z='%s':x='%s':y='%s':d=%d:s=%dx%d:fps=%d", zoom, x, y, frames, width, height, slideshowFPS)
+}
+
+// syncSlideshow renders the photos found in photosDir as a slideshow where
+// each photo lasts exactly opts.PhotoBeats beats of the grid, with Ken Burns
+// pans and transitions starting on the beat.
+func syncSlideshow(photosDir string, grid beatGrid, audioPath string, outputPath string, opts slideshowOptions) error {
+	if opts.PhotoBeats < 1 {
+		return fmt.Errorf("invalid number of beats per photo: %d", opts.PhotoBeats)
+	}
+	if opts.TransitionBeats < 0 || opts.TransitionBeats >= float64(opts.PhotoBeats) {
+		return fmt.Errorf("the transitions must be shorter than the photos")
+	}
+	if opts.TransitionBeats > 0 {
+		if err := validateTransition(opts.Transition); err != nil {
+			return err
+		}
+	}
+
+	photos, err := listPhotos(photosDir)
+	if err != nil {
+		return err
+	}
+	// photos are RGB, they're converted with the matrix players assume for
+	// the size of the slideshow
+	SourceColor = guessColor(opts.Height)
+
+	// the photo i starts on the beat i*PhotoBeats, each photo but the last
+	// lasts a little longer to blend with the next one during the transition
+	starts := make([]float64, len(photos)+1)
+	for i := range starts {
+		starts[i] = grid.BeatTime(float64(i * opts.PhotoBeats))
+	}
+	totalDuration := starts[len(photos)] - starts[0]
+
+	var filterComplexParts []string
+	for i := range photos {
+		clipDuration := starts[i+1] - starts[i]
+		var transitionDuration float64
+		if i < len(photos)-1 && opts.TransitionBeats > 0 {
+			transitionDuration = grid.BeatTime(float64((i+1)*opts.PhotoBeats)+opts.TransitionBeats) - starts[i+1]
+			clipDuration += transitionDuration
+		}
+		frames := int(clipDuration*slideshowFPS + 0.5)
+		filterComplexParts = append(filterComplexParts, fmt.Sprintf(
+			"[%d:v]scale=%d:%d:force_original_aspect_ratio=increase,crop=%[2]d:%[3]d,%s,%s,trim=duration=%s,setpts=PTS-STARTPTS,setsar=1[p%d]",
+			i, opts.Width*2, opts.Height*2, colorConvertFilter(SourceColor, "yuv420p"), kenBurnsFilter(i, frames, opts.Width, opts.Height), seconds(clipDuration), i,
+		))
+	}
+
+	// chain the photos, the transitions start on the first beat of the next
+	// photo
+	last := "p0"
+	for i := 1; i < len(photos); i++ {
+		out := fmt.Sprintf("x%d", i)
+		if opts.TransitionBeats > 0 {
+			transitionDuration := grid.BeatTime(float64(i*opts.PhotoBeats)+opts.TransitionBeats) - starts[i]
+			filter, err := transitionFilter(opts.Transition, last, fmt.Sprintf("p%d", i), out, starts[i]-starts[0], transitionDuration)
+			if err != nil {
+				return err
+			}
+			filterComplexParts = append(filterComplexParts, filter)
+		} else {
+			filterComplexParts = append(filterComplexParts, fmt.Sprintf("[%s][p%d]concat=n=2:v=1:a=0[%s]", last, i, out))
+		}
+		last = out
+	}
+	filterComplexParts = append(filterComplexParts, fmt.Sprintf("[%s]null%s[outv]", last, videoOffsetFilter()))
+	filterComplex := strings.Join(filterComplexParts, "; ")
+
+	cmdArgs := []string{"-y"}
+	for _, photo := range photos {
+		cmdArgs = append(cmdArgs, "-i", photo)
+	}
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, audioOffsetArgs()...)
+		cmdArgs = append(cmdArgs, "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", filterComplex,
+		"-map", "[outv]",
+	)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", fmt.Sprintf("%d:a", len(photos)))
+		cmdArgs = append(cmdArgs, audioMuxArgs()...)
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputPath)...)
+	cmdArgs = append(cmdArgs,
+		"-r", fmt.Sprintf("%d", slideshowFPS),
+		"-t", seconds(totalDuration+max(AVOffset, 0)).timestamp(),
+		outputPath,
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("slideshow.start", len(photos), opts.PhotoBeats, totalDuration)
+	if err := runFFmpeg("slideshow", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	say("slideshow.saved", outputPath)
+	recordOutput(outputPath)
+
+	return nil
+}
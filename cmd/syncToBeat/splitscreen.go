@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// splitScreenOptions holds the settings of the split screen between the
+// synced video and a second video.
+type splitScreenOptions struct {
+	// With is the second video, empty disables the split screen.
+	With string
+	// Layout splits the frame in left and right halves (lr) or top and
+	// bottom halves (tb).
+	Layout string
+	// Every is how often the layout changes: beat or bar.
+	Every string
+	// Mode is how it changes: swap exchanges the halves, wipe sweeps the
+	// second video across the frame and back.
+	Mode string
+}
+
+// SplitScreen is the split screen of the synced video.
+var SplitScreen = splitScreenOptions{Layout: "lr", Every: "beat", Mode: "swap"}
+
+// validateSplitScreen checks the split screen settings.
+func validateSplitScreen(opts splitScreenOptions) error {
+	if opts.Layout != "lr" && opts.Layout != "tb" {
+		return fmt.Errorf("invalid split screen layout %q, expected lr or tb", opts.Layout)
+	}
+	if opts.Every != "beat" && opts.Every != "bar" {
+		return fmt.Errorf("invalid split screen change %q, expected beat or bar", opts.Every)
+	}
+	if opts.Mode != "swap" && opts.Mode != "wipe" {
+		return fmt.Errorf("invalid split screen mode %q, expected swap or wipe", opts.Mode)
+	}
+	return nil
+}
+
+// splitScreenFilter returns the filter graph laying out the inputs [a] and
+// [b], both width x height, into [output]. The halves show the center of
+// each video.
+func splitScreenFilter(grid beatGrid, opts splitScreenOptions, width, height int) string {
+	every := 1.0
+	if opts.Every == "bar" {
+		every = float64(grid.Meter.BeatsPerBar())
+	}
+
+	if opts.Mode == "wipe" {
+		// the second video covers the frame up to the divider, which moves
+		// across the frame over a change and back over the next one
+		position := positionExpression(grid, "T")
+		progress := fmt.Sprintf("if(mod(floor((%[1]s)/%[2]g),2),1-mod(%[1]s,%[2]g)/%[2]g,mod(%[1]s,%[2]g)/%[2]g)", position, every)
+		coordinate := "X/W"
+		if opts.Layout == "tb" {
+			coordinate = "Y/H"
+		}
+		return fmt.Sprintf("[a][b]blend=all_expr='if(lt(%s,%s),B,A)'[output]", coordinate, progress)
+	}
+
+	halfCrop, stack := fmt.Sprintf("crop=%d:%d:%d:0", width/2&^1, height, width/4), "hstack"
+	if opts.Layout == "tb" {
+		halfCrop, stack = fmt.Sprintf("crop=%d:%d:0:%d", width, height/2&^1, height/4), "vstack"
+	}
+	// the halves swap on every other change
+	swapped := fmt.Sprintf("mod(floor((%s)/%g),2)", positionExpression(grid, "t"), every)
+	return strings.Join([]string{
+		fmt.Sprintf("[a]%s,split[a1][a2]", halfCrop),
+		fmt.Sprintf("[b]%s,split[b1][b2]", halfCrop),
+		fmt.Sprintf("[a1][b1]%s[ab]", stack),
+		fmt.Sprintf("[b2][a2]%s[ba]", stack),
+		fmt.Sprintf("[ab][ba]overlay=enable='%s'[output]", swapped),
+	}, "; ")
+}
+
+// addSplitScreen lays out the synced video and the second video of opts side
+// by side, changing the layout on the beats or bars of the grid.
+func addSplitScreen(inputVideoPath string, grid beatGrid, opts splitScreenOptions, outputVideoPath string) error {
+	dimensions, err := getVideoDimensions(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video dimensions: %v", err)
+	}
+	duration, err := getVideoDuration(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to get video duration: %v", err)
+	}
+
+	filterComplex := fmt.Sprintf(
+		"[0:v]setsar=1[a]; [1:v]scale=%d:%d:force_original_aspect_ratio=increase,crop=%[1]d:%[2]d,setsar=1,setpts=PTS-STARTPTS[b]; %s",
+		dimensions.Width, dimensions.Height, splitScreenFilter(grid, opts, dimensions.Width, dimensions.Height),
+	)
+
+	cmdArgs := []string{
+		"-y",
+		"-i", inputVideoPath,
+		"-i", opts.With,
+		"-filter_complex", filterComplex,
+		"-map", "[output]",
+		"-map", "0:a?",
+		"-c:a", "copy",
+		"-t", seconds(duration).timestamp(),
+	}
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs, outputVideoPath)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("split.start", opts.With, inputVideoPath)
+	if err := runFFmpeg("split", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
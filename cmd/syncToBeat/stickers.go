@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// stickerOptions configures the sticker bursts popping on the beats.
+type stickerOptions struct {
+	// Dir is the folder of PNG stickers, the effect is disabled when empty.
+	Dir string
+	// Every is the number of beats between bursts.
+	Every int
+	// Count is the number of stickers popping in each burst.
+	Count int
+	// Size is the width of the stickers relative to the width of the video.
+	Size float64
+}
+
+// Stickers configures the sticker bursts added to the synced video.
+var Stickers = stickerOptions{Every: 4, Count: 1, Size: 0.2}
+
+// stickerBurst is a sticker popping on the video.
+type stickerBurst struct {
+	Sticker int
+	Start   float64
+	End     float64
+	// X and Y are the position of the sticker relative to the free space
+	// around it, between 0 and 1.
+	X float64
+	Y float64
+}
+
+// listStickers returns the sorted paths of the PNG stickers found in dir.
+func listStickers(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var stickers []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".png") {
+			stickers = append(stickers, filepath.Join(dir, entry.Name()))
+		}
+	}
+	if len(stickers) == 0 {
+		return nil, fmt.Errorf("no PNG stickers found in %s", dir)
+	}
+	sort.Strings(stickers)
+	return stickers, nil
+}
+
+// planStickerBursts picks where and which stickers pop every opts.Every beats
+// of the grid, each sticker staying on screen for half a beat.
+func planStickerBursts(grid beatGrid, duration float64, stickerCount int, opts stickerOptions) []stickerBurst {
+	rng := newRand("stickers")
+	var bursts []stickerBurst
+	for beat := 0; ; beat += opts.Every {
+		start := grid.BeatTime(float64(beat))
+		if start >= duration {
+			break
+		}
+		if start < 0 {
+			continue
+		}
+		end := min(grid.BeatTime(float64(beat)+0.5), duration)
+		for i := 0; i < opts.Count; i++ {
+			bursts = append(bursts, stickerBurst{
+				Sticker: rng.Intn(stickerCount),
+				Start:   start,
+				End:     end,
+				X:       rng.Float64(),
+				Y:       rng.Float64(),
+			})
+		}
+	}
+	return bursts
+}
+
+// addStickersToVideo overlays stickers popping on the beats of the grid.
+func addStickersToVideo(inputVideoPath string, grid beatGrid, outputVideoPath string, opts stickerOptions) error {
+	if opts.Every < 1 || opts.Count < 1 {
+		return fmt.Errorf("invalid sticker bursts: %d stickers every %d beats", opts.Count, opts.Every)
+	}
+	if opts.Size <= 0 || opts.Size > 1 {
+		return fmt.Errorf("invalid sticker size %.2f, expected a fraction of the video width", opts.Size)
+	}
+	stickers, err := listStickers(opts.Dir)
+	if err != nil {
+		return err
+	}
+	info, err := probe.ProbeMedia(inputVideoPath)
+	if err != nil {
+		return fmt.Errorf("failed to probe video: %v", err)
+	}
+	video, ok := info.Video()
+	if !ok {
+		return fmt.Errorf("failed to get video dimensions: no video streams found")
+	}
+
+	bursts := planStickerBursts(grid, info.Duration, len(stickers), opts)
+	if len(bursts) == 0 {
+		return fmt.Errorf("no beats to pop stickers on")
+	}
+
+	// every use of a sticker needs its own copy of the input stream
+	uses := make([][]string, len(stickers))
+	labels := make([]string, len(bursts))
+	for i, burst := range bursts {
+		labels[i] = fmt.Sprintf("[s%d_%d]", burst.Sticker, len(uses[burst.Sticker]))
+		uses[burst.Sticker] = append(uses[burst.Sticker], labels[i])
+	}
+
+	width := int(float64(video.Width) * opts.Size)
+	var filterComplexParts []string
+	for i, stickerUses := range uses {
+		if len(stickerUses) == 0 {
+			continue
+		}
+		filterComplexParts = append(filterComplexParts, fmt.Sprintf(
+			"[%d:v]scale=%d:-1,format=rgba,split=%d%s", i+1, width, len(stickerUses), strings.Join(stickerUses, ""),
+		))
+	}
+	last := "[0:v]"
+	for i, burst := range bursts {
+		popped := fmt.Sprintf("[p%d]", i)
+		out := fmt.Sprintf("[o%d]", i)
+		filterComplexParts = append(filterComplexParts,
+			fmt.Sprintf("%sfade=in:st=%s:d=0.05:alpha=1,fade=out:st=%s:d=0.05:alpha=1%s", labels[i], seconds(burst.Start), seconds(max(burst.End-0.05, burst.Start)), popped),
+			fmt.Sprintf("%s%soverlay=x=(W-w)*%s:y=(H-h)*%s:enable='between(t,%s,%s)'%s", last, popped, exact(burst.X), exact(burst.Y), seconds(burst.Start), seconds(burst.End), out),
+		)
+		last = out
+	}
+	filterComplexParts = append(filterComplexParts, last+"null[output]")
+
+	cmdArgs := []string{"-y", "-i", inputVideoPath}
+	for _, sticker := range stickers {
+		cmdArgs = append(cmdArgs, "-loop", "1", "-i", sticker)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", strings.Join(filterComplexParts, "; "),
+		"-map", "[output]",
+		"-map", "0:a?",
+		"-c:a", "copy",
+	)
+	cmdArgs = append(cmdArgs, encoderArgs()...)
+	cmdArgs = append(cmdArgs, containerArgs(outputVideoPath)...)
+	cmdArgs = append(cmdArgs,
+		"-t", seconds(info.Duration).timestamp(),
+		outputVideoPath,
+	)
+
+	if Debug {
+		log.Println("Running FFmpeg with arguments:", cmdArgs)
+	}
+
+	say("stickers.start", len(bursts), inputVideoPath)
+	if err := runFFmpeg("stickers", cmdArgs); err != nil {
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+	recordOutput(outputVideoPath)
+
+	return nil
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// StorePath is the SQLite database keeping the analysis caches, the history
+// of the runs and the state of the batches of renders, empty disables it. It
+// defaults to the SYNCTOBEAT_STORE environment variable so that the
+// subcommands use it too.
+var StorePath = os.Getenv("SYNCTOBEAT_STORE")
+
+// storeSchema creates the tables of the store.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS analyses (
+	kind TEXT NOT NULL,
+	path TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	result TEXT NOT NULL,
+	created TEXT NOT NULL,
+	PRIMARY KEY (kind, path)
+);
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	time TEXT NOT NULL,
+	args TEXT NOT NULL,
+	outputs TEXT NOT NULL,
+	warnings INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS jobs (
+	batch TEXT NOT NULL,
+	item TEXT NOT NULL,
+	output TEXT NOT NULL,
+	finished TEXT NOT NULL,
+	PRIMARY KEY (batch, item)
+);
+`
+
+// storeTables are the tables of the store, as cleared by the store command.
+var storeTables = []string{"analyses", "runs", "jobs"}
+
+var storeSchemaOnce sync.Once
+var storeSchemaErr error
+
+// checkSQLiteAvailable returns the path of the sqlite3 executable, found in
+// the PATH or set with the SQLITE3_PATH environment variable.
+func checkSQLiteAvailable() (string, error) {
+	sqlitePath, err := probe.FindExecutable("sqlite3", "SQLITE3_PATH")
+	if err != nil {
+		return "", fmt.Errorf("sqlite3 is not available: %v", err)
+	}
+	return sqlitePath, nil
+}
+
+// sqlString quotes a string for a SQL statement.
+func sqlString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// storeExec runs the SQL statements on the store with the sqlite3 executable,
+// decoding the rows of the last query into rows when it isn't nil.
+func storeExec(statements string, rows any) error {
+	storeSchemaOnce.Do(func() {
+		storeSchemaErr = runSQLite(storeSchema, nil)
+	})
+	if storeSchemaErr != nil {
+		return storeSchemaErr
+	}
+	return runSQLite(statements, rows)
+}
+
+func runSQLite(statements string, rows any) error {
+	sqlitePath, err := checkSQLiteAvailable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(sqlitePath, "-batch", "-bail", "-json", StorePath)
+	// concurrent runs share the store, wait for their writes to finish
+	cmd.Stdin = strings.NewReader(".timeout 5000\n" + statements)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("store %s: %v %s", StorePath, err, strings.TrimSpace(stderr.String()))
+	}
+	if rows == nil || len(bytes.TrimSpace(output)) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(output, rows); err != nil {
+		return fmt.Errorf("store %s: %v", StorePath, err)
+	}
+	return nil
+}
+
+// fileFingerprint identifies the version of a file by its size and
+// modification time.
+func fileFingerprint(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+// cachedAnalysis fills result with the analysis of the file cached in the
+// store, or runs analyze, which fills result, and caches it. The analysis
+// runs uncached without a store or when the store fails.
+func cachedAnalysis(kind string, path string, result any, analyze func() error) error {
+	if StorePath == "" {
+		return analyze()
+	}
+	fingerprint, err := fileFingerprint(path)
+	if err != nil {
+		return analyze()
+	}
+	key := sqlString(kind) + " AND path = " + sqlString(path)
+
+	var rows []struct {
+		Fingerprint string `json:"fingerprint"`
+		Result      string `json:"result"`
+	}
+	err = storeExec("SELECT fingerprint, result FROM analyses WHERE kind = "+key+";", &rows)
+	if err == nil && len(rows) == 1 && rows[0].Fingerprint == fingerprint {
+		if json.Unmarshal([]byte(rows[0].Result), result) == nil {
+			return nil
+		}
+	}
+
+	if err := analyze(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil
+	}
+	err = storeExec(fmt.Sprintf(
+		"INSERT OR REPLACE INTO analyses (kind, path, fingerprint, result, created) VALUES (%s, %s, %s, %s, %s);",
+		sqlString(kind), sqlString(path), sqlString(fingerprint), sqlString(string(data)), sqlString(time.Now().Format(time.RFC3339)),
+	), nil)
+	if err != nil {
+		warn(WarnStore, err)
+	}
+	return nil
+}
+
+// storeAppendRun records a run of the history in the store.
+func storeAppendRun(entry historyEntry) error {
+	args, err := json.Marshal(entry.Args)
+	if err != nil {
+		return err
+	}
+	outputs, err := json.Marshal(entry.Outputs)
+	if err != nil {
+		return err
+	}
+	return storeExec(fmt.Sprintf(
+		"INSERT INTO runs (time, args, outputs, warnings) VALUES (%s, %s, %s, %d);",
+		sqlString(entry.Time.Format(time.RFC3339Nano)), sqlString(string(args)), sqlString(string(outputs)), entry.Warnings,
+	), nil)
+}
+
+// storeRuns returns the runs of the history recorded in the store, oldest
+// first.
+func storeRuns() ([]historyEntry, error) {
+	var rows []struct {
+		Time     string `json:"time"`
+		Args     string `json:"args"`
+		Outputs  string `json:"outputs"`
+		Warnings int    `json:"warnings"`
+	}
+	if err := storeExec("SELECT time, args, outputs, warnings FROM runs ORDER BY id;", &rows); err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	for _, row := range rows {
+		entry := historyEntry{Warnings: row.Warnings}
+		var err error
+		if entry.Time, err = time.Parse(time.RFC3339Nano, row.Time); err != nil {
+			return nil, fmt.Errorf("store %s: %v", StorePath, err)
+		}
+		if err := json.Unmarshal([]byte(row.Args), &entry.Args); err != nil {
+			return nil, fmt.Errorf("store %s: %v", StorePath, err)
+		}
+		if err := json.Unmarshal([]byte(row.Outputs), &entry.Outputs); err != nil {
+			return nil, fmt.Errorf("store %s: %v", StorePath, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// renderBatch holds the intermediate renders of a batch, such as the
+// previews of a sweep. With a store, the batch is named after the complete
+// command line and the finished items are recorded so that running the same
+// command again after an interruption resumes it.
+type renderBatch struct {
+	Kind string
+	// Dir holds the intermediate files of the batch.
+	Dir       string
+	name      string
+	resumable bool
+}
+
+// startBatch creates the directory of a batch of renders.
+func startBatch(kind string) (*renderBatch, error) {
+	batch := &renderBatch{Kind: kind}
+	if StorePath == "" || runArgs == nil {
+		dir, err := os.MkdirTemp("", "syncToBeat-"+kind+"-*")
+		if err != nil {
+			return nil, err
+		}
+		batch.Dir = dir
+		return batch, nil
+	}
+	sum := sha256.Sum256([]byte(kind + "\x00" + strings.Join(runArgs, "\x00")))
+	batch.name = hex.EncodeToString(sum[:8])
+	batch.Dir = filepath.Join(os.TempDir(), "syncToBeat-"+kind+"-"+batch.name)
+	batch.resumable = true
+	return batch, os.MkdirAll(batch.Dir, 0755)
+}
+
+// finished returns the output of an item recorded as finished by a previous
+// run of the batch, when it's still there.
+func (b *renderBatch) finished(item string) (string, bool) {
+	if !b.resumable {
+		return "", false
+	}
+	var rows []struct {
+		Output string `json:"output"`
+	}
+	err := storeExec("SELECT output FROM jobs WHERE batch = "+sqlString(b.name)+" AND item = "+sqlString(item)+";", &rows)
+	if err != nil || len(rows) != 1 {
+		return "", false
+	}
+	if _, err := os.Stat(rows[0].Output); err != nil {
+		return "", false
+	}
+	say("batch.resumed", b.Kind, item)
+	return rows[0].Output, true
+}
+
+// finish records an item of the batch as finished.
+func (b *renderBatch) finish(item string, output string) {
+	if !b.resumable {
+		return
+	}
+	err := storeExec(fmt.Sprintf(
+		"INSERT OR REPLACE INTO jobs (batch, item, output, finished) VALUES (%s, %s, %s, %s);",
+		sqlString(b.name), sqlString(item), sqlString(output), sqlString(time.Now().Format(time.RFC3339)),
+	), nil)
+	if err != nil {
+		warn(WarnStore, err)
+	}
+}
+
+// close removes the intermediate files once the batch succeeded. A failed
+// resumable batch keeps them for the next run.
+func (b *renderBatch) close(succeeded bool) {
+	if b.resumable && !succeeded {
+		return
+	}
+	os.RemoveAll(b.Dir)
+	if b.resumable {
+		if err := storeExec("DELETE FROM jobs WHERE batch = "+sqlString(b.name)+";", nil); err != nil {
+			warn(WarnStore, err)
+		}
+	}
+}
+
+const storeUsage = `Usage:
+  <program> store info              count the rows of each table of the store
+  <program> store clear [table...]  empty tables of the store (analyses, runs or jobs, all by default)
+
+The store is set with -store before the subcommand, as in
+<program> -store runs.db store info, or with the SYNCTOBEAT_STORE environment
+variable. It's a SQLite database that can also be queried with sqlite3.`
+
+// runStoreCommand runs the store subcommands.
+func runStoreCommand(args []string) error {
+	if len(args) == 0 {
+		say("usage.store")
+		return nil
+	}
+	if StorePath == "" {
+		return fmt.Errorf("no store set, set -store before the subcommand or the SYNCTOBEAT_STORE environment variable")
+	}
+
+	switch args[0] {
+	case "info":
+		for _, table := range storeTables {
+			var rows []struct {
+				Count int `json:"count"`
+			}
+			if err := storeExec("SELECT COUNT(*) AS count FROM "+table+";", &rows); err != nil {
+				return err
+			}
+			if len(rows) == 1 {
+				say("store.table", table, rows[0].Count)
+			}
+		}
+		return nil
+	case "clear":
+		tables := args[1:]
+		if len(tables) == 0 {
+			tables = storeTables
+		}
+		var statements []string
+		for _, table := range tables {
+			if !containsString(storeTables, table) {
+				say("usage.store")
+				return fmt.Errorf("unknown table %q", table)
+			}
+			statements = append(statements, "DELETE FROM "+table+";")
+		}
+		return storeExec(strings.Join(statements, "\n"), nil)
+	}
+	say("usage.store")
+	return nil
+}
@@ -0,0 +1,18 @@
+package main
+
+import "github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+
+// Strict turns the workarounds of the planner into errors, for when a wrong
+// sync is worse than no sync: keyframes sharing a time or out of order,
+// keyframes landing on the beat of the previous one, past the end of the
+// video or beyond the speed limit, and segments shorter than a frame.
+var Strict = false
+
+// checkStrictSegments checks that every segment still lasts a frame once
+// moved onto the frames of the video, shorter ones rendering nothing.
+func checkStrictSegments(keyframes []Keyframe, segments []segment) error {
+	if !Strict {
+		return nil
+	}
+	return plan.CheckFrames(keyframes, segments)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+// maxContactSheetTiles bounds the number of previews of a contact sheet.
+const maxContactSheetTiles = 25
+
+// size of the tiles of the contact sheets.
+const (
+	tileWidth  = 640
+	tileHeight = 360
+)
+
+// sweepParameter is a setting and the values a sweep tries.
+type sweepParameter struct {
+	Name   string
+	Values []string
+}
+
+// sweepGridParameters are the sweepable settings of the grid, the other
+// parameters name command line flags.
+var sweepGridParameters = map[string]bool{"subdivision": true, "offset": true, "swing": true, "snap-to": true}
+
+// parseSweep parses a parameter grid such as
+// "subdivision=1,2,4;offset=0,0.05;shake=0,0.02".
+func parseSweep(spec string) ([]sweepParameter, error) {
+	var parameters []sweepParameter
+	combinations := 1
+	for _, part := range strings.Split(spec, ";") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		if !ok || values == "" {
+			return nil, fmt.Errorf("invalid sweep parameter %q, expected name=value,value", part)
+		}
+		if !sweepGridParameters[name] && flag.Lookup(name) == nil {
+			return nil, fmt.Errorf("invalid sweep parameter %q, not a flag", name)
+		}
+		parameter := sweepParameter{Name: name}
+		for _, value := range strings.Split(values, ",") {
+			parameter.Values = append(parameter.Values, strings.TrimSpace(value))
+		}
+		parameters = append(parameters, parameter)
+		combinations *= len(parameter.Values)
+	}
+	if len(parameters) == 0 {
+		return nil, fmt.Errorf("empty sweep")
+	}
+	if combinations > maxContactSheetTiles {
+		return nil, fmt.Errorf("the sweep has %d combinations, at most %d are supported", combinations, maxContactSheetTiles)
+	}
+	return parameters, nil
+}
+
+// sweepCombinations returns every combination of the parameter values, each
+// combination holding one value per parameter.
+func sweepCombinations(parameters []sweepParameter) [][]string {
+	combinations := [][]string{{}}
+	for _, parameter := range parameters {
+		var next [][]string
+		for _, combination := range combinations {
+			for _, value := range parameter.Values {
+				next = append(next, append(append([]string{}, combination...), value))
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// applySweepCombination returns the grid with the settings of a combination,
+// the other parameters are set through their flags.
+func applySweepCombination(grid beatGrid, parameters []sweepParameter, values []string) (beatGrid, error) {
+	for i, parameter := range parameters {
+		value := values[i]
+		var err error
+		switch parameter.Name {
+		case "subdivision":
+			grid.Subdivision, err = strconv.Atoi(value)
+		case "offset":
+			grid.Offset, err = strconv.ParseFloat(value, 64)
+		case "swing":
+			if grid.Swing, err = strconv.ParseFloat(value, 64); err == nil {
+				err = beatgrid.ValidateSwing(grid.Swing)
+			}
+		case "snap-to":
+			grid.SnapTo = value
+			err = beatgrid.ValidateSnapTo(value)
+		default:
+			err = flag.Set(parameter.Name, value)
+		}
+		if err != nil {
+			return grid, fmt.Errorf("invalid value %q for %s: %v", value, parameter.Name, err)
+		}
+	}
+	return grid, applyDerivedSettings()
+}
+
+// applyDerivedSettings updates the settings derived from flags once they are
+// parsed, after the flags changed during a sweep or a montage.
+func applyDerivedSettings() error {
+	var err error
+	if CutTransitionBeats, err = strconv.ParseFloat(flag.Lookup("transition-beats").Value.String(), 64); err != nil {
+		return err
+	}
+	ColorCycle.Tints, err = parseTints(flag.Lookup("tints").Value.String())
+	return err
+}
+
+// renderPreview renders the synced video with the effects of the separate
+// passes, without the audio. The intermediate files are written next to
+// outputPath.
+func renderPreview(grid beatGrid, videoPath string, audioPath string, keyframes []Keyframe, outputPath string) error {
+	dir := filepath.Dir(outputPath)
+	current := filepath.Join(dir, "synced.mkv")
+	if err := ffmpegAdjustSpeed(grid, videoPath, "", current, keyframes); err != nil {
+		return err
+	}
+	pass := func(name string, render func(input, output string) error) error {
+		output := filepath.Join(dir, name+".mkv")
+		if err := render(current, output); err != nil {
+			return err
+		}
+		current = output
+		return nil
+	}
+	if Stickers.Dir != "" {
+		if err := pass("stickers", func(input, output string) error { return addStickersToVideo(input, grid, output, Stickers) }); err != nil {
+			return err
+		}
+	}
+	if BounceAmount > 0 && audioPath != "" {
+		if err := pass("bounce", func(input, output string) error { return addBounceToVideo(input, audioPath, output, BounceAmount) }); err != nil {
+			return err
+		}
+	}
+	if Shake.Amplitude > 0 {
+		if err := pass("shake", func(input, output string) error { return addShakeToVideo(input, grid, keyframes, output, Shake) }); err != nil {
+			return err
+		}
+	}
+	return os.Rename(current, outputPath)
+}
+
+// contactSheetArgs returns the ffmpeg arguments tiling the previews, each
+// labeled with its settings, with the audio when set. 0 columns makes the
+// sheet as square as possible.
+func contactSheetArgs(previews []string, labels []string, columns int, audioPath string, outputPath string) []string {
+	if columns <= 0 {
+		columns = int(math.Ceil(math.Sqrt(float64(len(previews)))))
+	}
+	var args []string
+	var filters, tiles, layout []string
+	for i, preview := range previews {
+		args = append(args, "-i", preview)
+		filters = append(filters, fmt.Sprintf(
+			"[%d:v]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,drawtext=text=%s:expansion=none:fontfile='%s':fontsize=20:fontcolor=white:x=10:y=h-th-10:box=1:boxcolor=black@0.6:boxborderw=6[tile%d]",
+			i, tileWidth, tileHeight, tileWidth, tileHeight, escapeFilterText(labels[i]), defaultTitleFont, i,
+		))
+		tiles = append(tiles, fmt.Sprintf("[tile%d]", i))
+		layout = append(layout, fmt.Sprintf("%d_%d", (i%columns)*tileWidth, (i/columns)*tileHeight))
+	}
+	if len(previews) == 1 {
+		filters = append(filters, "[tile0]null[sheet]")
+	} else {
+		filters = append(filters, fmt.Sprintf("%sxstack=inputs=%d:layout=%s:fill=black[sheet]", strings.Join(tiles, ""), len(previews), strings.Join(layout, "|")))
+	}
+
+	args = append([]string{"-y"}, args...)
+	if audioPath != "" {
+		args = append(args, "-i", audioPath)
+	}
+	args = append(args, "-filter_complex", strings.Join(filters, "; "), "-map", "[sheet]")
+	if audioPath != "" {
+		args = append(args, "-map", fmt.Sprintf("%d:a:0", len(previews)), "-shortest")
+		args = append(args, audioMuxArgs()...)
+	}
+	args = append(args, encoderArgs()...)
+	args = append(args, containerArgs(outputPath)...)
+	return append(args, outputPath)
+}
+
+// runSweep renders a preview for every combination of the parameter grid and
+// tiles them in a contact sheet written to outputPath.
+func runSweep(parameters []sweepParameter, grid beatGrid, videoPath string, audioPath string, keyframes []Keyframe, outputPath string) error {
+	batch, err := startBatch("sweep")
+	if err != nil {
+		return err
+	}
+	succeeded := false
+	defer func() { batch.close(succeeded) }()
+
+	var previews, labels []string
+	for i, values := range sweepCombinations(parameters) {
+		candidate, err := applySweepCombination(grid, parameters, values)
+		if err != nil {
+			return err
+		}
+		var label []string
+		for j, parameter := range parameters {
+			label = append(label, parameter.Name+"="+values[j])
+		}
+		say("sweep.preview", i+1, strings.Join(label, " "))
+
+		if preview, ok := batch.finished(strings.Join(label, " ")); ok {
+			previews = append(previews, preview)
+			labels = append(labels, strings.Join(label, " "))
+			continue
+		}
+		previewDir := filepath.Join(batch.Dir, strconv.Itoa(i))
+		if err := os.MkdirAll(previewDir, 0755); err != nil {
+			return err
+		}
+		preview := filepath.Join(previewDir, "preview.mkv")
+		if err := renderPreview(candidate, videoPath, audioPath, keyframes, preview); err != nil {
+			return fmt.Errorf("preview %s: %v", strings.Join(label, " "), err)
+		}
+		batch.finish(strings.Join(label, " "), preview)
+		previews = append(previews, preview)
+		labels = append(labels, strings.Join(label, " "))
+	}
+
+	say("sweep.start", len(previews), outputPath)
+	if err := runFFmpeg("contact sheet", contactSheetArgs(previews, labels, 0, audioPath, outputPath)); err != nil {
+		return err
+	}
+	succeeded = true
+	say("sweep.saved", outputPath)
+	recordOutput(outputPath)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"time"
+)
+
+// captureTaps plays the audio with ffplay and records the time of every
+// spacebar tap, and of every mark of the MIDI and OSC sources, as a
+// keyframe until the audio ends or q is pressed. The keyframes are written
+// to outputPath. When the terminal can't read single keys, the taps are
+// recorded when Enter is pressed.
+func captureTaps(audioPath string, outputPath string, sources tapSources) error {
+	ffplayPath, err := checkFFplayAvailable()
+	if err != nil {
+		return fmt.Errorf("ffplay is not available: %v", err)
+	}
+	marks := make(chan string, 64)
+	closers, err := sources.start(marks)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, closer := range closers {
+			closer.Close()
+		}
+	}()
+	restore, err := rawTerminal()
+	lineMode := err != nil
+	if lineMode {
+		say("tap.line_mode")
+	} else {
+		defer restore()
+	}
+
+	cmd := exec.Command(ffplayPath, "-v", "error", "-nodisp", "-autoexit", audioPath)
+	cmd.Stderr = os.Stderr
+	say("tap.start", audioPath)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error running ffplay: %v", err)
+	}
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	keys := make(chan byte)
+	go func() {
+		reader := bufio.NewReader(os.Stdin)
+		for {
+			key, err := reader.ReadByte()
+			if err != nil {
+				close(keys)
+				return
+			}
+			keys <- key
+		}
+	}()
+
+	// an interrupt stops the capture so the terminal gets restored
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	var keyframes []Keyframe
+	playing := true
+	for playing {
+		select {
+		case <-done:
+			playing = false
+		case <-interrupted:
+			cmd.Process.Kill()
+			<-done
+			playing = false
+		case label := <-marks:
+			t := time.Since(started).Seconds()
+			keyframes = append(keyframes, Keyframe{Time: t, Label: label})
+			say("tap.marked", len(keyframes), t, label)
+		case key, ok := <-keys:
+			if !ok {
+				// stdin is closed, the taps are over but the audio plays on
+				keys = nil
+				continue
+			}
+			switch {
+			case key == 'q' || key == 27: // escape
+				cmd.Process.Kill()
+				<-done
+				playing = false
+			case key == ' ' && !lineMode, key == '\n' && lineMode:
+				t := time.Since(started).Seconds()
+				keyframes = append(keyframes, Keyframe{Time: t})
+				say("tap.recorded", len(keyframes), t)
+			}
+		}
+	}
+
+	if len(keyframes) == 0 {
+		return fmt.Errorf("no taps recorded")
+	}
+	data, err := json.MarshalIndent(keyframes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(outputPath, data, 0o644); err != nil {
+		return err
+	}
+	say("tap.saved", len(keyframes), outputPath)
+	if len(keyframes) >= 2 {
+		say("bpm.estimated", estimateBPM(keyframes))
+	}
+	return nil
+}
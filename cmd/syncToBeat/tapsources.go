@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+)
+
+// tapSources are the controllers marking keyframes along with the keyboard
+// during a tap capture.
+type tapSources struct {
+	// MIDIDevice is the path of a raw MIDI device, such as /dev/snd/midiC1D0
+	// on Linux. Every note played marks a keyframe labeled with the note.
+	MIDIDevice string
+	// OSCAddress is the UDP address OSC messages are received on, such as
+	// :9000. Every message marks a keyframe labeled with its OSC address,
+	// except the ones with a zero first argument such as pad releases.
+	OSCAddress string
+}
+
+// start starts reading the sources, sending the label of every mark to
+// marks. The returned closers stop the reading.
+func (s tapSources) start(marks chan<- string) ([]io.Closer, error) {
+	var closers []io.Closer
+	if s.MIDIDevice != "" {
+		device, err := os.Open(s.MIDIDevice)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the MIDI device: %v", err)
+		}
+		closers = append(closers, device)
+		go readMIDINotes(device, marks)
+		say("tap.midi", s.MIDIDevice)
+	}
+	if s.OSCAddress != "" {
+		conn, err := net.ListenPacket("udp", s.OSCAddress)
+		if err != nil {
+			for _, closer := range closers {
+				closer.Close()
+			}
+			return nil, fmt.Errorf("failed to listen to OSC messages: %v", err)
+		}
+		closers = append(closers, conn)
+		go readOSCMessages(conn, marks)
+		say("tap.osc", conn.LocalAddr().String())
+	}
+	return closers, nil
+}
+
+// sendMark sends a mark without blocking once the capture is over.
+func sendMark(marks chan<- string, label string) {
+	select {
+	case marks <- label:
+	default:
+	}
+}
+
+var noteNames = []string{"C", "C#", "D", "D#", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// noteName returns the name of a MIDI note, 60 being C4.
+func noteName(note byte) string {
+	return fmt.Sprintf("%s%d", noteNames[note%12], int(note)/12-1)
+}
+
+// midiDataLength returns the number of data bytes following a MIDI status
+// byte.
+func midiDataLength(status byte) int {
+	switch {
+	case status >= 0xC0 && status < 0xE0, status == 0xF1, status == 0xF3:
+		return 1
+	case status < 0xF0, status == 0xF2:
+		return 2
+	}
+	return 0
+}
+
+// readMIDINotes reads a raw MIDI stream and marks the note on messages, until
+// the stream fails or is closed.
+func readMIDINotes(r io.Reader, marks chan<- string) {
+	reader := bufio.NewReader(r)
+	var status byte
+	var data []byte
+	inSysEx := false
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return
+		}
+		switch {
+		case b >= 0xF8:
+			// real time messages can come in between the bytes of others
+			continue
+		case b == 0xF0:
+			inSysEx = true
+			continue
+		case b == 0xF7:
+			inSysEx = false
+			continue
+		case inSysEx:
+			continue
+		case b >= 0x80:
+			status, data = b, data[:0]
+			continue
+		}
+		// data bytes, the status is kept for the next messages (running
+		// status)
+		if status == 0 {
+			continue
+		}
+		data = append(data, b)
+		if len(data) < midiDataLength(status) {
+			continue
+		}
+		if status&0xF0 == 0x90 && data[1] > 0 {
+			sendMark(marks, noteName(data[0]))
+		}
+		data = data[:0]
+	}
+}
+
+// oscString reads a null-terminated OSC string padded to 4 bytes.
+func oscString(packet []byte) (string, []byte, bool) {
+	end := bytes.IndexByte(packet, 0)
+	if end < 0 {
+		return "", nil, false
+	}
+	padded := (end + 4) &^ 3
+	if padded > len(packet) {
+		padded = len(packet)
+	}
+	return string(packet[:end]), packet[padded:], true
+}
+
+// oscMessageAddresses returns the addresses of the messages of an OSC
+// packet, skipping the messages with a zero first argument.
+func oscMessageAddresses(packet []byte) []string {
+	if bytes.HasPrefix(packet, []byte("#bundle\x00")) {
+		// the bundle header is followed by a time tag and sized elements
+		var addresses []string
+		rest := packet[min(16, len(packet)):]
+		for len(rest) >= 4 {
+			size := int(binary.BigEndian.Uint32(rest))
+			rest = rest[4:]
+			if size > len(rest) {
+				break
+			}
+			addresses = append(addresses, oscMessageAddresses(rest[:size])...)
+			rest = rest[size:]
+		}
+		return addresses
+	}
+
+	address, rest, ok := oscString(packet)
+	if !ok || address == "" || address[0] != '/' {
+		return nil
+	}
+	types, args, ok := oscString(rest)
+	if ok && len(types) > 1 && types[0] == ',' && len(args) >= 4 {
+		switch types[1] {
+		case 'f':
+			if math.Float32frombits(binary.BigEndian.Uint32(args)) == 0 {
+				return nil
+			}
+		case 'i':
+			if binary.BigEndian.Uint32(args) == 0 {
+				return nil
+			}
+		}
+	}
+	return []string{address}
+}
+
+// readOSCMessages reads OSC packets and marks their messages, until the
+// connection is closed.
+func readOSCMessages(conn net.PacketConn, marks chan<- string) {
+	buffer := make([]byte, 65536)
+	for {
+		n, _, err := conn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		for _, address := range oscMessageAddresses(buffer[:n]) {
+			sendMark(marks, address)
+		}
+	}
+}
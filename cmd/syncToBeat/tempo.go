@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	// AutoBPM is the -bpm value detecting the tempo of the music.
+	AutoBPM = "auto"
+	// minTempo and maxTempo bound the detected tempo, in BPM.
+	minTempo = 60
+	maxTempo = 200
+	// preferredTempo is the tempo the detection leans towards when the
+	// music could be counted at half or double speed.
+	preferredTempo = 120
+	// tempoRefineBeats is the number of beats the period is measured over
+	// once found, for a precision finer than a frame of the onset strength.
+	tempoRefineBeats = 8
+	// lowTempoConfidence is the confidence below which the detected tempo
+	// is reported as unreliable.
+	lowTempoConfidence = 0.3
+)
+
+// tempoEstimate is the tempo detected in the music.
+type tempoEstimate struct {
+	BPM float64
+	// Offset is the time of the first beat, in seconds.
+	Offset float64
+	// Confidence, between 0 and 1, is how periodic the onsets are at the
+	// detected tempo.
+	Confidence float64
+}
+
+// autocorrelation returns the autocorrelation of the values, minus their
+// mean, for the lags up to maxLag.
+func autocorrelation(values []float64, maxLag int) []float64 {
+	var mean float64
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	centered := make([]float64, len(values))
+	for i, v := range values {
+		centered[i] = v - mean
+	}
+	ac := make([]float64, min(maxLag, len(values)-1)+1)
+	for lag := range ac {
+		for i := lag; i < len(centered); i++ {
+			ac[lag] += centered[i] * centered[i-lag]
+		}
+	}
+	return ac
+}
+
+// peakLag returns the lag of the highest autocorrelation between from and
+// to, interpolated between the lags around it.
+func peakLag(ac []float64, from int, to int) float64 {
+	from, to = max(from, 1), min(to, len(ac)-2)
+	best := from
+	for lag := from; lag <= to; lag++ {
+		if ac[lag] > ac[best] {
+			best = lag
+		}
+	}
+	if best <= 0 || best >= len(ac)-1 {
+		return float64(best)
+	}
+	// the vertex of the parabola through the peak and its neighbors
+	previous, next := ac[best-1], ac[best+1]
+	if curvature := previous - 2*ac[best] + next; curvature < 0 {
+		return float64(best) + 0.5*(previous-next)/curvature
+	}
+	return float64(best)
+}
+
+// estimateTempo finds the tempo of the onset strength from its
+// autocorrelation: the onsets of music at a steady tempo repeat every beat.
+// Of the tempos the music could be counted at, the ones closer to
+// preferredTempo are favored.
+func estimateTempo(strength []float64) (tempoEstimate, error) {
+	frameRate := float64(onsetSampleRate) / onsetHop
+	minLag := int(math.Floor(frameRate * 60 / maxTempo))
+	maxLag := int(math.Ceil(frameRate * 60 / minTempo))
+	if len(strength) < 2*maxLag {
+		return tempoEstimate{}, fmt.Errorf("the music is too short to detect its tempo, at least %.0fs are needed", 2*float64(maxLag)/frameRate)
+	}
+	ac := autocorrelation(strength, maxLag*tempoRefineBeats)
+	if ac[0] <= 0 {
+		return tempoEstimate{}, fmt.Errorf("no onsets found to detect the tempo from")
+	}
+
+	best, bestScore := 0, math.Inf(-1)
+	for lag := minLag; lag <= maxLag; lag++ {
+		// a log-normal prior around preferredTempo, an octave wide
+		octaves := math.Log2(60 * frameRate / float64(lag) / preferredTempo)
+		score := ac[lag] * math.Exp(-0.5*octaves*octaves)
+		if score > bestScore {
+			best, bestScore = lag, score
+		}
+	}
+	period := peakLag(ac, best-1, best+1)
+	// the peak a few beats away is as sharp but spans more frames
+	beats := tempoRefineBeats
+	for beats > 1 && int(math.Ceil(period*float64(beats)))+1 >= len(ac)-1 {
+		beats--
+	}
+	if beats > 1 {
+		far := period * float64(beats)
+		period = peakLag(ac, int(far)-beats/2-1, int(far)+beats/2+1) / float64(beats)
+	}
+	confidence := math.Max(0, math.Min(1, ac[int(math.Round(period))]/ac[0]))
+
+	// the first beat is where the onsets one period apart are the strongest
+	phase, phaseScore := 0, math.Inf(-1)
+	for start := 0; float64(start) < period; start++ {
+		var score float64
+		for t := float64(start); int(math.Round(t)) < len(strength); t += period {
+			score += strength[int(math.Round(t))]
+		}
+		if score > phaseScore {
+			phase, phaseScore = start, score
+		}
+	}
+	offset := float64(phase)/frameRate + onsetFrameSize/2/float64(onsetSampleRate)
+	return tempoEstimate{BPM: 60 * frameRate / period, Offset: offset, Confidence: confidence}, nil
+}
+
+// detectTempo returns the tempo of the music.
+func detectTempo(audioPath string) (tempoEstimate, error) {
+	strength, err := onsetEnvelope(audioPath, Onsets)
+	if err != nil {
+		return tempoEstimate{}, err
+	}
+	tempo, err := estimateTempo(strength)
+	if err != nil {
+		return tempoEstimate{}, fmt.Errorf("%s: %v", audioPath, err)
+	}
+	say("tempo.detected", tempo.BPM, tempo.Confidence*100, tempo.Offset)
+	if tempo.Confidence < lowTempoConfidence {
+		warn(WarnLowTempoConfidence, tempo.Confidence*100)
+	}
+	return tempo, nil
+}
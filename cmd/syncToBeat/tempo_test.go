@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestAutocorrelation(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		maxLag int
+		want   []float64
+	}{
+		{name: "alternating", values: []float64{1, 0, 1, 0}, maxLag: 2, want: []float64{1, -0.75, 0.5}},
+		{name: "lags past the values", values: []float64{1, 0, 1}, maxLag: 5, want: []float64{2.0 / 3, -4.0 / 9, 1.0 / 9}},
+		{name: "constant", values: []float64{2, 2, 2}, maxLag: 1, want: []float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := autocorrelation(tt.values, tt.maxLag)
+			if len(got) != len(tt.want) {
+				t.Fatalf("autocorrelation() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+					t.Errorf("autocorrelation() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestPeakLag(t *testing.T) {
+	tests := []struct {
+		name     string
+		ac       []float64
+		from, to int
+		want     float64
+	}{
+		{name: "symmetric peak", ac: []float64{9, 0, 1, 4, 1, 0}, from: 1, to: 4, want: 3},
+		{name: "interpolated", ac: []float64{9, 0, 2, 4, 3, 0}, from: 1, to: 4, want: 3 + 0.5*(2-3)/(2-8+3)},
+		{name: "within the range", ac: []float64{9, 0, 4, 1, 8, 0}, from: 1, to: 3, want: 2 + 0.5*(0-1)/(0-8+1)},
+		{name: "flat", ac: []float64{9, 1, 1, 1, 1}, from: 1, to: 3, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := peakLag(tt.ac, tt.from, tt.to); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("peakLag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEstimateTempo(t *testing.T) {
+	tests := []struct {
+		bpm    float64
+		offset float64
+	}{
+		{bpm: 120, offset: 0.5},
+		{bpm: 97, offset: 0.25},
+		{bpm: 140, offset: 0.1},
+		{bpm: 174, offset: 0.3},
+	}
+	for _, tt := range tests {
+		t.Run(exact(tt.bpm), func(t *testing.T) {
+			strength, err := onsetStrength(clickTrack(tt.bpm, tt.offset, 12), Onsets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := estimateTempo(strength)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// music can be counted at half or double speed as well
+			bpm := got.BPM
+			for _, octave := range []float64{0.5, 2} {
+				if math.Abs(got.BPM*octave-tt.bpm) < math.Abs(bpm-tt.bpm) {
+					bpm = got.BPM * octave
+				}
+			}
+			if math.Abs(bpm-tt.bpm) > 0.5 {
+				t.Errorf("estimateTempo() = %.2f BPM, want %.2f", got.BPM, tt.bpm)
+			}
+			period := 60 / tt.bpm
+			if phase := math.Mod(got.Offset-tt.offset+period, period); math.Min(phase, period-phase) > 0.03 {
+				t.Errorf("estimateTempo() offset = %.3fs, want a beat of %.3fs", got.Offset, tt.offset)
+			}
+			if got.Confidence < lowTempoConfidence {
+				t.Errorf("estimateTempo() confidence = %.2f, want at least %.2f", got.Confidence, lowTempoConfidence)
+			}
+		})
+	}
+}
+
+func TestEstimateTempoErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		strength []float64
+	}{
+		{name: "too short", strength: make([]float64, 100)},
+		{name: "silence", strength: make([]float64, 1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := estimateTempo(tt.strength); err == nil {
+				t.Error("estimateTempo() didn't fail")
+			}
+		})
+	}
+}
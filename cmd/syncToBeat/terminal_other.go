@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// rawTerminal isn't supported on this platform, the keys are read a line at
+// a time.
+func rawTerminal() (restore func(), err error) {
+	return nil, errors.New("raw terminal not supported")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MusicalTimecode adds the position on the beat grid (bar and beat) to the
+// synced video: "burn" draws it on the frames, "track" adds it as a subtitle
+// track, empty doesn't add it.
+var MusicalTimecode = ""
+
+// validateMusicalTimecode checks the musical timecode setting.
+func validateMusicalTimecode(mode string) error {
+	switch mode {
+	case "", "burn", "track":
+		return nil
+	}
+	return fmt.Errorf("invalid musical timecode %q, expected burn or track", mode)
+}
+
+// musicalTimecodeFilter returns the drawtext filter burning the bar and beat
+// of each frame in the top left corner.
+func musicalTimecodeFilter(grid beatGrid) string {
+	position := fmt.Sprintf("max(%s,0)", positionExpression(grid, "t"))
+	beatsPerBar := grid.Meter.BeatsPerBar()
+	// the colons of the expansions are escaped for the option parser
+	text := fmt.Sprintf(`bar %%{eif\:floor(%[1]s/%[2]d)+1\:d} beat %%{eif\:floor(mod(%[1]s,%[2]d))+1\:d}`, position, beatsPerBar)
+	return fmt.Sprintf("drawtext=text='%s':fontfile='%s':fontsize=28:fontcolor=white:x=20:y=20:box=1:boxcolor=black@0.5:boxborderw=8", text, defaultTitleFont)
+}
+
+// subtitleCodecs maps the containers to the codec of their subtitle tracks.
+var subtitleCodecs = map[string]string{
+	".mp4":  "mov_text",
+	".m4v":  "mov_text",
+	".mov":  "mov_text",
+	".mkv":  "srt",
+	".webm": "webvtt",
+}
+
+// srtTime formats a time in seconds as an SRT timestamp.
+func srtTime(t float64) string {
+	ms := int(t*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// musicalTimecodeCues returns the SRT subtitles naming the bar and beat of
+// every beat of the grid until duration.
+func musicalTimecodeCues(grid beatGrid, duration float64) string {
+	var cues strings.Builder
+	first := max(0, int(grid.BeatPosition(0)))
+	for i, beat := 1, first; ; i, beat = i+1, beat+1 {
+		start := grid.BeatTime(float64(beat))
+		if start >= duration {
+			break
+		}
+		end := min(grid.BeatTime(float64(beat+1)), duration)
+		bar, beatInBar := grid.Meter.BarAndBeat(float64(beat))
+		fmt.Fprintf(&cues, "%d\n%s --> %s\nbar %d beat %.0f\n\n", i, srtTime(max(start, 0)), srtTime(end), bar, beatInBar)
+	}
+	return cues.String()
+}
+
+// addMusicalTimecodeTrack adds a subtitle track with the bar and beat of every
+// beat to the video, in place.
+func addMusicalTimecodeTrack(videoPath string, grid beatGrid) error {
+	name, extension := splitExtension(videoPath)
+	codec, ok := subtitleCodecs[strings.ToLower(extension)]
+	if !ok {
+		return fmt.Errorf("%s files can't hold a timecode track, burn it instead", extension)
+	}
+	duration, err := getVideoDuration(videoPath)
+	if err != nil {
+		return err
+	}
+
+	cuesFile, err := os.CreateTemp("", "timecode-*.srt")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(cuesFile.Name())
+	if _, err := cuesFile.WriteString(musicalTimecodeCues(grid, duration)); err != nil {
+		cuesFile.Close()
+		return err
+	}
+	if err := cuesFile.Close(); err != nil {
+		return err
+	}
+
+	tempPath := name + "_timecode" + extension
+	cmdArgs := []string{
+		"-y",
+		"-i", videoPath,
+		"-i", cuesFile.Name(),
+		"-map", "0", "-map", "1:s",
+		"-c", "copy", "-c:s", codec,
+		"-metadata:s:s:0", "title=Musical timecode",
+	}
+	cmdArgs = append(cmdArgs, containerArgs(videoPath)...)
+	cmdArgs = append(cmdArgs, tempPath)
+	if err := runFFmpeg("timecode track", cmdArgs); err != nil {
+		os.Remove(tempPath)
+		return err
+	}
+	return os.Rename(tempPath, videoPath)
+}
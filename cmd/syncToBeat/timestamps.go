@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// seconds is a time or a duration, in seconds, passed to ffmpeg. fmt doesn't
+// depend on the locale, but %f rounds to the microsecond and the rounding
+// errors add up over the segments of long videos, so times are formatted
+// with all the precision of a float64 instead.
+type seconds float64
+
+// String formats the time as a decimal number of seconds, without exponent.
+func (s seconds) String() string {
+	return strconv.FormatFloat(float64(s), 'f', -1, 64)
+}
+
+// timestamp formats the time as a [-]HH:MM:SS.fraction ffmpeg timestamp,
+// for the time options such as -ss and -t.
+func (s seconds) timestamp() string {
+	return probe.Timestamp(float64(s))
+}
+
+// rational returns the time as an exact fraction N/D of a second with the
+// given denominator, such as the timebase of a stream, for the expressions
+// of filters like setpts.
+func (s seconds) rational(denominator int64) string {
+	return fmt.Sprintf("%d/%d", int64(math.Round(float64(s)*float64(denominator))), denominator)
+}
+
+// exact formats a number that isn't a time, such as a speed factor or a
+// tempo in beats per second, with all the precision of a float64 like
+// seconds.
+func exact(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultTitleFont is the font used by the title cards not setting one.
+const defaultTitleFont = "fonts/Roboto-Light.ttf"
+
+// TitleCard is a text shown when the keyframe with the matching label plays.
+type TitleCard struct {
+	// Label is the label of the keyframe the title starts on.
+	Label string `json:"label"`
+	Text  string `json:"text"`
+	// Font is the path to the font file, defaults to defaultTitleFont.
+	Font     string `json:"font,omitempty"`
+	FontSize int    `json:"font_size,omitempty"`
+	// Beats is how long the title stays on screen, defaults to a bar.
+	Beats float64 `json:"beats,omitempty"`
+	// Card hides the video behind a black card instead of overlaying the
+	// text on top of it.
+	Card bool `json:"card,omitempty"`
+}
+
+// Titles are the title cards added to the synced video.
+var Titles []TitleCard
+
+// readTitles reads the title cards manifest from a JSON file.
+func readTitles(filePath string) ([]TitleCard, error) {
+	var titles []TitleCard
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &titles); err != nil {
+		return nil, err
+	}
+	for _, title := range titles {
+		if title.Label == "" {
+			return nil, fmt.Errorf("title %q isn't attached to a keyframe label", title.Text)
+		}
+		if title.Beats < 0 {
+			return nil, fmt.Errorf("title %q has an invalid duration: %.2f beats", title.Label, title.Beats)
+		}
+	}
+	return titles, nil
+}
+
+// escapeFilterText escapes a string used as a filter option value in a
+// filter graph, once for the graph and once for the option parser.
+func escapeFilterText(text string) string {
+	var escaped strings.Builder
+	for _, r := range text {
+		if strings.ContainsRune(`\':,;[]`, r) {
+			escaped.WriteString(`\\\`)
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// keyframeOutputTime returns when the labeled keyframe plays in the synced
+// video.
+func keyframeOutputTime(label string, keyframes []Keyframe, segments []segment) (float64, error) {
+	for i, kf := range keyframes {
+		if kf.Label != label {
+			continue
+		}
+		for _, seg := range segments {
+			if seg.Keyframe == i {
+				return seg.NearestBeatTime, nil
+			}
+		}
+		// keyframes at the very start of the video don't end any segment
+		return 0, nil
+	}
+	return 0, fmt.Errorf("no keyframe labeled %q", label)
+}
+
+// titlesFilter returns the filter chain drawing the title cards on the synced
+// video, or an empty string when there are no titles.
+func titlesFilter(titles []TitleCard, grid beatGrid, keyframes []Keyframe, segments []segment) (string, error) {
+	var filters []string
+	for _, title := range titles {
+		start, err := keyframeOutputTime(title.Label, keyframes, segments)
+		if err != nil {
+			return "", err
+		}
+		beats := title.Beats
+		if beats == 0 {
+			beats = float64(grid.Meter.BeatsPerBar())
+		}
+		end := grid.BeatTime(grid.BeatPosition(start) + beats)
+		enable := fmt.Sprintf("enable='between(t,%s,%s)'", seconds(start), seconds(end))
+
+		font := title.Font
+		if font == "" {
+			font = defaultTitleFont
+		}
+		fontSize := title.FontSize
+		if fontSize == 0 {
+			fontSize = 64
+		}
+
+		if title.Card {
+			filters = append(filters, "drawbox=x=0:y=0:w=iw:h=ih:color=black:t=fill:"+enable)
+		}
+		drawText := fmt.Sprintf(
+			"drawtext=text=%s:expansion=none:fontfile=%s:fontsize=%d:fontcolor=white:x=(w-tw)/2:y=(h-th)/2:%s",
+			escapeFilterText(title.Text), escapeFilterText(font), fontSize, enable,
+		)
+		if !title.Card {
+			drawText += ":box=1:boxcolor=black@0.5:boxborderw=20"
+		}
+		filters = append(filters, drawText)
+	}
+	return strings.Join(filters, ","), nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEscapeFilterText(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Chorus", want: "Chorus"},
+		{text: "It's 3:00", want: `It\\\'s 3\\\:00`},
+		{text: `a\b`, want: `a\\\\b`},
+		{text: "[out],movie=x;", want: `\\\[out\\\]\\\,movie=x\\\;`},
+	}
+	for _, tt := range tests {
+		if got := escapeFilterText(tt.text); got != tt.want {
+			t.Errorf("escapeFilterText(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTitlesFilter(t *testing.T) {
+	keyframes := []Keyframe{{Time: 0}, {Time: 2, Label: "drop"}}
+	segments := []segment{{Keyframe: 1, Start: 0, End: 2, NearestBeatTime: 2, SpeedFactor: 1}}
+	tests := []struct {
+		name  string
+		title TitleCard
+		want  string
+	}{
+		{
+			name:  "overlay",
+			title: TitleCard{Label: "drop", Text: "Drop: now"},
+			want:  `drawtext=text=Drop\\\: now:expansion=none:fontfile=fonts/Roboto-Light.ttf:fontsize=64:fontcolor=white:x=(w-tw)/2:y=(h-th)/2:enable='between(t,2,4)':box=1:boxcolor=black@0.5:boxborderw=20`,
+		},
+		{
+			name:  "card",
+			title: TitleCard{Label: "drop", Text: "Drop", Font: "Bold.ttf", FontSize: 32, Beats: 2, Card: true},
+			want:  `drawbox=x=0:y=0:w=iw:h=ih:color=black:t=fill:enable='between(t,2,3)',drawtext=text=Drop:expansion=none:fontfile=Bold.ttf:fontsize=32:fontcolor=white:x=(w-tw)/2:y=(h-th)/2:enable='between(t,2,3)'`,
+		},
+		{
+			// the font can't close the option to inject other filters
+			name:  "hostile font",
+			title: TitleCard{Label: "drop", Text: "Drop", Font: "x.ttf':text=a,movie=/etc/passwd[leak];[leak]"},
+			want:  `drawtext=text=Drop:expansion=none:fontfile=x.ttf\\\'\\\:text=a\\\,movie=/etc/passwd\\\[leak\\\]\\\;\\\[leak\\\]:fontsize=64:fontcolor=white:x=(w-tw)/2:y=(h-th)/2:enable='between(t,2,4)':box=1:boxcolor=black@0.5:boxborderw=20`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := titlesFilter([]TitleCard{tt.title}, beatGrid{BPM: 120}, keyframes, segments)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("titlesFilter() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := titlesFilter([]TitleCard{{Label: "intro"}}, beatGrid{BPM: 120}, keyframes, segments); err == nil || !strings.Contains(err.Error(), "intro") {
+		t.Errorf("titlesFilter() of an unknown label error = %v", err)
+	}
+}
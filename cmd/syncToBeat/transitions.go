@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// xfadeTransitions maps the transition names accepted on the command line to
+// the transitions of ffmpeg's xfade filter.
+var xfadeTransitions = map[string]string{
+	"fade":     "fade",
+	"dissolve": "dissolve",
+	"wipe":     "wipeleft",
+	"slide":    "slideleft",
+	"circle":   "circleopen",
+	"zoom":     "zoomin",
+	"pixelize": "pixelize",
+	// the glitch blends the photos with a fade hidden under glitchEffect
+	"glitch": "fade",
+}
+
+// cutTransitions are the transitions that can be applied on hard cuts, where
+// the clips don't overlap.
+var cutTransitions = map[string]bool{
+	"glitch": true,
+}
+
+var (
+	// CutTransition is the transition applied on the cuts between the synced
+	// segments, none when empty.
+	CutTransition = ""
+	// CutTransitionBeats is the duration of the cut transitions in beats.
+	CutTransitionBeats = 0.5
+)
+
+// transitionNames returns the sorted names of the available transitions.
+func transitionNames() []string {
+	var names []string
+	for name := range xfadeTransitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// validateTransition checks that the named transition exists.
+func validateTransition(name string) error {
+	if _, ok := xfadeTransitions[name]; !ok {
+		return fmt.Errorf("unknown transition %q, expected one of %s", name, strings.Join(transitionNames(), ", "))
+	}
+	return nil
+}
+
+// transitionFilter returns the filter graph chain blending the from label
+// into the to label with the named transition, starting offset seconds into
+// from and lasting duration seconds. The result is labeled out.
+func transitionFilter(name string, from, to, out string, offset, duration float64) (string, error) {
+	xfade, ok := xfadeTransitions[name]
+	if !ok {
+		return "", validateTransition(name)
+	}
+	filter := fmt.Sprintf("[%s][%s]xfade=transition=%s:duration=%s:offset=%s", from, to, xfade, seconds(duration), seconds(offset))
+	if name == "glitch" {
+		filter += "," + glitchEffect(offset, offset+duration)
+	}
+	return filter + "[" + out + "]", nil
+}
+
+// glitchEffect returns the filter chain corrupting the frames between start
+// and end: the color channels drift apart, the picture breaks into blocks and
+// gets noisy.
+func glitchEffect(start, end float64) string {
+	enable := fmt.Sprintf("enable='between(t,%s,%s)'", seconds(start), seconds(end))
+	return strings.Join([]string{
+		"rgbashift=rh=-16:bh=16:gv=8:edge=wrap:" + enable,
+		"pixelize=w=24:h=12:mode=avg:" + enable,
+		"noise=alls=40:allf=t+u:" + enable,
+	}, ",")
+}
+
+// cutTransitionsFilter returns the filter chain applying the named cut
+// transition around each cut, lasting the given number of beats centered on
+// the cut.
+func cutTransitionsFilter(name string, grid beatGrid, cuts []float64, beats float64) (string, error) {
+	if !cutTransitions[name] {
+		return "", fmt.Errorf("transition %q can't be used on cuts", name)
+	}
+	var effects []string
+	for _, cut := range cuts {
+		position := grid.BeatPosition(cut)
+		start := grid.BeatTime(position - beats/2)
+		end := grid.BeatTime(position + beats/2)
+		effects = append(effects, glitchEffect(max(start, 0), end))
+	}
+	return strings.Join(effects, ","), nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Upscale is how the multicam angles smaller than the largest one are
+// upscaled to its size: lanczos scales them with the lanczos filter, plugin
+// hands them to the upscale plugin (e.g. a Real-ESRGAN wrapper). Empty keeps
+// the size of the first angle, scaling the others with ffmpeg's default.
+var Upscale = ""
+
+// validateUpscale checks the upscale setting.
+func validateUpscale(mode string) error {
+	switch mode {
+	case "", "lanczos", "plugin":
+		return nil
+	}
+	return fmt.Errorf("invalid upscale mode %q, expected lanczos or plugin", mode)
+}
+
+// upscalePlugin returns the registered upscale plugin.
+func upscalePlugin() (Plugin, bool) {
+	for _, plugin := range Plugins {
+		if plugin.Kind == "upscale" {
+			return plugin, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// largestDimensions returns the dimensions of the largest of the videos.
+func largestDimensions(dimensions []VideoDimensions) VideoDimensions {
+	var largest VideoDimensions
+	for _, d := range dimensions {
+		if d.Width*d.Height > largest.Width*largest.Height {
+			largest = d
+		}
+	}
+	return largest
+}
+
+// upscaleAngles runs the upscale plugin on the angles smaller than size and
+// returns the angles pointing to the upscaled videos, written to dir.
+func upscaleAngles(angles []CameraAngle, dimensions []VideoDimensions, size VideoDimensions, dir string) ([]CameraAngle, error) {
+	plugin, ok := upscalePlugin()
+	if !ok {
+		return nil, fmt.Errorf("no upscale plugin registered, see -plugins")
+	}
+	upscaled := make([]CameraAngle, len(angles))
+	copy(upscaled, angles)
+	for i, angle := range angles {
+		if dimensions[i].Width >= size.Width && dimensions[i].Height >= size.Height {
+			continue
+		}
+		request := newPluginRequest(plugin.Kind, beatGrid{}, angle.Path, "")
+		request.Width, request.Height = size.Width, size.Height
+		request.Output = filepath.Join(dir, fmt.Sprintf("angle%d%s", i, filepath.Ext(angle.Path)))
+		if _, err := runPlugin(plugin, request); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(request.Output); err != nil {
+			return nil, fmt.Errorf("plugin %s didn't write the upscaled video: %v", plugin.Name, err)
+		}
+		say("multicam.upscaled", angle.Path, dimensions[i].Width, dimensions[i].Height, size.Width, size.Height)
+		upscaled[i].Path = request.Output
+	}
+	return upscaled, nil
+}
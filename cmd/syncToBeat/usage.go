@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// phaseUsage records the resources used by the ffmpeg process of a phase.
+type phaseUsage struct {
+	Phase       string  `json:"phase"`
+	WallSeconds float64 `json:"wall_seconds"`
+	UserCPU     float64 `json:"user_cpu_seconds"`
+	SystemCPU   float64 `json:"system_cpu_seconds"`
+	// MaxRSSBytes is the peak resident memory of the process, 0 when the
+	// platform doesn't report it.
+	MaxRSSBytes int64 `json:"max_rss_bytes"`
+}
+
+var (
+	phaseUsagesMu sync.Mutex
+	phaseUsages   []phaseUsage
+)
+
+// recordPhaseUsage records the resources used by a finished process.
+func recordPhaseUsage(phase string, state *os.ProcessState, wall time.Duration) {
+	if state == nil {
+		return
+	}
+	usage := phaseUsage{
+		Phase:       phase,
+		WallSeconds: wall.Seconds(),
+		UserCPU:     state.UserTime().Seconds(),
+		SystemCPU:   state.SystemTime().Seconds(),
+		MaxRSSBytes: maxRSS(state),
+	}
+
+	phaseUsagesMu.Lock()
+	phaseUsages = append(phaseUsages, usage)
+	phaseUsagesMu.Unlock()
+}
+
+// recordedPhaseUsages returns the usage of every phase run so far.
+func recordedPhaseUsages() []phaseUsage {
+	phaseUsagesMu.Lock()
+	defer phaseUsagesMu.Unlock()
+	return append([]phaseUsage(nil), phaseUsages...)
+}
+
+// printUsageReport prints the resources used by each phase.
+func printUsageReport() {
+	usages := recordedPhaseUsages()
+	if len(usages) == 0 {
+		return
+	}
+	if JSONOutput {
+		writeJSONLine(event{
+			Time:   time.Now(),
+			Level:  "info",
+			ID:     "usage.report",
+			Text:   formatMessage("usage.report"),
+			Fields: map[string]any{"phases": usages},
+		})
+		return
+	}
+	fmt.Println(formatMessage("usage.report"))
+	var totalWall, totalCPU float64
+	for _, usage := range usages {
+		cpu := usage.UserCPU + usage.SystemCPU
+		totalWall += usage.WallSeconds
+		totalCPU += cpu
+		memory := "n/a"
+		if usage.MaxRSSBytes > 0 {
+			memory = fmt.Sprintf("%.1f MB", float64(usage.MaxRSSBytes)/(1<<20))
+		}
+		fmt.Printf("  %-14s wall %7.2fs  cpu %7.2fs  peak memory %s\n", usage.Phase, usage.WallSeconds, cpu, memory)
+	}
+	fmt.Printf("  %-14s wall %7.2fs  cpu %7.2fs\n", "total", totalWall, totalCPU)
+}
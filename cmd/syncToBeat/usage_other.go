@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// maxRSS returns 0 as the peak memory of a finished process isn't available
+// on this platform without wrapping it in a job object (Windows).
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// beatPulseOptions configures the gentle pulses following the beats, the
+// vignette darkening the edges of the frame and the letterbox bars closing
+// in.
+type beatPulseOptions struct {
+	// Vignette is how much the vignette angle opens on the beat, in radians,
+	// 0 disables the vignette pulse.
+	Vignette float64
+	// Letterbox is the height of each bar on the beat relative to the frame
+	// height, 0 disables the letterbox pulse.
+	Letterbox float64
+	// Envelope is the shape of the intensity within each beat, one of the
+	// pulseEnvelopes.
+	Envelope string
+}
+
+// BeatPulse configures the vignette and letterbox pulses of the synced video.
+var BeatPulse = beatPulseOptions{Envelope: "decay"}
+
+// pulseEnvelopes are formats of ffmpeg expressions of the phase of the beat,
+// from 0 on the beat to 1 just before the next one, returning the intensity
+// of the pulse between 0 and 1.
+var pulseEnvelopes = map[string]string{
+	// full intensity on the beat, fading out quickly
+	"decay": "pow(1-%[1]s,3)",
+	// full intensity on the beat, fading out linearly
+	"linear": "(1-%[1]s)",
+	// smooth swell peaking on the beat
+	"sine": "(1+cos(2*PI*%[1]s))/2",
+	// building up to the next beat
+	"rise": "pow(%[1]s,2)",
+}
+
+// envelopeExpression returns an ffmpeg expression of t evaluating to the
+// intensity of the named envelope on the grid.
+func envelopeExpression(grid beatGrid, envelope string) (string, error) {
+	shape, ok := pulseEnvelopes[envelope]
+	if !ok {
+		return "", fmt.Errorf("unknown envelope %q, expected decay, linear, sine or rise", envelope)
+	}
+	position := positionExpression(grid, "t")
+	phase := fmt.Sprintf("(%s-floor(%[1]s))", position)
+	return fmt.Sprintf(shape, phase), nil
+}
+
+// beatPulseFilter returns the filter chain animating the vignette and the
+// letterbox bars with the beat.
+func beatPulseFilter(grid beatGrid, opts beatPulseOptions) (string, error) {
+	if opts.Vignette < 0 || opts.Vignette > 1 {
+		return "", fmt.Errorf("invalid vignette pulse %.2f, expected an angle between 0 and 1 radian", opts.Vignette)
+	}
+	if opts.Letterbox < 0 || opts.Letterbox >= 0.5 {
+		return "", fmt.Errorf("invalid letterbox pulse %.2f, expected a fraction of the height below 0.5", opts.Letterbox)
+	}
+	envelope, err := envelopeExpression(grid, opts.Envelope)
+	if err != nil {
+		return "", err
+	}
+
+	var filters []string
+	if opts.Vignette > 0 {
+		// PI/5 is the default angle of the vignette filter
+		filters = append(filters, fmt.Sprintf("vignette=a='PI/5+%s*%s':eval=frame", exact(opts.Vignette), envelope))
+	}
+	if opts.Letterbox > 0 {
+		height := fmt.Sprintf("ih*%s*%s", exact(opts.Letterbox), envelope)
+		// a box height of 0 would fill the whole frame
+		enable := fmt.Sprintf("enable='gte(%s,1)'", strings.ReplaceAll(height, "ih", "h"))
+		filters = append(filters,
+			fmt.Sprintf("drawbox=x=0:y=0:w=iw:h='%s':color=black:t=fill:%s", height, enable),
+			fmt.Sprintf("drawbox=x=0:y='ih-%s':w=iw:h='%[1]s':color=black:t=fill:%s", height, enable),
+		)
+	}
+	return strings.Join(filters, ","), nil
+}
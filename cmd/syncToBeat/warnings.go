@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Warning codes are stable so pipelines can gate on specific warnings.
+const (
+	// WarnSpeedClamp is raised when a segment has to be squeezed to a
+	// minimum duration because its keyframe snaps onto the previous beat.
+	WarnSpeedClamp = "W001"
+	// WarnKeyframeBeyondDuration is raised for keyframes after the end of the
+	// video.
+	WarnKeyframeBeyondDuration = "W002"
+	// WarnZeroDurationSegment is raised when two keyframes share the same
+	// time.
+	WarnZeroDurationSegment = "W003"
+	// WarnKeyframesOutOfOrder is raised when a keyframe comes before the
+	// previous one.
+	WarnKeyframesOutOfOrder = "W004"
+	// WarnExtremeSpeed is raised when a segment plays more than
+	// plan.ExtremeSpeedFactor times faster or slower than the original.
+	WarnExtremeSpeed = "W005"
+	// WarnBlackSegment is raised for segments made mostly of black frames.
+	WarnBlackSegment = "W006"
+	// WarnFrozenSegment is raised for segments made mostly of frozen frames.
+	WarnFrozenSegment = "W007"
+	// WarnAudioPeaks is raised when the audio peaks above truePeakCeiling.
+	WarnAudioPeaks = "W008"
+	// WarnAudioNotPassedThrough is raised when the audio copied into the
+	// output doesn't hash the same as the source.
+	WarnAudioNotPassedThrough = "W009"
+	// WarnAudioTranscode is raised when the transcoded audio doesn't have
+	// the requested codec, sample rate or channels.
+	WarnAudioTranscode = "W010"
+	// WarnAudioDuration is raised when the muxed audio doesn't last as long
+	// as expected, a sign of sync problems.
+	WarnAudioDuration = "W011"
+	// WarnStore is raised when a result can't be recorded in the store, the
+	// run goes on without it.
+	WarnStore = "W012"
+	// WarnLowTempoConfidence is raised when the onsets of the music are too
+	// irregular for the detected tempo to be trusted.
+	WarnLowTempoConfidence = "W013"
+	// WarnQueue is raised when the server can't save its queue, the jobs
+	// go on without it.
+	WarnQueue = "W014"
+	// WarnQueuedJobDropped is raised when a job saved in the queue can't run
+	// with the API keys of the restarted server.
+	WarnQueuedJobDropped = "W015"
+)
+
+// warningMessages maps the warning codes to their messages.
+var warningMessages = map[string]string{
+	WarnSpeedClamp:             "warning.speed_clamp",
+	WarnKeyframeBeyondDuration: "warning.keyframe_beyond_duration",
+	WarnZeroDurationSegment:    "warning.zero_duration_segment",
+	WarnKeyframesOutOfOrder:    "warning.keyframes_out_of_order",
+	WarnExtremeSpeed:           "warning.extreme_speed",
+	WarnBlackSegment:           "warning.black_segment",
+	WarnFrozenSegment:          "warning.frozen_segment",
+	WarnAudioPeaks:             "warning.audio_peaks",
+	WarnAudioNotPassedThrough:  "warning.audio_not_passed_through",
+	WarnAudioTranscode:         "warning.audio_transcode",
+	WarnAudioDuration:          "warning.audio_duration",
+	WarnStore:                  "warning.store",
+	WarnLowTempoConfidence:     "warning.low_tempo_confidence",
+	WarnQueue:                  "warning.queue",
+	WarnQueuedJobDropped:       "warning.queued_job_dropped",
+}
+
+// runWarning is a warning raised during the run.
+type runWarning struct {
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Fields  map[string]any `json:"fields,omitempty"`
+}
+
+var (
+	warningsMu sync.Mutex
+	warnings   []runWarning
+)
+
+// warn records and reports a warning. The same warning is only recorded
+// once, the plan can be computed several times in a run.
+func warn(code string, args ...any) {
+	if quiet {
+		return
+	}
+	id := warningMessages[code]
+	w := runWarning{
+		Code:    code,
+		Message: formatMessage(id, args...),
+		Fields:  messageFields(id, args...),
+	}
+
+	warningsMu.Lock()
+	for _, recorded := range warnings {
+		if recorded.Code == w.Code && recorded.Message == w.Message {
+			warningsMu.Unlock()
+			return
+		}
+	}
+	warnings = append(warnings, w)
+	warningsMu.Unlock()
+
+	if JSONOutput {
+		writeJSONLine(event{Time: time.Now(), Level: "warning", ID: code, Text: w.Message, Fields: w.Fields})
+		return
+	}
+	say("warning", code, w.Message)
+}
+
+// recordedWarnings returns the warnings raised so far.
+func recordedWarnings() []runWarning {
+	warningsMu.Lock()
+	defer warningsMu.Unlock()
+	return append([]runWarning(nil), warnings...)
+}
+
+// printWarningsSummary lists the codes of the warnings raised during the run.
+func printWarningsSummary() {
+	recorded := recordedWarnings()
+	if len(recorded) == 0 || JSONOutput {
+		return
+	}
+	var codes []string
+	for _, w := range recorded {
+		codes = append(codes, w.Code)
+	}
+	say("warnings.summary", len(recorded), strings.Join(codes, ", "))
+}
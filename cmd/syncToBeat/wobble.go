@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Wobble is the amplitude of the speed oscillation applied on every beat of
+// the synced video, 0.2 plays the video up to 20% faster and slower within
+// each beat. 0 disables the effect.
+var Wobble = 0.0
+
+// validateWobble checks that the wobble keeps the timestamps increasing even
+// in the fastest part of the grid.
+func validateWobble(grid beatGrid, amount float64) error {
+	fastest := grid.BPM
+	for _, span := range grid.Spans() {
+		fastest = math.Max(fastest, span.BPM)
+	}
+	if amount < 0 || amount*fastest/grid.BPM >= 1 {
+		return fmt.Errorf("invalid wobble %.2f, expected a fraction below %.2f", amount, grid.BPM/fastest)
+	}
+	return nil
+}
+
+// wobbleFilter returns the setpts filter oscillating the speed of the video
+// around every beat. The time shift is a sine of the beat position so the
+// beats themselves stay in place and the overall timing is untouched.
+func wobbleFilter(grid beatGrid, amount float64) string {
+	shift := fmt.Sprintf("%s*sin(2*PI*%s)", seconds(amount*grid.BeatDuration()/(2*math.Pi)), positionExpression(grid, "T"))
+	return fmt.Sprintf("setpts='(T+%s)/TB'", shift)
+}
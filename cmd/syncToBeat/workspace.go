@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// UseWorkspace keeps the files of the runs in the project directory instead of
+// next to the sources, under versioned names so that nothing gets
+// overwritten.
+var UseWorkspace = false
+
+// workspaceDir is the project directory, relative to the working directory.
+const workspaceDir = ".avsync"
+
+// the areas of the project directory.
+const (
+	areaPlans    = "plans"
+	areaCache    = "cache"
+	areaPreviews = "previews"
+	areaRenders  = "renders"
+)
+
+var workspaceAreas = []string{areaPlans, areaCache, areaPreviews, areaRenders}
+
+// versionedName matches the files of the project directory: name.vN.ext,
+// possibly with a suffix such as _audio_ before the extension.
+var versionedName = regexp.MustCompile(`^(.*)\.v(\d+)(.*)$`)
+
+var (
+	runVersionOnce sync.Once
+	runVersion     int
+)
+
+// workspaceVersion returns the version of the current run, one more than the
+// latest version found in the project directory.
+func workspaceVersion() int {
+	runVersionOnce.Do(func() {
+		for _, area := range workspaceAreas {
+			entries, _ := os.ReadDir(filepath.Join(workspaceDir, area))
+			for _, entry := range entries {
+				if match := versionedName.FindStringSubmatch(entry.Name()); match != nil {
+					version, _ := strconv.Atoi(match[2])
+					runVersion = max(runVersion, version)
+				}
+			}
+		}
+		runVersion++
+	})
+	return runVersion
+}
+
+// workspacePath returns the versioned path of a file of the current run in an
+// area of the project directory, creating the area if needed.
+func workspacePath(area string, name string, extension string) (string, error) {
+	dir := filepath.Join(workspaceDir, area)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s.v%d%s", name, workspaceVersion(), extension)), nil
+}
+
+// outputArea returns the area of the project directory the outputs of the
+// given kind are written to.
+func outputArea(kind string) string {
+	switch kind {
+	case "debug", "not_synced", "sweep", "montage":
+		return areaPreviews
+	}
+	return areaRenders
+}
+
+// savedPlan is the plan of a render, saved in the project directory.
+type savedPlan struct {
+	Grid      string     `json:"grid"`
+	Keyframes []Keyframe `json:"keyframes"`
+	Segments  []segment  `json:"segments"`
+}
+
+// saveWorkspacePlan saves the plan of the render written to outputPath.
+func saveWorkspacePlan(outputPath string, grid beatGrid, keyframes []Keyframe, segments []segment) error {
+	if !UseWorkspace {
+		return nil
+	}
+	name, _ := splitExtension(filepath.Base(outputPath))
+	if match := versionedName.FindStringSubmatch(name); match != nil {
+		name = match[1]
+	}
+	planPath, err := workspacePath(areaPlans, name, ".json")
+	if err != nil {
+		return err
+	}
+	return writePlan(planPath, grid, keyframes, segments)
+}
+
+// writePlan writes a plan as JSON, as read back by diff-plan.
+func writePlan(path string, grid beatGrid, keyframes []Keyframe, segments []segment) error {
+	data, err := json.MarshalIndent(savedPlan{Grid: grid.String(), Keyframes: keyframes, Segments: segments}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// workspaceFile is a file of the project directory.
+type workspaceFile struct {
+	path    string
+	group   string
+	version int
+	size    int64
+}
+
+// workspaceFiles lists the files of an area of the project directory.
+func workspaceFiles(area string) ([]workspaceFile, error) {
+	entries, err := os.ReadDir(filepath.Join(workspaceDir, area))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []workspaceFile
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		file := workspaceFile{path: filepath.Join(workspaceDir, area, entry.Name()), group: entry.Name(), size: info.Size()}
+		if match := versionedName.FindStringSubmatch(entry.Name()); match != nil {
+			file.group = match[1] + match[3]
+			file.version, _ = strconv.Atoi(match[2])
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// removeWorkspaceFiles deletes files and reports the space freed.
+func removeWorkspaceFiles(files []workspaceFile) error {
+	var freed int64
+	for _, file := range files {
+		if err := os.Remove(file.path); err != nil {
+			return err
+		}
+		freed += file.size
+	}
+	say("workspace.removed", len(files), float64(freed)/(1<<20))
+	return nil
+}
+
+const workspaceUsage = `Usage:
+  <program> clean [area...]    empty areas of the project directory (cache and previews by default, or all)
+  <program> gc [keep]          keep the latest versions (3 by default) of each file of the project directory`
+
+// runCleanCommand empties areas of the project directory.
+func runCleanCommand(args []string) error {
+	areas := args
+	if len(areas) == 0 {
+		areas = []string{areaCache, areaPreviews}
+	} else if len(areas) == 1 && areas[0] == "all" {
+		areas = workspaceAreas
+	}
+
+	var files []workspaceFile
+	for _, area := range areas {
+		known := false
+		for _, a := range workspaceAreas {
+			known = known || a == area
+		}
+		if !known {
+			say("usage.workspace")
+			return fmt.Errorf("unknown area %q", area)
+		}
+		areaFiles, err := workspaceFiles(area)
+		if err != nil {
+			return err
+		}
+		files = append(files, areaFiles...)
+	}
+	return removeWorkspaceFiles(files)
+}
+
+// runGCCommand removes all but the latest versions of each file of the
+// project directory.
+func runGCCommand(args []string) error {
+	keep := 3
+	if len(args) > 0 {
+		var err error
+		if keep, err = strconv.Atoi(args[0]); err != nil || keep < 1 {
+			say("usage.workspace")
+			return fmt.Errorf("invalid number of versions to keep %q", args[0])
+		}
+	}
+
+	var stale []workspaceFile
+	for _, area := range workspaceAreas {
+		files, err := workspaceFiles(area)
+		if err != nil {
+			return err
+		}
+		groups := map[string][]workspaceFile{}
+		for _, file := range files {
+			groups[file.group] = append(groups[file.group], file)
+		}
+		for _, group := range groups {
+			sort.Slice(group, func(i, j int) bool { return group[i].version > group[j].version })
+			if len(group) > keep {
+				stale = append(stale, group[keep:]...)
+			}
+		}
+	}
+	return removeWorkspaceFiles(stale)
+}
@@ -0,0 +1,126 @@
+// Package aivsync retimes videos so their keyframes land on the beats of
+// music, for Go programs embedding the pipeline of the syncToBeat command
+// without running it.
+//
+// Plan computes the retimed segments of a video, Render writes the synced
+// video with ffmpeg. The grid, the planner, the probing of the media and
+// the ffmpeg filters are in the beatgrid, plan, probe and render packages.
+//
+//	grid := beatgrid.Grid{BPM: 120}
+//	keyframes := []plan.Keyframe{{Time: 1.9}, {Time: 4.1}}
+//	segments, err := aivsync.Render(ctx, "in.mp4", keyframes, "out.mp4", aivsync.RenderOptions{
+//		PlanOptions: aivsync.PlanOptions{Grid: grid, SpeedLimit: 2},
+//		AudioPath:   "song.wav",
+//	})
+package aivsync
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/render"
+)
+
+// PlanOptions are the grid the keyframes are synced to and the settings of
+// the planner.
+type PlanOptions struct {
+	// Grid is the grid of the song. With BeatTimes, the grid follows them
+	// as with WithBeatTimes and its BPM doesn't need to be set.
+	Grid beatgrid.Grid
+	// SpeedLimit bounds the speed factor of the segments, 0 means no limit.
+	SpeedLimit float64
+	// Target is the length the edit has to fill, the zero value doesn't
+	// constrain it.
+	Target plan.Target
+	// Strict fails on the keyframes the planner would otherwise work around.
+	Strict bool
+	// Notify, when set, receives the notices of the planner about the
+	// keyframes it worked around.
+	Notify func(plan.Notice)
+}
+
+// RenderOptions are the settings of the plan and of the render.
+type RenderOptions struct {
+	PlanOptions
+	// AudioPath is the music of the synced video, the video is silent when
+	// empty.
+	AudioPath string
+	// Render tunes the ffmpeg encode.
+	Render render.Options
+}
+
+// validate checks the settings of the grid, the BPM of a grid of beat times
+// being derived from them.
+func (o PlanOptions) validate() error {
+	if o.Grid.BeatTimes != nil {
+		if err := beatgrid.ValidateBeatTimes(o.Grid.BeatTimes); err != nil {
+			return err
+		}
+	} else if o.Grid.BPM <= 0 {
+		return fmt.Errorf("invalid BPM %.2f", o.Grid.BPM)
+	}
+	if err := beatgrid.ValidateSwing(o.Grid.Swing); err != nil {
+		return err
+	}
+	if err := beatgrid.ValidateSnapTo(o.Grid.SnapTo); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Plan returns the segments of the video between its keyframes, retimed for
+// the keyframes to land on the grid. The segments are counted in frames of
+// the video at videoPath when given, so they render frame exact.
+func Plan(ctx context.Context, videoPath string, keyframes []plan.Keyframe, opts PlanOptions) ([]plan.Segment, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.Grid.BeatTimes != nil {
+		opts.Grid = opts.Grid.WithBeatTimes(opts.Grid.BeatTimes)
+	}
+	// the plan is counted in frames of the video when there is one
+	var video probe.Stream
+	if videoPath != "" {
+		info, err := probe.ProbeMediaContext(ctx, videoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe %s: %v", videoPath, err)
+		}
+		var ok bool
+		if video, ok = info.Video(); !ok {
+			return nil, fmt.Errorf("%s has no video stream", videoPath)
+		}
+	}
+	segments, err := plan.Plan(opts.Grid, keyframes, plan.Options{
+		SpeedLimit: opts.SpeedLimit,
+		Target:     opts.Target,
+		Strict:     opts.Strict,
+		FrameRate:  video.FrameRate,
+		Notify:     opts.Notify,
+	})
+	if err != nil || videoPath == "" {
+		return segments, err
+	}
+	segments = plan.Quantize(segments, video)
+	if opts.Strict {
+		if err := plan.CheckFrames(keyframes, segments); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// Render plans the video at videoPath and writes the synced video to
+// outputPath, returning the segments it rendered.
+func Render(ctx context.Context, videoPath string, keyframes []plan.Keyframe, outputPath string, opts RenderOptions) ([]plan.Segment, error) {
+	segments, err := Plan(ctx, videoPath, keyframes, opts.PlanOptions)
+	if err != nil {
+		return nil, err
+	}
+	if err := render.Render(ctx, videoPath, opts.AudioPath, segments, outputPath, opts.Render); err != nil {
+		return nil, err
+	}
+	return segments, nil
+}
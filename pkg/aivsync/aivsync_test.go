@@ -0,0 +1,43 @@
+package aivsync
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+)
+
+func TestPlan(t *testing.T) {
+	// keyframes snapped to whole beats
+	keyframes := []plan.Keyframe{{Time: 0}, {Time: 1.9}, {Time: 4.1}}
+	tests := []struct {
+		name      string
+		grid      beatgrid.Grid
+		wantBeats []float64
+		wantErr   bool
+	}{
+		{name: "tempo", grid: beatgrid.Grid{BPM: 60, Subdivision: 1}, wantBeats: []float64{2, 4}},
+		{name: "beat times only", grid: beatgrid.Grid{Subdivision: 1, BeatTimes: []float64{0, 0.9, 2.1, 3, 4.2}}, wantBeats: []float64{2.1, 4.2}},
+		{name: "beat times with a grid", grid: beatgrid.Grid{Subdivision: 1}.WithBeatTimes([]float64{0, 0.9, 2.1, 3, 4.2}), wantBeats: []float64{2.1, 4.2}},
+		{name: "no tempo", grid: beatgrid.Grid{}, wantErr: true},
+		{name: "invalid beat times", grid: beatgrid.Grid{BeatTimes: []float64{1, 0.5}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			segments, err := Plan(context.Background(), "", keyframes, PlanOptions{Grid: tt.grid})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Plan() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(segments) != len(tt.wantBeats) {
+				t.Fatalf("Plan() = %v, want segments ending at %v", segments, tt.wantBeats)
+			}
+			for i, seg := range segments {
+				if math.Abs(seg.NearestBeatTime-tt.wantBeats[i]) > 1e-9 {
+					t.Errorf("Plan() segment %d ends at %v, want %v", i, seg.NearestBeatTime, tt.wantBeats[i])
+				}
+			}
+		})
+	}
+}
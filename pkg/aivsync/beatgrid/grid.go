@@ -0,0 +1,416 @@
+// Package beatgrid describes the beats of the music the keyframes of a video
+// get synced to: a tempo, tempo sections or the times of the beats, a meter
+// and the snapping targets within the beats.
+package beatgrid
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Section overrides the tempo of the grid for a labeled section of the song,
+// for instance a half-time bridge.
+type Section struct {
+	Label string  `json:"label"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	BPM   float64 `json:"bpm"`
+}
+
+// Grid describes the beats the keyframes get synced to.
+type Grid struct {
+	BPM float64
+	// Offset is the time, in seconds, of the first beat.
+	Offset float64
+	// Sections override the tempo for parts of the song, they are sorted and
+	// don't overlap.
+	Sections []Section
+	// BeatTimes, when set, are the times of the beats of the song, for songs
+	// whose tempo drifts. They replace the tempo of BPM and the sections,
+	// the tempo before the first and after the last beat being the one of
+	// their neighboring beat. See WithBeatTimes.
+	BeatTimes []float64
+	// Subdivision is the number of snapping targets per beat, 0 uses
+	// DefaultSubdivision.
+	Subdivision int
+	// Swing is the position, in percent, of the off-beat subdivisions within
+	// each pair of subdivisions. 50 (or 0) is a straight grid and 66 a
+	// triplet feel.
+	Swing float64
+	// Meter groups the beats into bars, the zero value is 4/4.
+	Meter Meter
+	// SnapTo picks what the keyframes snap to: "beat" (the default) snaps to
+	// the subdivisions of the beats, "pulse" to the pulses of the meter and
+	// "bar" to the downbeats.
+	SnapTo string
+}
+
+// DefaultSubdivision snaps keyframes to a hundredth of a beat.
+const DefaultSubdivision = 100
+
+// ValidateSwing checks that a swing percentage is usable.
+func ValidateSwing(swing float64) error {
+	if swing != 0 && (swing < 50 || swing >= 100) {
+		return fmt.Errorf("invalid swing %.2f%%, expected a percentage between 50 and 100", swing)
+	}
+	return nil
+}
+
+// ReadSections reads the tempo sections from a JSON file.
+func ReadSections(filePath string) ([]Section, error) {
+	var sections []Section
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &sections); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Start < sections[j].Start })
+	for i, section := range sections {
+		if section.Start < 0 || section.End <= section.Start {
+			return nil, fmt.Errorf("section %q has an invalid time range: %.2fs - %.2fs", section.Label, section.Start, section.End)
+		}
+		if section.BPM <= 0 {
+			return nil, fmt.Errorf("section %q has an invalid BPM: %.2f", section.Label, section.BPM)
+		}
+		if i > 0 && section.Start < sections[i-1].End {
+			return nil, fmt.Errorf("sections %q and %q overlap", sections[i-1].Label, section.Label)
+		}
+	}
+	return sections, nil
+}
+
+// ReadBeatTimes reads the times of the beats of a song from a JSON array of
+// seconds.
+func ReadBeatTimes(filePath string) ([]float64, error) {
+	var beats []float64
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fileBytes, &beats); err != nil {
+		return nil, err
+	}
+	if err := ValidateBeatTimes(beats); err != nil {
+		return nil, err
+	}
+	return beats, nil
+}
+
+// ValidateBeatTimes checks that the beat times are usable as a grid.
+func ValidateBeatTimes(beats []float64) error {
+	if len(beats) < 2 {
+		return fmt.Errorf("at least 2 beat times are needed, %d given", len(beats))
+	}
+	for i, beat := range beats {
+		if beat < 0 {
+			return fmt.Errorf("invalid beat time %.3fs, expected a positive time", beat)
+		}
+		if i > 0 && beat <= beats[i-1] {
+			return fmt.Errorf("beat %d at %.3fs doesn't come after the previous one at %.3fs", i+1, beat, beats[i-1])
+		}
+	}
+	return nil
+}
+
+// WithBeatTimes returns the grid following the beat times, which must be
+// valid, its first beat being the first one: the Offset of the grid is
+// replaced by it. The BPM of the grid is their average tempo.
+func (g Grid) WithBeatTimes(beats []float64) Grid {
+	g.BeatTimes = append([]float64(nil), beats...)
+	g.Sections = nil
+	g.Offset = beats[0]
+	g.BPM = float64(len(beats)-1) * 60 / (beats[len(beats)-1] - beats[0])
+	return g
+}
+
+// BeatDuration returns the duration of a beat in seconds outside of the
+// sections, on average with beat times.
+func (g Grid) BeatDuration() float64 {
+	return 60 / g.BPM
+}
+
+// Span is a part of the grid with a constant tempo, lasting until the start
+// of the next one.
+type Span struct {
+	Start float64
+	BPM   float64
+}
+
+// SpanTolerance is how far, in seconds, the beat times can be from the span
+// of constant tempo they are merged into.
+const SpanTolerance = 0.005
+
+// Spans splits the grid from 0 in spans of constant tempo. With beat times,
+// the beats within SpanTolerance of a constant tempo share a span, so that
+// the expressions following the spans stay short.
+func (g Grid) Spans() []Span {
+	if len(g.BeatTimes) >= 2 {
+		return beatSpans(g.BeatTimes)
+	}
+	spans := []Span{{Start: 0, BPM: g.BPM}}
+	for i, section := range g.Sections {
+		spans = append(spans, Span{Start: section.Start, BPM: section.BPM})
+		// contiguous sections don't go back to the tempo of the grid
+		if i+1 == len(g.Sections) || g.Sections[i+1].Start > section.End {
+			spans = append(spans, Span{Start: section.End, BPM: g.BPM})
+		}
+	}
+	return spans
+}
+
+// beatSpans returns the spans of the beat times: the tempo of the first beat
+// until it, the merged spans between the first and the last beat and the
+// tempo of the last beat after it.
+func beatSpans(beats []float64) []Span {
+	last := len(beats) - 1
+	spans := []Span{{Start: 0, BPM: 60 / (beats[1] - beats[0])}}
+	add := func(span Span) {
+		// the tempos of evenly spaced beats only differ by rounding errors
+		if previous := spans[len(spans)-1].BPM; math.Abs(span.BPM-previous) > 1e-9*previous {
+			spans = append(spans, span)
+		}
+	}
+	for start := 0; start < last; {
+		end := start + 1
+		for end < last && steadyBeats(beats[start:end+2]) {
+			end++
+		}
+		add(Span{Start: beats[start], BPM: 60 * float64(end-start) / (beats[end] - beats[start])})
+		start = end
+	}
+	add(Span{Start: beats[last], BPM: 60 / (beats[last] - beats[last-1])})
+	return spans
+}
+
+// steadyBeats reports whether the beats are all within SpanTolerance of the
+// constant tempo going from the first to the last.
+func steadyBeats(beats []float64) bool {
+	n := len(beats) - 1
+	period := (beats[n] - beats[0]) / float64(n)
+	for i := 1; i < n; i++ {
+		if math.Abs(beats[0]+float64(i)*period-beats[i]) > SpanTolerance {
+			return false
+		}
+	}
+	return true
+}
+
+// beatIndex returns the position of t among the beat times, the first beat
+// being at 0.
+func (g Grid) beatIndex(t float64) float64 {
+	beats := g.BeatTimes
+	last := len(beats) - 1
+	if t <= beats[0] {
+		return (t - beats[0]) / (beats[1] - beats[0])
+	}
+	if t >= beats[last] {
+		return float64(last) + (t-beats[last])/(beats[last]-beats[last-1])
+	}
+	i := sort.SearchFloat64s(beats, t) - 1
+	return float64(i) + (t-beats[i])/(beats[i+1]-beats[i])
+}
+
+// beatIndexTime is the inverse of beatIndex.
+func (g Grid) beatIndexTime(index float64) float64 {
+	beats := g.BeatTimes
+	last := len(beats) - 1
+	if index <= 0 {
+		return beats[0] + index*(beats[1]-beats[0])
+	}
+	if index >= float64(last) {
+		return beats[last] + (index-float64(last))*(beats[last]-beats[last-1])
+	}
+	i := int(index)
+	return beats[i] + (index-float64(i))*(beats[i+1]-beats[i])
+}
+
+// SectionAt returns the section containing the time t, if any.
+func (g Grid) SectionAt(t float64) (Section, bool) {
+	for _, section := range g.Sections {
+		if t >= section.Start && t < section.End {
+			return section, true
+		}
+	}
+	return Section{}, false
+}
+
+// BeatsAt returns the number of beats elapsed between 0 and t, following the
+// tempo changes of the sections.
+func (g Grid) BeatsAt(t float64) float64 {
+	if len(g.BeatTimes) >= 2 {
+		return g.beatIndex(t) - g.beatIndex(0)
+	}
+	if t <= 0 {
+		return t * g.BPM / 60
+	}
+
+	var beats, cursor float64
+	for _, section := range g.Sections {
+		if t <= section.Start {
+			break
+		}
+		beats += (section.Start - cursor) * g.BPM / 60
+		end := math.Min(t, section.End)
+		beats += (end - section.Start) * section.BPM / 60
+		cursor = end
+	}
+	return beats + (t-cursor)*g.BPM/60
+}
+
+// TimeAtBeats is the inverse of BeatsAt.
+func (g Grid) TimeAtBeats(beats float64) float64 {
+	if len(g.BeatTimes) >= 2 {
+		return g.beatIndexTime(beats + g.beatIndex(0))
+	}
+	if beats <= 0 {
+		return beats * 60 / g.BPM
+	}
+
+	var elapsed, cursor float64
+	for _, section := range g.Sections {
+		before := (section.Start - cursor) * g.BPM / 60
+		if beats <= elapsed+before {
+			break
+		}
+		elapsed += before
+		within := (section.End - section.Start) * section.BPM / 60
+		if beats <= elapsed+within {
+			return section.Start + (beats-elapsed)*60/section.BPM
+		}
+		elapsed += within
+		cursor = section.End
+	}
+	return cursor + (beats-elapsed)*60/g.BPM
+}
+
+// BeatPosition returns the position, in beats, of the given time on the grid.
+func (g Grid) BeatPosition(t float64) float64 {
+	return g.BeatsAt(t) - g.BeatsAt(g.Offset)
+}
+
+// BeatTime returns the time, in seconds, of the given beat position.
+func (g Grid) BeatTime(position float64) float64 {
+	return g.TimeAtBeats(position + g.BeatsAt(g.Offset))
+}
+
+// ValidateSnapTo checks the snapping target setting of the grid.
+func ValidateSnapTo(snapTo string) error {
+	switch snapTo {
+	case "", "beat", "pulse", "bar":
+		return nil
+	}
+	return fmt.Errorf("invalid snapping target %q, expected beat, pulse or bar", snapTo)
+}
+
+// Snap returns the snapping target nearest to the given beat position. With
+// swing, the off-beat target of each pair of subdivisions is moved later
+// (or earlier) so it lands where the swung notes are played.
+func (g Grid) Snap(position float64) float64 {
+	beatsPerBar := float64(g.Meter.BeatsPerBar())
+	switch g.SnapTo {
+	case "bar":
+		return math.Round(position/beatsPerBar) * beatsPerBar
+	case "pulse":
+		barStart := math.Floor(position/beatsPerBar) * beatsPerBar
+		nearest := barStart + beatsPerBar
+		for _, start := range g.Meter.PulseStarts() {
+			if target := barStart + float64(start); math.Abs(position-target) < math.Abs(position-nearest) {
+				nearest = target
+			}
+		}
+		return nearest
+	}
+
+	subdivision := g.Subdivision
+	if subdivision <= 0 {
+		subdivision = DefaultSubdivision
+	}
+	step := 1 / float64(subdivision)
+	if g.Swing == 0 || g.Swing == 50 || subdivision%2 != 0 {
+		return math.Round(position/step) * step
+	}
+
+	pairStart := math.Floor(position/(2*step)) * 2 * step
+	offBeat := pairStart + 2*step*g.Swing/100
+	targets := []float64{pairStart, offBeat, pairStart + 2*step}
+	nearest := targets[0]
+	for _, target := range targets[1:] {
+		if math.Abs(position-target) < math.Abs(position-nearest) {
+			nearest = target
+		}
+	}
+	return nearest
+}
+
+// Delayed returns the grid of the music when it starts the given number of
+// seconds later.
+func (g Grid) Delayed(seconds float64) Grid {
+	g.Offset += seconds
+	sections := make([]Section, len(g.Sections))
+	for i, section := range g.Sections {
+		section.Start += seconds
+		section.End += seconds
+		sections[i] = section
+	}
+	g.Sections = sections
+	if g.BeatTimes != nil {
+		beats := make([]float64, len(g.BeatTimes))
+		for i, beat := range g.BeatTimes {
+			beats[i] = beat + seconds
+		}
+		g.BeatTimes = beats
+	}
+	return g
+}
+
+// Beat is a beat of the grid.
+type Beat struct {
+	Position float64
+	Time     float64
+	Downbeat bool
+}
+
+// Beats returns the beats of the grid from the start of the music to
+// duration.
+func (g Grid) Beats(duration float64) []Beat {
+	beatsPerBar := float64(g.Meter.BeatsPerBar())
+	var beats []Beat
+	for position := math.Ceil(g.BeatPosition(0) - 1e-9); ; position++ {
+		t := g.BeatTime(position)
+		if t >= duration {
+			break
+		}
+		inBar := math.Mod(position, beatsPerBar)
+		beats = append(beats, Beat{Position: position, Time: t, Downbeat: inBar == 0})
+	}
+	return beats
+}
+
+// String describes the grid.
+func (g Grid) String() string {
+	description := fmt.Sprintf("%.2f BPM, first beat at %.3fs", g.BPM, g.Offset)
+	if len(g.BeatTimes) >= 2 {
+		description = fmt.Sprintf("%d beats from %.3fs to %.3fs, %.2f BPM on average", len(g.BeatTimes), g.BeatTimes[0], g.BeatTimes[len(g.BeatTimes)-1], g.BPM)
+	}
+	if len(g.Meter.Groups) > 0 {
+		description += ", " + g.Meter.String()
+	}
+	if g.Swing != 0 && g.Swing != 50 {
+		description += fmt.Sprintf(", %.0f%% swing", g.Swing)
+	}
+	if len(g.Sections) == 0 {
+		return description
+	}
+	var sections []string
+	for _, section := range g.Sections {
+		sections = append(sections, fmt.Sprintf("%s %.2fs-%.2fs @ %.2f BPM", section.Label, section.Start, section.End, section.BPM))
+	}
+	return description + " (" + strings.Join(sections, ", ") + ")"
+}
@@ -0,0 +1,236 @@
+package beatgrid
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadBeatTimes(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []float64
+		wantErr bool
+	}{
+		{name: "valid", content: "[0.5, 1, 1.5]", want: []float64{0.5, 1, 1.5}},
+		{name: "single beat", content: "[0.5]", wantErr: true},
+		{name: "negative", content: "[-0.5, 1]", wantErr: true},
+		{name: "not increasing", content: "[1, 1, 2]", wantErr: true},
+		{name: "not an array", content: `{"beats": [1, 2]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "beats.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadBeatTimes(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadBeatTimes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadBeatTimes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ReadBeatTimes(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("ReadBeatTimes() of a missing file didn't fail")
+	}
+}
+
+// evenBeats returns count beats from first, period seconds apart.
+func evenBeats(first, period float64, count int) []float64 {
+	beats := make([]float64, count)
+	for i := range beats {
+		beats[i] = first + float64(i)*period
+	}
+	return beats
+}
+
+func TestSpans(t *testing.T) {
+	jittered := evenBeats(1, 0.5, 9)
+	for i := range jittered {
+		if i%2 == 1 {
+			jittered[i] += SpanTolerance / 2
+		}
+	}
+	slowing := append(evenBeats(1, 0.5, 5), evenBeats(3.6, 0.6, 4)...)
+
+	tests := []struct {
+		name string
+		grid Grid
+		want []Span
+	}{
+		{
+			name: "constant tempo",
+			grid: Grid{BPM: 120},
+			want: []Span{{Start: 0, BPM: 120}},
+		},
+		{
+			name: "sections",
+			grid: Grid{BPM: 120, Sections: []Section{{Start: 10, End: 20, BPM: 60}, {Start: 20, End: 30, BPM: 90}, {Start: 40, End: 50, BPM: 60}}},
+			want: []Span{{0, 120}, {10, 60}, {20, 90}, {30, 120}, {40, 60}, {50, 120}},
+		},
+		{
+			name: "even beat times",
+			grid: Grid{}.WithBeatTimes(evenBeats(1, 0.5, 9)),
+			want: []Span{{Start: 0, BPM: 120}},
+		},
+		{
+			name: "beat times within the tolerance",
+			grid: Grid{}.WithBeatTimes(jittered),
+			want: []Span{
+				{Start: 0, BPM: 60 / (jittered[1] - jittered[0])},
+				{Start: 1, BPM: 120},
+				{Start: jittered[8], BPM: 60 / (jittered[8] - jittered[7])},
+			},
+		},
+		{
+			name: "tempo change",
+			grid: Grid{}.WithBeatTimes(slowing),
+			want: []Span{{Start: 0, BPM: 120}, {Start: 3, BPM: 60 / 0.6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.grid.Spans()
+			if len(got) != len(tt.want) {
+				t.Fatalf("Spans() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if math.Abs(got[i].Start-tt.want[i].Start) > 1e-9 || math.Abs(got[i].BPM-tt.want[i].BPM) > 1e-6 {
+					t.Errorf("Spans()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSpansFollowBeatTimes(t *testing.T) {
+	beats := []float64{0.5, 1.02, 1.49, 2.1, 2.55, 3.2, 3.61, 4.3}
+	grid := Grid{}.WithBeatTimes(beats)
+	spans := grid.Spans()
+	for i, beat := range beats {
+		// walk the spans like the filter expressions do
+		var elapsed float64
+		for j, span := range spans {
+			end := beat
+			if j+1 < len(spans) && spans[j+1].Start < beat {
+				end = spans[j+1].Start
+			}
+			if end <= span.Start {
+				break
+			}
+			elapsed += (end - span.Start) * span.BPM / 60
+		}
+		if want := grid.BeatsAt(beat); math.Abs(elapsed-want) > SpanTolerance*4 {
+			t.Errorf("beat %d at %.3fs: %.4f beats from the spans, want %.4f", i, beat, elapsed, want)
+		}
+	}
+}
+
+func TestBeatsAt(t *testing.T) {
+	tests := []struct {
+		name string
+		grid Grid
+		time float64
+		want float64
+	}{
+		{name: "constant tempo", grid: Grid{BPM: 120}, time: 3, want: 6},
+		{name: "before 0", grid: Grid{BPM: 120}, time: -1, want: -2},
+		{name: "within a section", grid: Grid{BPM: 120, Sections: []Section{{Start: 2, End: 4, BPM: 60}}}, time: 3, want: 5},
+		{name: "after a section", grid: Grid{BPM: 120, Sections: []Section{{Start: 2, End: 4, BPM: 60}}}, time: 5, want: 8},
+		{name: "between beat times", grid: Grid{}.WithBeatTimes([]float64{1, 2, 2.5}), time: 2.25, want: 2.5},
+		{name: "before the first beat time", grid: Grid{}.WithBeatTimes([]float64{1, 2, 2.5}), time: 0.5, want: 0.5},
+		{name: "after the last beat time", grid: Grid{}.WithBeatTimes([]float64{1, 2, 2.5}), time: 3, want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.grid.BeatsAt(tt.time)
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("BeatsAt(%v) = %v, want %v", tt.time, got, tt.want)
+			}
+			if back := tt.grid.TimeAtBeats(got); math.Abs(back-tt.time) > 1e-9 {
+				t.Errorf("TimeAtBeats(%v) = %v, want %v", got, back, tt.time)
+			}
+		})
+	}
+}
+
+func TestReadSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []Section
+		wantErr bool
+	}{
+		{
+			name:    "sorted",
+			content: `[{"label": "b", "start": 20, "end": 30, "bpm": 90}, {"label": "a", "start": 10, "end": 20, "bpm": 60}]`,
+			want:    []Section{{Label: "a", Start: 10, End: 20, BPM: 60}, {Label: "b", Start: 20, End: 30, BPM: 90}},
+		},
+		{name: "overlapping", content: `[{"start": 10, "end": 25, "bpm": 60}, {"start": 20, "end": 30, "bpm": 90}]`, wantErr: true},
+		{name: "empty range", content: `[{"start": 10, "end": 10, "bpm": 60}]`, wantErr: true},
+		{name: "no tempo", content: `[{"start": 10, "end": 20}]`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sections.json")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := ReadSections(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ReadSections() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ReadSections() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSnap(t *testing.T) {
+	tests := []struct {
+		name     string
+		grid     Grid
+		position float64
+		want     float64
+	}{
+		{name: "default subdivision", grid: Grid{}, position: 1.234, want: 1.23},
+		{name: "quarter beats", grid: Grid{Subdivision: 4}, position: 1.3, want: 1.25},
+		{name: "straight swing", grid: Grid{Subdivision: 2, Swing: 50}, position: 1.4, want: 1.5},
+		{name: "swung off-beat", grid: Grid{Subdivision: 2, Swing: 66}, position: 1.6, want: 1.66},
+		{name: "swung on-beat", grid: Grid{Subdivision: 2, Swing: 66}, position: 1.2, want: 1},
+		{name: "odd subdivision ignores swing", grid: Grid{Subdivision: 3, Swing: 66}, position: 1.3, want: 1.0 + 1.0/3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grid.Snap(tt.position); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Snap(%v) = %v, want %v", tt.position, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSwing(t *testing.T) {
+	tests := []struct {
+		swing   float64
+		wantErr bool
+	}{
+		{swing: 0},
+		{swing: 50},
+		{swing: 66},
+		{swing: 40, wantErr: true},
+		{swing: 100, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := ValidateSwing(tt.swing); (err != nil) != tt.wantErr {
+			t.Errorf("ValidateSwing(%v) error = %v, wantErr %v", tt.swing, err, tt.wantErr)
+		}
+	}
+}
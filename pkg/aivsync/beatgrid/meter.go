@@ -0,0 +1,123 @@
+package beatgrid
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Meter describes how the beats of the grid are grouped into bars. The BPM of
+// the grid counts the notes of the meter unit, so a 7/8 bar lasts 7 beats.
+type Meter struct {
+	// Groups lists the number of beats of each pulse of a bar, 2+2+3 for a
+	// 7/8 bar or 3+3 for a compound 6/8 bar.
+	Groups []int
+	// Unit is the note value of a beat (4 for quarter notes, 8 for eighths).
+	Unit int
+}
+
+// DefaultMeter is a 4/4 bar with a pulse on every beat.
+var DefaultMeter = Meter{Groups: []int{1, 1, 1, 1}, Unit: 4}
+
+// ParseMeter parses a time signature such as 4/4, 5/4, 7/8 or 6/8. The
+// grouping of the beats can be given explicitly, 3+2+2/8 for instance,
+// otherwise eighth note meters are grouped by 3 when compound (6/8, 9/8, 12/8)
+// or by 2 with a final group of 3 when odd (5/8, 7/8).
+func ParseMeter(value string) (Meter, error) {
+	countStr, unitStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return Meter{}, fmt.Errorf("invalid meter %q, expected a time signature like 7/8", value)
+	}
+	unit, err := strconv.Atoi(unitStr)
+	if err != nil || unit <= 0 {
+		return Meter{}, fmt.Errorf("invalid meter unit in %q", value)
+	}
+
+	var groups []int
+	for _, part := range strings.Split(countStr, "+") {
+		count, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || count <= 0 {
+			return Meter{}, fmt.Errorf("invalid beat count in %q", value)
+		}
+		groups = append(groups, count)
+	}
+	if len(groups) > 1 {
+		return Meter{Groups: groups, Unit: unit}, nil
+	}
+
+	count := groups[0]
+	groups = nil
+	switch {
+	case unit < 8:
+		for i := 0; i < count; i++ {
+			groups = append(groups, 1)
+		}
+	case count%3 == 0:
+		for i := 0; i < count/3; i++ {
+			groups = append(groups, 3)
+		}
+	case count < 4:
+		groups = []int{count}
+	default:
+		for remaining := count; remaining > 0; {
+			if remaining == 3 {
+				groups = append(groups, 3)
+				break
+			}
+			groups = append(groups, 2)
+			remaining -= 2
+		}
+	}
+	return Meter{Groups: groups, Unit: unit}, nil
+}
+
+// OrDefault returns the default meter when m isn't set.
+func (m Meter) OrDefault() Meter {
+	if len(m.Groups) == 0 {
+		return DefaultMeter
+	}
+	return m
+}
+
+// BeatsPerBar returns the number of beats of a bar.
+func (m Meter) BeatsPerBar() int {
+	var total int
+	for _, group := range m.OrDefault().Groups {
+		total += group
+	}
+	return total
+}
+
+// PulseStarts returns the positions, in beats from the start of the bar, of
+// the felt pulses of the bar.
+func (m Meter) PulseStarts() []int {
+	var starts []int
+	var position int
+	for _, group := range m.OrDefault().Groups {
+		starts = append(starts, position)
+		position += group
+	}
+	return starts
+}
+
+// BarAndBeat splits a beat position into a 1 based bar number and the
+// 1 based beat within that bar.
+func (m Meter) BarAndBeat(position float64) (int, float64) {
+	beatsPerBar := float64(m.BeatsPerBar())
+	bar := math.Floor(position / beatsPerBar)
+	return int(bar) + 1, position - bar*beatsPerBar + 1
+}
+
+// String returns the time signature of the meter.
+func (m Meter) String() string {
+	m = m.OrDefault()
+	var groups []string
+	for _, group := range m.Groups {
+		groups = append(groups, strconv.Itoa(group))
+	}
+	if m.Unit < 8 {
+		return fmt.Sprintf("%d/%d", m.BeatsPerBar(), m.Unit)
+	}
+	return fmt.Sprintf("%s/%d", strings.Join(groups, "+"), m.Unit)
+}
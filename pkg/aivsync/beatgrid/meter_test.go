@@ -0,0 +1,93 @@
+package beatgrid
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseMeter(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Meter
+		wantErr bool
+	}{
+		{value: "4/4", want: Meter{Groups: []int{1, 1, 1, 1}, Unit: 4}},
+		{value: "6/8", want: Meter{Groups: []int{3, 3}, Unit: 8}},
+		{value: "7/8", want: Meter{Groups: []int{2, 2, 3}, Unit: 8}},
+		{value: "3+2+2/8", want: Meter{Groups: []int{3, 2, 2}, Unit: 8}},
+		{value: "2/8", want: Meter{Groups: []int{2}, Unit: 8}},
+		{value: "4", wantErr: true},
+		{value: "0/4", wantErr: true},
+		{value: "4/x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseMeter(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseMeter(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMeter(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMeterBars(t *testing.T) {
+	tests := []struct {
+		meter      string
+		position   float64
+		wantBar    int
+		wantBeat   float64
+		wantPerBar int
+		wantPulses []int
+	}{
+		{meter: "4/4", position: 5.5, wantBar: 2, wantBeat: 2.5, wantPerBar: 4, wantPulses: []int{0, 1, 2, 3}},
+		{meter: "7/8", position: 15, wantBar: 3, wantBeat: 2, wantPerBar: 7, wantPulses: []int{0, 2, 4}},
+		{meter: "6/8", position: -1, wantBar: 0, wantBeat: 6, wantPerBar: 6, wantPulses: []int{0, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.meter, func(t *testing.T) {
+			meter, err := ParseMeter(tt.meter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := meter.BeatsPerBar(); got != tt.wantPerBar {
+				t.Errorf("BeatsPerBar() = %d, want %d", got, tt.wantPerBar)
+			}
+			if got := meter.PulseStarts(); !reflect.DeepEqual(got, tt.wantPulses) {
+				t.Errorf("PulseStarts() = %v, want %v", got, tt.wantPulses)
+			}
+			if bar, beat := meter.BarAndBeat(tt.position); bar != tt.wantBar || math.Abs(beat-tt.wantBeat) > 1e-9 {
+				t.Errorf("BarAndBeat(%v) = %d, %v, want %d, %v", tt.position, bar, beat, tt.wantBar, tt.wantBeat)
+			}
+		})
+	}
+}
+
+func TestSnapToMeter(t *testing.T) {
+	sevenEight, err := ParseMeter("7/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		grid     Grid
+		position float64
+		want     float64
+	}{
+		{name: "bar", grid: Grid{SnapTo: "bar"}, position: 5.9, want: 4},
+		{name: "next bar", grid: Grid{SnapTo: "bar"}, position: 6.1, want: 8},
+		{name: "odd bar", grid: Grid{SnapTo: "bar", Meter: sevenEight}, position: 10, want: 7},
+		{name: "pulse", grid: Grid{SnapTo: "pulse", Meter: sevenEight}, position: 4.4, want: 4},
+		{name: "pulse of the next bar", grid: Grid{SnapTo: "pulse", Meter: sevenEight}, position: 6.6, want: 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.grid.Snap(tt.position); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Snap(%v) = %v, want %v", tt.position, got, tt.want)
+			}
+		})
+	}
+}
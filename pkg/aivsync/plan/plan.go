@@ -0,0 +1,268 @@
+// Package plan computes how the segments of a video between its keyframes
+// get retimed so that every keyframe lands on a beat of the grid.
+package plan
+
+import (
+	"fmt"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// Keyframe is a moment of the video to sync to the beat.
+type Keyframe struct {
+	Time float64 `json:"time"`
+	// Label optionally names the keyframe so title cards can refer to it.
+	Label string `json:"label,omitempty"`
+	// Priority ranks the segment ending at the keyframe, the lowest ones are
+	// dropped first to fit a target length.
+	Priority int `json:"priority,omitempty"`
+}
+
+// Segment is the section of the source video between two keyframes and how
+// it gets retimed so its last keyframe lands on the beat.
+type Segment struct {
+	// Keyframe is the index of the keyframe ending the segment.
+	Keyframe int
+	// Start and End delimit the segment in the source video.
+	Start float64
+	End   float64
+	// NearestBeatTime is where the keyframe ending the segment gets moved to.
+	NearestBeatTime float64
+	SpeedFactor     float64
+	// StartPTS and EndPTS delimit the segment exactly, in ticks of Timebase,
+	// once the segment is quantized. Timebase is zero otherwise.
+	StartPTS int64          `json:",omitempty"`
+	EndPTS   int64          `json:",omitempty"`
+	Timebase probe.Rational `json:",omitempty"`
+	// StartFrame, EndFrame and BeatFrame count Start, End and
+	// NearestBeatTime in frames of FrameRate when the plan is counted in
+	// frames, FrameRate is zero otherwise.
+	StartFrame int64          `json:",omitempty"`
+	EndFrame   int64          `json:",omitempty"`
+	BeatFrame  int64          `json:",omitempty"`
+	FrameRate  probe.Rational `json:",omitempty"`
+	// Reasons explain where the keyframe lands, in the order the planner
+	// made its decisions.
+	Reasons []Reason `json:"-"`
+}
+
+// Reason is a decision of the planner about a keyframe, identified by a
+// message ID such as "reason.speed_limit" with the arguments of the message.
+type Reason struct {
+	ID   string
+	Args []any
+}
+
+// Explain records a reason the keyframe of the segment lands where it does.
+func (seg *Segment) Explain(id string, args ...any) {
+	seg.Reasons = append(seg.Reasons, Reason{id, args})
+}
+
+// ExtremeSpeedFactor is the speed change above which a segment is reported
+// with ExtremeSpeed.
+const ExtremeSpeedFactor = 4
+
+// The notices the planner reports while working around the keyframes.
+const (
+	// SkippedFirst reports that the first keyframe, at 0, starts the video.
+	SkippedFirst = "skipped_first"
+	// ZeroDuration reports a keyframe sharing the time of the previous one,
+	// its segment is skipped. Args: keyframe.
+	ZeroDuration = "zero_duration"
+	// OutOfOrder reports a keyframe before the previous one. Args: keyframe,
+	// time, previous time.
+	OutOfOrder = "out_of_order"
+	// SpeedClamp reports a keyframe landing on the beat of the previous one,
+	// its segment is squeezed to 0.01s, or a frame when the plan is counted
+	// in frames. Args: keyframe.
+	SpeedClamp = "speed_clamp"
+	// ExtremeSpeed reports a segment playing more than ExtremeSpeedFactor
+	// times faster or slower than the original. Args: keyframe, speed.
+	ExtremeSpeed = "extreme_speed"
+)
+
+// Notice is something the planner reports about the keyframes.
+type Notice struct {
+	ID   string
+	Args []any
+}
+
+// Options tune the planner, the zero value snaps every keyframe to its
+// nearest target on the grid.
+type Options struct {
+	// SpeedLimit bounds the speed factor of the segments, 0 means no limit.
+	// A keyframe whose nearest target would need a faster (or slower) segment
+	// lands on a neighboring beat instead when one fits.
+	SpeedLimit float64
+	// Target is the length the edit has to fill.
+	Target Target
+	// Strict turns the workarounds of the planner into errors.
+	Strict bool
+	// FrameRate, when set, counts the plan in frames of the video: the
+	// keyframes and the beats they land on are moved onto frames and the
+	// speed factors are ratios of frame counts, so the edit doesn't drift
+	// over hour long videos.
+	FrameRate probe.Rational
+	// Notify, when set, receives the notices of the planner.
+	Notify func(Notice)
+}
+
+// notify reports a notice.
+func (o Options) notify(id string, args ...any) {
+	if o.Notify != nil {
+		o.Notify(Notice{ID: id, Args: args})
+	}
+}
+
+// StrictError returns the error of the strict mode about a keyframe, located
+// by its index, label and time.
+func StrictError(i int, kf Keyframe, format string, args ...any) error {
+	location := fmt.Sprintf("keyframe %d", i)
+	if kf.Label != "" {
+		location += fmt.Sprintf(" %q", kf.Label)
+	}
+	location += " at " + probe.Timestamp(kf.Time)
+	return fmt.Errorf("strict mode: %s %s", location, fmt.Sprintf(format, args...))
+}
+
+// snapReason explains the snapping of a keyframe to the grid, shift being
+// the move from its original time to the nearest target.
+func snapReason(grid beatgrid.Grid, shift float64) Reason {
+	switch grid.SnapTo {
+	case "bar":
+		return Reason{"reason.snap_bar", []any{shift}}
+	case "pulse":
+		return Reason{"reason.snap_pulse", []any{grid.Meter.String(), shift}}
+	}
+	subdivision := grid.Subdivision
+	if subdivision <= 0 {
+		subdivision = beatgrid.DefaultSubdivision
+	}
+	if grid.Swing != 0 && grid.Swing != 50 && subdivision%2 == 0 {
+		return Reason{"reason.snap_swing", []any{subdivision, grid.Swing, shift}}
+	}
+	if subdivision == 1 {
+		return Reason{"reason.snap_beat", []any{shift}}
+	}
+	return Reason{"reason.snap_subdivision", []any{subdivision, shift}}
+}
+
+// landingTime returns the time the keyframe at t lands on, the previous
+// keyframe being at lastTime, and the reasons it lands there.
+func landingTime(grid beatgrid.Grid, t float64, lastTime float64, speedLimit float64) (float64, []Reason) {
+	position := grid.BeatPosition(t)
+	nearest := grid.BeatTime(grid.Snap(position))
+	reasons := []Reason{snapReason(grid, nearest-t)}
+	if speedLimit <= 0 {
+		return nearest, reasons
+	}
+	step := 1.0
+	if grid.SnapTo == "bar" {
+		step = float64(grid.Meter.BeatsPerBar())
+	}
+	for _, shift := range []float64{0, -step, step, -2 * step, 2 * step} {
+		candidate := grid.BeatTime(grid.Snap(position + shift))
+		if candidate <= lastTime {
+			continue
+		}
+		speed := (t - lastTime) / (candidate - lastTime)
+		if speed <= speedLimit && speed >= 1/speedLimit {
+			if candidate != nearest {
+				reasons = append(reasons, Reason{"reason.speed_limit", []any{speedLimit, shift, candidate - nearest}})
+			}
+			return candidate, reasons
+		}
+	}
+	return nearest, append(reasons, Reason{"reason.speed_limit_missed", []any{speedLimit}})
+}
+
+// Plan splits the video at each keyframe and computes the speed factor
+// needed for every keyframe to land on its nearest beat.
+func Plan(grid beatgrid.Grid, keyframes []Keyframe, opts Options) ([]Segment, error) {
+	var segments []Segment
+	clock := frameClock(opts.FrameRate)
+
+	lastTime := 0.0
+	var lastFrame int64
+	for i, kf := range keyframes {
+		if i == 0 && kf.Time == 0.0 {
+			opts.notify(SkippedFirst)
+			continue
+		}
+
+		nearestBeatTime, reasons := landingTime(grid, kf.Time, lastTime, opts.SpeedLimit)
+		keyframeTime := kf.Time
+		keyframeFrame, beatFrame := clock.frame(kf.Time), clock.frame(nearestBeatTime)
+		if clock.counts() {
+			keyframeTime, nearestBeatTime = clock.time(keyframeFrame), clock.time(beatFrame)
+		}
+
+		segmentDuration := keyframeTime - lastTime
+		// Avoid division by zero by ensuring segmentDuration is not zero
+		if segmentDuration == 0 {
+			if opts.Strict {
+				return nil, StrictError(i, kf, "has the same time as the previous keyframe, its segment would be skipped")
+			}
+			opts.notify(ZeroDuration, i)
+			continue
+		}
+		if segmentDuration < 0 {
+			if opts.Strict {
+				return nil, StrictError(i, kf, "comes before the previous keyframe at %s", probe.Timestamp(lastTime))
+			}
+			opts.notify(OutOfOrder, i, kf.Time, lastTime)
+		}
+
+		adjustedSegmentDuration := nearestBeatTime - lastTime
+		adjustedFrames := beatFrame - lastFrame
+		// ensure adjustedSegmentDuration is not zero to avoid NaN speed factor
+		if adjustedSegmentDuration == 0 {
+			if opts.Strict {
+				return nil, StrictError(i, kf, "lands on the beat of the previous keyframe at %s, its segment would be squeezed to %s", probe.Timestamp(nearestBeatTime), clock.squeeze())
+			}
+			opts.notify(SpeedClamp, i)
+			adjustedSegmentDuration = 0.01 // A small, non-zero value
+			adjustedFrames = 1
+			reasons = append(reasons, Reason{"reason.speed_clamped", nil})
+		}
+
+		speedFactor := segmentDuration / adjustedSegmentDuration
+		if clock.counts() {
+			speedFactor = float64(keyframeFrame-lastFrame) / float64(adjustedFrames)
+		}
+		if opts.Strict && opts.SpeedLimit > 0 && (speedFactor > opts.SpeedLimit || speedFactor < 1/opts.SpeedLimit) {
+			return nil, StrictError(i, kf, "needs a speed of %.3fx to land on a beat, beyond the speed limit of %gx", speedFactor, opts.SpeedLimit)
+		}
+		if speedFactor > ExtremeSpeedFactor || speedFactor < 1.0/ExtremeSpeedFactor {
+			opts.notify(ExtremeSpeed, i, speedFactor)
+		}
+
+		seg := Segment{
+			Keyframe:        i,
+			Start:           lastTime,
+			End:             keyframeTime,
+			NearestBeatTime: nearestBeatTime,
+			SpeedFactor:     speedFactor,
+			Reasons:         reasons,
+		}
+		if clock.counts() {
+			seg.StartFrame, seg.EndFrame, seg.BeatFrame = lastFrame, keyframeFrame, beatFrame
+			seg.FrameRate = opts.FrameRate
+		}
+		segments = append(segments, seg)
+
+		lastTime = keyframeTime
+		lastFrame = keyframeFrame
+	}
+
+	// Ensure we have segments to concatenate
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("no segments to process")
+	}
+
+	if opts.Target.IsSet() {
+		return fitToTarget(grid, keyframes, segments, opts)
+	}
+	return segments, nil
+}
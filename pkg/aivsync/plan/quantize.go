@@ -0,0 +1,108 @@
+package plan
+
+import (
+	"math"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// mulDivRound returns a*b/c rounded to the nearest integer, c being positive.
+func mulDivRound(a, b, c int64) int64 {
+	product := a * b
+	if product < 0 {
+		return -((-product + c/2) / c)
+	}
+	return (product + c/2) / c
+}
+
+// frameClock counts times in frames of a frame rate, the zero value
+// counting nothing.
+type frameClock probe.Rational
+
+// counts reports whether the clock has a frame rate.
+func (c frameClock) counts() bool {
+	return c.Num > 0 && c.Den > 0
+}
+
+// frame returns the frame nearest to the time t, 0 without a frame rate.
+func (c frameClock) frame(t float64) int64 {
+	if !c.counts() {
+		return 0
+	}
+	return int64(math.Round(t * float64(c.Num) / float64(c.Den)))
+}
+
+// time returns the time of the frame.
+func (c frameClock) time(frame int64) float64 {
+	return float64(frame) * float64(c.Den) / float64(c.Num)
+}
+
+// squeeze describes the shortest a segment gets, for the strict mode.
+func (c frameClock) squeeze() string {
+	if c.counts() {
+		return "a frame"
+	}
+	return "0.01s"
+}
+
+// frameTicks returns the timestamp, in ticks of the timebase, of the frame
+// nearest to the time t.
+func frameTicks(t float64, frameRate probe.Rational, timebase probe.Rational) int64 {
+	return ticksOfFrame(frameClock(frameRate).frame(t), frameRate, timebase)
+}
+
+// ticksOfFrame returns the timestamp, in ticks of the timebase, of a frame.
+func ticksOfFrame(frame int64, frameRate probe.Rational, timebase probe.Rational) int64 {
+	// frame / frameRate / timebase = frame * frameRate.Den * timebase.Den / (frameRate.Num * timebase.Num)
+	return mulDivRound(frame*frameRate.Den, timebase.Den, frameRate.Num*timebase.Num)
+}
+
+// ticksSeconds converts ticks of the timebase to seconds.
+func ticksSeconds(ticks int64, timebase probe.Rational) float64 {
+	return float64(ticks) * float64(timebase.Num) / float64(timebase.Den)
+}
+
+// Quantize moves the boundaries of the segments onto the frames of the video
+// and counts them in ticks of its timebase, so the trims and the
+// concatenation stay frame exact over hour long videos where float seconds
+// drift. The speed factors are recomputed for the snapped segments to keep
+// lasting the beats they were planned to, the segments planned in frames of
+// the video already being on its frames. The segments are left as they are
+// when the video doesn't report its timebase or frame rate.
+func Quantize(segments []Segment, video probe.Stream) []Segment {
+	if video.TimeBase.Den == 0 || video.FrameRate.Den == 0 {
+		return segments
+	}
+	quantized := make([]Segment, len(segments))
+	for i, seg := range segments {
+		seg.Timebase = video.TimeBase
+		if seg.FrameRate == video.FrameRate {
+			seg.StartPTS = ticksOfFrame(seg.StartFrame, video.FrameRate, video.TimeBase)
+			seg.EndPTS = ticksOfFrame(seg.EndFrame, video.FrameRate, video.TimeBase)
+			quantized[i] = seg
+			continue
+		}
+		// the time the segment lasts once retimed
+		beatSpan := (seg.End - seg.Start) / seg.SpeedFactor
+		seg.StartPTS = frameTicks(seg.Start, video.FrameRate, video.TimeBase)
+		seg.EndPTS = frameTicks(seg.End, video.FrameRate, video.TimeBase)
+		seg.Start = ticksSeconds(seg.StartPTS, video.TimeBase)
+		seg.End = ticksSeconds(seg.EndPTS, video.TimeBase)
+		if seg.EndPTS != seg.StartPTS {
+			seg.SpeedFactor = (seg.End - seg.Start) / beatSpan
+		}
+		quantized[i] = seg
+	}
+	return quantized
+}
+
+// CheckFrames checks that every quantized segment still lasts a frame,
+// shorter ones rendering nothing.
+func CheckFrames(keyframes []Keyframe, segments []Segment) error {
+	for _, seg := range segments {
+		if seg.Timebase.Den != 0 && seg.EndPTS <= seg.StartPTS {
+			return StrictError(seg.Keyframe, keyframes[seg.Keyframe], "ends a segment shorter than a frame, from %s", probe.Timestamp(seg.Start))
+		}
+	}
+	return nil
+}
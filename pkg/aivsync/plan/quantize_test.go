@@ -0,0 +1,145 @@
+package plan
+
+import (
+	"math"
+	"testing"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+func TestQuantize(t *testing.T) {
+	ntsc := probe.Stream{TimeBase: probe.Rational{Num: 1, Den: 30000}, FrameRate: probe.Rational{Num: 30000, Den: 1001}}
+	tests := []struct {
+		name      string
+		video     probe.Stream
+		segment   Segment
+		wantStart int64
+		wantEnd   int64
+	}{
+		{
+			name:      "on frames",
+			video:     ntsc,
+			segment:   Segment{Start: 0, End: 1.001, SpeedFactor: 1},
+			wantStart: 0,
+			wantEnd:   30030,
+		},
+		{
+			name:      "between frames",
+			video:     ntsc,
+			segment:   Segment{Start: 0.51, End: 2.49, SpeedFactor: 1.2},
+			wantStart: 15015,
+			wantEnd:   75075,
+		},
+		{
+			name:      "millisecond timebase",
+			video:     probe.Stream{TimeBase: probe.Rational{Num: 1, Den: 1000}, FrameRate: probe.Rational{Num: 25, Den: 1}},
+			segment:   Segment{Start: 1.01, End: 1.99, SpeedFactor: 0.8},
+			wantStart: 1000,
+			wantEnd:   2000,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			beatSpan := (tt.segment.End - tt.segment.Start) / tt.segment.SpeedFactor
+			got := Quantize([]Segment{tt.segment}, tt.video)[0]
+			if got.StartPTS != tt.wantStart || got.EndPTS != tt.wantEnd {
+				t.Fatalf("Quantize() = %d-%d ticks, want %d-%d", got.StartPTS, got.EndPTS, tt.wantStart, tt.wantEnd)
+			}
+			if got.Timebase != tt.video.TimeBase {
+				t.Errorf("Quantize() timebase = %v, want %v", got.Timebase, tt.video.TimeBase)
+			}
+			// the snapped segment still lasts the beats it was planned to
+			if span := (got.End - got.Start) / got.SpeedFactor; math.Abs(span-beatSpan) > 1e-9 {
+				t.Errorf("Quantize() lasts %vs once retimed, want %vs", span, beatSpan)
+			}
+		})
+	}
+}
+
+func TestQuantizeWithoutTimebase(t *testing.T) {
+	segments := []Segment{{Start: 0.51, End: 2.49, SpeedFactor: 1.2}}
+	got := Quantize(segments, probe.Stream{FrameRate: probe.Rational{Num: 25, Den: 1}})
+	if got[0].Start != 0.51 || got[0].End != 2.49 || got[0].SpeedFactor != 1.2 || got[0].Timebase.Den != 0 {
+		t.Errorf("Quantize() = %+v, want the segments unchanged", got[0])
+	}
+}
+
+func TestMulDivRound(t *testing.T) {
+	tests := []struct {
+		a, b, c int64
+		want    int64
+	}{
+		{a: 10, b: 3, c: 4, want: 8},
+		{a: 10, b: 1, c: 4, want: 3},
+		{a: -10, b: 1, c: 4, want: -3},
+		{a: 1001, b: 30000, c: 30000, want: 1001},
+	}
+	for _, tt := range tests {
+		if got := mulDivRound(tt.a, tt.b, tt.c); got != tt.want {
+			t.Errorf("mulDivRound(%d, %d, %d) = %d, want %d", tt.a, tt.b, tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestPlanFrames(t *testing.T) {
+	ntsc := probe.Stream{TimeBase: probe.Rational{Num: 1, Den: 30000}, FrameRate: probe.Rational{Num: 30000, Den: 1001}}
+	// an hour of keyframes slightly off the beats of 123 BPM
+	grid := beatgrid.Grid{BPM: 123, Subdivision: 1}
+	keyframes := []Keyframe{{Time: 0}}
+	for beat := 2.0; beat < 123*60; beat += 2 {
+		keyframes = append(keyframes, Keyframe{Time: grid.BeatTime(beat) + 0.013})
+	}
+	segments, err := Plan(grid, keyframes, Options{FrameRate: ntsc.FrameRate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	quantized := Quantize(segments, ntsc)
+
+	var previous Segment
+	for i, seg := range quantized {
+		if seg.FrameRate != ntsc.FrameRate {
+			t.Fatalf("segment %d frame rate = %v, want %v", i, seg.FrameRate, ntsc.FrameRate)
+		}
+		if seg.StartFrame != previous.EndFrame {
+			t.Fatalf("segment %d starts at frame %d, the previous one ends at %d", i, seg.StartFrame, previous.EndFrame)
+		}
+		// 1001 ticks of 1/30000s per frame
+		if seg.StartPTS != seg.StartFrame*1001 || seg.EndPTS != seg.EndFrame*1001 {
+			t.Fatalf("segment %d = %d-%d ticks, want frames %d-%d", i, seg.StartPTS, seg.EndPTS, seg.StartFrame, seg.EndFrame)
+		}
+		if want := float64(seg.EndFrame-seg.StartFrame) / float64(seg.BeatFrame-seg.StartFrame); seg.SpeedFactor != want {
+			t.Fatalf("segment %d speed = %v, want %v", i, seg.SpeedFactor, want)
+		}
+		if beat := grid.BeatTime(float64(2 * (i + 1))); math.Abs(seg.NearestBeatTime-beat) > 0.5*1001/30000 {
+			t.Fatalf("segment %d lands at %vs, more than half a frame from its beat at %vs", i, seg.NearestBeatTime, beat)
+		}
+		previous = seg
+	}
+}
+
+func TestPlanFramesTarget(t *testing.T) {
+	pal := probe.Rational{Num: 25, Den: 1}
+	grid := beatgrid.Grid{BPM: 100, Subdivision: 1}
+	keyframes := []Keyframe{{Time: 1.21}, {Time: 2.38}, {Time: 3.61}}
+	segments, err := Plan(grid, keyframes, Options{FrameRate: pal, Target: Target{Duration: 3.3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var previousFrame int64
+	for i, seg := range segments {
+		if seg.BeatFrame <= previousFrame {
+			t.Fatalf("segment %d lands on frame %d, not after %d", i, seg.BeatFrame, previousFrame)
+		}
+		if want := float64(seg.BeatFrame) / 25; seg.NearestBeatTime != want {
+			t.Errorf("segment %d lands at %vs, want frame %d at %vs", i, seg.NearestBeatTime, seg.BeatFrame, want)
+		}
+		if want := float64(seg.EndFrame-seg.StartFrame) / float64(seg.BeatFrame-previousFrame); seg.SpeedFactor != want {
+			t.Errorf("segment %d speed = %v, want %v", i, seg.SpeedFactor, want)
+		}
+		previousFrame = seg.BeatFrame
+	}
+	if want := int64(math.Round(3.3 * 25)); previousFrame != want {
+		t.Errorf("the edit ends on frame %d, want frame %d of the target", previousFrame, want)
+	}
+}
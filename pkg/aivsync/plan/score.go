@@ -0,0 +1,57 @@
+package plan
+
+import (
+	"math"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+// weights of the components of the sync score.
+const (
+	residualWeight      = 4.0
+	speedVarianceWeight = 1.0
+)
+
+// SyncScore rates how well a plan syncs the keyframes to the music.
+type SyncScore struct {
+	// Score goes from 0 to 100, a perfect sync scoring 100.
+	Score float64
+	// Residual is the mean distance, in beats, between where the keyframes
+	// land and the nearest whole beat, weighted by the keyframe priorities.
+	Residual float64
+	// SpeedVariance is the variance of the speed changes of the segments, in
+	// octaves (log2 of the speed factor), so that halving and doubling the
+	// speed count the same.
+	SpeedVariance float64
+}
+
+// Score computes the sync score of a plan.
+func Score(grid beatgrid.Grid, keyframes []Keyframe, segments []Segment) SyncScore {
+	if len(segments) == 0 {
+		return SyncScore{}
+	}
+
+	var residual, totalWeight float64
+	var speeds []float64
+	for _, seg := range segments {
+		weight := 1 + math.Max(float64(keyframes[seg.Keyframe].Priority), 0)
+		position := grid.BeatPosition(seg.NearestBeatTime)
+		residual += weight * math.Abs(position-math.Round(position))
+		totalWeight += weight
+		speeds = append(speeds, math.Log2(seg.SpeedFactor))
+	}
+	residual /= totalWeight
+
+	var mean, variance float64
+	for _, speed := range speeds {
+		mean += speed
+	}
+	mean /= float64(len(speeds))
+	for _, speed := range speeds {
+		variance += (speed - mean) * (speed - mean)
+	}
+	variance /= float64(len(speeds))
+
+	cost := residualWeight*residual + speedVarianceWeight*variance
+	return SyncScore{Score: 100 / (1 + cost), Residual: residual, SpeedVariance: variance}
+}
@@ -0,0 +1,158 @@
+package plan
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/beatgrid"
+)
+
+// Target is the length the edit has to fill, for instance to fit an ad
+// spot. Only one of Bars and Duration is set, the zero value doesn't
+// constrain the edit.
+type Target struct {
+	Bars     int
+	Duration float64
+}
+
+// IsSet reports whether the target constrains the edit.
+func (t Target) IsSet() bool {
+	return t.Bars > 0 || t.Duration > 0
+}
+
+// End returns the time at which the edit has to end.
+func (t Target) End(grid beatgrid.Grid) float64 {
+	if t.Bars > 0 {
+		return grid.BeatTime(float64(t.Bars * grid.Meter.BeatsPerBar()))
+	}
+	return t.Duration
+}
+
+// fitToTarget makes the segments end exactly at the target. The
+// lowest priority segments (the latest ones first on ties) are dropped as
+// long as the rest still fills the target, then the remaining difference is
+// spread over the segments by whole beats, so the keyframes stay on the
+// grid, and the last segment absorbs what's left.
+func fitToTarget(grid beatgrid.Grid, keyframes []Keyframe, segments []Segment, opts Options) ([]Segment, error) {
+	target := opts.Target
+	if target.Bars > 0 && target.Duration > 0 {
+		return nil, fmt.Errorf("the target can be a number of bars or a duration, not both")
+	}
+	end := target.End(grid)
+	if end <= 0 {
+		return nil, fmt.Errorf("invalid target duration %.2fs", end)
+	}
+
+	// work in beats, the length of a segment is the distance between its
+	// keyframe and the previous one on the grid
+	start := grid.BeatPosition(0)
+	targetLength := grid.BeatPosition(end) - start
+	lengths := make([]float64, len(segments))
+	previous := start
+	var total float64
+	for i, seg := range segments {
+		position := grid.BeatPosition(seg.NearestBeatTime)
+		lengths[i] = position - previous
+		previous = position
+		total += lengths[i]
+	}
+
+	// drop the lowest priority segments while the others still fill the target
+	order := make([]int, len(segments))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		pa, pb := keyframes[segments[order[a]].Keyframe].Priority, keyframes[segments[order[b]].Keyframe].Priority
+		if pa != pb {
+			return pa < pb
+		}
+		return order[a] > order[b]
+	})
+	dropped := make([]bool, len(segments))
+	kept := len(segments)
+	for _, i := range order {
+		if kept == 1 || total-lengths[i] < targetLength {
+			continue
+		}
+		dropped[i] = true
+		total -= lengths[i]
+		kept--
+	}
+
+	var fitted []Segment
+	var fittedLengths []float64
+	var cut []int
+	for i, seg := range segments {
+		if dropped[i] {
+			cut = append(cut, i)
+			continue
+		}
+		for _, c := range cut {
+			seg.Explain("reason.target_cut", segments[c].Keyframe, keyframes[segments[c].Keyframe].Priority)
+		}
+		cut = nil
+		fitted = append(fitted, seg)
+		fittedLengths = append(fittedLengths, lengths[i])
+	}
+	// the segments cut at the end are explained on the last one kept
+	for _, c := range cut {
+		fitted[len(fitted)-1].Explain("reason.target_cut", segments[c].Keyframe, keyframes[segments[c].Keyframe].Priority)
+	}
+	plannedLengths := append([]float64(nil), fittedLengths...)
+
+	// spread the difference by whole beats, taking beats from the longest
+	// segments or giving beats to the shortest ones, without making any
+	// segment shorter than a beat
+	difference := targetLength - total
+	for math.Abs(difference) >= 1 {
+		pick := -1
+		for i, length := range fittedLengths {
+			switch {
+			case difference < 0 && length >= 2 && (pick < 0 || length > fittedLengths[pick]):
+				pick = i
+			case difference > 0 && (pick < 0 || length < fittedLengths[pick]):
+				pick = i
+			}
+		}
+		if pick < 0 {
+			break
+		}
+		step := math.Copysign(1, difference)
+		fittedLengths[pick] += step
+		difference -= step
+	}
+	last := len(fittedLengths) - 1
+	fittedLengths[last] += difference
+	if fittedLengths[last] <= 0 {
+		return nil, fmt.Errorf("the segments can't be fitted in %.2fs", end)
+	}
+
+	clock := frameClock(opts.FrameRate)
+	position := start
+	previousTime := 0.0
+	var previousFrame int64
+	for i := range fitted {
+		if change := fittedLengths[i] - plannedLengths[i]; math.Abs(change) > 1e-9 {
+			fitted[i].Explain("reason.target", change, end)
+		}
+		position += fittedLengths[i]
+		fitted[i].NearestBeatTime = grid.BeatTime(position)
+		fitted[i].SpeedFactor = (fitted[i].End - fitted[i].Start) / (fitted[i].NearestBeatTime - previousTime)
+		if clock.counts() {
+			fitted[i].BeatFrame = clock.frame(fitted[i].NearestBeatTime)
+			if fitted[i].BeatFrame <= previousFrame {
+				return nil, fmt.Errorf("the segments can't be fitted in %.2fs", end)
+			}
+			fitted[i].NearestBeatTime = clock.time(fitted[i].BeatFrame)
+			fitted[i].SpeedFactor = float64(fitted[i].EndFrame-fitted[i].StartFrame) / float64(fitted[i].BeatFrame-previousFrame)
+			previousFrame = fitted[i].BeatFrame
+		}
+		if fitted[i].SpeedFactor > ExtremeSpeedFactor || fitted[i].SpeedFactor < 1.0/ExtremeSpeedFactor {
+			opts.notify(ExtremeSpeed, fitted[i].Keyframe, fitted[i].SpeedFactor)
+		}
+		previousTime = fitted[i].NearestBeatTime
+	}
+	return fitted, nil
+}
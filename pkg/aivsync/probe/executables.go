@@ -0,0 +1,49 @@
+package probe
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+var (
+	executablesMu sync.Mutex
+	// executables caches the lookups so the PATH is only searched once per
+	// process for each tool.
+	executables = map[string]executableLookup{}
+)
+
+type executableLookup struct {
+	path string
+	err  error
+}
+
+// FindExecutable returns the path of the named executable, such as ffmpeg.
+// The path found in the envVar environment variable takes precedence over
+// the PATH lookup. Results are cached for the lifetime of the process.
+func FindExecutable(name string, envVar string) (string, error) {
+	executablesMu.Lock()
+	defer executablesMu.Unlock()
+
+	if lookup, ok := executables[name]; ok {
+		return lookup.path, lookup.err
+	}
+
+	var lookup executableLookup
+	if override := os.Getenv(envVar); override != "" {
+		// exec.LookPath checks that the file exists and is executable,
+		// including trying the PATHEXT extensions on Windows.
+		lookup.path, lookup.err = exec.LookPath(override)
+		if lookup.err != nil {
+			lookup.err = fmt.Errorf("%s=%s is not usable: %v", envVar, override, lookup.err)
+		}
+	} else {
+		// exec.LookPath returns the first match, like running the first
+		// result of `where` on Windows or `which` on Unix-like systems.
+		lookup.path, lookup.err = exec.LookPath(name)
+	}
+	executables[name] = lookup
+
+	return lookup.path, lookup.err
+}
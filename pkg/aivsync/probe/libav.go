@@ -0,0 +1,159 @@
+//go:build libav
+
+package probe
+
+/*
+#cgo pkg-config: libavformat libavcodec libavutil
+#include <stdlib.h>
+#include <libavformat/avformat.h>
+#include <libavcodec/avcodec.h>
+#include <libavutil/channel_layout.h>
+#include <libavutil/display.h>
+#include <libavutil/error.h>
+#include <libavutil/pixdesc.h>
+
+static AVStream *stream_at(AVFormatContext *ctx, unsigned int i) {
+	return ctx->streams[i];
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unsafe"
+)
+
+func init() {
+	libavProbe = probeWithLibav
+}
+
+// noPTS is AV_NOPTS_VALUE, which cgo can't translate.
+const noPTS = math.MinInt64
+
+// avError returns the message of a libav error code.
+func avError(code C.int) error {
+	buf := make([]C.char, C.AV_ERROR_MAX_STRING_SIZE)
+	C.av_strerror(code, &buf[0], C.size_t(len(buf)))
+	return fmt.Errorf("%s", C.GoString(&buf[0]))
+}
+
+// rationalString formats a libav rational as ffprobe does.
+func rationalString(r C.AVRational) string {
+	return fmt.Sprintf("%d/%d", int(r.num), int(r.den))
+}
+
+// dictionary copies the entries of a libav dictionary, such as the metadata
+// of a file.
+func dictionary(dict *C.AVDictionary) map[string]string {
+	entries := map[string]string{}
+	empty := C.CString("")
+	defer C.free(unsafe.Pointer(empty))
+	for entry := C.av_dict_get(dict, empty, nil, C.AV_DICT_IGNORE_SUFFIX); entry != nil; entry = C.av_dict_get(dict, empty, entry, C.AV_DICT_IGNORE_SUFFIX) {
+		entries[C.GoString(entry.key)] = C.GoString(entry.value)
+	}
+	return entries
+}
+
+// fieldOrders are the names ffprobe gives to the field orders.
+var fieldOrders = map[C.enum_AVFieldOrder]string{
+	C.AV_FIELD_PROGRESSIVE: "progressive",
+	C.AV_FIELD_TT:          "tt",
+	C.AV_FIELD_BB:          "bb",
+	C.AV_FIELD_TB:          "tb",
+	C.AV_FIELD_BT:          "bt",
+}
+
+// probeWithLibav reads the metadata of the file with libavformat, filling
+// the same fields as ffprobe so both backends go through parseProbeOutput.
+func probeWithLibav(path string) (ffprobeOutput, error) {
+	var probeOutput ffprobeOutput
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	var ctx *C.AVFormatContext
+	if ret := C.avformat_open_input(&ctx, cPath, nil, nil); ret < 0 {
+		return probeOutput, avError(ret)
+	}
+	defer C.avformat_close_input(&ctx)
+	if ret := C.avformat_find_stream_info(ctx, nil); ret < 0 {
+		return probeOutput, avError(ret)
+	}
+
+	probeOutput.Format.FormatName = C.GoString(ctx.iformat.name)
+	if ctx.duration != noPTS {
+		probeOutput.Format.Duration = strconv.FormatFloat(float64(ctx.duration)/1e6, 'f', 6, 64)
+	}
+	if ctx.bit_rate > 0 {
+		probeOutput.Format.BitRate = strconv.FormatInt(int64(ctx.bit_rate), 10)
+	}
+	probeOutput.Format.Tags = dictionary(ctx.metadata)
+
+	for i := C.uint(0); i < ctx.nb_streams; i++ {
+		st := C.stream_at(ctx, i)
+		par := st.codecpar
+		stream := ffprobeStream{
+			Index:         int(st.index),
+			CodecName:     C.GoString(C.avcodec_get_name(par.codec_id)),
+			TimeBase:      rationalString(st.time_base),
+			BitsPerSample: int(C.av_get_bits_per_sample(par.codec_id)),
+			Tags:          dictionary(st.metadata),
+			Disposition:   map[string]int{},
+		}
+		if name := C.av_get_media_type_string(par.codec_type); name != nil {
+			stream.CodecType = C.GoString(name)
+		}
+		if st.duration != noPTS {
+			stream.Duration = strconv.FormatFloat(float64(st.duration)*float64(st.time_base.num)/float64(st.time_base.den), 'f', 6, 64)
+		}
+		if par.bit_rate > 0 {
+			stream.BitRate = strconv.FormatInt(int64(par.bit_rate), 10)
+		}
+		if par.bits_per_raw_sample > 0 {
+			stream.BitsPerRawSample = strconv.Itoa(int(par.bits_per_raw_sample))
+		}
+		if st.disposition&C.AV_DISPOSITION_ATTACHED_PIC != 0 {
+			stream.Disposition["attached_pic"] = 1
+		}
+
+		switch par.codec_type {
+		case C.AVMEDIA_TYPE_VIDEO:
+			stream.Width = int(par.width)
+			stream.Height = int(par.height)
+			if name := C.av_get_pix_fmt_name(C.enum_AVPixelFormat(par.format)); name != nil {
+				stream.PixFmt = C.GoString(name)
+			}
+			stream.AvgFrameRate = rationalString(st.avg_frame_rate)
+			stream.RFrameRate = rationalString(st.r_frame_rate)
+			if name := C.av_color_range_name(par.color_range); name != nil {
+				stream.ColorRange = C.GoString(name)
+			}
+			if name := C.av_color_space_name(par.color_space); name != nil {
+				stream.ColorSpace = C.GoString(name)
+			}
+			if name := C.av_color_transfer_name(par.color_trc); name != nil {
+				stream.ColorTransfer = C.GoString(name)
+			}
+			if name := C.av_color_primaries_name(par.color_primaries); name != nil {
+				stream.ColorPrimaries = C.GoString(name)
+			}
+			stream.FieldOrder = fieldOrders[par.field_order]
+			if sideData := C.av_packet_side_data_get(par.coded_side_data, par.nb_coded_side_data, C.AV_PKT_DATA_DISPLAYMATRIX); sideData != nil {
+				rotation := C.av_display_rotation_get((*C.int32_t)(unsafe.Pointer(sideData.data)))
+				stream.SideDataList = append(stream.SideDataList, ffprobeSideData{Rotation: float64(rotation)})
+			}
+		case C.AVMEDIA_TYPE_AUDIO:
+			stream.SampleRate = strconv.Itoa(int(par.sample_rate))
+			stream.Channels = int(par.ch_layout.nb_channels)
+			if par.ch_layout.order != C.AV_CHANNEL_ORDER_UNSPEC {
+				buf := make([]C.char, 64)
+				if C.av_channel_layout_describe(&par.ch_layout, &buf[0], C.size_t(len(buf))) > 0 {
+					stream.ChannelLayout = C.GoString(&buf[0])
+				}
+			}
+		}
+		probeOutput.Streams = append(probeOutput.Streams, stream)
+	}
+	return probeOutput, nil
+}
@@ -0,0 +1,620 @@
+package probe
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+// NativeMP4 makes ProbeMedia read the moov box of MP4 and MOV files itself
+// instead of running ffprobe, which speeds up scanning large libraries. Files
+// it doesn't understand, such as fragmented files or unknown codecs, are
+// still probed with ffprobe, as are the videos without fiel and colr boxes,
+// whose field order and colors ffprobe reads from the bitstream.
+var NativeMP4 = true
+
+// mp4Extensions are the extensions of the files read natively.
+var mp4Extensions = map[string]bool{
+	".mp4": true,
+	".m4v": true,
+	".m4a": true,
+	".mov": true,
+	".3gp": true,
+}
+
+// nativeComplete reports whether the metadata read natively has everything
+// ffprobe would report: the field order and color properties of the videos
+// are only read from the sample entries.
+func nativeComplete(info MediaInfo) bool {
+	for _, stream := range info.Streams {
+		if stream.Type != "video" || stream.Attached {
+			continue
+		}
+		if stream.FieldOrder == "" || stream.ColorRange == "" || stream.ColorSpace == "" || stream.ColorTransfer == "" || stream.ColorPrimaries == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// mp4FormatName is the name ffprobe gives to the MP4 and MOV demuxer.
+const mp4FormatName = "mov,mp4,m4a,3gp,3g2,mj2"
+
+// maxMoovSize is the size of the largest moov box read, files with larger
+// sample tables are left to ffprobe.
+const maxMoovSize = 64 << 20
+
+// mp4Codecs are the names ffprobe gives to the codecs of the sample entries.
+var mp4Codecs = map[string]string{
+	"avc1": "h264",
+	"avc3": "h264",
+	"hvc1": "hevc",
+	"hev1": "hevc",
+	"av01": "av1",
+	"vp09": "vp9",
+	"mp4v": "mpeg4",
+	"apch": "prores",
+	"apcn": "prores",
+	"apcs": "prores",
+	"apco": "prores",
+	"ap4h": "prores",
+	"ap4x": "prores",
+	"jpeg": "mjpeg",
+	"mp4a": "aac",
+	"ac-3": "ac3",
+	"ec-3": "eac3",
+	"Opus": "opus",
+	"fLaC": "flac",
+	"alac": "alac",
+	"sowt": "pcm_s16le",
+	"twos": "pcm_s16be",
+	"in24": "pcm_s24be",
+	"in32": "pcm_s32be",
+	"fl32": "pcm_f32be",
+}
+
+// mp4Box is a box of an MP4 file, Data being its payload.
+type mp4Box struct {
+	Type string
+	Data []byte
+}
+
+// mp4Boxes splits data into the boxes it contains.
+func mp4Boxes(data []byte) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("truncated box header")
+		}
+		size := uint64(binary.BigEndian.Uint32(data))
+		boxType := string(data[4:8])
+		header := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated %s box header", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size of the %s box", boxType)
+		}
+		boxes = append(boxes, mp4Box{Type: boxType, Data: data[header:size]})
+		data = data[size:]
+	}
+	return boxes, nil
+}
+
+// childBox returns the payload of the first box of the given type, nil when
+// there is none.
+func childBox(boxes []mp4Box, boxType string) []byte {
+	for _, box := range boxes {
+		if box.Type == boxType {
+			return box.Data
+		}
+	}
+	return nil
+}
+
+// boxPath returns the payload of the box found by following the path of box
+// types from data, nil when one of them is missing.
+func boxPath(data []byte, path ...string) []byte {
+	for _, boxType := range path {
+		boxes, err := mp4Boxes(data)
+		if err != nil {
+			return nil
+		}
+		if data = childBox(boxes, boxType); data == nil {
+			return nil
+		}
+	}
+	return data
+}
+
+// probeMP4 reads the metadata of an MP4 or MOV file from its moov box,
+// filling the same fields as ffprobe.
+func probeMP4(path string) (ffprobeOutput, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return ffprobeOutput{}, err
+	}
+
+	// walk the top level boxes, skipping the media data, up to the moov box
+	var offset int64
+	header := make([]byte, 16)
+	for offset+8 <= stat.Size() {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return ffprobeOutput{}, err
+		}
+		size := int64(binary.BigEndian.Uint32(header))
+		boxType := string(header[4:8])
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = stat.Size() - offset
+		case 1:
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return ffprobeOutput{}, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > stat.Size() {
+			return ffprobeOutput{}, fmt.Errorf("invalid size of the %s box", boxType)
+		}
+		if boxType == "moov" {
+			if size-headerSize > maxMoovSize {
+				return ffprobeOutput{}, fmt.Errorf("the moov box is larger than %d bytes", maxMoovSize)
+			}
+			moov := make([]byte, size-headerSize)
+			if _, err := io.ReadFull(io.NewSectionReader(f, offset+headerSize, size-headerSize), moov); err != nil {
+				return ffprobeOutput{}, err
+			}
+			return parseMoov(moov, stat.Size())
+		}
+		offset += size
+	}
+	return ffprobeOutput{}, fmt.Errorf("no moov box found")
+}
+
+// parseMoov reads the movie header and the tracks of a moov box.
+func parseMoov(moov []byte, fileSize int64) (ffprobeOutput, error) {
+	var probeOutput ffprobeOutput
+	boxes, err := mp4Boxes(moov)
+	if err != nil {
+		return probeOutput, err
+	}
+	if childBox(boxes, "mvex") != nil {
+		return probeOutput, fmt.Errorf("fragmented files aren't supported")
+	}
+
+	timescale, duration, err := mediaHeader(childBox(boxes, "mvhd"))
+	if err != nil {
+		return probeOutput, fmt.Errorf("invalid mvhd box: %v", err)
+	}
+	if timescale == 0 || duration == 0 {
+		return probeOutput, fmt.Errorf("the movie has no duration")
+	}
+	seconds := float64(duration) / float64(timescale)
+	probeOutput.Format.FormatName = mp4FormatName
+	probeOutput.Format.Duration = strconv.FormatFloat(seconds, 'f', 6, 64)
+	probeOutput.Format.BitRate = strconv.FormatInt(int64(float64(fileSize)*8/seconds), 10)
+	probeOutput.Format.Tags = mp4Tags(childBox(boxes, "udta"))
+
+	for _, box := range boxes {
+		if box.Type != "trak" {
+			continue
+		}
+		stream, err := parseTrak(box.Data)
+		if err != nil {
+			return probeOutput, fmt.Errorf("track %d: %v", len(probeOutput.Streams), err)
+		}
+		stream.Index = len(probeOutput.Streams)
+		probeOutput.Streams = append(probeOutput.Streams, stream)
+	}
+	return probeOutput, nil
+}
+
+// mp4TagNames are the names ffprobe gives to the iTunes metadata items.
+var mp4TagNames = map[string]string{
+	"\xa9nam": "title",
+	"\xa9ART": "artist",
+	"\xa9alb": "album",
+	"\xa9gen": "genre",
+	"\xa9day": "date",
+	"\xa9too": "encoder",
+	"tmpo":    "tmpo",
+}
+
+// mp4Tags reads the iTunes metadata of a udta box.
+func mp4Tags(udta []byte) map[string]string {
+	tags := map[string]string{}
+	meta := boxPath(udta, "meta")
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		// the MP4 meta box has a version and flags, the QuickTime one doesn't
+		meta = meta[4:]
+	}
+	items, err := mp4Boxes(boxPath(meta, "ilst"))
+	if err != nil {
+		return tags
+	}
+	for _, item := range items {
+		name := mp4TagNames[item.Type]
+		data := boxPath(item.Data, "data")
+		if name == "" || len(data) < 8 {
+			continue
+		}
+		value := data[8:]
+		switch binary.BigEndian.Uint32(data) & 0xffffff {
+		case 1:
+			tags[name] = string(value)
+		case 21:
+			// big endian signed integer of 1 to 8 bytes
+			if len(value) == 0 || len(value) > 8 {
+				continue
+			}
+			n := int64(int8(value[0]))
+			for _, b := range value[1:] {
+				n = n<<8 | int64(b)
+			}
+			tags[name] = strconv.FormatInt(n, 10)
+		}
+	}
+	return tags
+}
+
+// mediaHeader reads the timescale and the duration of a mvhd or mdhd box,
+// which share their layout up to the duration.
+func mediaHeader(data []byte) (timescale uint32, duration uint64, err error) {
+	if len(data) < 4 {
+		return 0, 0, fmt.Errorf("truncated box")
+	}
+	if data[0] == 1 {
+		if len(data) < 32 {
+			return 0, 0, fmt.Errorf("truncated box")
+		}
+		return binary.BigEndian.Uint32(data[20:]), binary.BigEndian.Uint64(data[24:]), nil
+	}
+	if len(data) < 20 {
+		return 0, 0, fmt.Errorf("truncated box")
+	}
+	return binary.BigEndian.Uint32(data[12:]), uint64(binary.BigEndian.Uint32(data[16:])), nil
+}
+
+// parseTrak reads a track into a stream.
+func parseTrak(trak []byte) (ffprobeStream, error) {
+	stream := ffprobeStream{Tags: map[string]string{}, Disposition: map[string]int{}}
+
+	mdia := boxPath(trak, "mdia")
+	timescale, duration, err := mediaHeader(boxPath(mdia, "mdhd"))
+	if err != nil {
+		return stream, fmt.Errorf("invalid mdhd box: %v", err)
+	}
+	if timescale == 0 {
+		return stream, fmt.Errorf("the track has no timescale")
+	}
+	stream.TimeBase = fmt.Sprintf("1/%d", timescale)
+	seconds := float64(duration) / float64(timescale)
+	stream.Duration = strconv.FormatFloat(seconds, 'f', 6, 64)
+
+	hdlr := boxPath(mdia, "hdlr")
+	if len(hdlr) < 12 {
+		return stream, fmt.Errorf("invalid hdlr box")
+	}
+	switch string(hdlr[8:12]) {
+	case "vide":
+		stream.CodecType = "video"
+	case "soun":
+		stream.CodecType = "audio"
+	default:
+		// timecodes, chapters and other metadata tracks
+		stream.CodecType = "data"
+		return stream, nil
+	}
+
+	stbl := boxPath(mdia, "minf", "stbl")
+	stsd := boxPath(stbl, "stsd")
+	if len(stsd) < 8 {
+		return stream, fmt.Errorf("invalid stsd box")
+	}
+	entries, err := mp4Boxes(stsd[8:])
+	if err != nil || len(entries) == 0 {
+		return stream, fmt.Errorf("invalid stsd box")
+	}
+	entry := entries[0]
+	if stream.CodecName = mp4Codecs[entry.Type]; stream.CodecName == "" {
+		return stream, fmt.Errorf("unknown codec %q", entry.Type)
+	}
+
+	if bytes := sampleBytes(boxPath(stbl, "stsz")); bytes > 0 && seconds > 0 {
+		stream.BitRate = strconv.FormatInt(int64(float64(bytes)*8/seconds), 10)
+	}
+
+	if stream.CodecType == "audio" {
+		return stream, parseAudioEntry(&stream, entry.Data, timescale)
+	}
+
+	if err := parseVideoEntry(&stream, entry.Data); err != nil {
+		return stream, err
+	}
+	if samples, ticks := sampleTiming(boxPath(stbl, "stts")); samples > 0 && ticks > 0 {
+		num, den := samples*uint64(timescale), ticks
+		divisor := gcd(num, den)
+		stream.AvgFrameRate = fmt.Sprintf("%d/%d", num/divisor, den/divisor)
+		stream.RFrameRate = stream.AvgFrameRate
+	}
+	tkhd := boxPath(trak, "tkhd")
+	matrixOffset := 40
+	if len(tkhd) > 0 && tkhd[0] == 1 {
+		matrixOffset = 52
+	}
+	if len(tkhd) >= matrixOffset+36 {
+		// the rotation of the display matrix, as av_display_rotation_get
+		a := float64(int32(binary.BigEndian.Uint32(tkhd[matrixOffset:])))
+		b := float64(int32(binary.BigEndian.Uint32(tkhd[matrixOffset+4:])))
+		if rotation := math.Round(-math.Atan2(b, a) * 180 / math.Pi); rotation != 0 {
+			stream.SideDataList = append(stream.SideDataList, ffprobeSideData{Rotation: rotation})
+		}
+	}
+	return stream, nil
+}
+
+// sampleBytes returns the total size of the samples listed by a stsz box.
+func sampleBytes(stsz []byte) uint64 {
+	if len(stsz) < 12 {
+		return 0
+	}
+	size := uint64(binary.BigEndian.Uint32(stsz[4:]))
+	count := uint64(binary.BigEndian.Uint32(stsz[8:]))
+	if size != 0 {
+		return size * count
+	}
+	var total uint64
+	for i := 12; i+4 <= len(stsz); i += 4 {
+		total += uint64(binary.BigEndian.Uint32(stsz[i:]))
+	}
+	return total
+}
+
+// sampleTiming returns the number of samples and their total duration in
+// ticks of the timescale from a stts box.
+func sampleTiming(stts []byte) (samples uint64, ticks uint64) {
+	if len(stts) < 8 {
+		return 0, 0
+	}
+	count := int(binary.BigEndian.Uint32(stts[4:]))
+	for i := 0; i < count && 8+i*8+8 <= len(stts); i++ {
+		entry := stts[8+i*8:]
+		n := uint64(binary.BigEndian.Uint32(entry))
+		samples += n
+		ticks += n * uint64(binary.BigEndian.Uint32(entry[4:]))
+	}
+	return samples, ticks
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
+
+// parseVideoEntry reads the dimensions, pixel format, colors and field order
+// of a visual sample entry.
+func parseVideoEntry(stream *ffprobeStream, entry []byte) error {
+	// 8 bytes of sample entry header and 70 of visual sample entry
+	if len(entry) < 78 {
+		return fmt.Errorf("truncated video sample entry")
+	}
+	stream.Width = int(binary.BigEndian.Uint16(entry[24:]))
+	stream.Height = int(binary.BigEndian.Uint16(entry[26:]))
+	children, err := mp4Boxes(entry[78:])
+	if err != nil {
+		return fmt.Errorf("invalid video sample entry: %v", err)
+	}
+
+	chroma, depth := -1, 0
+	switch stream.CodecName {
+	case "h264":
+		chroma, depth = avcFormat(childBox(children, "avcC"))
+	case "hevc":
+		if hvcC := childBox(children, "hvcC"); len(hvcC) >= 18 {
+			chroma, depth = int(hvcC[16]&3), int(hvcC[17]&7)+8
+		}
+	}
+	if depth > 0 {
+		stream.PixFmt = pixelFormat(chroma, depth)
+		stream.BitsPerRawSample = strconv.Itoa(depth)
+	}
+
+	if colr := childBox(children, "colr"); len(colr) >= 10 {
+		switch string(colr[:4]) {
+		case "nclx", "nclc":
+			stream.ColorPrimaries = colorPrimaries[binary.BigEndian.Uint16(colr[4:])]
+			stream.ColorTransfer = colorTransfers[binary.BigEndian.Uint16(colr[6:])]
+			stream.ColorSpace = colorMatrices[binary.BigEndian.Uint16(colr[8:])]
+			if string(colr[:4]) == "nclx" && len(colr) >= 11 {
+				stream.ColorRange = "tv"
+				if colr[10]&0x80 != 0 {
+					stream.ColorRange = "pc"
+				}
+			}
+		}
+	}
+
+	if fiel := childBox(children, "fiel"); len(fiel) >= 2 {
+		// the field order as read by the mov demuxer of ffmpeg
+		switch {
+		case fiel[0] == 1:
+			stream.FieldOrder = "progressive"
+		case fiel[0] == 2 && fiel[1] == 1:
+			stream.FieldOrder = "tt"
+		case fiel[0] == 2 && fiel[1] == 6:
+			stream.FieldOrder = "bb"
+		case fiel[0] == 2 && fiel[1] == 9:
+			stream.FieldOrder = "tb"
+		case fiel[0] == 2 && fiel[1] == 14:
+			stream.FieldOrder = "bt"
+		}
+	}
+	return nil
+}
+
+// avcFormat returns the chroma format and bit depth of an avcC box, the high
+// profiles storing them after the parameter sets.
+func avcFormat(avcC []byte) (chroma int, depth int) {
+	if len(avcC) < 6 {
+		return -1, 0
+	}
+	switch avcC[1] {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+	default:
+		return 1, 8
+	}
+	offset := 6
+	for i, n := 0, int(avcC[5]&0x1f); i < n; i++ {
+		if offset+2 > len(avcC) {
+			return 1, 8
+		}
+		offset += 2 + int(binary.BigEndian.Uint16(avcC[offset:]))
+	}
+	if offset >= len(avcC) {
+		return 1, 8
+	}
+	n := int(avcC[offset])
+	offset++
+	for i := 0; i < n; i++ {
+		if offset+2 > len(avcC) {
+			return 1, 8
+		}
+		offset += 2 + int(binary.BigEndian.Uint16(avcC[offset:]))
+	}
+	if offset+2 > len(avcC) {
+		return 1, 8
+	}
+	return int(avcC[offset] & 3), int(avcC[offset+1]&7) + 8
+}
+
+// pixelFormat names the pixel format of a chroma format and bit depth as
+// ffprobe does.
+func pixelFormat(chroma, depth int) string {
+	var name string
+	switch chroma {
+	case 0:
+		name = "gray"
+	case 1:
+		name = "yuv420p"
+	case 2:
+		name = "yuv422p"
+	case 3:
+		name = "yuv444p"
+	default:
+		return ""
+	}
+	if depth > 8 {
+		name += strconv.Itoa(depth) + "le"
+	}
+	return name
+}
+
+// parseAudioEntry reads the sample rate and the channels of a sound sample
+// entry, timescale being the sample rate when the entry doesn't have one.
+func parseAudioEntry(stream *ffprobeStream, entry []byte, timescale uint32) error {
+	if len(entry) < 28 {
+		return fmt.Errorf("truncated audio sample entry")
+	}
+	var sampleRate uint32
+	switch binary.BigEndian.Uint16(entry[8:]) {
+	case 2:
+		// QuickTime sound description version 2
+		if len(entry) < 44 {
+			return fmt.Errorf("truncated audio sample entry")
+		}
+		sampleRate = uint32(math.Float64frombits(binary.BigEndian.Uint64(entry[32:])))
+		stream.Channels = int(binary.BigEndian.Uint32(entry[40:]))
+	default:
+		stream.Channels = int(binary.BigEndian.Uint16(entry[16:]))
+		sampleRate = binary.BigEndian.Uint32(entry[24:]) >> 16
+	}
+	if sampleRate == 0 {
+		sampleRate = timescale
+	}
+	stream.SampleRate = strconv.Itoa(int(sampleRate))
+	if stream.CodecName == "aac" {
+		stream.ChannelLayout = aacChannelLayouts[stream.Channels]
+	}
+	switch stream.CodecName {
+	case "pcm_s16le", "pcm_s16be":
+		stream.BitsPerSample = 16
+	case "pcm_s24be":
+		stream.BitsPerSample = 24
+	case "pcm_s32be", "pcm_f32be":
+		stream.BitsPerSample = 32
+	}
+	return nil
+}
+
+// aacChannelLayouts are the layouts ffprobe reports for AAC streams.
+var aacChannelLayouts = map[int]string{
+	1: "mono",
+	2: "stereo",
+	3: "3.0",
+	4: "4.0",
+	5: "5.0",
+	6: "5.1",
+	8: "7.1",
+}
+
+// colorPrimaries, colorTransfers and colorMatrices name the code points of
+// the colr box (ITU-T H.273) as ffprobe does.
+var colorPrimaries = map[uint16]string{
+	1:  "bt709",
+	4:  "bt470m",
+	5:  "bt470bg",
+	6:  "smpte170m",
+	7:  "smpte240m",
+	8:  "film",
+	9:  "bt2020",
+	10: "smpte428",
+	11: "smpte431",
+	12: "smpte432",
+	22: "jedec-p22",
+}
+
+var colorTransfers = map[uint16]string{
+	1:  "bt709",
+	4:  "gamma22",
+	5:  "gamma28",
+	6:  "smpte170m",
+	7:  "smpte240m",
+	8:  "linear",
+	11: "iec61966-2-4",
+	13: "iec61966-2-1",
+	14: "bt2020-10",
+	15: "bt2020-12",
+	16: "smpte2084",
+	18: "arib-std-b67",
+}
+
+var colorMatrices = map[uint16]string{
+	0:  "gbr",
+	1:  "bt709",
+	4:  "fcc",
+	5:  "bt470bg",
+	6:  "smpte170m",
+	7:  "smpte240m",
+	8:  "ycgco",
+	9:  "bt2020nc",
+	10: "bt2020c",
+}
@@ -0,0 +1,368 @@
+package probe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// box builds an MP4 box of the given type around the payloads.
+func box(boxType string, payloads ...[]byte) []byte {
+	data := bytes.Join(payloads, nil)
+	header := binary.BigEndian.AppendUint32(nil, uint32(8+len(data)))
+	return append(append(header, boxType...), data...)
+}
+
+// be32 encodes big endian 32 bit integers.
+func be32(values ...uint32) []byte {
+	var data []byte
+	for _, value := range values {
+		data = binary.BigEndian.AppendUint32(data, value)
+	}
+	return data
+}
+
+func TestMP4Boxes(t *testing.T) {
+	largeBox := append(be32(1), "free"...)
+	largeBox = binary.BigEndian.AppendUint64(largeBox, 20)
+	largeBox = append(largeBox, 1, 2, 3, 4)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []mp4Box
+		wantErr bool
+	}{
+		{name: "empty", data: nil},
+		{
+			name: "sibling boxes",
+			data: append(box("ftyp", []byte("isom")), box("free")...),
+			want: []mp4Box{{Type: "ftyp", Data: []byte("isom")}, {Type: "free", Data: []byte{}}},
+		},
+		{
+			name: "box up to the end",
+			data: append(append(be32(0), "mdat"...), 1, 2),
+			want: []mp4Box{{Type: "mdat", Data: []byte{1, 2}}},
+		},
+		{
+			name: "64 bit size",
+			data: largeBox,
+			want: []mp4Box{{Type: "free", Data: []byte{1, 2, 3, 4}}},
+		},
+		{name: "truncated header", data: []byte{0, 0, 0}, wantErr: true},
+		{name: "truncated 64 bit size", data: append(be32(1), "free"...), wantErr: true},
+		{name: "size past the end", data: append(be32(12), "free"...), wantErr: true},
+		{name: "size smaller than the header", data: append(be32(4), "free"...), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mp4Boxes(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mp4Boxes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("mp4Boxes() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].Type != tt.want[i].Type || !bytes.Equal(got[i].Data, tt.want[i].Data) {
+					t.Errorf("mp4Boxes()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBoxPath(t *testing.T) {
+	moov := box("moov", box("mvhd", []byte{1}), box("trak", box("mdia", box("mdhd", []byte{2}))))
+	tests := []struct {
+		name string
+		path []string
+		want []byte
+	}{
+		{name: "child", path: []string{"moov", "mvhd"}, want: []byte{1}},
+		{name: "nested", path: []string{"moov", "trak", "mdia", "mdhd"}, want: []byte{2}},
+		{name: "missing", path: []string{"moov", "udta"}},
+		{name: "through a leaf", path: []string{"moov", "mvhd", "mdhd"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := boxPath(moov, tt.path...); !bytes.Equal(got, tt.want) {
+				t.Errorf("boxPath(%v) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMediaHeader(t *testing.T) {
+	version1 := append([]byte{1, 0, 0, 0}, make([]byte, 16)...)
+	version1 = binary.BigEndian.AppendUint32(version1, 48000)
+	version1 = binary.BigEndian.AppendUint64(version1, 1<<33)
+
+	tests := []struct {
+		name          string
+		data          []byte
+		wantTimescale uint32
+		wantDuration  uint64
+		wantErr       bool
+	}{
+		{name: "version 0", data: be32(0, 0, 0, 30000, 60060), wantTimescale: 30000, wantDuration: 60060},
+		{name: "version 1", data: version1, wantTimescale: 48000, wantDuration: 1 << 33},
+		{name: "truncated version 0", data: be32(0, 0, 0), wantErr: true},
+		{name: "truncated version 1", data: version1[:24], wantErr: true},
+		{name: "missing", data: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timescale, duration, err := mediaHeader(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("mediaHeader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if timescale != tt.wantTimescale || duration != tt.wantDuration {
+				t.Errorf("mediaHeader() = %d, %d, want %d, %d", timescale, duration, tt.wantTimescale, tt.wantDuration)
+			}
+		})
+	}
+}
+
+func TestSampleTables(t *testing.T) {
+	tests := []struct {
+		name        string
+		stts        []byte
+		stsz        []byte
+		wantSamples uint64
+		wantTicks   uint64
+		wantBytes   uint64
+	}{
+		{
+			name:        "constant",
+			stts:        be32(0, 1, 30, 1001),
+			stsz:        be32(0, 1000, 30),
+			wantSamples: 30,
+			wantTicks:   30030,
+			wantBytes:   30000,
+		},
+		{
+			name:        "variable",
+			stts:        be32(0, 2, 2, 1000, 1, 500),
+			stsz:        be32(0, 0, 3, 10, 20, 30),
+			wantSamples: 3,
+			wantTicks:   2500,
+			wantBytes:   60,
+		},
+		{
+			name:        "count past the end",
+			stts:        be32(0, 3, 2, 1000),
+			stsz:        be32(0, 0, 3, 10),
+			wantSamples: 2,
+			wantTicks:   2000,
+			wantBytes:   10,
+		},
+		{name: "truncated", stts: be32(0), stsz: be32(0, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if samples, ticks := sampleTiming(tt.stts); samples != tt.wantSamples || ticks != tt.wantTicks {
+				t.Errorf("sampleTiming() = %d, %d, want %d, %d", samples, ticks, tt.wantSamples, tt.wantTicks)
+			}
+			if got := sampleBytes(tt.stsz); got != tt.wantBytes {
+				t.Errorf("sampleBytes() = %d, want %d", got, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func TestPixelFormat(t *testing.T) {
+	tests := []struct {
+		chroma, depth int
+		want          string
+	}{
+		{chroma: 1, depth: 8, want: "yuv420p"},
+		{chroma: 2, depth: 10, want: "yuv422p10le"},
+		{chroma: 3, depth: 12, want: "yuv444p12le"},
+		{chroma: 0, depth: 8, want: "gray"},
+		{chroma: -1, depth: 8, want: ""},
+	}
+	for _, tt := range tests {
+		if got := pixelFormat(tt.chroma, tt.depth); got != tt.want {
+			t.Errorf("pixelFormat(%d, %d) = %q, want %q", tt.chroma, tt.depth, got, tt.want)
+		}
+	}
+}
+
+// testTrak builds a track of the given handler with a single sample entry.
+func testTrak(handler string, timescale, duration uint32, entry []byte, tables ...[]byte) []byte {
+	stbl := box("stbl", append([][]byte{box("stsd", be32(0, 1), entry)}, tables...)...)
+	return box("trak", box("mdia",
+		box("mdhd", be32(0, 0, 0, timescale, duration)),
+		box("hdlr", be32(0, 0), []byte(handler), make([]byte, 12)),
+		box("minf", stbl),
+	))
+}
+
+func TestProbeMP4(t *testing.T) {
+	video := make([]byte, 70)
+	binary.BigEndian.PutUint16(video[16:], 1920)
+	binary.BigEndian.PutUint16(video[18:], 1080)
+	// 6 bytes of reserved fields and the data reference index before the
+	// visual sample entry
+	avc1 := box("avc1", make([]byte, 8), video, box("avcC", []byte{1, 66, 0, 30, 0xff, 0xe0, 0}))
+
+	audio := make([]byte, 20)
+	binary.BigEndian.PutUint16(audio[8:], 2)
+	binary.BigEndian.PutUint32(audio[16:], 48000<<16)
+	mp4a := box("mp4a", make([]byte, 8), audio)
+
+	moov := box("moov",
+		box("mvhd", be32(0, 0, 0, 1000, 1001)),
+		testTrak("vide", 30000, 30030, avc1, box("stts", be32(0, 1, 30, 1001)), box("stsz", be32(0, 1000, 30))),
+		testTrak("soun", 48000, 48048, mp4a),
+		testTrak("tmcd", 30000, 30030, box("tmcd")),
+	)
+	file := bytes.Join([][]byte{box("ftyp", []byte("isom")), box("mdat", make([]byte, 100)), moov}, nil)
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := probeMP4(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Format.FormatName != mp4FormatName || got.Format.Duration != "1.001000" {
+		t.Errorf("probeMP4() format = %+v", got.Format)
+	}
+	if len(got.Streams) != 3 {
+		t.Fatalf("probeMP4() has %d streams, want 3", len(got.Streams))
+	}
+
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{name: "video codec", got: got.Streams[0].CodecName, want: "h264"},
+		{name: "frame rate", got: got.Streams[0].AvgFrameRate, want: "30000/1001"},
+		{name: "timebase", got: got.Streams[0].TimeBase, want: "1/30000"},
+		{name: "pixel format", got: got.Streams[0].PixFmt, want: "yuv420p"},
+		{name: "video bit rate", got: got.Streams[0].BitRate, want: "239760"},
+		{name: "audio codec", got: got.Streams[1].CodecName, want: "aac"},
+		{name: "sample rate", got: got.Streams[1].SampleRate, want: "48000"},
+		{name: "channel layout", got: got.Streams[1].ChannelLayout, want: "stereo"},
+		{name: "timecode track", got: got.Streams[2].CodecType, want: "data"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("probeMP4() %s = %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+	if stream := got.Streams[0]; stream.Width != 1920 || stream.Height != 1080 {
+		t.Errorf("probeMP4() dimensions = %dx%d, want 1920x1080", stream.Width, stream.Height)
+	}
+}
+
+func TestProbeMP4Unsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+	}{
+		{name: "no moov", file: box("ftyp", []byte("isom"))},
+		{name: "fragmented", file: box("moov", box("mvhd", be32(0, 0, 0, 1000, 1000)), box("mvex"))},
+		{name: "no duration", file: box("moov", box("mvhd", be32(0, 0, 0, 1000, 0)))},
+		{name: "truncated box", file: append(be32(100), "moov"...)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "video.mp4")
+			if err := os.WriteFile(path, tt.file, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := probeMP4(path); err == nil {
+				t.Error("probeMP4() didn't fail")
+			}
+		})
+	}
+}
+
+func TestNativeComplete(t *testing.T) {
+	video := Stream{Type: "video", FieldOrder: "progressive", ColorRange: "tv", ColorSpace: "bt709", ColorTransfer: "bt709", ColorPrimaries: "bt709"}
+	noFieldOrder := video
+	noFieldOrder.FieldOrder = ""
+	noColors := video
+	noColors.ColorRange, noColors.ColorSpace, noColors.ColorTransfer, noColors.ColorPrimaries = "", "", "", ""
+	noRange := video
+	noRange.ColorRange = ""
+	cover := Stream{Type: "video", Attached: true}
+	audio := Stream{Type: "audio"}
+
+	tests := []struct {
+		name    string
+		streams []Stream
+		want    bool
+	}{
+		{name: "video and audio", streams: []Stream{video, audio}, want: true},
+		{name: "audio only", streams: []Stream{audio}, want: true},
+		{name: "attached picture", streams: []Stream{audio, cover}, want: true},
+		{name: "no field order", streams: []Stream{noFieldOrder, audio}},
+		{name: "no colors", streams: []Stream{noColors}},
+		{name: "no color range", streams: []Stream{noRange}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nativeComplete(MediaInfo{Streams: tt.streams}); got != tt.want {
+				t.Errorf("nativeComplete() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeMP4Boxes(t *testing.T) {
+	video := make([]byte, 70)
+	binary.BigEndian.PutUint16(video[16:], 720)
+	binary.BigEndian.PutUint16(video[18:], 480)
+	avcC := box("avcC", []byte{1, 66, 0, 30, 0xff, 0xe0, 0})
+	// bt709 with the full range flag, then two fields bottom first
+	colr := box("colr", []byte("nclx"), []byte{0, 1, 0, 1, 0, 1, 0x80})
+	fiel := box("fiel", []byte{2, 6})
+
+	tests := []struct {
+		name         string
+		boxes        [][]byte
+		wantOrder    string
+		wantRange    string
+		wantComplete bool
+	}{
+		{name: "fiel and colr", boxes: [][]byte{avcC, colr, fiel}, wantOrder: "bb", wantRange: "pc", wantComplete: true},
+		{name: "no fiel", boxes: [][]byte{avcC, colr}, wantRange: "pc"},
+		{name: "no colr", boxes: [][]byte{avcC, fiel}, wantOrder: "bb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			avc1 := box("avc1", append([][]byte{make([]byte, 8), video}, tt.boxes...)...)
+			moov := box("moov",
+				box("mvhd", be32(0, 0, 0, 1000, 1000)),
+				testTrak("vide", 30000, 30000, avc1, box("stts", be32(0, 1, 30, 1000)), box("stsz", be32(0, 1000, 30))),
+			)
+			path := filepath.Join(t.TempDir(), "video.mov")
+			if err := os.WriteFile(path, moov, 0644); err != nil {
+				t.Fatal(err)
+			}
+			probeOutput, err := probeMP4(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := parseProbeOutput(probeOutput)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stream, _ := info.Video()
+			if stream.FieldOrder != tt.wantOrder || stream.ColorRange != tt.wantRange {
+				t.Errorf("probeMP4() field order = %q, color range = %q, want %q, %q", stream.FieldOrder, stream.ColorRange, tt.wantOrder, tt.wantRange)
+			}
+			if got := nativeComplete(info); got != tt.wantComplete {
+				t.Errorf("nativeComplete() = %v, want %v", got, tt.wantComplete)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+// Package probe reads the metadata of media files using ffprobe, MP4 and MOV
+// files being read natively when possible.
+//
+// The path of the ffprobe executable is looked up in the PATH unless the
+// FFPROBE_PATH environment variable is set, FindExecutable looks up the other
+// tools of ffmpeg the same way.
+package probe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rational is an exact fraction, such as a timebase or a frame rate. The zero
+// value means unknown.
+type Rational struct {
+	Num int64
+	Den int64
+}
+
+// Float returns the value of the fraction, 0 when unknown.
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return 0
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+// Stream describes one of the streams of a media file.
+type Stream struct {
+	Index int
+	// Type is the codec type reported by ffprobe: video, audio, subtitle,
+	// data or attachment.
+	Type     string
+	Codec    string
+	Duration float64
+	BitRate  int64
+	// TimeBase is the unit of the timestamps of the stream, in seconds.
+	TimeBase Rational
+	// BitDepth is the number of bits per sample for audio streams and per
+	// color component for video streams, 0 when unknown.
+	BitDepth int
+	// Attached is set for pictures attached to the file, such as cover art,
+	// which ffprobe exposes as video streams.
+	Attached bool
+
+	// Video properties.
+	Width  int
+	Height int
+	FPS    float64
+	// FrameRate is the exact frame rate FPS comes from.
+	FrameRate   Rational
+	PixelFormat string
+	// ColorRange (tv or pc), ColorSpace (the YUV matrix), ColorTransfer and
+	// ColorPrimaries are the color properties as named by ffprobe, empty
+	// when the file doesn't set them.
+	ColorRange     string
+	ColorSpace     string
+	ColorTransfer  string
+	ColorPrimaries string
+	// FieldOrder is progressive, or tt, bb, tb or bt for interlaced video,
+	// empty when unknown.
+	FieldOrder string
+	// Rotation in degrees, as set by the display matrix or the rotate tag
+	// phones write.
+	Rotation int
+
+	// Audio properties.
+	SampleRate    int
+	Channels      int
+	ChannelLayout string
+}
+
+// MediaInfo holds the metadata of a media file gathered in a single ffprobe
+// call.
+type MediaInfo struct {
+	// FormatName is the container format, as named by ffprobe (e.g.
+	// "mov,mp4,m4a,3gp,3g2,mj2").
+	FormatName string
+	// Duration of the file in seconds.
+	Duration float64
+	BitRate  int64
+	Streams  []Stream
+	// Tags are the metadata of the file, such as title or TBPM, with
+	// lowercased keys. The tags of the streams are included when the file
+	// doesn't set them, as in Ogg files.
+	Tags map[string]string
+}
+
+// Video returns the first video stream that isn't an attached picture.
+func (m MediaInfo) Video() (Stream, bool) {
+	for _, stream := range m.Streams {
+		if stream.Type == "video" && !stream.Attached {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+// Audio returns the first audio stream.
+func (m MediaInfo) Audio() (Stream, bool) {
+	for _, stream := range m.Streams {
+		if stream.Type == "audio" {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+var (
+	// Timeout bounds how long ProbeMedia waits for ffprobe, broken files can
+	// make it hang.
+	Timeout = 30 * time.Second
+	// MaxOutputSize is the maximum number of bytes of ffprobe output parsed
+	// before giving up on a file.
+	MaxOutputSize int64 = 16 << 20
+)
+
+// maxStderrSize is the maximum number of bytes of ffprobe errors kept to
+// report a failure.
+const maxStderrSize = 4096
+
+// FFprobePath returns the path of the ffprobe executable, the lookup is only
+// done once per process.
+func FFprobePath() (string, error) {
+	return FindExecutable("ffprobe", "FFPROBE_PATH")
+}
+
+// ffprobeOutput is the subset of `ffprobe -show_streams -show_format -of json`
+// we care about.
+type ffprobeOutput struct {
+	Streams []ffprobeStream `json:"streams"`
+	Format  struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		BitRate    string            `json:"bit_rate"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+// ffprobeStream is a stream of the ffprobe output.
+type ffprobeStream struct {
+	Index            int               `json:"index"`
+	CodecType        string            `json:"codec_type"`
+	CodecName        string            `json:"codec_name"`
+	Width            int               `json:"width"`
+	Height           int               `json:"height"`
+	PixFmt           string            `json:"pix_fmt"`
+	ColorRange       string            `json:"color_range"`
+	ColorSpace       string            `json:"color_space"`
+	ColorTransfer    string            `json:"color_transfer"`
+	ColorPrimaries   string            `json:"color_primaries"`
+	FieldOrder       string            `json:"field_order"`
+	AvgFrameRate     string            `json:"avg_frame_rate"`
+	RFrameRate       string            `json:"r_frame_rate"`
+	TimeBase         string            `json:"time_base"`
+	SampleRate       string            `json:"sample_rate"`
+	Channels         int               `json:"channels"`
+	ChannelLayout    string            `json:"channel_layout"`
+	BitsPerSample    int               `json:"bits_per_sample"`
+	BitsPerRawSample string            `json:"bits_per_raw_sample"`
+	Duration         string            `json:"duration"`
+	BitRate          string            `json:"bit_rate"`
+	Tags             map[string]string `json:"tags"`
+	Disposition      map[string]int    `json:"disposition"`
+	SideDataList     []ffprobeSideData `json:"side_data_list"`
+}
+
+// ffprobeSideData is the side data of a stream.
+type ffprobeSideData struct {
+	Rotation float64 `json:"rotation"`
+}
+
+// ProbeMedia retrieves the metadata of the given media file with a single
+// ffprobe invocation, giving up after Timeout.
+func ProbeMedia(path string) (MediaInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), Timeout)
+	defer cancel()
+	return ProbeMediaContext(ctx, path)
+}
+
+// libavProbe reads the metadata of a file in process with the libav
+// libraries, it's only set in executables built with the libav tag.
+var libavProbe func(path string) (ffprobeOutput, error)
+
+// useLibav is set when the files are probed with libavProbe.
+var useLibav bool
+
+// LibavAvailable reports whether the executable was built with the libav tag,
+// linking the libav libraries.
+func LibavAvailable() bool {
+	return libavProbe != nil
+}
+
+// UseLibav makes ProbeMedia read the files in process with the libav
+// libraries instead of running ffprobe, saving a process per probe. It fails
+// unless the executable was built with the libav tag.
+func UseLibav(enable bool) error {
+	if enable && libavProbe == nil {
+		return fmt.Errorf("this executable wasn't built with the libav tag (go build -tags libav)")
+	}
+	useLibav = enable
+	return nil
+}
+
+// ProbeMediaContext is like ProbeMedia but the ffprobe process is killed when
+// the context is done instead of after Timeout. Files probed in process, with
+// libav or natively, aren't bounded by the context.
+func ProbeMediaContext(ctx context.Context, path string) (MediaInfo, error) {
+	if useLibav {
+		probeOutput, err := libavProbe(path)
+		if err != nil {
+			return MediaInfo{}, fmt.Errorf("libav error probing %s: %v", path, err)
+		}
+		return parseProbeOutput(probeOutput)
+	}
+	if NativeMP4 && mp4Extensions[strings.ToLower(filepath.Ext(path))] {
+		// anything the native reader doesn't handle is left to ffprobe
+		if probeOutput, err := probeMP4(path); err == nil {
+			if info, err := parseProbeOutput(probeOutput); err == nil && nativeComplete(info) {
+				return info, nil
+			}
+		}
+	}
+
+	ffprobe, err := FFprobePath()
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe is not available: %v", err)
+	}
+
+	cmdArgs := []string{
+		"-v", "error",
+		"-show_streams",
+		"-show_format",
+		"-of", "json",
+		path,
+	}
+
+	cmd := exec.CommandContext(ctx, ffprobe, cmdArgs...)
+	stderr := &cappedBuffer{max: maxStderrSize}
+	cmd.Stderr = stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return MediaInfo{}, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	// decode the output as it comes, refusing to read more than MaxOutputSize
+	limited := &io.LimitedReader{R: stdout, N: MaxOutputSize + 1}
+	var probeOutput ffprobeOutput
+	decodeErr := json.NewDecoder(limited).Decode(&probeOutput)
+	if decodeErr == nil {
+		// make sure nothing but whitespace follows the JSON document
+		io.Copy(io.Discard, limited)
+	}
+	tooLarge := limited.N <= 0
+	if decodeErr != nil || tooLarge {
+		cmd.Process.Kill()
+	}
+	waitErr := cmd.Wait()
+
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return MediaInfo{}, fmt.Errorf("ffprobe timed out probing %s", path)
+	case ctx.Err() != nil:
+		return MediaInfo{}, fmt.Errorf("ffprobe interrupted: %v", ctx.Err())
+	case tooLarge:
+		return MediaInfo{}, fmt.Errorf("ffprobe output for %s exceeds %d bytes", path, MaxOutputSize)
+	case waitErr != nil:
+		return MediaInfo{}, fmt.Errorf("ffprobe error: %v %s", waitErr, strings.TrimSpace(stderr.String()))
+	case decodeErr != nil:
+		return MediaInfo{}, fmt.Errorf("failed to parse ffprobe output: %v", decodeErr)
+	}
+
+	return parseProbeOutput(probeOutput)
+}
+
+// cappedBuffer is a bytes.Buffer silently dropping anything written past max
+// bytes.
+type cappedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// parseProbeOutput converts the raw ffprobe output into a MediaInfo.
+func parseProbeOutput(probeOutput ffprobeOutput) (MediaInfo, error) {
+	info := MediaInfo{
+		FormatName: probeOutput.Format.FormatName,
+		BitRate:    parseInt64(probeOutput.Format.BitRate),
+		Tags:       map[string]string{},
+	}
+	for key, value := range probeOutput.Format.Tags {
+		info.Tags[strings.ToLower(key)] = value
+	}
+
+	var streamDuration float64
+	for _, raw := range probeOutput.Streams {
+		stream := Stream{
+			Index:         raw.Index,
+			Type:          raw.CodecType,
+			Codec:         raw.CodecName,
+			BitRate:       parseInt64(raw.BitRate),
+			Attached:      raw.Disposition["attached_pic"] == 1,
+			Width:         raw.Width,
+			Height:        raw.Height,
+			PixelFormat:   raw.PixFmt,
+			Channels:      raw.Channels,
+			ChannelLayout: raw.ChannelLayout,
+		}
+		stream.Duration, _ = strconv.ParseFloat(raw.Duration, 64)
+		stream.TimeBase = parseRational(raw.TimeBase)
+		stream.SampleRate, _ = strconv.Atoi(raw.SampleRate)
+		stream.BitDepth, _ = strconv.Atoi(raw.BitsPerRawSample)
+		if stream.BitDepth == 0 {
+			stream.BitDepth = raw.BitsPerSample
+		}
+
+		if stream.Type == "video" {
+			stream.FPS = parseFrameRate(raw.AvgFrameRate)
+			stream.FrameRate = parseRational(raw.AvgFrameRate)
+			if stream.FPS == 0 {
+				stream.FPS = parseFrameRate(raw.RFrameRate)
+				stream.FrameRate = parseRational(raw.RFrameRate)
+			}
+			if stream.BitDepth == 0 {
+				stream.BitDepth = pixelFormatBitDepth(raw.PixFmt)
+			}
+			stream.ColorRange = knownColor(raw.ColorRange)
+			stream.ColorSpace = knownColor(raw.ColorSpace)
+			stream.ColorTransfer = knownColor(raw.ColorTransfer)
+			stream.ColorPrimaries = knownColor(raw.ColorPrimaries)
+			if raw.FieldOrder != "unknown" {
+				stream.FieldOrder = raw.FieldOrder
+			}
+			if rotate, ok := raw.Tags["rotate"]; ok {
+				stream.Rotation, _ = strconv.Atoi(rotate)
+			}
+			for _, sideData := range raw.SideDataList {
+				if sideData.Rotation != 0 {
+					stream.Rotation = int(sideData.Rotation)
+				}
+			}
+		}
+
+		for key, value := range raw.Tags {
+			if key = strings.ToLower(key); info.Tags[key] == "" && key != "rotate" {
+				info.Tags[key] = value
+			}
+		}
+
+		if stream.Duration > streamDuration && !stream.Attached {
+			streamDuration = stream.Duration
+		}
+		info.Streams = append(info.Streams, stream)
+	}
+
+	info.Duration = streamDuration
+	if probeOutput.Format.Duration != "" {
+		duration, err := strconv.ParseFloat(probeOutput.Format.Duration, 64)
+		if err != nil {
+			return MediaInfo{}, fmt.Errorf("failed to parse duration: %v", err)
+		}
+		info.Duration = duration
+	}
+
+	_, hasVideo := info.Video()
+	_, hasAudio := info.Audio()
+	if !hasVideo && !hasAudio {
+		return MediaInfo{}, fmt.Errorf("no audio or video streams found")
+	}
+
+	return info, nil
+}
+
+// knownColor returns the color property reported by ffprobe, or an empty
+// string when it's unknown.
+func knownColor(value string) string {
+	switch value {
+	case "unknown", "unspecified", "reserved":
+		return ""
+	}
+	return value
+}
+
+// parseFrameRate parses the num/den frame rates reported by ffprobe.
+func parseFrameRate(rate string) float64 {
+	numStr, denStr, ok := strings.Cut(rate, "/")
+	if !ok {
+		fps, _ := strconv.ParseFloat(rate, 64)
+		return fps
+	}
+	num, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0
+	}
+	den, err := strconv.ParseFloat(denStr, 64)
+	if err != nil || den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// parseRational parses fractions such as "1/90000", the zero Rational being
+// returned for invalid or undefined ("0/0") fractions.
+func parseRational(value string) Rational {
+	numStr, denStr, ok := strings.Cut(value, "/")
+	if !ok {
+		return Rational{}
+	}
+	num, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil {
+		return Rational{}
+	}
+	den, err := strconv.ParseInt(denStr, 10, 64)
+	if err != nil || den <= 0 || num <= 0 {
+		return Rational{}
+	}
+	return Rational{Num: num, Den: den}
+}
+
+// pixelFormatBitDepth guesses the bit depth from pixel format names such as
+// yuv420p10le, 8 bits being the default.
+func pixelFormatBitDepth(pixFmt string) int {
+	if pixFmt == "" {
+		return 0
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(pixFmt, "le"), "be")
+	for _, depth := range []int{9, 10, 12, 14, 16} {
+		if strings.HasSuffix(name, "p"+strconv.Itoa(depth)) {
+			return depth
+		}
+	}
+	return 8
+}
+
+func parseInt64(value string) int64 {
+	v, _ := strconv.ParseInt(value, 10, 64)
+	return v
+}
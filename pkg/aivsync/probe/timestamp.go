@@ -0,0 +1,24 @@
+package probe
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Timestamp formats a time, in seconds, as a [-]HH:MM:SS.fraction ffmpeg
+// timestamp, for the time options such as -ss and -t.
+func Timestamp(t float64) string {
+	sign := ""
+	if t < 0 {
+		sign, t = "-", -t
+	}
+	// the fraction is taken from the shortest decimal form of the time, the
+	// difference with the whole seconds would show the binary rounding
+	_, fraction, _ := strings.Cut(strconv.FormatFloat(t, 'f', -1, 64), ".")
+	if fraction != "" {
+		fraction = "." + fraction
+	}
+	total := int64(t)
+	return fmt.Sprintf("%s%02d:%02d:%02d%s", sign, total/3600, total/60%60, total%60, fraction)
+}
@@ -0,0 +1,154 @@
+// Package render retimes a video with ffmpeg following the segments of a
+// plan, the music being muxed in when given.
+//
+// The path of the ffmpeg executable is looked up in the PATH unless the
+// FFMPEG_PATH environment variable is set.
+package render
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/plan"
+	"github.com/mattetti/AIVideoSync/pkg/aivsync/probe"
+)
+
+// DefaultEncoderArgs encode the video in H.264, as the syncToBeat command
+// does by default.
+var DefaultEncoderArgs = []string{"-c:v", "libx264", "-preset", "medium", "-crf", "22"}
+
+// DefaultAudioArgs encode the music in 48kHz stereo AAC.
+var DefaultAudioArgs = []string{"-c:a", "aac", "-b:a", "192k", "-ar", "48000", "-ac", "2"}
+
+// Options tune the render, the zero value renders H.264 video with AAC music
+// using the ffmpeg found in the PATH.
+type Options struct {
+	// FFmpegPath is the ffmpeg executable, FFMPEG_PATH or the PATH are used
+	// when empty.
+	FFmpegPath string
+	// EncoderArgs are the output options encoding the video,
+	// DefaultEncoderArgs when nil.
+	EncoderArgs []string
+	// AudioArgs are the output options encoding the music, DefaultAudioArgs
+	// when nil.
+	AudioArgs []string
+	// Filter, when set, is a filter chain applied to every segment of the
+	// source before it's retimed, such as a deinterlacer.
+	Filter string
+	// Log receives the errors of ffmpeg.
+	Log io.Writer
+}
+
+// TrimFilter returns the trim filter extracting the segment, by timestamps
+// when the segment is quantized.
+func TrimFilter(seg plan.Segment) string {
+	if seg.Timebase.Den == 0 {
+		return "trim=start=" + strconv.FormatFloat(seg.Start, 'f', -1, 64) + ":end=" + strconv.FormatFloat(seg.End, 'f', -1, 64)
+	}
+	return "trim=start_pts=" + strconv.FormatInt(seg.StartPTS, 10) + ":end_pts=" + strconv.FormatInt(seg.EndPTS, 10)
+}
+
+// SpeedFilterComplex builds the filter graph retiming the segments of the
+// first input, filter being applied to each segment first when set. The
+// retimed video is labeled [outv].
+func SpeedFilterComplex(segments []plan.Segment, filter string) string {
+	var filterComplexParts []string
+	var concatParts []string // To keep track of the labels for concatenation
+
+	if filter != "" {
+		filter += ","
+	}
+	for _, seg := range segments {
+		// the timestamps are scaled by the inverse of the speed
+		scale := strconv.FormatFloat(1/seg.SpeedFactor, 'f', -1, 64)
+		filterComplexParts = append(filterComplexParts, fmt.Sprintf("[0:v]%s%s,setpts=(PTS-STARTPTS)*%s[v%d]; ", filter, TrimFilter(seg), scale, seg.Keyframe))
+		concatParts = append(concatParts, fmt.Sprintf("[v%d]", seg.Keyframe))
+	}
+
+	// Adding the concat filter part correctly
+	filterComplexParts = append(filterComplexParts, fmt.Sprintf("%sconcat=n=%d:v=1:a=0[outv]", strings.Join(concatParts, ""), len(concatParts)))
+
+	// Join all filter parts to form the complete filter_complex string
+	return strings.Join(filterComplexParts, "")
+}
+
+// ffmpegPath returns the ffmpeg executable of the options.
+func (o Options) ffmpegPath() (string, error) {
+	if o.FFmpegPath != "" {
+		return o.FFmpegPath, nil
+	}
+	path, err := probe.FindExecutable("ffmpeg", "FFMPEG_PATH")
+	if err != nil {
+		return "", fmt.Errorf("FFmpeg is not available: %v", err)
+	}
+	return path, nil
+}
+
+// Render retimes the video following the segments and writes it to
+// outputPath, with the music of audioPath as its sound track when given, the
+// sound of the video being dropped.
+func Render(ctx context.Context, videoPath string, audioPath string, segments []plan.Segment, outputPath string, opts Options) error {
+	if len(segments) == 0 {
+		return fmt.Errorf("no segments to render")
+	}
+	ffmpegPath, err := opts.ffmpegPath()
+	if err != nil {
+		return err
+	}
+	encoderArgs := opts.EncoderArgs
+	if encoderArgs == nil {
+		encoderArgs = DefaultEncoderArgs
+	}
+	audioArgs := opts.AudioArgs
+	if audioArgs == nil {
+		audioArgs = DefaultAudioArgs
+	}
+
+	cmdArgs := []string{"-y", "-hide_banner", "-loglevel", "error", "-i", videoPath}
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-i", audioPath)
+	}
+	cmdArgs = append(cmdArgs,
+		"-filter_complex", SpeedFilterComplex(segments, opts.Filter),
+		"-map", "[outv]",
+	)
+	cmdArgs = append(cmdArgs, encoderArgs...)
+	if audioPath != "" {
+		cmdArgs = append(cmdArgs, "-map", "1:a:0")
+		cmdArgs = append(cmdArgs, audioArgs...)
+		// the music stops with the video
+		cmdArgs = append(cmdArgs, "-t", probe.Timestamp(segments[len(segments)-1].NearestBeatTime))
+	} else {
+		cmdArgs = append(cmdArgs, "-an")
+	}
+	cmdArgs = append(cmdArgs, outputPath)
+
+	cmd := exec.CommandContext(ctx, ffmpegPath, cmdArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if opts.Log != nil {
+		cmd.Stderr = io.MultiWriter(&stderr, opts.Log)
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed: %v: %s", err, lastLine(stderr.String()))
+	}
+	return nil
+}
+
+// lastLine returns the last line of the output of ffmpeg, where it reports
+// why it failed.
+func lastLine(output string) string {
+	output = strings.TrimSpace(output)
+	if i := strings.LastIndexByte(output, '\n'); i >= 0 {
+		return output[i+1:]
+	}
+	return output
+}